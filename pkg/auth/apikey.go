@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// APIKeyHeader is the header in which callers present an API key.
+const APIKeyHeader = "X-API-Key"
+
+// apiKey is a single API key read from an API key file.
+type apiKey struct {
+	Name     string `json:"name"`
+	SHA256   string `json:"sha256"`
+	UserUID  string `json:"user_uid"`
+	AllUsers bool   `json:"all_users"`
+
+	hash []byte
+}
+
+// apiKeyFile is the format of the file from which we read API keys.
+type apiKeyFile struct {
+	Keys []*apiKey `json:"keys"`
+}
+
+// apiKeyAuthenticator is our implementation of Authenticator for static API
+// keys.
+type apiKeyAuthenticator struct {
+	keys []*apiKey
+}
+
+// NewAPIKeyAuthenticator returns an Authenticator accepting the API keys listed
+// in the JSON file at the given path. Keys are stored as the hex encoded
+// SHA-256 hash of the key, along with the name of the key, and either the uid
+// of the user for whom it may act or all_users if it may act for any user:
+//
+//	{"keys": [{"name": "importer", "sha256": "9f86d0...", "all_users": true}]}
+func NewAPIKeyAuthenticator(path string) (Authenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read API key file")
+	}
+
+	var file apiKeyFile
+
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse API key file")
+	}
+
+	for _, key := range file.Keys {
+		if key.Name == "" {
+			return nil, errors.New("API key file contains a key without a name")
+		}
+
+		key.hash, err = hex.DecodeString(key.SHA256)
+		if err != nil || len(key.hash) != sha256.Size {
+			return nil, errors.Errorf("API key %s must have a hex encoded SHA-256 hash", key.Name)
+		}
+
+		if key.UserUID == "" && !key.AllUsers {
+			return nil, errors.Errorf("API key %s must have either a user_uid or all_users", key.Name)
+		}
+	}
+
+	return &apiKeyAuthenticator{keys: file.Keys}, nil
+}
+
+// Authenticate is our implementation of the method defined in the
+// Authenticator interface. Every key is compared in constant time, so the
+// time taken does not reveal which key nearly matched.
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	presented := r.Header.Get(APIKeyHeader)
+	if presented == "" {
+		return nil, ErrNoCredentials
+	}
+
+	hash := sha256.Sum256([]byte(presented))
+
+	var matched *apiKey
+
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash) == 1 {
+			matched = key
+		}
+	}
+
+	if matched == nil {
+		return nil, errors.Wrap(ErrInvalidCredentials, "unknown API key")
+	}
+
+	return &Principal{
+		Name:     matched.Name,
+		UserUID:  matched.UserUID,
+		AllUsers: matched.AllUsers,
+	}, nil
+}
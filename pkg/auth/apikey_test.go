@@ -0,0 +1,127 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/auth"
+)
+
+// writeFile writes the given contents to a key file in the given directory,
+// returning its path.
+func writeFile(t *testing.T, dir, contents string) string {
+	path := filepath.Join(dir, "keys.json")
+
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	assert.Nil(t, err)
+
+	return path
+}
+
+// the keys below are the SHA-256 hashes of "secret" and "password"
+const apiKeys = `{"keys": [
+	{"name": "importer", "sha256": "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", "all_users": true},
+	{"name": "alice-app", "sha256": "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8", "user_uid": "alice"}
+]}`
+
+func TestAPIKeyAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	authenticator, err := auth.NewAPIKeyAuthenticator(writeFile(t, dir, apiKeys))
+	assert.Nil(t, err)
+
+	testcases := []struct {
+		label             string
+		key               string
+		expectedPrincipal *auth.Principal
+		expectedErr       error
+	}{
+		{
+			label:             "all users key",
+			key:               "secret",
+			expectedPrincipal: &auth.Principal{Name: "importer", AllUsers: true},
+		},
+		{
+			label:             "user key",
+			key:               "password",
+			expectedPrincipal: &auth.Principal{Name: "alice-app", UserUID: "alice"},
+		},
+		{
+			label:       "unknown key",
+			key:         "guess",
+			expectedErr: auth.ErrInvalidCredentials,
+		},
+		{
+			label:       "no key",
+			expectedErr: auth.ErrNoCredentials,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/", nil)
+			assert.Nil(t, err)
+
+			if tc.key != "" {
+				req.Header.Set(auth.APIKeyHeader, tc.key)
+			}
+
+			principal, err := authenticator.Authenticate(req)
+			assert.Equal(t, tc.expectedErr, errors.Cause(err))
+			assert.Equal(t, tc.expectedPrincipal, principal)
+		})
+	}
+}
+
+func TestInvalidAPIKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	testcases := []struct {
+		label    string
+		contents string
+	}{
+		{
+			label:    "invalid json",
+			contents: `{"keys":`,
+		},
+		{
+			label:    "missing name",
+			contents: `{"keys": [{"sha256": "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", "all_users": true}]}`,
+		},
+		{
+			label:    "invalid hash",
+			contents: `{"keys": [{"name": "importer", "sha256": "secret", "all_users": true}]}`,
+		},
+		{
+			label:    "no user",
+			contents: `{"keys": [{"name": "importer", "sha256": "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"}]}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := auth.NewAPIKeyAuthenticator(writeFile(t, dir, tc.contents))
+			assert.NotNil(t, err)
+		})
+	}
+
+	_, err = auth.NewAPIKeyAuthenticator("/does/not/exist.json")
+	assert.NotNil(t, err)
+}
+
+func TestCanActFor(t *testing.T) {
+	assert.True(t, (&auth.Principal{AllUsers: true}).CanActFor("alice"))
+	assert.True(t, (&auth.Principal{UserUID: "alice"}).CanActFor("alice"))
+	assert.False(t, (&auth.Principal{UserUID: "alice"}).CanActFor("bob"))
+	assert.False(t, (&auth.Principal{}).CanActFor(""))
+}
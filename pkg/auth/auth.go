@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNoCredentials is the error returned by an Authenticator when a request
+// does not contain credentials of the kind it checks.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrInvalidCredentials is the error returned (wrapped) by an Authenticator
+// when a request contains credentials of the kind it checks, but they are not
+// valid.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator is the interface of a component able to authenticate incoming
+// requests.
+type Authenticator interface {
+	// Authenticate returns the principal identified by the credentials of the
+	// given request. If the request contains no credentials of the kind we
+	// check we return ErrNoCredentials, and if they are invalid we return an
+	// error wrapping ErrInvalidCredentials.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// Principal describes an authenticated caller of our API.
+type Principal struct {
+	// Name identifies the caller, being the name of their API key or the
+	// subject of their token.
+	Name string
+
+	// UserUID is the uid of the user for whom the caller may act.
+	UserUID string
+
+	// AllUsers is true for trusted callers, such as other services, which may
+	// act for any user.
+	AllUsers bool
+}
+
+// CanActFor returns true if the principal may act for the user with the given
+// uid.
+func (p *Principal) CanActFor(userUID string) bool {
+	return p.AllUsers || (p.UserUID != "" && p.UserUID == userUID)
+}
+
+// contextKey is the type of the key under which we store a principal in a
+// context, so it cannot collide with keys from other packages.
+type contextKey struct{}
+
+// NewContext returns a copy of the given context carrying the given principal.
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal carried by the given context, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(*Principal)
+	return principal, ok
+}
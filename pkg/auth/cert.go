@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+// certificateKey is the type of the key under which we store a client
+// certificate in a context.
+type certificateKey struct{}
+
+// NewCertificateContext returns a copy of the given context carrying the given
+// verified client certificate.
+func NewCertificateContext(ctx context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, certificateKey{}, cert)
+}
+
+// CertificateFromContext returns the verified client certificate carried by
+// the given context, if any. A certificate is only present when the caller
+// connected using mutual TLS.
+func CertificateFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(certificateKey{}).(*x509.Certificate)
+	return cert, ok
+}
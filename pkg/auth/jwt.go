@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// leeway is the clock skew we tolerate when checking the times within a token.
+const leeway = time.Minute
+
+// algorithms contains the hash function for each of the HMAC signing
+// algorithms we accept.
+var algorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// jwtKey is a single signing key read from a JWT key file.
+type jwtKey struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+
+	secret []byte
+}
+
+// jwtKeyFile is the format of the file from which we read signing keys.
+type jwtKeyFile struct {
+	Keys []*jwtKey `json:"keys"`
+}
+
+// jwtHeader contains the fields of a token's header we inspect.
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// jwtClaims contains the claims of a token we inspect.
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt *int64 `json:"exp"`
+	NotBefore *int64 `json:"nbf"`
+}
+
+// jwtAuthenticator is our implementation of Authenticator for HMAC signed JSON
+// web tokens.
+type jwtAuthenticator struct {
+	keys []*jwtKey
+	now  func() time.Time
+}
+
+// NewJWTAuthenticator returns an Authenticator accepting JSON web tokens
+// presented as bearer tokens, signed using HMAC with one of the base64 encoded
+// secrets listed in the JSON file at the given path. If a token names a key
+// via its kid header, only that key is tried. Listing several keys allows
+// the signing key to be rotated without rejecting tokens signed with the
+// previous one:
+//
+//	{"keys": [{"id": "2018-06", "secret": "c2VjcmV0..."}]}
+//
+// Tokens must have an expiry time, and the subject of a token is the uid of
+// the user for whom its bearer may act.
+func NewJWTAuthenticator(path string) (Authenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read JWT key file")
+	}
+
+	var file jwtKeyFile
+
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse JWT key file")
+	}
+
+	if len(file.Keys) == 0 {
+		return nil, errors.New("JWT key file contains no keys")
+	}
+
+	for _, key := range file.Keys {
+		key.secret, err = base64.StdEncoding.DecodeString(key.Secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode secret of JWT key %s", key.ID)
+		}
+
+		if len(key.secret) < 32 {
+			return nil, errors.Errorf("secret of JWT key %s must be at least 32 bytes", key.ID)
+		}
+	}
+
+	return &jwtAuthenticator{
+		keys: file.Keys,
+		now:  time.Now,
+	}, nil
+}
+
+// Authenticate is our implementation of the method defined in the
+// Authenticator interface.
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	authorization := r.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.verify(strings.TrimSpace(authorization[7:]))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidCredentials, err.Error())
+	}
+
+	return &Principal{
+		Name:    claims.Subject,
+		UserUID: claims.Subject,
+	}, nil
+}
+
+// verify checks the signature and times of the given token, returning its
+// claims if it is valid.
+func (a *jwtAuthenticator) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+
+	err := decodeSegment(parts[0], &header)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed token header")
+	}
+
+	// the algorithm is checked against our allowed list, so tokens claiming to
+	// be unsigned, or signed with some other kind of key, are rejected
+	newHash, ok := algorithms[header.Algorithm]
+	if !ok {
+		return nil, errors.Errorf("unsupported signing algorithm: %s", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	if !a.checkSignature(header.KeyID, newHash, signed, signature) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims jwtClaims
+
+	err = decodeSegment(parts[1], &claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed token claims")
+	}
+
+	now := a.now()
+
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiry time")
+	}
+
+	if now.After(time.Unix(*claims.ExpiresAt, 0).Add(leeway)) {
+		return nil, errors.New("token has expired")
+	}
+
+	if claims.NotBefore != nil && now.Add(leeway).Before(time.Unix(*claims.NotBefore, 0)) {
+		return nil, errors.New("token is not yet valid")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	return &claims, nil
+}
+
+// checkSignature returns true if the given signature is valid for the signed
+// bytes under any of our keys matching the given key id, or any of our keys
+// if the id is empty.
+func (a *jwtAuthenticator) checkSignature(keyID string, newHash func() hash.Hash, signed, signature []byte) bool {
+	for _, key := range a.keys {
+		if keyID != "" && key.ID != keyID {
+			continue
+		}
+
+		mac := hmac.New(newHash, key.secret)
+		mac.Write(signed)
+
+		if hmac.Equal(mac.Sum(nil), signature) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decodeSegment decodes a base64url encoded JSON segment of a token into v.
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,182 @@
+package auth_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/auth"
+)
+
+var (
+	currentSecret  = []byte("0123456789abcdef0123456789abcdef")
+	previousSecret = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// newToken returns a token with the given header and claims, signed with the
+// given secret using HS256.
+func newToken(t *testing.T, header, claims map[string]interface{}, secret []byte) string {
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		assert.Nil(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	signed := encode(header) + "." + encode(claims)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	keys, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"id": "current", "secret": base64.StdEncoding.EncodeToString(currentSecret)},
+			{"id": "previous", "secret": base64.StdEncoding.EncodeToString(previousSecret)},
+		},
+	})
+	assert.Nil(t, err)
+
+	authenticator, err := auth.NewJWTAuthenticator(writeFile(t, dir, string(keys)))
+	assert.Nil(t, err)
+
+	hs256 := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	valid := map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	testcases := []struct {
+		label             string
+		authorization     string
+		expectedPrincipal *auth.Principal
+		expectedErr       error
+	}{
+		{
+			label:             "valid token",
+			authorization:     "Bearer " + newToken(t, hs256, valid, currentSecret),
+			expectedPrincipal: &auth.Principal{Name: "alice", UserUID: "alice"},
+		},
+		{
+			label:             "previous key",
+			authorization:     "bearer " + newToken(t, hs256, valid, previousSecret),
+			expectedPrincipal: &auth.Principal{Name: "alice", UserUID: "alice"},
+		},
+		{
+			label:             "named key",
+			authorization:     "Bearer " + newToken(t, map[string]interface{}{"alg": "HS256", "kid": "previous"}, valid, previousSecret),
+			expectedPrincipal: &auth.Principal{Name: "alice", UserUID: "alice"},
+		},
+		{
+			label:         "wrong named key",
+			authorization: "Bearer " + newToken(t, map[string]interface{}{"alg": "HS256", "kid": "current"}, valid, previousSecret),
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "unknown secret",
+			authorization: "Bearer " + newToken(t, hs256, valid, []byte("not our secret not our secret!!!")),
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "unsigned",
+			authorization: "Bearer " + newToken(t, map[string]interface{}{"alg": "none"}, valid, currentSecret),
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "expired",
+			authorization: "Bearer " + newToken(t, hs256, map[string]interface{}{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()}, currentSecret),
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "no expiry",
+			authorization: "Bearer " + newToken(t, hs256, map[string]interface{}{"sub": "alice"}, currentSecret),
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "not yet valid",
+			authorization: "Bearer " + newToken(t, hs256, map[string]interface{}{"sub": "alice", "exp": time.Now().Add(2 * time.Hour).Unix(), "nbf": time.Now().Add(time.Hour).Unix()}, currentSecret),
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "no subject",
+			authorization: "Bearer " + newToken(t, hs256, map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}, currentSecret),
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "malformed",
+			authorization: "Bearer abc.def",
+			expectedErr:   auth.ErrInvalidCredentials,
+		},
+		{
+			label:         "basic auth",
+			authorization: "Basic YWxpY2U6c2VjcmV0",
+			expectedErr:   auth.ErrNoCredentials,
+		},
+		{
+			label:       "no token",
+			expectedErr: auth.ErrNoCredentials,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/", nil)
+			assert.Nil(t, err)
+
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+
+			principal, err := authenticator.Authenticate(req)
+			assert.Equal(t, tc.expectedErr, errors.Cause(err))
+			assert.Equal(t, tc.expectedPrincipal, principal)
+		})
+	}
+}
+
+func TestInvalidJWTKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	testcases := []struct {
+		label    string
+		contents string
+	}{
+		{
+			label:    "invalid json",
+			contents: `{"keys":`,
+		},
+		{
+			label:    "no keys",
+			contents: `{"keys": []}`,
+		},
+		{
+			label:    "invalid secret",
+			contents: `{"keys": [{"id": "current", "secret": "not base64!"}]}`,
+		},
+		{
+			label:    "short secret",
+			contents: `{"keys": [{"id": "current", "secret": "c2VjcmV0"}]}`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := auth.NewJWTAuthenticator(writeFile(t, dir, tc.contents))
+			assert.NotNil(t, err)
+		})
+	}
+}
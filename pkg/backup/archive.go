@@ -0,0 +1,232 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+const (
+	// archiveFormat identifies a file as one of our backup archives.
+	archiveFormat = "devicereg-backup"
+
+	// archiveVersion is the version of the archive format written by Write.
+	archiveVersion = 1
+
+	// kdfIterations is the number of PBKDF2 iterations used to derive the
+	// archive key from the passphrase.
+	kdfIterations = 600000
+
+	// saltSize is the size in bytes of the random salt used to derive the key.
+	saltSize = 16
+
+	// keySize is the size in bytes of the derived AES-256 key.
+	keySize = 32
+)
+
+// ErrDecrypt is the error returned (wrapped) when an archive cannot be
+// decrypted, either because the passphrase is wrong or because the archive has
+// been modified.
+var ErrDecrypt = errors.New("wrong passphrase or corrupted archive")
+
+// Archive is the decrypted contents of a backup archive.
+type Archive struct {
+	// SchemaVersion is the schema version of the database the snapshot was
+	// read from.
+	SchemaVersion uint
+
+	// CreatedAt is the time at which the archive was written.
+	CreatedAt time.Time
+
+	// Snapshot is the backed up contents of the database.
+	Snapshot *postgres.Snapshot
+}
+
+// header is the unencrypted first line of an archive, describing how the rest
+// of it is encrypted. The header is authenticated along with the encrypted
+// contents, so it cannot be modified without detection.
+type header struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	SchemaVersion uint      `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	KDF           string    `json:"kdf"`
+	Iterations    int       `json:"iterations"`
+	Salt          []byte    `json:"salt"`
+	Nonce         []byte    `json:"nonce"`
+}
+
+// Write writes an archive of the given snapshot to w. The archive consists of
+// a single line JSON header followed by the gzipped JSON snapshot encrypted
+// with AES-256-GCM, using a key derived from the passphrase with PBKDF2. GCM
+// authenticates both the header and the encrypted snapshot, so any change to
+// the archive is detected when it is read.
+func Write(w io.Writer, archive *Archive, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("backup passphrase must not be empty")
+	}
+
+	var compressed bytes.Buffer
+
+	zw := gzip.NewWriter(&compressed)
+
+	err := json.NewEncoder(zw).Encode(archive.Snapshot)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode snapshot")
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to compress snapshot")
+	}
+
+	h := &header{
+		Format:        archiveFormat,
+		Version:       archiveVersion,
+		SchemaVersion: archive.SchemaVersion,
+		CreatedAt:     archive.CreatedAt.UTC(),
+		KDF:           "pbkdf2-sha256",
+		Iterations:    kdfIterations,
+		Salt:          make([]byte, saltSize),
+	}
+
+	_, err = rand.Read(h.Salt)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate salt")
+	}
+
+	gcm, err := newGCM(passphrase, h.Salt, h.Iterations)
+	if err != nil {
+		return err
+	}
+
+	h.Nonce = make([]byte, gcm.NonceSize())
+
+	_, err = rand.Read(h.Nonce)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate nonce")
+	}
+
+	headerLine, err := json.Marshal(h)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode header")
+	}
+
+	headerLine = append(headerLine, '\n')
+
+	_, err = w.Write(headerLine)
+	if err != nil {
+		return errors.Wrap(err, "failed to write header")
+	}
+
+	_, err = w.Write(gcm.Seal(nil, h.Nonce, compressed.Bytes(), headerLine))
+	if err != nil {
+		return errors.Wrap(err, "failed to write snapshot")
+	}
+
+	return nil
+}
+
+// Read reads and decrypts an archive written by Write, returning an error
+// wrapping ErrDecrypt if the passphrase is wrong or the archive has been
+// modified.
+func Read(r io.Reader, passphrase string) (*Archive, error) {
+	br := bufio.NewReader(r)
+
+	headerLine, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read header")
+	}
+
+	var h header
+
+	err = json.Unmarshal(headerLine, &h)
+	if err != nil || h.Format != archiveFormat {
+		return nil, errors.New("not a backup archive")
+	}
+
+	if h.Version != archiveVersion {
+		return nil, errors.Errorf("unsupported archive version: %d", h.Version)
+	}
+
+	// the iteration count is fixed rather than read from the header, so that an
+	// altered archive cannot make us derive its key with a weaker or far more
+	// expensive count before the header is authenticated
+	if h.KDF != "pbkdf2-sha256" || h.Iterations != kdfIterations {
+		return nil, errors.Errorf("unsupported key derivation: %s with %d iterations", h.KDF, h.Iterations)
+	}
+
+	gcm, err := newGCM(passphrase, h.Salt, h.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(h.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid archive nonce")
+	}
+
+	ciphertext, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read snapshot")
+	}
+
+	compressed, err := gcm.Open(nil, h.Nonce, ciphertext, headerLine)
+	if err != nil {
+		return nil, errors.Wrap(ErrDecrypt, "failed to decrypt snapshot")
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress snapshot")
+	}
+	defer zr.Close()
+
+	decoder := json.NewDecoder(zr)
+
+	// numbers are kept as their original text, so large ids are restored exactly
+	decoder.UseNumber()
+
+	var snapshot postgres.Snapshot
+
+	err = decoder.Decode(&snapshot)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode snapshot")
+	}
+
+	return &Archive{
+		SchemaVersion: h.SchemaVersion,
+		CreatedAt:     h.CreatedAt,
+		Snapshot:      &snapshot,
+	}, nil
+}
+
+// newGCM returns an AES-256-GCM cipher keyed by the key derived from the given
+// passphrase and salt.
+func newGCM(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, iterations, keySize, sha256.New)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	return gcm, nil
+}
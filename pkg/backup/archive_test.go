@@ -0,0 +1,135 @@
+package backup_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/backup"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+func newArchive() *backup.Archive {
+	return &backup.Archive{
+		SchemaVersion: 20180620101512,
+		CreatedAt:     time.Date(2018, 6, 21, 10, 0, 0, 0, time.UTC),
+		Snapshot: &postgres.Snapshot{
+			Tables: map[string][]postgres.Row{
+				"users": {
+					{"id": 1, "uid": "alice", "private_key": "secret"},
+				},
+				"devices": {
+					{"id": 9007199254740993, "device_token": "abc123", "disposition": "indoor"},
+				},
+			},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := backup.Write(&buf, newArchive(), "passphrase")
+	assert.Nil(t, err)
+
+	// private keys must not appear in the archive
+	assert.NotContains(t, buf.String(), "secret")
+
+	archive, err := backup.Read(&buf, "passphrase")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(20180620101512), archive.SchemaVersion)
+	assert.True(t, newArchive().CreatedAt.Equal(archive.CreatedAt))
+
+	users := archive.Snapshot.Tables["users"]
+	assert.Len(t, users, 1)
+	assert.Equal(t, "alice", users[0]["uid"])
+	assert.Equal(t, "secret", users[0]["private_key"])
+
+	// large ids survive without losing precision
+	devices := archive.Snapshot.Tables["devices"]
+	assert.Len(t, devices, 1)
+	assert.Equal(t, json.Number("9007199254740993"), devices[0]["id"])
+}
+
+func TestReadErrors(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := backup.Write(&buf, newArchive(), "passphrase")
+	assert.Nil(t, err)
+
+	archive := buf.Bytes()
+	headerEnd := bytes.IndexByte(archive, '\n')
+
+	testcases := []struct {
+		label       string
+		archive     func() []byte
+		passphrase  string
+		expectedErr error
+	}{
+		{
+			label:       "wrong passphrase",
+			archive:     func() []byte { return archive },
+			passphrase:  "wrong",
+			expectedErr: backup.ErrDecrypt,
+		},
+		{
+			label: "tampered header",
+			archive: func() []byte {
+				tampered := bytes.Replace(archive, []byte("20180620101512"), []byte("20180620101513"), 1)
+				return tampered
+			},
+			passphrase:  "passphrase",
+			expectedErr: backup.ErrDecrypt,
+		},
+		{
+			label: "tampered body",
+			archive: func() []byte {
+				tampered := append([]byte{}, archive...)
+				tampered[headerEnd+10] ^= 0xff
+				return tampered
+			},
+			passphrase:  "passphrase",
+			expectedErr: backup.ErrDecrypt,
+		},
+		{
+			label: "truncated body",
+			archive: func() []byte {
+				return archive[:len(archive)-1]
+			},
+			passphrase:  "passphrase",
+			expectedErr: backup.ErrDecrypt,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := backup.Read(bytes.NewReader(tc.archive()), tc.passphrase)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, errors.Cause(err))
+		})
+	}
+}
+
+func TestReadInvalidArchive(t *testing.T) {
+	_, err := backup.Read(bytes.NewReader([]byte("not an archive\n")), "passphrase")
+	assert.NotNil(t, err)
+
+	err = backup.Write(&bytes.Buffer{}, newArchive(), "")
+	assert.NotNil(t, err)
+
+	var buf bytes.Buffer
+
+	err = backup.Write(&buf, newArchive(), "passphrase")
+	assert.Nil(t, err)
+
+	weakened := bytes.Replace(buf.Bytes(), []byte(`"iterations":600000`), []byte(`"iterations":1`), 1)
+	assert.NotEqual(t, buf.Bytes(), weakened)
+
+	_, err = backup.Read(bytes.NewReader(weakened), "passphrase")
+	assert.NotNil(t, err)
+	assert.NotEqual(t, backup.ErrDecrypt, errors.Cause(err))
+}
@@ -1,11 +1,20 @@
 package crypto
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"math/big"
 
 	"github.com/pkg/errors"
 	"github.com/thingful/zenroom-go"
 
+	"github.com/thingful/iotdevicereg/pkg/crypto/ec25519"
 	"github.com/thingful/iotdevicereg/pkg/lua"
 )
 
@@ -19,32 +28,416 @@ type KeyPair struct {
 
 	// PublicKey is the public key part of the key pair.
 	PublicKey string `json:"public"`
+
+	// Curve is the name of the elliptic curve of the key pair.
+	Curve string `json:"-"`
+
+	// Encoding is the name of the encoding of both keys.
+	Encoding string `json:"-"`
 }
 
 // NewKeyPair is a function that returns an instantiated key pair ready for
-// persistence to the DB. Currently this is a fake implementation that just
-// reads some random bytes /dev/urandom, so we should re-implement this function
-// using Zenroom, to create a real key pair that Zenroom is able to use for
-// encryption.
-func NewKeyPair() (*KeyPair, error) {
+// persistence to the DB, created by Zenroom on the curve and with the encoding
+// given in options. A nil options creates an ec25519 key pair encoded as
+// base64.
+func NewKeyPair(options *KeyOptions) (*KeyPair, error) {
+	if options != nil {
+		err := options.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keys := map[string]string{
+		"curve": options.curve(),
+	}
+
+	var keyPair KeyPair
+
+	err := exec("generatekeys.lua", keys, nil, &keyPair)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPair.Curve = options.curve()
+	keyPair.Encoding = options.encoding()
+
+	// zenroom outputs base64, so re-encode if another encoding is required
+	if keyPair.Encoding != Base64Encoding {
+		keyPair.PrivateKey, err = ConvertKey(keyPair.PrivateKey, Base64Encoding, keyPair.Encoding)
+		if err != nil {
+			return nil, err
+		}
+
+		keyPair.PublicKey, err = ConvertKey(keyPair.PublicKey, Base64Encoding, keyPair.Encoding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &keyPair, nil
+}
+
+// Sign returns a base64 encoded signature over the given payload, created with
+// the signer's private key, which may be verified by anyone holding the
+// signer's public key. On the ec25519 curve this is an Ed25519 signature,
+// while on nist256 it is an ECDSA signature over the SHA-256 hash of the
+// payload, being the big endian r and s values each padded to 32 bytes.
+// Signatures are only supported on the SignatureCurves, and the key must have
+// the curve and encoding given in options.
+func Sign(payload []byte, privateKey string, options *KeyOptions) (string, error) {
+	if len(payload) == 0 {
+		return "", errors.New("payload must not be empty")
+	}
+
+	err := validateSignatureOptions(options)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := decodeKey(privateKey, options.encoding())
+	if err != nil {
+		return "", errors.Wrap(err, "invalid private key")
+	}
+
+	var signature []byte
+
+	switch options.curve() {
+	case Nist256Curve:
+		key := &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: elliptic.P256()},
+			D:         new(big.Int).SetBytes(secret),
+		}
+		key.PublicKey.X, key.PublicKey.Y = key.Curve.ScalarBaseMult(secret)
+
+		digest := sha256.Sum256(payload)
+
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return "", errors.Wrap(err, "failed to sign payload")
+		}
+
+		signature = append(leftPad(r.Bytes(), 32), leftPad(s.Bytes(), 32)...)
+	default:
+		signature, err = ec25519.Sign(rand.Reader, secret, payload)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to sign payload")
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// Verify returns true if the given signature over the given payload was
+// created by Sign using the private key matching the given public key, which
+// must have the curve and encoding given in options. On the ec25519 curve the
+// public key may also be a compressed Ed25519 key, so that signatures made by
+// any Ed25519 implementation may be verified. A malformed signature is not an
+// error, but is simply invalid.
+func Verify(payload []byte, signature, publicKey string, options *KeyOptions) (bool, error) {
+	if len(payload) == 0 {
+		return false, errors.New("payload must not be empty")
+	}
+
+	err := validateSignatureOptions(options)
+	if err != nil {
+		return false, err
+	}
+
+	public, err := decodeKey(publicKey, options.encoding())
+	if err != nil {
+		return false, errors.Wrap(err, "invalid public key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != 64 {
+		return false, nil
+	}
+
+	switch options.curve() {
+	case Nist256Curve:
+		x, y := elliptic.Unmarshal(elliptic.P256(), public)
+		if x == nil {
+			return false, errors.New("invalid public key")
+		}
+
+		digest := sha256.Sum256(payload)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+
+		return ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, digest[:], r, s), nil
+	default:
+		valid, err := ec25519.Verify(public, payload, sig)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid public key")
+		}
+
+		return valid, nil
+	}
+}
+
+// ValidateSigningKey returns an error unless the given public key is one
+// against which Verify is able to check signatures, having the curve and
+// encoding given in options.
+func ValidateSigningKey(publicKey string, options *KeyOptions) error {
+	err := validateSignatureOptions(options)
+	if err != nil {
+		return err
+	}
+
+	public, err := decodeKey(publicKey, options.encoding())
+	if err != nil {
+		return errors.Wrap(err, "invalid public key")
+	}
+
+	switch options.curve() {
+	case Nist256Curve:
+		x, _ := elliptic.Unmarshal(elliptic.P256(), public)
+		if x == nil {
+			return errors.New("invalid public key")
+		}
+	default:
+		err = ec25519.ValidatePublicKey(public)
+		if err != nil {
+			return errors.Wrap(err, "invalid public key")
+		}
+	}
+
+	return nil
+}
+
+// validateSignatureOptions returns an error if the given options are invalid,
+// or name a curve on which we do not support signatures.
+func validateSignatureOptions(options *KeyOptions) error {
+	if options != nil {
+		err := options.Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !options.SupportsSignatures() {
+		return errors.Errorf("signatures are not supported on curve: %s", options.curve())
+	}
+
+	return nil
+}
+
+// leftPad returns the given big endian bytes padded with leading zeros to the
+// given length.
+func leftPad(b []byte, length int) []byte {
+	if len(b) >= length {
+		return b
+	}
+
+	padded := make([]byte, length)
+	copy(padded[length-len(b):], b)
+
+	return padded
+}
+
+// EncryptedPayload is a payload encrypted by Encrypt, containing everything
+// other than the key pairs that a recipient requires to decrypt it. All fields
+// are base64 encoded.
+type EncryptedPayload struct {
+	// Ciphertext is the encrypted payload, followed by the GCM authentication
+	// tag.
+	Ciphertext string `json:"ciphertext"`
+
+	// IV is the random 12 byte nonce used for encryption.
+	IV string `json:"iv"`
+
+	// Header is the additional authenticated data passed to AES-GCM.
+	Header string `json:"header"`
+}
+
+// Encrypt encrypts the given payload so that it can be decrypted by the
+// holder of the private key matching the given public key. The payload is
+// encrypted with AES-256-GCM, using as the key the SHA-256 hash of the ECDH
+// shared secret of the sender's private key and the recipient's public key as
+// computed by Zenroom, along with a random IV and the given header as
+// additional authenticated data. This is the scheme used for data encrypted
+// with the key pairs we create, so the recipient decrypts with their private
+// key and the sender's public key. Both keys must have the curve and encoding
+// given in options.
+func Encrypt(payload, header []byte, privateKey, publicKey string, options *KeyOptions) (*EncryptedPayload, error) {
+	if len(payload) == 0 {
+		return nil, errors.New("payload must not be empty")
+	}
+
+	if len(header) == 0 {
+		return nil, errors.New("header must not be empty")
+	}
+
+	aead, err := sessionCipher(privateKey, publicKey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aead.NonceSize())
+
+	_, err = rand.Read(iv)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read random IV")
+	}
+
+	return &EncryptedPayload{
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, iv, payload, header)),
+		IV:         base64.StdEncoding.EncodeToString(iv),
+		Header:     base64.StdEncoding.EncodeToString(header),
+	}, nil
+}
+
+// Decrypt decrypts a payload encrypted by Encrypt, using the recipient's
+// private key and the sender's public key. Both keys must have the curve and
+// encoding given in options. We return an error if the ciphertext, IV or
+// header have been modified, as then the payload cannot be authenticated.
+func Decrypt(encrypted *EncryptedPayload, privateKey, publicKey string, options *KeyOptions) ([]byte, error) {
+	if encrypted.Ciphertext == "" || encrypted.IV == "" || encrypted.Header == "" {
+		return nil, errors.New("encrypted payload is incomplete")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted.Ciphertext)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid ciphertext")
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(encrypted.IV)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid IV")
+	}
+
+	header, err := base64.StdEncoding.DecodeString(encrypted.Header)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid header")
+	}
+
+	aead, err := sessionCipher(privateKey, publicKey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != aead.NonceSize() {
+		return nil, errors.New("invalid IV length")
+	}
+
+	payload, err := aead.Open(nil, iv, ciphertext, header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt payload")
+	}
+
+	return payload, nil
+}
+
+// sessionCipher returns the AES-256-GCM cipher keyed by the SHA-256 hash of
+// the ECDH shared secret of the given private and public keys.
+func sessionCipher(privateKey, publicKey string, options *KeyOptions) (cipher.AEAD, error) {
+	keys, err := scriptKeys(privateKey, publicKey, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var output struct {
+		Secret []byte `json:"secret"`
+	}
+
+	err = exec("session.lua", keys, nil, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(output.Secret) == 0 {
+		return nil, errors.New("failed to compute shared secret")
+	}
+
+	key := sha256.Sum256(output.Secret)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	return aead, nil
+}
+
+// scriptKeys returns the keys passed to our scripts for the given key pair,
+// which are the curve along with both keys encoded as base64, as that is the
+// only encoding our scripts understand.
+func scriptKeys(privateKey, publicKey string, options *KeyOptions) (map[string]string, error) {
+	if options != nil {
+		err := options.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	secret, err := ConvertKey(privateKey, options.encoding(), Base64Encoding)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid private key")
+	}
+
+	public, err := ConvertKey(publicKey, options.encoding(), Base64Encoding)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+
+	return map[string]string{
+		"curve":  options.curve(),
+		"secret": secret,
+		"public": public,
+	}, nil
+}
+
+// exec runs the named Zenroom script, passing in the given keys marshalled to
+// JSON and the given data base64 encoded, and unmarshals the output of the
+// script into out.
+func exec(name string, keys interface{}, data []byte, out interface{}) error {
 	// read script from go-bindata asset
-	script, err := lua.Asset("generatekeys.lua")
+	script, err := lua.Asset(name)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to read zenroom script")
+		return errors.Wrap(err, "failed to read zenroom script")
+	}
+
+	var keysArg, dataArg []byte
+
+	if keys != nil {
+		b, err := json.Marshal(keys)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal zenroom keys")
+		}
+
+		keysArg = nullTerminate(b)
 	}
 
-	// execute zenroom, passing in the script cast to a string
-	keys, err := zenroom.Exec(script, nil, nil)
+	if data != nil {
+		dataArg = nullTerminate([]byte(base64.StdEncoding.EncodeToString(data)))
+	}
+
+	// execute zenroom, passing in the script as a C string
+	output, err := zenroom.Exec(nullTerminate(script), keysArg, dataArg)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to execute zenroom script")
+		return errors.Wrap(err, "failed to execute zenroom script")
 	}
 
-	// unmarshal the returned data into a struct
-	var keyPair KeyPair
-	err = json.Unmarshal(keys, &keyPair)
+	// unmarshal the returned data into the given value
+	err = json.Unmarshal(output, out)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal zenroom output")
+		return errors.Wrap(err, "failed to unmarshal zenroom output")
 	}
 
-	return &keyPair, nil
+	return nil
+}
+
+// nullTerminate returns a copy of b with a trailing null byte, as Zenroom reads
+// its arguments as C strings.
+func nullTerminate(b []byte) []byte {
+	terminated := make([]byte, len(b)+1)
+	copy(terminated, b)
+
+	return terminated
 }
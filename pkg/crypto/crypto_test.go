@@ -0,0 +1,277 @@
+package crypto_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+)
+
+func TestNewKeyPair(t *testing.T) {
+	keyPair, err := crypto.NewKeyPair(nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", keyPair.PrivateKey)
+	assert.NotEqual(t, "", keyPair.PublicKey)
+	assert.Equal(t, crypto.Ec25519Curve, keyPair.Curve)
+	assert.Equal(t, crypto.Base64Encoding, keyPair.Encoding)
+
+	_, err = base64.StdEncoding.DecodeString(keyPair.PublicKey)
+	assert.Nil(t, err)
+}
+
+func TestKeyOptions(t *testing.T) {
+	for _, curve := range crypto.Curves {
+		for _, encoding := range crypto.Encodings {
+			options := &crypto.KeyOptions{Curve: curve, Encoding: encoding}
+
+			t.Run(curve+"/"+encoding, func(t *testing.T) {
+				signer, err := crypto.NewKeyPair(options)
+				assert.Nil(t, err)
+				assert.Equal(t, curve, signer.Curve)
+				assert.Equal(t, encoding, signer.Encoding)
+
+				switch encoding {
+				case crypto.HexEncoding:
+					_, err = hex.DecodeString(signer.PublicKey)
+					assert.Nil(t, err)
+				case crypto.MultibaseEncoding:
+					assert.True(t, strings.HasPrefix(signer.PublicKey, "m"))
+				}
+
+				payload := []byte("hello")
+
+				signature, err := crypto.Sign(payload, signer.PrivateKey, options)
+				if !options.SupportsSignatures() {
+					assert.NotNil(t, err)
+					assert.Equal(t, "signatures are not supported on curve: "+curve, err.Error())
+					return
+				}
+				assert.Nil(t, err)
+
+				valid, err := crypto.Verify(payload, signature, signer.PublicKey, options)
+				assert.Nil(t, err)
+				assert.True(t, valid)
+			})
+		}
+	}
+
+	_, err := crypto.NewKeyPair(&crypto.KeyOptions{Curve: "secp256k1"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "unsupported curve: secp256k1", err.Error())
+
+	_, err = crypto.NewKeyPair(&crypto.KeyOptions{Encoding: "base58"})
+	assert.NotNil(t, err)
+	assert.Equal(t, "unsupported key encoding: base58", err.Error())
+}
+
+func TestConvertKey(t *testing.T) {
+	testcases := []struct {
+		label    string
+		key      string
+		from     string
+		to       string
+		expected string
+	}{
+		{
+			label:    "base64 to hex",
+			key:      "3q2+7w==",
+			from:     crypto.Base64Encoding,
+			to:       crypto.HexEncoding,
+			expected: "deadbeef",
+		},
+		{
+			label:    "hex to multibase",
+			key:      "deadbeef",
+			from:     crypto.HexEncoding,
+			to:       crypto.MultibaseEncoding,
+			expected: "m3q2+7w",
+		},
+		{
+			label:    "multibase hex to base64",
+			key:      "fdeadbeef",
+			from:     crypto.MultibaseEncoding,
+			to:       crypto.Base64Encoding,
+			expected: "3q2+7w==",
+		},
+		{
+			label:    "multibase padded base64 to hex",
+			key:      "M3q2+7w==",
+			from:     crypto.MultibaseEncoding,
+			to:       crypto.HexEncoding,
+			expected: "deadbeef",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			got, err := crypto.ConvertKey(tc.key, tc.from, tc.to)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+
+	_, err := crypto.ConvertKey("zabc", crypto.MultibaseEncoding, crypto.HexEncoding)
+	assert.NotNil(t, err)
+
+	_, err = crypto.ConvertKey("not hex", crypto.HexEncoding, crypto.Base64Encoding)
+	assert.NotNil(t, err)
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, curve := range crypto.SignatureCurves {
+		options := &crypto.KeyOptions{Curve: curve}
+
+		t.Run(curve, func(t *testing.T) {
+			signer, err := crypto.NewKeyPair(options)
+			assert.Nil(t, err)
+
+			other, err := crypto.NewKeyPair(options)
+			assert.Nil(t, err)
+
+			payload := []byte(`{"temperature": 21.5}`)
+
+			signature, err := crypto.Sign(payload, signer.PrivateKey, options)
+			assert.Nil(t, err)
+			assert.NotEqual(t, "", signature)
+
+			// only the signer's public key is needed to verify
+			valid, err := crypto.Verify(payload, signature, signer.PublicKey, options)
+			assert.Nil(t, err)
+			assert.True(t, valid)
+
+			valid, err = crypto.Verify([]byte(`{"temperature": 31.5}`), signature, signer.PublicKey, options)
+			assert.Nil(t, err)
+			assert.False(t, valid)
+
+			valid, err = crypto.Verify(payload, signature, other.PublicKey, options)
+			assert.Nil(t, err)
+			assert.False(t, valid)
+
+			// a public key cannot be used to sign
+			_, err = crypto.Sign(payload, signer.PublicKey, options)
+			assert.NotNil(t, err)
+
+			valid, err = crypto.Verify(payload, "", signer.PublicKey, options)
+			assert.Nil(t, err)
+			assert.False(t, valid)
+
+			valid, err = crypto.Verify(payload, "not a signature", signer.PublicKey, options)
+			assert.Nil(t, err)
+			assert.False(t, valid)
+
+			_, err = crypto.Verify(payload, signature, "AAAA", options)
+			assert.NotNil(t, err)
+
+			_, err = crypto.Sign([]byte{}, signer.PrivateKey, options)
+			assert.NotNil(t, err)
+
+			assert.Nil(t, crypto.ValidateSigningKey(signer.PublicKey, options))
+			assert.NotNil(t, crypto.ValidateSigningKey("AAAA", options))
+			assert.NotNil(t, crypto.ValidateSigningKey(signer.PublicKey, &crypto.KeyOptions{Curve: curve, Encoding: crypto.HexEncoding}))
+		})
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	sender, err := crypto.NewKeyPair(nil)
+	assert.Nil(t, err)
+
+	recipient, err := crypto.NewKeyPair(nil)
+	assert.Nil(t, err)
+
+	payload := []byte(`{"temperature": 21.5}`)
+
+	encrypted, err := crypto.Encrypt(payload, []byte("abc123"), sender.PrivateKey, recipient.PublicKey, nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", encrypted.Ciphertext)
+	assert.NotEqual(t, "", encrypted.IV)
+	assert.Equal(t, "YWJjMTIz", encrypted.Header)
+
+	decrypted, err := crypto.Decrypt(encrypted, recipient.PrivateKey, sender.PublicKey, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, decrypted)
+
+	// each encryption uses a new IV
+	other, err := crypto.Encrypt(payload, []byte("abc123"), sender.PrivateKey, recipient.PublicKey, nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, encrypted.IV, other.IV)
+
+	decrypted, err = crypto.Decrypt(other, recipient.PrivateKey, sender.PublicKey, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, decrypted)
+
+	// the payload does not decrypt with any other key pair
+	_, err = crypto.Decrypt(encrypted, sender.PrivateKey, sender.PublicKey, nil)
+	assert.NotNil(t, err)
+
+	_, err = crypto.Encrypt([]byte{}, []byte("abc123"), sender.PrivateKey, recipient.PublicKey, nil)
+	assert.NotNil(t, err)
+
+	_, err = crypto.Decrypt(&crypto.EncryptedPayload{}, recipient.PrivateKey, sender.PublicKey, nil)
+	assert.NotNil(t, err)
+}
+
+func TestDecryptTampered(t *testing.T) {
+	sender, err := crypto.NewKeyPair(nil)
+	assert.Nil(t, err)
+
+	recipient, err := crypto.NewKeyPair(nil)
+	assert.Nil(t, err)
+
+	payload := []byte(`{"temperature": 21.5}`)
+
+	encrypted, err := crypto.Encrypt(payload, []byte("abc123"), sender.PrivateKey, recipient.PublicKey, nil)
+	assert.Nil(t, err)
+
+	// flip the last bit of the given base64 encoded value
+	tamper := func(value string) string {
+		b, err := base64.StdEncoding.DecodeString(value)
+		assert.Nil(t, err)
+
+		b[len(b)-1] ^= 0x01
+
+		return base64.StdEncoding.EncodeToString(b)
+	}
+
+	testcases := []struct {
+		label     string
+		encrypted *crypto.EncryptedPayload
+	}{
+		{
+			label: "tampered ciphertext",
+			encrypted: &crypto.EncryptedPayload{
+				Ciphertext: tamper(encrypted.Ciphertext),
+				IV:         encrypted.IV,
+				Header:     encrypted.Header,
+			},
+		},
+		{
+			label: "tampered IV",
+			encrypted: &crypto.EncryptedPayload{
+				Ciphertext: encrypted.Ciphertext,
+				IV:         tamper(encrypted.IV),
+				Header:     encrypted.Header,
+			},
+		},
+		{
+			label: "tampered header",
+			encrypted: &crypto.EncryptedPayload{
+				Ciphertext: encrypted.Ciphertext,
+				IV:         encrypted.IV,
+				Header:     base64.StdEncoding.EncodeToString([]byte("def456")),
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := crypto.Decrypt(tc.encrypted, recipient.PrivateKey, sender.PublicKey, nil)
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "failed to decrypt payload")
+		})
+	}
+}
@@ -0,0 +1,262 @@
+// Package ec25519 implements key generation and signatures with key pairs on
+// the curve Zenroom calls ec25519, in pure Go so that it does not require cgo.
+// This is the twisted Edwards curve used by Ed25519, on which a private key is
+// a scalar less than the order of the base point encoded as 32 big endian
+// bytes, and a public key is the product of the private key and the base point
+// encoded as 0x04 followed by the big endian x and y coordinates.
+//
+// Signatures are standard Ed25519 signatures, so may be verified by any Ed25519
+// implementation given the public key in its compressed form. All curve
+// arithmetic is done by github.com/agl/ed25519, a port of the ref10
+// implementation of Ed25519, and this package only converts between its
+// encodings and those used by Zenroom.
+package ec25519
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"io"
+
+	"github.com/agl/ed25519"
+	"github.com/agl/ed25519/edwards25519"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PrivateKeySize is the size in bytes of an encoded private key.
+	PrivateKeySize = 32
+
+	// PublicKeySize is the size in bytes of an encoded public key.
+	PublicKeySize = 65
+
+	// CompressedPublicKeySize is the size in bytes of a public key in the
+	// compressed form used by Ed25519.
+	CompressedPublicKeySize = ed25519.PublicKeySize
+
+	// SignatureSize is the size in bytes of a signature.
+	SignatureSize = ed25519.SignatureSize
+)
+
+var (
+	// order is the little endian order of the base point.
+	order = [32]byte{
+		0xed, 0xd3, 0xf5, 0x5c, 0x1a, 0x63, 0x12, 0x58, 0xd6, 0x9c, 0xf7, 0xa2, 0xde, 0xf9, 0xde, 0x14,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10,
+	}
+
+	// identity is the compressed neutral element of the curve.
+	identity = [32]byte{0x01}
+)
+
+// PublicKey returns the public key matching the given private key.
+func PublicKey(privateKey []byte) ([]byte, error) {
+	scalar, err := unmarshalPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var point edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMultBase(&point, scalar)
+
+	return marshal(&point), nil
+}
+
+// GenerateKey returns a new key pair, with the private key read from rand.
+func GenerateKey(rand io.Reader) ([]byte, []byte, error) {
+	var wide [64]byte
+
+	_, err := io.ReadFull(rand, wide[:])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read random scalar")
+	}
+
+	// reducing 512 random bits modulo the order gives a scalar with negligible
+	// bias, in the same range as those generated by Zenroom
+	var scalar [32]byte
+	edwards25519.ScReduce(&scalar, &wide)
+
+	var point edwards25519.ExtendedGroupElement
+	edwards25519.GeScalarMultBase(&point, &scalar)
+
+	return reverse(scalar[:]), marshal(&point), nil
+}
+
+// ValidatePublicKey returns an error if the given public key, which may be
+// either in our encoding or compressed, is not a valid point in the prime order
+// subgroup.
+func ValidatePublicKey(publicKey []byte) error {
+	_, err := unmarshalPublicKey(publicKey)
+	return err
+}
+
+// Sign returns an Ed25519 signature over the given message, created with the
+// given private key. Where Ed25519 derives the nonce from a secret hashed from
+// the seed of the key, which a Zenroom private key does not have, we instead
+// hash the private key with 32 bytes read from rand.
+func Sign(rand io.Reader, privateKey, message []byte) ([]byte, error) {
+	scalar, err := unmarshalPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var entropy [32]byte
+
+	_, err = io.ReadFull(rand, entropy[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read random nonce")
+	}
+
+	var point edwards25519.ExtendedGroupElement
+	var publicKey [32]byte
+	edwards25519.GeScalarMultBase(&point, scalar)
+	point.ToBytes(&publicKey)
+
+	var digest [64]byte
+	h := sha512.New()
+	h.Write(entropy[:])
+	h.Write(scalar[:])
+	h.Write(message)
+	h.Sum(digest[:0])
+
+	var nonce, r [32]byte
+	edwards25519.ScReduce(&nonce, &digest)
+	edwards25519.GeScalarMultBase(&point, &nonce)
+	point.ToBytes(&r)
+
+	h.Reset()
+	h.Write(r[:])
+	h.Write(publicKey[:])
+	h.Write(message)
+	h.Sum(digest[:0])
+
+	var k, s [32]byte
+	edwards25519.ScReduce(&k, &digest)
+	edwards25519.ScMulAdd(&s, &k, scalar, &nonce)
+
+	return append(r[:], s[:]...), nil
+}
+
+// Verify returns true if the given Ed25519 signature over the given message was
+// created with the private key matching the given public key, which may be
+// either in our encoding or compressed. An error is returned only if the public
+// key is invalid, while a malformed signature is simply not valid.
+func Verify(publicKey, message, signature []byte) (bool, error) {
+	compressed, err := unmarshalPublicKey(publicKey)
+	if err != nil {
+		return false, err
+	}
+
+	if len(signature) != SignatureSize {
+		return false, nil
+	}
+
+	var sig [SignatureSize]byte
+	copy(sig[:], signature)
+
+	return ed25519.Verify(compressed, message, &sig), nil
+}
+
+// marshal encodes a point as 0x04 followed by its big endian affine
+// coordinates.
+func marshal(point *edwards25519.ExtendedGroupElement) []byte {
+	var recip, x, y edwards25519.FieldElement
+	var xBytes, yBytes [32]byte
+
+	edwards25519.FeInvert(&recip, &point.Z)
+	edwards25519.FeMul(&x, &point.X, &recip)
+	edwards25519.FeMul(&y, &point.Y, &recip)
+	edwards25519.FeToBytes(&xBytes, &x)
+	edwards25519.FeToBytes(&yBytes, &y)
+
+	encoded := make([]byte, 0, PublicKeySize)
+	encoded = append(encoded, 0x04)
+	encoded = append(encoded, reverse(xBytes[:])...)
+
+	return append(encoded, reverse(yBytes[:])...)
+}
+
+// unmarshalPublicKey decodes a public key in either our encoding or compressed
+// form, returning it compressed. The key is rejected unless it is a canonically
+// encoded point in the prime order subgroup.
+func unmarshalPublicKey(publicKey []byte) (*[32]byte, error) {
+	var compressed [32]byte
+	var x []byte
+
+	switch {
+	case len(publicKey) == PublicKeySize && publicKey[0] == 0x04:
+		// the compressed form is the little endian y coordinate, with the low
+		// bit of x in its top bit
+		copy(compressed[:], reverse(publicKey[33:]))
+		if compressed[31]&0x80 != 0 {
+			return nil, errors.New("invalid public key point")
+		}
+
+		x = reverse(publicKey[1:33])
+		compressed[31] |= (x[0] & 1) << 7
+	case len(publicKey) == CompressedPublicKeySize:
+		copy(compressed[:], publicKey)
+	default:
+		return nil, errors.New("invalid public key length or format")
+	}
+
+	var point edwards25519.ExtendedGroupElement
+	if !point.FromBytes(&compressed) {
+		return nil, errors.New("invalid public key point")
+	}
+
+	// decompression ignores out of range values, so we check that the key
+	// round trips, which for our encoding also checks the x coordinate
+	var check [32]byte
+	point.ToBytes(&check)
+	if check != compressed || (x != nil && !bytes.Equal(marshal(&point)[1:33], publicKey[1:33])) {
+		return nil, errors.New("invalid public key point")
+	}
+
+	// the key is in the prime order subgroup if the product of it and the
+	// order is the identity
+	var zero [32]byte
+	var product edwards25519.ProjectiveGroupElement
+	edwards25519.GeDoubleScalarMultVartime(&product, &order, &point, &zero)
+	product.ToBytes(&check)
+
+	if compressed == identity || check != identity {
+		return nil, errors.New("public key is not in the prime order subgroup")
+	}
+
+	return &compressed, nil
+}
+
+// unmarshalPrivateKey decodes a private key into a little endian scalar,
+// checking it is a valid scalar.
+func unmarshalPrivateKey(privateKey []byte) (*[32]byte, error) {
+	if len(privateKey) != PrivateKeySize {
+		return nil, errors.New("invalid private key length")
+	}
+
+	var scalar [32]byte
+	var wide [64]byte
+	copy(scalar[:], reverse(privateKey))
+	copy(wide[:], scalar[:])
+
+	// the scalar is less than the order only if reducing it leaves it
+	// unchanged
+	var reduced [32]byte
+	edwards25519.ScReduce(&reduced, &wide)
+
+	if reduced != scalar || reduced == [32]byte{} {
+		return nil, errors.New("invalid private key scalar")
+	}
+
+	return &scalar, nil
+}
+
+// reverse returns a copy of the given bytes in reverse order, used to convert
+// between little and big endian.
+func reverse(b []byte) []byte {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+
+	return reversed
+}
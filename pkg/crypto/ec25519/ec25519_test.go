@@ -0,0 +1,224 @@
+package ec25519_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto/ec25519"
+)
+
+func newKey(t *testing.T) ([]byte, []byte) {
+	// a scalar below 2^252 is always less than the order of the base point
+	privateKey := make([]byte, ec25519.PrivateKeySize)
+	_, err := rand.Read(privateKey)
+	assert.Nil(t, err)
+	privateKey[0] &= 0x0f
+
+	publicKey, err := ec25519.PublicKey(privateKey)
+	assert.Nil(t, err)
+
+	return privateKey, publicKey
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.Nil(t, err)
+
+	return b
+}
+
+func reverse(b []byte) []byte {
+	reversed := make([]byte, len(b))
+	for i := range b {
+		reversed[len(b)-1-i] = b[i]
+	}
+
+	return reversed
+}
+
+func TestGenerateKey(t *testing.T) {
+	privateKey, publicKey, err := ec25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	assert.Len(t, privateKey, ec25519.PrivateKeySize)
+	assert.Len(t, publicKey, ec25519.PublicKeySize)
+
+	// the public key is the product of the private key and the base point
+	expected, err := ec25519.PublicKey(privateKey)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, publicKey)
+
+	otherPrivateKey, _, err := ec25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	assert.NotEqual(t, privateKey, otherPrivateKey)
+
+	_, _, err = ec25519.GenerateKey(bytes.NewReader([]byte{0x01}))
+	assert.NotNil(t, err)
+}
+
+func TestSignVerify(t *testing.T) {
+	privateKey, publicKey := newKey(t)
+	_, otherPublicKey := newKey(t)
+
+	assert.Len(t, publicKey, ec25519.PublicKeySize)
+	assert.Equal(t, byte(0x04), publicKey[0])
+
+	message := []byte(`{"temperature": 21.5}`)
+
+	signature, err := ec25519.Sign(rand.Reader, privateKey, message)
+	assert.Nil(t, err)
+	assert.Len(t, signature, ec25519.SignatureSize)
+
+	valid, err := ec25519.Verify(publicKey, message, signature)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+
+	// each signature uses a new nonce
+	other, err := ec25519.Sign(rand.Reader, privateKey, message)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Equal(signature, other))
+
+	valid, err = ec25519.Verify(publicKey, message, other)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+
+	valid, err = ec25519.Verify(publicKey, []byte(`{"temperature": 31.5}`), signature)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+
+	valid, err = ec25519.Verify(otherPublicKey, message, signature)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+
+	tampered := append([]byte{}, signature...)
+	tampered[40] ^= 0x01
+
+	valid, err = ec25519.Verify(publicKey, message, tampered)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+
+	valid, err = ec25519.Verify(publicKey, message, signature[:32])
+	assert.Nil(t, err)
+	assert.False(t, valid)
+
+	valid, err = ec25519.Verify(publicKey, message, make([]byte, ec25519.SignatureSize))
+	assert.Nil(t, err)
+	assert.False(t, valid)
+}
+
+func TestZenroomKey(t *testing.T) {
+	// a key pair generated by Zenroom's ec25519 keygen
+	privateKey, err := base64.StdEncoding.DecodeString("BC8neRhMS1B+HUfd6Txa9ADxph22+KZBqZ2+ff6nIVc=")
+	assert.Nil(t, err)
+
+	expected, err := base64.StdEncoding.DecodeString("BGwg5+NZb7tsFh3sjJE2xct+4u5migULodBWJZjvE4QlGOJUkhS6ReZ9y065z1y8xR7UKaDBjuGMdrpzJl1eD/g=")
+	assert.Nil(t, err)
+
+	publicKey, err := ec25519.PublicKey(privateKey)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, publicKey)
+
+	signature, err := ec25519.Sign(rand.Reader, privateKey, []byte("hello"))
+	assert.Nil(t, err)
+
+	valid, err := ec25519.Verify(expected, []byte("hello"), signature)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
+
+func TestVerifyEd25519(t *testing.T) {
+	// test vector 2 from section 7.1 of RFC 8032, with the public key in both
+	// our encoding and compressed
+	message := []byte{0x72}
+	compressed := decodeHex(t, "3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c")
+	publicKey := decodeHex(t, "0474ad28205b4f384bc0813e6585864e528085f91fb6a5096f244ae01e57de43ae0c66f42af155cdc08c96c42ecf2c989cbc7e1b4da70ab7925a8943e8c317403d")
+	signature := decodeHex(t, "92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00")
+
+	for _, key := range [][]byte{publicKey, compressed} {
+		valid, err := ec25519.Verify(key, message, signature)
+		assert.Nil(t, err)
+		assert.True(t, valid)
+
+		valid, err = ec25519.Verify(key, []byte{0x73}, signature)
+		assert.Nil(t, err)
+		assert.False(t, valid)
+	}
+
+	// our signatures verify against the compressed key too
+	privateKey, publicKey := newKey(t)
+
+	signature, err := ec25519.Sign(rand.Reader, privateKey, message)
+	assert.Nil(t, err)
+
+	compressed = reverse(publicKey[33:])
+	compressed[31] |= (publicKey[32] & 1) << 7
+
+	valid, err := ec25519.Verify(compressed, message, signature)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
+
+func TestInvalidKeys(t *testing.T) {
+	_, publicKey := newKey(t)
+
+	offCurve := append([]byte{}, publicKey...)
+	offCurve[64] ^= 0x01
+
+	wrongX := append([]byte{}, publicKey...)
+	wrongX[32] ^= 0x02
+
+	// (0, -1) is on the curve, but has order 2
+	lowOrder := make([]byte, ec25519.PublicKeySize)
+	lowOrder[0] = 0x04
+	copy(lowOrder[33:], []byte{
+		0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xec,
+	})
+
+	testcases := []struct {
+		label       string
+		publicKey   []byte
+		expectedErr string
+	}{
+		{
+			label:       "too short",
+			publicKey:   publicKey[:33],
+			expectedErr: "invalid public key length or format",
+		},
+		{
+			label:       "off curve",
+			publicKey:   offCurve,
+			expectedErr: "invalid public key point",
+		},
+		{
+			label:       "wrong x coordinate",
+			publicKey:   wrongX,
+			expectedErr: "invalid public key point",
+		},
+		{
+			label:       "low order",
+			publicKey:   lowOrder,
+			expectedErr: "public key is not in the prime order subgroup",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := ec25519.Verify(tc.publicKey, []byte("hello"), make([]byte, ec25519.SignatureSize))
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+
+	_, err := ec25519.Sign(rand.Reader, make([]byte, ec25519.PrivateKeySize), []byte("hello"))
+	assert.NotNil(t, err)
+	assert.Equal(t, "invalid private key scalar", err.Error())
+
+	_, err = ec25519.PublicKey([]byte{0x01})
+	assert.NotNil(t, err)
+	assert.Equal(t, "invalid private key length", err.Error())
+}
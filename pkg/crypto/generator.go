@@ -0,0 +1,90 @@
+package crypto
+
+import (
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto/ec25519"
+)
+
+const (
+	// ZenroomBackend is the name of the key generation backend which generates
+	// key pairs using Zenroom. This is the default, and supports all Curves.
+	ZenroomBackend = "zenroom"
+
+	// NativeBackend is the name of the key generation backend which generates
+	// key pairs in pure Go using the ec25519 package, which does not require
+	// cgo. This supports only the ec25519 curve, for which it produces keys in
+	// exactly the format Zenroom does.
+	NativeBackend = "native"
+)
+
+// Backends is the list of supported key generation backends.
+var Backends = []string{
+	ZenroomBackend,
+	NativeBackend,
+}
+
+// KeyGenerator is the interface of something able to generate new key pairs
+// with the given options.
+type KeyGenerator interface {
+	NewKeyPair(options *KeyOptions) (*KeyPair, error)
+}
+
+// DefaultKeyGenerator is the KeyGenerator used when none is configured, which
+// generates key pairs using Zenroom.
+var DefaultKeyGenerator KeyGenerator = zenroomGenerator{}
+
+// NewKeyGenerator returns the KeyGenerator for the named backend, defaulting
+// to Zenroom if the name is empty.
+func NewKeyGenerator(backend string) (KeyGenerator, error) {
+	switch backend {
+	case "", ZenroomBackend:
+		return zenroomGenerator{}, nil
+	case NativeBackend:
+		return nativeGenerator{}, nil
+	default:
+		return nil, errors.Errorf("unknown key generation backend: %s", backend)
+	}
+}
+
+// zenroomGenerator is a KeyGenerator which generates key pairs by running our
+// key generation script within Zenroom.
+type zenroomGenerator struct{}
+
+// NewKeyPair generates a new key pair using Zenroom.
+func (g zenroomGenerator) NewKeyPair(options *KeyOptions) (*KeyPair, error) {
+	return NewKeyPair(options)
+}
+
+// nativeGenerator is a KeyGenerator which generates ec25519 key pairs in pure
+// Go, in exactly the format Zenroom does.
+type nativeGenerator struct{}
+
+// NewKeyPair generates a new ec25519 key pair in pure Go, returning an error
+// for any other curve.
+func (g nativeGenerator) NewKeyPair(options *KeyOptions) (*KeyPair, error) {
+	if options != nil {
+		err := options.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if options.curve() != Ec25519Curve {
+		return nil, errors.Errorf("native key generation does not support curve: %s", options.curve())
+	}
+
+	privateKey, publicKey, err := ec25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyPair{
+		PrivateKey: encodeKey(privateKey, options.encoding()),
+		PublicKey:  encodeKey(publicKey, options.encoding()),
+		Curve:      options.curve(),
+		Encoding:   options.encoding(),
+	}, nil
+}
@@ -0,0 +1,98 @@
+package crypto_test
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+)
+
+func TestNewKeyGenerator(t *testing.T) {
+	for _, backend := range append(crypto.Backends, "") {
+		generator, err := crypto.NewKeyGenerator(backend)
+		assert.Nil(t, err)
+		assert.NotNil(t, generator)
+	}
+
+	_, err := crypto.NewKeyGenerator("openssl")
+	assert.NotNil(t, err)
+	assert.Equal(t, "unknown key generation backend: openssl", err.Error())
+}
+
+func TestNativeKeyPair(t *testing.T) {
+	generator, err := crypto.NewKeyGenerator(crypto.NativeBackend)
+	assert.Nil(t, err)
+
+	keyPair, err := generator.NewKeyPair(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, crypto.Ec25519Curve, keyPair.Curve)
+	assert.Equal(t, crypto.Base64Encoding, keyPair.Encoding)
+
+	// keys have the same shape as those output by zenroom
+	privateKey, err := base64.StdEncoding.DecodeString(keyPair.PrivateKey)
+	assert.Nil(t, err)
+	assert.Len(t, privateKey, 32)
+
+	publicKey, err := base64.StdEncoding.DecodeString(keyPair.PublicKey)
+	assert.Nil(t, err)
+	assert.Len(t, publicKey, 65)
+	assert.Equal(t, byte(0x04), publicKey[0])
+
+	keyPair, err = generator.NewKeyPair(&crypto.KeyOptions{Encoding: crypto.HexEncoding})
+	assert.Nil(t, err)
+	assert.Equal(t, crypto.HexEncoding, keyPair.Encoding)
+
+	publicKey, err = hex.DecodeString(keyPair.PublicKey)
+	assert.Nil(t, err)
+	assert.Len(t, publicKey, 65)
+
+	_, err = generator.NewKeyPair(&crypto.KeyOptions{Curve: crypto.Nist256Curve})
+	assert.NotNil(t, err)
+	assert.Equal(t, "native key generation does not support curve: nist256", err.Error())
+
+	_, err = generator.NewKeyPair(&crypto.KeyOptions{Curve: "secp256k1"})
+	assert.NotNil(t, err)
+}
+
+// TestGeneratorsCrossCheck verifies that key pairs generated natively and by
+// zenroom agree on the shared secret of an ECDH exchange between them, as a
+// payload encrypted with one side of the exchange only decrypts using the
+// other.
+func TestGeneratorsCrossCheck(t *testing.T) {
+	native, err := crypto.NewKeyGenerator(crypto.NativeBackend)
+	assert.Nil(t, err)
+
+	zenroom, err := crypto.NewKeyGenerator(crypto.ZenroomBackend)
+	assert.Nil(t, err)
+
+	for _, encoding := range crypto.Encodings {
+		options := &crypto.KeyOptions{Encoding: encoding}
+
+		t.Run(encoding, func(t *testing.T) {
+			device, err := native.NewKeyPair(options)
+			assert.Nil(t, err)
+
+			user, err := zenroom.NewKeyPair(options)
+			assert.Nil(t, err)
+
+			payload := []byte(`{"temperature": 21.5}`)
+
+			encrypted, err := crypto.Encrypt(payload, []byte("abc123"), device.PrivateKey, user.PublicKey, options)
+			assert.Nil(t, err)
+
+			decrypted, err := crypto.Decrypt(encrypted, user.PrivateKey, device.PublicKey, options)
+			assert.Nil(t, err)
+			assert.Equal(t, payload, decrypted)
+
+			signature, err := crypto.Sign(payload, device.PrivateKey, options)
+			assert.Nil(t, err)
+
+			valid, err := crypto.Verify(payload, signature, device.PublicKey, options)
+			assert.Nil(t, err)
+			assert.True(t, valid)
+		})
+	}
+}
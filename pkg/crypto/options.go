@@ -0,0 +1,187 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Ec25519Curve is the name of the curve used for key pairs if no curve is
+	// configured, and the curve of all key pairs created before curves were
+	// configurable.
+	Ec25519Curve = "ec25519"
+
+	// Nist256Curve is the name of the NIST P-256 curve.
+	Nist256Curve = "nist256"
+
+	// Base64Encoding is the name of the encoding used for keys if no encoding is
+	// configured, and the encoding of all keys created before encodings were
+	// configurable. Keys are encoded using standard padded base64.
+	Base64Encoding = "base64"
+
+	// HexEncoding is the name of the encoding which encodes keys as lower case
+	// hexadecimal.
+	HexEncoding = "hex"
+
+	// MultibaseEncoding is the name of the encoding which encodes keys as
+	// multibase strings. We encode using unpadded base64, so keys are prefixed
+	// with "m", but are able to decode keys encoded with any of the base64 or
+	// hexadecimal multibase encodings.
+	MultibaseEncoding = "multibase"
+)
+
+// Curves is the list of curves supported for key pairs. Device key pairs are
+// used by the encoder to encrypt data via Zenroom, so we only support curves
+// offered by the versions of Zenroom bundled both here and by the encoder.
+// Neither offers secp256k1 or any BLS curve, so those require Zenroom to be
+// upgraded in both before they can be added.
+var Curves = []string{
+	Ec25519Curve,
+	Nist256Curve,
+}
+
+// SignatureCurves is the list of curves on which we support signatures, being
+// those for which we are able to sign in Go, as the version of Zenroom we
+// bundle does not offer it.
+var SignatureCurves = []string{
+	Ec25519Curve,
+	Nist256Curve,
+}
+
+// Encodings is the list of encodings supported for keys.
+var Encodings = []string{
+	Base64Encoding,
+	HexEncoding,
+	MultibaseEncoding,
+}
+
+// KeyOptions describes the curve and encoding of a key pair. Empty fields are
+// treated as the defaults of ec25519 and base64, and a nil *KeyOptions as
+// entirely default.
+type KeyOptions struct {
+	// Curve is the name of the elliptic curve of the key pair, and must be one of
+	// Curves.
+	Curve string
+
+	// Encoding is the name of the encoding of the key pair, and must be one of
+	// Encodings.
+	Encoding string
+}
+
+// Validate returns an error if the curve or encoding is not supported.
+func (o *KeyOptions) Validate() error {
+	if !contains(Curves, o.curve()) {
+		return errors.Errorf("unsupported curve: %s", o.Curve)
+	}
+
+	if !contains(Encodings, o.encoding()) {
+		return errors.Errorf("unsupported key encoding: %s", o.Encoding)
+	}
+
+	return nil
+}
+
+// SupportsSignatures returns true if the curve is one of SignatureCurves.
+func (o *KeyOptions) SupportsSignatures() bool {
+	return contains(SignatureCurves, o.curve())
+}
+
+// curve returns the curve, or the default curve if none is set.
+func (o *KeyOptions) curve() string {
+	if o == nil || o.Curve == "" {
+		return Ec25519Curve
+	}
+	return o.Curve
+}
+
+// encoding returns the encoding, or the default encoding if none is set.
+func (o *KeyOptions) encoding() string {
+	if o == nil || o.Encoding == "" {
+		return Base64Encoding
+	}
+	return o.Encoding
+}
+
+// ConvertKey re-encodes the given key from one encoding to another.
+func ConvertKey(key, from, to string) (string, error) {
+	b, err := decodeKey(key, from)
+	if err != nil {
+		return "", err
+	}
+
+	return encodeKey(b, to), nil
+}
+
+// encodeKey encodes the given raw key with the named encoding, which must be
+// valid.
+func encodeKey(key []byte, encoding string) string {
+	switch encoding {
+	case HexEncoding:
+		return hex.EncodeToString(key)
+	case MultibaseEncoding:
+		return "m" + base64.RawStdEncoding.EncodeToString(key)
+	default:
+		return base64.StdEncoding.EncodeToString(key)
+	}
+}
+
+// decodeKey decodes the given key encoded with the named encoding, where an
+// empty name means the default encoding.
+func decodeKey(key, encoding string) ([]byte, error) {
+	var (
+		b   []byte
+		err error
+	)
+
+	switch encoding {
+	case "", Base64Encoding:
+		b, err = base64.StdEncoding.DecodeString(key)
+	case HexEncoding:
+		b, err = hex.DecodeString(key)
+	case MultibaseEncoding:
+		if key == "" {
+			return nil, errors.New("empty multibase key")
+		}
+
+		switch key[0] {
+		case 'm':
+			b, err = base64.RawStdEncoding.DecodeString(key[1:])
+		case 'M':
+			b, err = base64.StdEncoding.DecodeString(key[1:])
+		case 'u':
+			b, err = base64.RawURLEncoding.DecodeString(key[1:])
+		case 'U':
+			b, err = base64.URLEncoding.DecodeString(key[1:])
+		case 'f', 'F':
+			b, err = hex.DecodeString(key[1:])
+		default:
+			return nil, errors.Errorf("unsupported multibase prefix: %c", key[0])
+		}
+	default:
+		return nil, errors.Errorf("unsupported key encoding: %s", encoding)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode key")
+	}
+
+	return b, nil
+}
+
+// contains returns true if the given slice contains the given value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal returns true if both options describe the same curve and encoding,
+// once defaults are taken into account.
+func (o *KeyOptions) Equal(other *KeyOptions) bool {
+	return o.curve() == other.curve() && o.encoding() == other.encoding()
+}
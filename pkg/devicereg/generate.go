@@ -0,0 +1,7 @@
+package devicereg
+
+// The protocol buffer and Twirp code in this package is generated from
+// devicereg.proto using protoc along with protoc-gen-go and protoc-gen-twirp
+// v5.3.0, and must be regenerated via go generate after any change to it.
+
+//go:generate protoc --go_out=. --twirp_out=. devicereg.proto
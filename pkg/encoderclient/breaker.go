@@ -0,0 +1,127 @@
+package encoderclient
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// closed is the state of a breaker allowing all calls through.
+	closed = iota
+
+	// halfOpen is the state of a breaker which has been open for its cooldown,
+	// and is allowing a single probe call through to test whether the encoder
+	// has recovered.
+	halfOpen
+
+	// open is the state of a breaker rejecting all calls.
+	open
+)
+
+// breaker is a simple circuit breaker. It opens after threshold consecutive
+// failures, rejecting calls until cooldown has elapsed, after which a single
+// call is allowed through. If that call succeeds the breaker closes, otherwise
+// it opens again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// onStateChange is called with the new state whenever the state changes,
+	// while the breaker's lock is held.
+	onStateChange func(state int)
+}
+
+// newBreaker returns a new closed breaker.
+func newBreaker(threshold int, cooldown time.Duration, onStateChange func(state int)) *breaker {
+	return &breaker{
+		threshold:     threshold,
+		cooldown:      cooldown,
+		now:           time.Now,
+		onStateChange: onStateChange,
+	}
+}
+
+// allow returns true if a call may be made. A caller that is allowed must
+// report the outcome of its call via success or failure.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case open:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+
+		b.setState(halfOpen)
+		b.probing = true
+
+		return true
+	case halfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+
+		return true
+	default:
+		return true
+	}
+}
+
+// success records a successful call, closing the breaker.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+	b.setState(closed)
+}
+
+// failure records a failed call, opening the breaker if the call was a probe
+// or we have now seen threshold consecutive failures.
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+
+	if b.state == halfOpen || b.failures >= b.threshold {
+		b.probing = false
+		b.openedAt = b.now()
+		b.setState(open)
+	}
+}
+
+// release records a call whose outcome tells us nothing about the health of
+// the encoder, such as one cancelled by the caller. If the call was a probe,
+// another probe is allowed.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// setState changes the state of the breaker, notifying our callback if it
+// differs from the current state.
+func (b *breaker) setState(state int) {
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
@@ -0,0 +1,310 @@
+package encoderclient
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+)
+
+const (
+	// DefaultTimeout is the default maximum time we allow for a single attempt
+	// to call the encoder.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultMaxAttempts is the default number of times we attempt a call to
+	// the encoder before giving up.
+	DefaultMaxAttempts = 3
+
+	// DefaultInitialBackoff is the default interval we wait before retrying a
+	// failed call. The interval doubles after each subsequent failure.
+	DefaultInitialBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the default maximum interval we wait between
+	// attempts.
+	DefaultMaxBackoff = 2 * time.Second
+
+	// DefaultBreakerThreshold is the default number of consecutive failed calls
+	// after which the circuit breaker opens.
+	DefaultBreakerThreshold = 5
+
+	// DefaultBreakerCooldown is the default interval for which the circuit
+	// breaker stays open before allowing a call through to test whether the
+	// encoder has recovered.
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+var (
+	// breakerState is a prometheus gauge recording the state of the encoder
+	// circuit breaker.
+	breakerState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "decode_encoder_breaker_state",
+			Help: "State of the encoder circuit breaker: 0 closed, 1 half open, 2 open",
+		},
+	)
+
+	// breakerRejections is a prometheus counter recording calls to the encoder
+	// rejected by the open circuit breaker keyed by method.
+	breakerRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_encoder_breaker_rejections",
+			Help: "Counter of calls to the encoder rejected by the open circuit breaker",
+		},
+		[]string{
+			// which method are we calling
+			"method",
+		},
+	)
+
+	// encoderRetries is a prometheus counter recording retried calls to the
+	// encoder keyed by method.
+	encoderRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_encoder_retries",
+			Help: "Counter of retried calls to the encoder",
+		},
+		[]string{
+			// which method are we calling
+			"method",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(breakerState)
+	prometheus.MustRegister(breakerRejections)
+	prometheus.MustRegister(encoderRetries)
+}
+
+// Config is used to inject the wrapped encoder client and configuration into
+// our client. Any zero valued durations or counts are replaced with the
+// package defaults.
+type Config struct {
+	EncoderClient    encoder.Encoder
+	Timeout          time.Duration
+	MaxAttempts      int
+	InitialBackoff   time.Duration
+	MaxBackoff       time.Duration
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// Client is a decorator of an encoder client making calls resilient to the
+// encoder restarting or becoming briefly unavailable. Each attempt is given its
+// own deadline, and failed attempts are retried with an exponential backoff.
+// DeleteStream is idempotent so is retried after any transient failure, but
+// CreateStream is only retried if the request never reached the encoder, as
+// otherwise a retry could create a duplicate stream.
+//
+// Consecutive transient failures open a circuit breaker, after which calls
+// fail fast with a twirp.Unavailable error rather than waiting on an encoder
+// that is down.
+type Client struct {
+	encoderClient  encoder.Encoder
+	timeout        time.Duration
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	breaker        *breaker
+	logger         kitlog.Logger
+}
+
+// NewClient returns a new Client wrapping the encoder client in the given
+// config.
+func NewClient(config *Config, logger kitlog.Logger) *Client {
+	logger = kitlog.With(logger, "module", "encoderclient")
+
+	c := &Client{
+		encoderClient:  config.EncoderClient,
+		timeout:        config.Timeout,
+		maxAttempts:    config.MaxAttempts,
+		initialBackoff: config.InitialBackoff,
+		maxBackoff:     config.MaxBackoff,
+		logger:         logger,
+	}
+
+	if c.timeout == 0 {
+		c.timeout = DefaultTimeout
+	}
+
+	if c.maxAttempts == 0 {
+		c.maxAttempts = DefaultMaxAttempts
+	}
+
+	if c.initialBackoff == 0 {
+		c.initialBackoff = DefaultInitialBackoff
+	}
+
+	if c.maxBackoff == 0 {
+		c.maxBackoff = DefaultMaxBackoff
+	}
+
+	threshold := config.BreakerThreshold
+	if threshold == 0 {
+		threshold = DefaultBreakerThreshold
+	}
+
+	cooldown := config.BreakerCooldown
+	if cooldown == 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+
+	logger.Log("msg", "creating encoder client", "timeout", c.timeout, "maxAttempts", c.maxAttempts, "breakerThreshold", threshold)
+
+	c.breaker = newBreaker(threshold, cooldown, func(state int) {
+		breakerState.Set(float64(state))
+		logger.Log("msg", "encoder circuit breaker changed state", "state", stateNames[state])
+	})
+
+	breakerState.Set(closed)
+
+	return c
+}
+
+// stateNames contains a name for each breaker state, used when logging.
+var stateNames = map[int]string{
+	closed:   "closed",
+	halfOpen: "half_open",
+	open:     "open",
+}
+
+// CreateStream is our implementation of the method defined on the encoder
+// interface. It is only retried if the request never reached the encoder.
+func (c *Client) CreateStream(ctx context.Context, req *encoder.CreateStreamRequest) (*encoder.CreateStreamResponse, error) {
+	var resp *encoder.CreateStreamResponse
+
+	err := c.call(ctx, "CreateStream", isNotSent, func(ctx context.Context) error {
+		var err error
+		resp, err = c.encoderClient.CreateStream(ctx, req)
+		return err
+	})
+
+	return resp, err
+}
+
+// DeleteStream is our implementation of the method defined on the encoder
+// interface. It is retried after any transient failure.
+func (c *Client) DeleteStream(ctx context.Context, req *encoder.DeleteStreamRequest) (*encoder.DeleteStreamResponse, error) {
+	var resp *encoder.DeleteStreamResponse
+
+	err := c.call(ctx, "DeleteStream", isTransient, func(ctx context.Context) error {
+		var err error
+		resp, err = c.encoderClient.DeleteStream(ctx, req)
+		return err
+	})
+
+	return resp, err
+}
+
+// call makes up to maxAttempts attempts to call fn, each with its own
+// deadline, while the given retryable function returns true for the error of
+// the previous attempt. Every attempt must be allowed by our circuit breaker,
+// and its outcome is recorded by the breaker.
+func (c *Client) call(ctx context.Context, method string, retryable func(error) bool, fn func(ctx context.Context) error) error {
+	backoff := c.initialBackoff
+
+	for attempt := 1; ; attempt++ {
+		if !c.breaker.allow() {
+			breakerRejections.WithLabelValues(method).Inc()
+			return twirp.NewError(twirp.Unavailable, "encoder is unavailable")
+		}
+
+		err := c.attempt(ctx, fn)
+		if err == nil {
+			c.breaker.success()
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			// the caller gave up, which tells us nothing about the encoder
+			c.breaker.release()
+			return err
+		}
+
+		if !isTransient(err) {
+			// the encoder responded, so is available
+			c.breaker.success()
+			return err
+		}
+
+		c.breaker.failure()
+
+		if attempt >= c.maxAttempts || !retryable(err) {
+			return err
+		}
+
+		encoderRetries.WithLabelValues(method).Inc()
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff = backoff * 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// attempt calls fn once with a context limited by our timeout.
+func (c *Client) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	return fn(ctx)
+}
+
+// IsNotFound returns true if the given error is a twirp error with a not found
+// code, as returned by the encoder when asked to delete a stream it does not
+// have.
+func IsNotFound(err error) bool {
+	if twerr, ok := err.(twirp.Error); ok {
+		return twerr.Code() == twirp.NotFound
+	}
+
+	return false
+}
+
+// isTransient returns true if the given error indicates the encoder could not
+// be reached or failed to handle the call, rather than that it rejected the
+// call.
+func isTransient(err error) bool {
+	twerr, ok := err.(twirp.Error)
+	if !ok {
+		return true
+	}
+
+	switch twerr.Code() {
+	case twirp.Unavailable, twirp.Internal, twirp.Unknown, twirp.DeadlineExceeded, twirp.Canceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// isNotSent returns true if the given error shows that the request was never
+// sent to the encoder, because we failed to connect to it.
+func isNotSent(err error) bool {
+	// the twirp client wraps the error returned by the HTTP client, which is a
+	// *url.Error wrapping the error from the underlying connection
+	cause := errors.Cause(err)
+
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = urlErr.Err
+	}
+
+	if opErr, ok := cause.(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+
+	return false
+}
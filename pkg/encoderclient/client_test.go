@@ -0,0 +1,184 @@
+package encoderclient_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/encoderclient"
+	"github.com/thingful/iotdevicereg/pkg/mocks"
+)
+
+// dialError is the error returned by the encoder client when the encoder
+// cannot be connected to.
+var dialError = twirp.InternalErrorWith(&url.Error{
+	Op:  "Post",
+	URL: "http://encoder/twirp/encoder.Encoder/CreateStream",
+	Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+})
+
+func newClient(encoderClient encoder.Encoder) *encoderclient.Client {
+	return encoderclient.NewClient(&encoderclient.Config{
+		EncoderClient:    encoderClient,
+		Timeout:          time.Second,
+		MaxAttempts:      3,
+		InitialBackoff:   time.Millisecond,
+		MaxBackoff:       time.Millisecond,
+		BreakerThreshold: 5,
+		BreakerCooldown:  20 * time.Millisecond,
+	}, kitlog.NewNopLogger())
+}
+
+// hasDeadline matches a context with a deadline, showing the call was given a
+// timeout.
+var hasDeadline = mock.MatchedBy(func(ctx context.Context) bool {
+	_, ok := ctx.Deadline()
+	return ok
+})
+
+func TestDeleteStreamRetries(t *testing.T) {
+	encoderClient := new(mocks.Encoder)
+	req := &encoder.DeleteStreamRequest{StreamUid: "abc"}
+
+	encoderClient.On("DeleteStream", hasDeadline, req).Return(&encoder.DeleteStreamResponse{}, twirp.NewError(twirp.Unavailable, "restarting")).Once()
+	encoderClient.On("DeleteStream", hasDeadline, req).Return(&encoder.DeleteStreamResponse{}, nil).Once()
+
+	_, err := newClient(encoderClient).DeleteStream(context.Background(), req)
+	assert.Nil(t, err)
+
+	encoderClient.AssertNumberOfCalls(t, "DeleteStream", 2)
+}
+
+func TestDeleteStreamGivesUp(t *testing.T) {
+	encoderClient := new(mocks.Encoder)
+	req := &encoder.DeleteStreamRequest{StreamUid: "abc"}
+
+	encoderClient.On("DeleteStream", hasDeadline, req).Return(&encoder.DeleteStreamResponse{}, twirp.NewError(twirp.Unavailable, "restarting"))
+
+	_, err := newClient(encoderClient).DeleteStream(context.Background(), req)
+	assert.NotNil(t, err)
+
+	encoderClient.AssertNumberOfCalls(t, "DeleteStream", 3)
+}
+
+func TestNonTransientErrorsAreNotRetried(t *testing.T) {
+	encoderClient := new(mocks.Encoder)
+	req := &encoder.DeleteStreamRequest{StreamUid: "abc"}
+
+	encoderClient.On("DeleteStream", hasDeadline, req).Return(&encoder.DeleteStreamResponse{}, twirp.NotFoundError("stream not found"))
+
+	_, err := newClient(encoderClient).DeleteStream(context.Background(), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.NotFound, err.(twirp.Error).Code())
+
+	encoderClient.AssertNumberOfCalls(t, "DeleteStream", 1)
+}
+
+func TestCreateStreamRetries(t *testing.T) {
+	testcases := []struct {
+		label         string
+		err           error
+		expectedCalls int
+	}{
+		{
+			label:         "request not sent",
+			err:           dialError,
+			expectedCalls: 3,
+		},
+		{
+			label:         "request failed",
+			err:           twirp.InternalError("failed to create stream"),
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			encoderClient := new(mocks.Encoder)
+			req := &encoder.CreateStreamRequest{DeviceTopic: "device/sck/abc/readings"}
+
+			encoderClient.On("CreateStream", hasDeadline, req).Return(&encoder.CreateStreamResponse{}, tc.err)
+
+			_, err := newClient(encoderClient).CreateStream(context.Background(), req)
+			assert.NotNil(t, err)
+
+			encoderClient.AssertNumberOfCalls(t, "CreateStream", tc.expectedCalls)
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	encoderClient := new(mocks.Encoder)
+	req := &encoder.CreateStreamRequest{DeviceTopic: "device/sck/abc/readings"}
+
+	encoderClient.On("CreateStream", mock.Anything, req).Return(&encoder.CreateStreamResponse{}, dialError).Times(5)
+
+	client := newClient(encoderClient)
+
+	// the breaker opens during the second call's retries
+	for i := 0; i < 2; i++ {
+		_, err := client.CreateStream(context.Background(), req)
+		assert.NotNil(t, err)
+	}
+
+	encoderClient.AssertNumberOfCalls(t, "CreateStream", 5)
+
+	// while open calls fail fast without reaching the encoder
+	_, err := client.CreateStream(context.Background(), req)
+	assert.NotNil(t, err)
+	assert.Equal(t, twirp.Unavailable, err.(twirp.Error).Code())
+
+	encoderClient.AssertNumberOfCalls(t, "CreateStream", 5)
+
+	// once the cooldown has elapsed a call is allowed through, and its success
+	// closes the breaker
+	time.Sleep(30 * time.Millisecond)
+
+	encoderClient.On("CreateStream", mock.Anything, req).Return(&encoder.CreateStreamResponse{StreamUid: "abc"}, nil)
+
+	resp, err := client.CreateStream(context.Background(), req)
+	assert.Nil(t, err)
+	assert.Equal(t, "abc", resp.StreamUid)
+
+	resp, err = client.CreateStream(context.Background(), req)
+	assert.Nil(t, err)
+
+	encoderClient.AssertNumberOfCalls(t, "CreateStream", 7)
+}
+
+func TestFailedProbeReopensBreaker(t *testing.T) {
+	encoderClient := new(mocks.Encoder)
+	req := &encoder.DeleteStreamRequest{StreamUid: "abc"}
+
+	encoderClient.On("DeleteStream", mock.Anything, req).Return(&encoder.DeleteStreamResponse{}, twirp.NewError(twirp.Unavailable, "restarting"))
+
+	client := encoderclient.NewClient(&encoderclient.Config{
+		EncoderClient:    encoderClient,
+		MaxAttempts:      1,
+		BreakerThreshold: 1,
+		BreakerCooldown:  20 * time.Millisecond,
+	}, kitlog.NewNopLogger())
+
+	_, err := client.DeleteStream(context.Background(), req)
+	assert.NotNil(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+
+	// the probe fails so the breaker opens again immediately
+	_, err = client.DeleteStream(context.Background(), req)
+	assert.NotNil(t, err)
+
+	_, err = client.DeleteStream(context.Background(), req)
+	assert.Equal(t, twirp.Unavailable, err.(twirp.Error).Code())
+
+	encoderClient.AssertNumberOfCalls(t, "DeleteStream", 2)
+}
@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+// dateLayout is the layout of dates accepted by ParseTime in addition to
+// RFC3339 timestamps.
+const dateLayout = "2006-01-02"
+
+// ParseTime parses either an RFC3339 timestamp or a date, which is taken to
+// mean midnight UTC at the start of that day.
+func ParseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, nil
+	}
+
+	t, err = time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time, must be a date or RFC3339 timestamp: %s", value)
+	}
+
+	return t, nil
+}
+
+// ParseBoundingBox parses a bounding box given as four comma separated
+// decimals in the order minimum longitude, minimum latitude, maximum longitude
+// and maximum latitude.
+func ParseBoundingBox(value string) (*postgres.BoundingBox, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 4 {
+		return nil, errors.Errorf("invalid bounding box, must be min_lon,min_lat,max_lon,max_lat: %s", value)
+	}
+
+	coords := make([]float64, len(parts))
+
+	for i, part := range parts {
+		coord, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid bounding box coordinate: %s", part)
+		}
+
+		coords[i] = coord
+	}
+
+	box := &postgres.BoundingBox{
+		MinLongitude: coords[0],
+		MinLatitude:  coords[1],
+		MaxLongitude: coords[2],
+		MaxLatitude:  coords[3],
+	}
+
+	if box.MinLongitude < -180 || box.MaxLongitude > 180 || box.MinLatitude < -90 || box.MaxLatitude > 90 {
+		return nil, errors.Errorf("invalid bounding box, coordinates out of range: %s", value)
+	}
+
+	if box.MinLongitude > box.MaxLongitude || box.MinLatitude > box.MaxLatitude {
+		return nil, errors.Errorf("invalid bounding box, minimum exceeds maximum: %s", value)
+	}
+
+	return box, nil
+}
@@ -0,0 +1,40 @@
+package exporter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/exporter"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+func TestParseTime(t *testing.T) {
+	got, err := exporter.ParseTime("2018-06-21")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2018, 6, 21, 0, 0, 0, 0, time.UTC), got)
+
+	got, err = exporter.ParseTime("2018-06-21T10:30:00+02:00")
+	assert.Nil(t, err)
+	assert.True(t, time.Date(2018, 6, 21, 8, 30, 0, 0, time.UTC).Equal(got))
+
+	_, err = exporter.ParseTime("yesterday")
+	assert.NotNil(t, err)
+}
+
+func TestParseBoundingBox(t *testing.T) {
+	box, err := exporter.ParseBoundingBox("2.05, 41.3,2.25,41.47")
+	assert.Nil(t, err)
+	assert.Equal(t, &postgres.BoundingBox{
+		MinLongitude: 2.05,
+		MinLatitude:  41.3,
+		MaxLongitude: 2.25,
+		MaxLatitude:  41.47,
+	}, box)
+
+	for _, value := range []string{"2.05,41.3,2.25", "a,41.3,2.25,41.47", "2.25,41.3,2.05,41.47", "2.05,41.3,2.25,91"} {
+		_, err = exporter.ParseBoundingBox(value)
+		assert.NotNil(t, err, value)
+	}
+}
@@ -0,0 +1,227 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+const (
+	// CSVFormat is the name of the CSV output format, in which the first row is
+	// a header naming the columns, and the stream uids of each device are joined
+	// with semicolons.
+	CSVFormat = "csv"
+
+	// JSONLinesFormat is the name of the output format with one JSON object per
+	// device on each line.
+	JSONLinesFormat = "jsonl"
+
+	// OmitPrivateKeys is the name of the private key mode in which private keys
+	// are not output at all. This is the default.
+	OmitPrivateKeys = "omit"
+
+	// RedactPrivateKeys is the name of the private key mode in which private key
+	// columns are output, but with the value Redacted in place of every key. This
+	// shows that a key is held without revealing it.
+	RedactPrivateKeys = "redact"
+
+	// Redacted is the value output in place of private keys when redacting.
+	Redacted = "REDACTED"
+)
+
+// Formats is the list of supported output formats.
+var Formats = []string{
+	CSVFormat,
+	JSONLinesFormat,
+}
+
+// PrivateKeyModes is the list of supported private key modes.
+var PrivateKeyModes = []string{
+	OmitPrivateKeys,
+	RedactPrivateKeys,
+}
+
+// Record is a single exported device along with its owner and streams. The
+// device_token, user_uid, latitude, longitude, disposition and broker fields
+// match the columns read by the import command, so an export may be imported
+// into another registry.
+type Record struct {
+	DeviceToken       string   `json:"device_token"`
+	UserUID           string   `json:"user_uid"`
+	Latitude          float64  `json:"latitude"`
+	Longitude         float64  `json:"longitude"`
+	Disposition       string   `json:"disposition"`
+	Broker            string   `json:"broker"`
+	DevicePublicKey   string   `json:"device_public_key"`
+	DevicePrivateKey  string   `json:"device_private_key,omitempty"`
+	DeviceKeyCurve    string   `json:"device_key_curve"`
+	DeviceKeyEncoding string   `json:"device_key_encoding"`
+	UserPublicKey     string   `json:"user_public_key"`
+	UserPrivateKey    string   `json:"user_private_key,omitempty"`
+	UserKeyCurve      string   `json:"user_key_curve"`
+	UserKeyEncoding   string   `json:"user_key_encoding"`
+	StreamUIDs        []string `json:"stream_uids"`
+	CreatedAt         string   `json:"created_at"`
+	UpdatedAt         string   `json:"updated_at"`
+}
+
+// NewRecord returns the record for the given device, which must have its user
+// populated. If redact is true the private key fields are set to Redacted.
+func NewRecord(device *postgres.Device, redact bool) *Record {
+	record := &Record{
+		DeviceToken:       device.Token,
+		UserUID:           device.User.UID,
+		Latitude:          device.Latitude,
+		Longitude:         device.Longitude,
+		Disposition:       device.Disposition,
+		Broker:            device.Broker,
+		DevicePublicKey:   device.PublicKey,
+		DeviceKeyCurve:    device.KeyCurve,
+		DeviceKeyEncoding: device.KeyEncoding,
+		UserPublicKey:     device.User.PublicKey,
+		UserKeyCurve:      device.User.KeyCurve,
+		UserKeyEncoding:   device.User.KeyEncoding,
+		StreamUIDs:        make([]string, 0, len(device.Streams)),
+		CreatedAt:         device.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:         device.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+
+	if redact {
+		record.DevicePrivateKey = Redacted
+		record.UserPrivateKey = Redacted
+	}
+
+	for _, stream := range device.Streams {
+		record.StreamUIDs = append(record.StreamUIDs, stream.UID)
+	}
+
+	return record
+}
+
+// Writer is the interface of a writer of records in some format. Close must
+// be called once all records are written to flush any buffered output.
+type Writer interface {
+	Write(record *Record) error
+	Close() error
+}
+
+// NewWriter returns a Writer writing records in the given format. If redact is
+// true the output includes the private key fields, which should only ever
+// contain Redacted.
+func NewWriter(w io.Writer, format string, redact bool) (Writer, error) {
+	switch format {
+	case CSVFormat:
+		return &csvWriter{writer: csv.NewWriter(w), redact: redact}, nil
+	case JSONLinesFormat:
+		return &jsonLinesWriter{encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, errors.Errorf("unknown export format: %s", format)
+	}
+}
+
+// csvWriter writes records as CSV, writing the header before the first record.
+type csvWriter struct {
+	writer      *csv.Writer
+	redact      bool
+	wroteHeader bool
+}
+
+// Write writes a single record.
+func (c *csvWriter) Write(record *Record) error {
+	if !c.wroteHeader {
+		err := c.writer.Write(c.header())
+		if err != nil {
+			return errors.Wrap(err, "failed to write CSV header")
+		}
+
+		c.wroteHeader = true
+	}
+
+	row := []string{
+		record.DeviceToken,
+		record.UserUID,
+		strconv.FormatFloat(record.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(record.Longitude, 'f', -1, 64),
+		record.Disposition,
+		record.Broker,
+		record.DevicePublicKey,
+		record.DeviceKeyCurve,
+		record.DeviceKeyEncoding,
+		record.UserPublicKey,
+		record.UserKeyCurve,
+		record.UserKeyEncoding,
+		strings.Join(record.StreamUIDs, ";"),
+		record.CreatedAt,
+		record.UpdatedAt,
+	}
+
+	if c.redact {
+		row = append(row, record.DevicePrivateKey, record.UserPrivateKey)
+	}
+
+	return errors.Wrap(c.writer.Write(row), "failed to write CSV row")
+}
+
+// Close flushes any buffered rows, writing the header if no records were
+// written so that the output is always valid CSV.
+func (c *csvWriter) Close() error {
+	if !c.wroteHeader {
+		err := c.writer.Write(c.header())
+		if err != nil {
+			return errors.Wrap(err, "failed to write CSV header")
+		}
+	}
+
+	c.writer.Flush()
+
+	return errors.Wrap(c.writer.Error(), "failed to flush CSV")
+}
+
+// header returns the CSV header row.
+func (c *csvWriter) header() []string {
+	header := []string{
+		"device_token",
+		"user_uid",
+		"latitude",
+		"longitude",
+		"disposition",
+		"broker",
+		"device_public_key",
+		"device_key_curve",
+		"device_key_encoding",
+		"user_public_key",
+		"user_key_curve",
+		"user_key_encoding",
+		"stream_uids",
+		"created_at",
+		"updated_at",
+	}
+
+	if c.redact {
+		header = append(header, "device_private_key", "user_private_key")
+	}
+
+	return header
+}
+
+// jsonLinesWriter writes each record as a JSON object on its own line.
+type jsonLinesWriter struct {
+	encoder *json.Encoder
+}
+
+// Write writes a single record.
+func (j *jsonLinesWriter) Write(record *Record) error {
+	return errors.Wrap(j.encoder.Encode(record), "failed to write JSON line")
+}
+
+// Close does nothing, as each record is written immediately.
+func (j *jsonLinesWriter) Close() error {
+	return nil
+}
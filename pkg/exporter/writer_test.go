@@ -0,0 +1,100 @@
+package exporter_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/exporter"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+func newDevice() *postgres.Device {
+	device := &postgres.Device{
+		Token:       "abc123",
+		PrivateKey:  "secret",
+		PublicKey:   "devicepub",
+		Longitude:   2.15,
+		Latitude:    41.39,
+		Disposition: "outdoor",
+		Broker:      "tcp://mqtt.local:1883",
+		KeyCurve:    "ec25519",
+		KeyEncoding: "base64",
+		CreatedAt:   time.Date(2018, 6, 21, 10, 0, 0, 0, time.UTC),
+		UpdatedAt:   time.Date(2018, 6, 22, 10, 0, 0, 0, time.UTC),
+		User: &postgres.User{
+			UID:         "alice",
+			PrivateKey:  "secret",
+			PublicKey:   "userpub",
+			KeyCurve:    "ec25519",
+			KeyEncoding: "base64",
+		},
+	}
+
+	device.Streams = []*postgres.Stream{
+		{UID: "stream1", Device: device},
+		{UID: "stream2", Device: device},
+	}
+
+	return device
+}
+
+func TestJSONLinesWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer, err := exporter.NewWriter(&buf, exporter.JSONLinesFormat, false)
+	assert.Nil(t, err)
+
+	err = writer.Write(exporter.NewRecord(newDevice(), false))
+	assert.Nil(t, err)
+
+	err = writer.Close()
+	assert.Nil(t, err)
+
+	assert.Equal(t, `{"device_token":"abc123","user_uid":"alice","latitude":41.39,"longitude":2.15,"disposition":"outdoor","broker":"tcp://mqtt.local:1883","device_public_key":"devicepub","device_key_curve":"ec25519","device_key_encoding":"base64","user_public_key":"userpub","user_key_curve":"ec25519","user_key_encoding":"base64","stream_uids":["stream1","stream2"],"created_at":"2018-06-21T10:00:00Z","updated_at":"2018-06-22T10:00:00Z"}
+`, buf.String())
+
+	buf.Reset()
+
+	writer, err = exporter.NewWriter(&buf, exporter.JSONLinesFormat, true)
+	assert.Nil(t, err)
+
+	err = writer.Write(exporter.NewRecord(newDevice(), true))
+	assert.Nil(t, err)
+
+	assert.Contains(t, buf.String(), `"device_private_key":"REDACTED"`)
+	assert.Contains(t, buf.String(), `"user_private_key":"REDACTED"`)
+	assert.NotContains(t, buf.String(), "secret")
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	writer, err := exporter.NewWriter(&buf, exporter.CSVFormat, true)
+	assert.Nil(t, err)
+
+	err = writer.Write(exporter.NewRecord(newDevice(), true))
+	assert.Nil(t, err)
+
+	err = writer.Close()
+	assert.Nil(t, err)
+
+	assert.Equal(t, `device_token,user_uid,latitude,longitude,disposition,broker,device_public_key,device_key_curve,device_key_encoding,user_public_key,user_key_curve,user_key_encoding,stream_uids,created_at,updated_at,device_private_key,user_private_key
+abc123,alice,41.39,2.15,outdoor,tcp://mqtt.local:1883,devicepub,ec25519,base64,userpub,ec25519,base64,stream1;stream2,2018-06-21T10:00:00Z,2018-06-22T10:00:00Z,REDACTED,REDACTED
+`, buf.String())
+
+	// an empty export still has a header
+	buf.Reset()
+
+	writer, err = exporter.NewWriter(&buf, exporter.CSVFormat, false)
+	assert.Nil(t, err)
+
+	err = writer.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, "device_token,user_uid,latitude,longitude,disposition,broker,device_public_key,device_key_curve,device_key_encoding,user_public_key,user_key_curve,user_key_encoding,stream_uids,created_at,updated_at\n", buf.String())
+
+	_, err = exporter.NewWriter(&buf, "xml", false)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,335 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+)
+
+// DefaultProgressInterval is the default number of rows between each progress
+// log message.
+const DefaultProgressInterval = 50
+
+// Claimer is the interface of something able to claim a device, satisfied by
+// our RPC service implementation, so that imported devices are claimed exactly
+// as those claimed via the API.
+type Claimer interface {
+	ClaimDevice(ctx context.Context, req *devicereg.ClaimDeviceRequest) (*devicereg.ClaimDeviceResponse, error)
+}
+
+// Config is used to configure an Importer.
+type Config struct {
+	// Claimer is used to claim each device. Not used for a dry run.
+	Claimer Claimer
+
+	// Validate is used to validate each device for a dry run, and should apply
+	// the same validation as the Claimer.
+	Validate func(req *devicereg.ClaimDeviceRequest) error
+
+	// DryRun if true means devices are validated but not claimed, and no
+	// checkpoint is read or written.
+	DryRun bool
+
+	// CheckpointPath is the path of the file in which we record the line of the
+	// last row processed, so that an interrupted import resumes after it. The
+	// file is removed once the import completes. If empty no checkpoint is kept.
+	CheckpointPath string
+
+	// ReportPath is the path of a CSV file to which we write a row for every
+	// device that failed to import. If empty no report is written.
+	ReportPath string
+
+	// ProgressInterval is the number of rows between each progress log message.
+	ProgressInterval int
+}
+
+// Summary records the outcome of an import.
+type Summary struct {
+	// Claimed is the number of devices claimed, or for a dry run the number of
+	// devices that would be claimed.
+	Claimed int
+
+	// Failed is the number of rows that failed to parse or be claimed.
+	Failed int
+
+	// Skipped is the number of rows skipped as they had been processed before
+	// the checkpoint of a previous run.
+	Skipped int
+}
+
+// Importer claims devices read from an input file.
+type Importer struct {
+	claimer          Claimer
+	validate         func(req *devicereg.ClaimDeviceRequest) error
+	dryRun           bool
+	checkpointPath   string
+	reportPath       string
+	progressInterval int
+	logger           kitlog.Logger
+}
+
+// NewImporter returns a new Importer configured with the given config and
+// logger.
+func NewImporter(config *Config, logger kitlog.Logger) *Importer {
+	logger = kitlog.With(logger, "module", "importer")
+
+	progressInterval := config.ProgressInterval
+	if progressInterval == 0 {
+		progressInterval = DefaultProgressInterval
+	}
+
+	return &Importer{
+		claimer:          config.Claimer,
+		validate:         config.Validate,
+		dryRun:           config.DryRun,
+		checkpointPath:   config.CheckpointPath,
+		reportPath:       config.ReportPath,
+		progressInterval: progressInterval,
+		logger:           logger,
+	}
+}
+
+// Import claims every device read from the given reader, continuing past
+// devices that fail to parse or be claimed, which are recorded in the report.
+// An error is returned only if the import could not continue, in which case
+// running it again resumes from the last checkpoint.
+func (i *Importer) Import(ctx context.Context, reader Reader) (*Summary, error) {
+	var (
+		checkpoint int
+		err        error
+	)
+
+	if !i.dryRun {
+		checkpoint, err = i.readCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+
+		if checkpoint > 0 {
+			i.logger.Log("msg", "resuming import", "checkpoint", checkpoint)
+		}
+	}
+
+	report, err := i.openReport(checkpoint > 0)
+	if err != nil {
+		return nil, err
+	}
+	defer report.close()
+
+	summary := &Summary{}
+
+	for {
+		row, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			rowErr, ok := err.(*RowError)
+			if !ok {
+				return summary, err
+			}
+
+			if rowErr.Line <= checkpoint {
+				summary.Skipped++
+				continue
+			}
+
+			summary.Failed++
+
+			err = report.write(rowErr.Line, rowErr.DeviceToken, rowErr.UserUID, "invalid_argument", rowErr.Err.Error())
+			if err != nil {
+				return summary, err
+			}
+
+			err = i.writeCheckpoint(rowErr.Line)
+			if err != nil {
+				return summary, err
+			}
+
+			continue
+		}
+
+		if row.Line <= checkpoint {
+			summary.Skipped++
+			continue
+		}
+
+		err = i.claim(ctx, row.Request)
+		if err != nil {
+			twerr, ok := err.(twirp.Error)
+			if !ok {
+				twerr = twirp.InternalErrorWith(err)
+			}
+
+			summary.Failed++
+
+			err = report.write(row.Line, row.Request.DeviceToken, row.Request.UserUid, string(twerr.Code()), twerr.Msg())
+			if err != nil {
+				return summary, err
+			}
+		} else {
+			summary.Claimed++
+		}
+
+		err = i.writeCheckpoint(row.Line)
+		if err != nil {
+			return summary, err
+		}
+
+		if processed := summary.Claimed + summary.Failed; processed%i.progressInterval == 0 {
+			i.logger.Log("msg", "import progress", "line", row.Line, "claimed", summary.Claimed, "failed", summary.Failed)
+		}
+	}
+
+	// the import is complete so there is nothing to resume
+	if !i.dryRun && i.checkpointPath != "" {
+		err = os.Remove(i.checkpointPath)
+		if err != nil && !os.IsNotExist(err) {
+			return summary, errors.Wrap(err, "failed to remove checkpoint")
+		}
+	}
+
+	return summary, nil
+}
+
+// claim claims the device described by the given request, or for a dry run
+// only validates the request.
+func (i *Importer) claim(ctx context.Context, req *devicereg.ClaimDeviceRequest) error {
+	if i.dryRun {
+		if i.validate == nil {
+			return nil
+		}
+		return i.validate(req)
+	}
+
+	_, err := i.claimer.ClaimDevice(ctx, req)
+	return err
+}
+
+// readCheckpoint returns the line recorded by a previous interrupted run, or 0
+// if there is no checkpoint.
+func (i *Importer) readCheckpoint() (int, error) {
+	if i.checkpointPath == "" {
+		return 0, nil
+	}
+
+	b, err := ioutil.ReadFile(i.checkpointPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "failed to read checkpoint")
+	}
+
+	line, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse checkpoint")
+	}
+
+	return line, nil
+}
+
+// writeCheckpoint records the line of the last row processed. We write to a
+// temporary file and rename it, so the checkpoint is never left partially
+// written.
+func (i *Importer) writeCheckpoint(line int) error {
+	if i.dryRun || i.checkpointPath == "" {
+		return nil
+	}
+
+	tmpPath := i.checkpointPath + ".tmp"
+
+	err := ioutil.WriteFile(tmpPath, []byte(strconv.Itoa(line)+"\n"), 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+
+	err = os.Rename(tmpPath, i.checkpointPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to write checkpoint")
+	}
+
+	return nil
+}
+
+// reportHeader is the header row of the failure report.
+var reportHeader = []string{"line", "device_token", "user_uid", "error_code", "error_message"}
+
+// failureReport writes failed rows to the report file.
+type failureReport struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// openReport opens the failure report, appending to an existing report when
+// resuming an import so failures from the previous run are kept.
+func (i *Importer) openReport(resume bool) (*failureReport, error) {
+	if i.reportPath == "" {
+		return &failureReport{}, nil
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(i.reportPath, flags, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open report")
+	}
+
+	report := &failureReport{file: file, writer: csv.NewWriter(file)}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to open report")
+	}
+
+	if info.Size() == 0 {
+		err = report.writer.Write(reportHeader)
+		if err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "failed to write report")
+		}
+	}
+
+	return report, nil
+}
+
+// write records a failed row, flushing immediately so the report is complete
+// even if the import is interrupted.
+func (r *failureReport) write(line int, token, userUID, code, message string) error {
+	if r.writer == nil {
+		return nil
+	}
+
+	err := r.writer.Write([]string{strconv.Itoa(line), token, userUID, code, message})
+	if err != nil {
+		return errors.Wrap(err, "failed to write report")
+	}
+
+	r.writer.Flush()
+
+	return errors.Wrap(r.writer.Error(), "failed to write report")
+}
+
+// close closes the report file.
+func (r *failureReport) close() error {
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
@@ -0,0 +1,149 @@
+package importer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+	"github.com/thingful/iotdevicereg/pkg/importer"
+	"github.com/thingful/iotdevicereg/pkg/rpc"
+)
+
+// claimer is a fake Claimer recording claimed tokens, which fails to claim
+// the token "taken", and interrupts the import on claiming the token "crash".
+type claimer struct {
+	claimed []string
+}
+
+func (c *claimer) ClaimDevice(ctx context.Context, req *devicereg.ClaimDeviceRequest) (*devicereg.ClaimDeviceResponse, error) {
+	switch req.DeviceToken {
+	case "taken":
+		return nil, twirp.NewError(twirp.AlreadyExists, "device already claimed by another user")
+	case "crash":
+		panic("interrupted")
+	}
+
+	c.claimed = append(c.claimed, req.DeviceToken)
+
+	return &devicereg.ClaimDeviceResponse{}, nil
+}
+
+const input = `device_token,user_uid,latitude,longitude,broker
+abc123,alice,55.25,0.023,tcp://mqtt.local:1883
+taken,alice,55.25,0.023,tcp://mqtt.local:1883
+def456,alice,north,0.023,tcp://mqtt.local:1883
+hij789,alice,55.25,0.023,tcp://mqtt.local:1883
+`
+
+func newReader(t *testing.T, input string) importer.Reader {
+	reader, err := importer.NewReader(strings.NewReader(input), importer.CSVFormat)
+	assert.Nil(t, err)
+
+	return reader
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := &claimer{}
+
+	imp := importer.NewImporter(&importer.Config{
+		Claimer:        c,
+		CheckpointPath: filepath.Join(dir, "checkpoint"),
+		ReportPath:     filepath.Join(dir, "report.csv"),
+	}, kitlog.NewNopLogger())
+
+	summary, err := imp.Import(context.Background(), newReader(t, input))
+	assert.Nil(t, err)
+	assert.Equal(t, &importer.Summary{Claimed: 2, Failed: 2}, summary)
+	assert.Equal(t, []string{"abc123", "hij789"}, c.claimed)
+
+	report, err := ioutil.ReadFile(filepath.Join(dir, "report.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, `line,device_token,user_uid,error_code,error_message
+3,taken,alice,already_exists,device already claimed by another user
+4,def456,alice,invalid_argument,"invalid latitude: ""north"""
+`, string(report))
+
+	// the checkpoint is removed once complete
+	_, err = os.Stat(filepath.Join(dir, "checkpoint"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestImportResumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &importer.Config{
+		CheckpointPath: filepath.Join(dir, "checkpoint"),
+		ReportPath:     filepath.Join(dir, "report.csv"),
+	}
+
+	interrupted := strings.Replace(input, "hij789", "crash", 1)
+
+	c := &claimer{}
+	config.Claimer = c
+
+	func() {
+		defer func() {
+			assert.NotNil(t, recover())
+		}()
+
+		importer.NewImporter(config, kitlog.NewNopLogger()).Import(context.Background(), newReader(t, interrupted))
+	}()
+
+	checkpoint, err := ioutil.ReadFile(filepath.Join(dir, "checkpoint"))
+	assert.Nil(t, err)
+	assert.Equal(t, "4\n", string(checkpoint))
+
+	// running again only processes rows after the checkpoint
+	c = &claimer{}
+	config.Claimer = c
+
+	summary, err := importer.NewImporter(config, kitlog.NewNopLogger()).Import(context.Background(), newReader(t, input))
+	assert.Nil(t, err)
+	assert.Equal(t, &importer.Summary{Claimed: 1, Skipped: 3}, summary)
+	assert.Equal(t, []string{"hij789"}, c.claimed)
+
+	// failures from the interrupted run are kept
+	report, err := ioutil.ReadFile(filepath.Join(dir, "report.csv"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, strings.Count(string(report), "\n"))
+}
+
+func TestImportDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	imp := importer.NewImporter(&importer.Config{
+		Validate:       rpc.ValidateClaimDeviceRequest,
+		DryRun:         true,
+		CheckpointPath: filepath.Join(dir, "checkpoint"),
+		ReportPath:     filepath.Join(dir, "report.csv"),
+	}, kitlog.NewNopLogger())
+
+	summary, err := imp.Import(context.Background(), newReader(t, input+"klm012,,55.25,0.023,tcp://mqtt.local:1883\n"))
+	assert.Nil(t, err)
+
+	// a dry run is unable to detect devices already claimed
+	assert.Equal(t, &importer.Summary{Claimed: 3, Failed: 2}, summary)
+
+	report, err := ioutil.ReadFile(filepath.Join(dir, "report.csv"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(report), "6,klm012,,invalid_argument,user_uid is required")
+
+	_, err = os.Stat(filepath.Join(dir, "checkpoint"))
+	assert.True(t, os.IsNotExist(err))
+}
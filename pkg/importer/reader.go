@@ -0,0 +1,329 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+)
+
+const (
+	// CSVFormat is the name of the format of CSV files. The first row must be a
+	// header naming the columns, which may be in any order.
+	CSVFormat = "csv"
+
+	// JSONLinesFormat is the name of the format of files containing one JSON
+	// object per line, with keys named as the CSV columns.
+	JSONLinesFormat = "jsonl"
+)
+
+// Formats is the list of supported input formats.
+var Formats = []string{
+	CSVFormat,
+	JSONLinesFormat,
+}
+
+// columns are the names of the fields of each row. The device_token and
+// user_uid columns are required, and any others may be omitted from a file in
+// which case they are empty for every row.
+var columns = []string{
+	"device_token",
+	"user_uid",
+	"latitude",
+	"longitude",
+	"disposition",
+	"broker",
+	"challenge",
+	"challenge_response",
+}
+
+// columnAliases are alternative names accepted for columns.
+var columnAliases = map[string]string{
+	"lat": "latitude",
+	"lon": "longitude",
+	"lng": "longitude",
+}
+
+// Row is a single device to be claimed read from an input file.
+type Row struct {
+	// Line is the line number of the row within the file, starting at 1 for
+	// the first line of the file. For CSV files the header is line 1.
+	Line int
+
+	// Request is the claim request described by the row.
+	Request *devicereg.ClaimDeviceRequest
+}
+
+// RowError is the error returned when a single row cannot be parsed. Reading
+// may continue after a RowError.
+type RowError struct {
+	Line        int
+	DeviceToken string
+	UserUID     string
+	Err         error
+}
+
+// Error returns the message of the underlying error prefixed with the line.
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// Reader is the interface of a reader of rows from an input file. Next
+// returns io.EOF once all rows have been read.
+type Reader interface {
+	Next() (*Row, error)
+}
+
+// DetectFormat returns the format of the file with the given path based on its
+// extension.
+func DetectFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return CSVFormat, nil
+	case ".jsonl", ".ndjson", ".json":
+		return JSONLinesFormat, nil
+	default:
+		return "", errors.Errorf("unable to detect format of file: %s", path)
+	}
+}
+
+// NewReader returns a Reader reading rows in the given format.
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case CSVFormat:
+		return newCSVReader(r)
+	case JSONLinesFormat:
+		return &jsonLinesReader{scanner: bufio.NewScanner(r)}, nil
+	default:
+		return nil, errors.Errorf("unknown import format: %s", format)
+	}
+}
+
+// lineCounter is a reader counting the lines read from the underlying reader,
+// recording the last byte read so that a final line without a newline can be
+// detected.
+type lineCounter struct {
+	reader io.Reader
+	lines  int
+	last   byte
+}
+
+// Read reads from the underlying reader, counting newlines.
+func (l *lineCounter) Read(p []byte) (int, error) {
+	n, err := l.reader.Read(p)
+	if n > 0 {
+		l.lines += bytes.Count(p[:n], []byte{'\n'})
+		l.last = p[n-1]
+	}
+
+	return n, err
+}
+
+// csvReader reads rows from a CSV file with a header.
+type csvReader struct {
+	reader   *csv.Reader
+	buffered *bufio.Reader
+	counter  *lineCounter
+	indexes  map[string]int
+}
+
+// newCSVReader reads the header of the CSV file, returning an error if it does
+// not contain the required columns.
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	counter := &lineCounter{reader: r}
+
+	// csv.NewReader uses a bufio.Reader it is given as is, so we can see how
+	// much of the file it has buffered but not yet read
+	buffered := bufio.NewReader(counter)
+
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CSV header")
+	}
+
+	indexes := map[string]int{}
+
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if alias, ok := columnAliases[name]; ok {
+			name = alias
+		}
+
+		indexes[name] = i
+	}
+
+	for _, required := range columns[:2] {
+		if _, ok := indexes[required]; !ok {
+			return nil, errors.Errorf("CSV header is missing required column: %s", required)
+		}
+	}
+
+	return &csvReader{
+		reader:   reader,
+		buffered: buffered,
+		counter:  counter,
+		indexes:  indexes,
+	}, nil
+}
+
+// line returns the line on which the given record, just read, started. The
+// reader always reads whole lines, so the lines it has read are those counted
+// less any buffered beyond the record, and fields span a line for each newline
+// they contain.
+func (c *csvReader) line(record []string) int {
+	pending, _ := c.buffered.Peek(c.buffered.Buffered())
+
+	line := c.counter.lines - bytes.Count(pending, []byte{'\n'})
+	if len(pending) == 0 && c.counter.last != '\n' {
+		// the record is on the last line of the file, which has no newline
+		line++
+	}
+
+	for _, field := range record {
+		line -= strings.Count(field, "\n")
+	}
+
+	return line
+}
+
+// Next returns the next row of the file.
+func (c *csvReader) Next() (*Row, error) {
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+
+	if err != nil {
+		if parseErr, ok := err.(*csv.ParseError); ok {
+			return nil, &RowError{Line: parseErr.StartLine, Err: parseErr.Err}
+		}
+		return nil, errors.Wrap(err, "failed to read CSV row")
+	}
+
+	line := c.line(record)
+
+	fields := map[string]string{}
+
+	for _, name := range columns {
+		if i, ok := c.indexes[name]; ok && i < len(record) {
+			fields[name] = strings.TrimSpace(record[i])
+		}
+	}
+
+	return newRow(line, fields)
+}
+
+// jsonLinesReader reads rows from a file containing a JSON object per line.
+// Blank lines are ignored.
+type jsonLinesReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+// Next returns the next row of the file.
+func (j *jsonLinesReader) Next() (*Row, error) {
+	for j.scanner.Scan() {
+		j.line++
+
+		text := strings.TrimSpace(j.scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var object map[string]interface{}
+
+		err := json.Unmarshal([]byte(text), &object)
+		if err != nil {
+			return nil, &RowError{Line: j.line, Err: errors.Wrap(err, "invalid JSON")}
+		}
+
+		fields := map[string]string{}
+
+		for name, value := range object {
+			name = strings.ToLower(name)
+			if alias, ok := columnAliases[name]; ok {
+				name = alias
+			}
+
+			switch v := value.(type) {
+			case string:
+				fields[name] = strings.TrimSpace(v)
+			case float64:
+				fields[name] = strconv.FormatFloat(v, 'f', -1, 64)
+			case nil:
+			default:
+				return nil, &RowError{Line: j.line, Err: errors.Errorf("invalid value for %s", name)}
+			}
+		}
+
+		return newRow(j.line, fields)
+	}
+
+	if err := j.scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read JSON lines")
+	}
+
+	return nil, io.EOF
+}
+
+// newRow converts the named fields of a row into a claim request. Only the
+// parsing of values is checked here, as requests are validated when claimed.
+func newRow(line int, fields map[string]string) (*Row, error) {
+	rowError := func(err error) error {
+		return &RowError{
+			Line:        line,
+			DeviceToken: fields["device_token"],
+			UserUID:     fields["user_uid"],
+			Err:         err,
+		}
+	}
+
+	req := &devicereg.ClaimDeviceRequest{
+		DeviceToken:       fields["device_token"],
+		UserUid:           fields["user_uid"],
+		Broker:            fields["broker"],
+		Challenge:         fields["challenge"],
+		ChallengeResponse: fields["challenge_response"],
+	}
+
+	if fields["latitude"] != "" || fields["longitude"] != "" {
+		latitude, err := strconv.ParseFloat(fields["latitude"], 64)
+		if err != nil {
+			return nil, rowError(errors.Errorf("invalid latitude: %q", fields["latitude"]))
+		}
+
+		longitude, err := strconv.ParseFloat(fields["longitude"], 64)
+		if err != nil {
+			return nil, rowError(errors.Errorf("invalid longitude: %q", fields["longitude"]))
+		}
+
+		req.Location = &devicereg.ClaimDeviceRequest_Location{
+			Latitude:  latitude,
+			Longitude: longitude,
+		}
+	}
+
+	if fields["disposition"] != "" {
+		disposition, ok := devicereg.ClaimDeviceRequest_Disposition_value[strings.ToUpper(fields["disposition"])]
+		if !ok {
+			return nil, rowError(errors.Errorf("invalid disposition: %q", fields["disposition"]))
+		}
+
+		req.Disposition = devicereg.ClaimDeviceRequest_Disposition(disposition)
+	}
+
+	return &Row{Line: line, Request: req}, nil
+}
@@ -0,0 +1,141 @@
+package importer_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+	"github.com/thingful/iotdevicereg/pkg/importer"
+)
+
+func TestDetectFormat(t *testing.T) {
+	format, err := importer.DetectFormat("devices.CSV")
+	assert.Nil(t, err)
+	assert.Equal(t, importer.CSVFormat, format)
+
+	format, err = importer.DetectFormat("/tmp/devices.jsonl")
+	assert.Nil(t, err)
+	assert.Equal(t, importer.JSONLinesFormat, format)
+
+	_, err = importer.DetectFormat("devices.txt")
+	assert.NotNil(t, err)
+}
+
+func TestCSVReader(t *testing.T) {
+	input := `device_token,user_uid,lat,lon,disposition,broker
+abc123,alice,55.25,0.023,outdoor,tcp://mqtt.local:1883
+def456,alice,,,,
+hij789,alice,north,0.023,indoor,tcp://mqtt.local:1883
+`
+
+	reader, err := importer.NewReader(strings.NewReader(input), importer.CSVFormat)
+	assert.Nil(t, err)
+
+	row, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, row.Line)
+	assert.Equal(t, &devicereg.ClaimDeviceRequest{
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Latitude:  55.25,
+			Longitude: 0.023,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_OUTDOOR,
+		Broker:      "tcp://mqtt.local:1883",
+	}, row.Request)
+
+	// missing values are left for validation when claimed
+	row, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, row.Line)
+	assert.Nil(t, row.Request.Location)
+
+	_, err = reader.Next()
+	assert.NotNil(t, err)
+	rowErr, ok := err.(*importer.RowError)
+	assert.True(t, ok)
+	assert.Equal(t, 4, rowErr.Line)
+	assert.Equal(t, "hij789", rowErr.DeviceToken)
+	assert.Equal(t, `line 4: invalid latitude: "north"`, err.Error())
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = importer.NewReader(strings.NewReader("token,user\n"), importer.CSVFormat)
+	assert.NotNil(t, err)
+	assert.Equal(t, "CSV header is missing required column: device_token", err.Error())
+}
+
+func TestCSVReaderLines(t *testing.T) {
+	input := "device_token,user_uid,broker\r\n" +
+		"abc123,alice,tcp://mqtt.local:1883\r\n" +
+		"\r\n" +
+		"def456,alice,\"tcp://mqtt.local:1883\r\nsecond line\"\r\n" +
+		"hij789,alice,tcp://mqtt.local:1883"
+
+	// blank lines are skipped, quoted fields may span lines, and the last line
+	// need not end with a newline
+	reader, err := importer.NewReader(strings.NewReader(input), importer.CSVFormat)
+	assert.Nil(t, err)
+
+	for _, expected := range []int{2, 4, 6} {
+		row, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, row.Line)
+	}
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// a large file is read in several chunks
+	rows := strings.Repeat("abc123,alice,tcp://mqtt.local:1883\n", 1000)
+
+	reader, err = importer.NewReader(strings.NewReader("device_token,user_uid,broker\n"+rows), importer.CSVFormat)
+	assert.Nil(t, err)
+
+	for line := 2; line <= 1001; line++ {
+		row, err := reader.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, line, row.Line)
+	}
+}
+
+func TestJSONLinesReader(t *testing.T) {
+	input := `{"device_token": "abc123", "user_uid": "alice", "latitude": 55.25, "longitude": 0.023, "broker": "tcp://mqtt.local:1883", "challenge": "c4a11e", "challenge_response": "0ff1ce"}
+
+{"device_token": "def456", "user_uid": "alice", "disposition": "garden"}
+not json
+`
+
+	reader, err := importer.NewReader(strings.NewReader(input), importer.JSONLinesFormat)
+	assert.Nil(t, err)
+
+	row, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, row.Line)
+	assert.Equal(t, "abc123", row.Request.DeviceToken)
+	assert.Equal(t, 55.25, row.Request.Location.Latitude)
+	assert.Equal(t, devicereg.ClaimDeviceRequest_INDOOR, row.Request.Disposition)
+	assert.Equal(t, "c4a11e", row.Request.Challenge)
+	assert.Equal(t, "0ff1ce", row.Request.ChallengeResponse)
+
+	_, err = reader.Next()
+	assert.NotNil(t, err)
+	assert.Equal(t, `line 3: invalid disposition: "garden"`, err.Error())
+
+	_, err = reader.Next()
+	assert.NotNil(t, err)
+	rowErr, ok := err.(*importer.RowError)
+	assert.True(t, ok)
+	assert.Equal(t, 4, rowErr.Line)
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = importer.NewReader(strings.NewReader(input), "xml")
+	assert.NotNil(t, err)
+}
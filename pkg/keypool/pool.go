@@ -0,0 +1,255 @@
+package keypool
+
+import (
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+)
+
+const (
+	// DefaultSize is the default maximum number of key pairs held by the pool.
+	DefaultSize = 100
+
+	// DefaultLowWatermark is the default number of key pairs at or below which
+	// the pool is refilled.
+	DefaultLowWatermark = 25
+
+	// DefaultInterval is the default interval at which we check whether the pool
+	// needs refilling, in addition to checking whenever a key pair is taken.
+	DefaultInterval = 10 * time.Second
+)
+
+var (
+	// keyPoolAvailable is a prometheus gauge recording the number of key pairs
+	// currently available in the pool.
+	keyPoolAvailable = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "decode_keypool_available",
+			Help: "Gauge of pre-generated key pairs available in the key pool",
+		},
+	)
+
+	// keyPoolRequests is a prometheus counter recording requests for key pairs
+	// keyed by source.
+	keyPoolRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_keypool_requests",
+			Help: "Counter of requests for key pairs from the key pool",
+		},
+		[]string{
+			// where did the key pair come from: pool or generated
+			"source",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(keyPoolAvailable)
+	prometheus.MustRegister(keyPoolRequests)
+}
+
+// Config is used to configure the pool. Any zero valued sizes or durations are
+// replaced with the package defaults.
+type Config struct {
+	// KeyOptions are the options of the key pairs held by the pool. Requests for
+	// key pairs with other options are always generated synchronously.
+	KeyOptions *crypto.KeyOptions
+
+	// Generator is used to generate all key pairs, both for the pool and when
+	// generating synchronously. If nil we use crypto.DefaultKeyGenerator.
+	Generator crypto.KeyGenerator
+
+	// Size is the maximum number of key pairs held by the pool.
+	Size int
+
+	// LowWatermark is the number of key pairs at or below which we refill the
+	// pool back up to Size.
+	LowWatermark int
+
+	// Interval is the interval at which we check whether the pool needs
+	// refilling.
+	Interval time.Duration
+
+	Verbose bool
+}
+
+// Pool is a component that pre-generates key pairs in the background and
+// holds them in a bounded in-memory buffer, so that generating key pairs does
+// not add to the latency of requests. Key pairs are only ever held in memory,
+// and each is handed out at most once. If the pool is empty we fall back to
+// generating key pairs synchronously.
+type Pool struct {
+	keyOptions   *crypto.KeyOptions
+	generator    crypto.KeyGenerator
+	lowWatermark int
+	interval     time.Duration
+	verbose      bool
+	logger       kitlog.Logger
+
+	keys   chan *crypto.KeyPair
+	refill chan struct{}
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewPool returns a new Pool instance configured with the given config and
+// logger. The pool is empty until started.
+func NewPool(config *Config, logger kitlog.Logger) *Pool {
+	logger = kitlog.With(logger, "module", "keypool")
+	logger.Log("msg", "creating key pool")
+
+	size := config.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+
+	p := &Pool{
+		keyOptions:   config.KeyOptions,
+		generator:    config.Generator,
+		lowWatermark: config.LowWatermark,
+		interval:     config.Interval,
+		verbose:      config.Verbose,
+		logger:       logger,
+		keys:         make(chan *crypto.KeyPair, size),
+		refill:       make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+	}
+
+	if p.lowWatermark == 0 {
+		p.lowWatermark = DefaultLowWatermark
+	}
+
+	if p.lowWatermark >= size {
+		p.lowWatermark = size - 1
+	}
+
+	if p.generator == nil {
+		p.generator = crypto.DefaultKeyGenerator
+	}
+
+	if p.interval == 0 {
+		p.interval = DefaultInterval
+	}
+
+	return p
+}
+
+// Start starts filling the pool in a background goroutine.
+func (p *Pool) Start() error {
+	p.logger.Log("msg", "starting key pool", "size", cap(p.keys), "lowWatermark", p.lowWatermark)
+
+	p.wg.Add(1)
+	go p.run()
+
+	return nil
+}
+
+// Stop signals the pool to stop refilling, and waits for any key pair being
+// generated to complete.
+func (p *Pool) Stop() error {
+	p.logger.Log("msg", "stopping key pool")
+
+	close(p.quit)
+	p.wg.Wait()
+
+	return nil
+}
+
+// NewKeyPair returns a key pair with the given options. If the options match
+// those of the pool and the pool is not empty we return a pre-generated key
+// pair, otherwise we generate one synchronously.
+func (p *Pool) NewKeyPair(options *crypto.KeyOptions) (*crypto.KeyPair, error) {
+	if options.Equal(p.keyOptions) {
+		select {
+		case keyPair := <-p.keys:
+			keyPoolRequests.With(prometheus.Labels{"source": "pool"}).Inc()
+			keyPoolAvailable.Set(float64(len(p.keys)))
+
+			if len(p.keys) <= p.lowWatermark {
+				p.signalRefill()
+			}
+
+			return keyPair, nil
+		default:
+			p.signalRefill()
+		}
+	}
+
+	keyPoolRequests.With(prometheus.Labels{"source": "generated"}).Inc()
+
+	return p.generator.NewKeyPair(options)
+}
+
+// Available returns the number of key pairs currently held by the pool.
+func (p *Pool) Available() int {
+	return len(p.keys)
+}
+
+// signalRefill asks the background goroutine to refill the pool, without
+// blocking if a refill has already been requested.
+func (p *Pool) signalRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// run is the main loop of the pool, filling the pool on start and then
+// refilling it whenever it falls to the low watermark until we are stopped.
+func (p *Pool) run() {
+	defer p.wg.Done()
+
+	p.fill()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if len(p.keys) <= p.lowWatermark {
+				p.fill()
+			}
+		case <-p.refill:
+			p.fill()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// fill generates key pairs until the pool is full or we are stopped.
+func (p *Pool) fill() {
+	var generated int
+
+	for len(p.keys) < cap(p.keys) {
+		select {
+		case <-p.quit:
+			return
+		default:
+		}
+
+		keyPair, err := p.generator.NewKeyPair(p.keyOptions)
+		if err != nil {
+			p.logger.Log("msg", "failed to generate key pair", "err", err)
+			return
+		}
+
+		select {
+		case p.keys <- keyPair:
+			generated++
+		default:
+			// the pool was filled concurrently, so discard the key pair
+		}
+
+		keyPoolAvailable.Set(float64(len(p.keys)))
+	}
+
+	if p.verbose && generated > 0 {
+		p.logger.Log("msg", "refilled key pool", "generated", generated)
+	}
+}
@@ -0,0 +1,114 @@
+package keypool_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+	"github.com/thingful/iotdevicereg/pkg/keypool"
+)
+
+// waitForAvailable waits until the pool holds the expected number of key
+// pairs, failing the test if this takes too long.
+func waitForAvailable(t *testing.T, pool *keypool.Pool, expected int) {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for pool.Available() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d key pairs, have %d", expected, pool.Available())
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPoolFillsAndRefills(t *testing.T) {
+	pool := keypool.NewPool(&keypool.Config{
+		Size:         3,
+		LowWatermark: 1,
+		Interval:     time.Hour,
+	}, kitlog.NewNopLogger())
+
+	err := pool.Start()
+	assert.Nil(t, err)
+	defer pool.Stop()
+
+	waitForAvailable(t, pool, 3)
+
+	keyPair, err := pool.NewKeyPair(nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", keyPair.PrivateKey)
+	assert.NotEqual(t, "", keyPair.PublicKey)
+	assert.Equal(t, crypto.Ec25519Curve, keyPair.Curve)
+	assert.Equal(t, 2, pool.Available())
+
+	// taking the pool down to the low watermark triggers a refill
+	other, err := pool.NewKeyPair(&crypto.KeyOptions{Curve: crypto.Ec25519Curve, Encoding: crypto.Base64Encoding})
+	assert.Nil(t, err)
+	assert.NotEqual(t, keyPair.PrivateKey, other.PrivateKey)
+
+	waitForAvailable(t, pool, 3)
+}
+
+func TestPoolGeneratesOtherOptions(t *testing.T) {
+	pool := keypool.NewPool(&keypool.Config{
+		Size: 2,
+	}, kitlog.NewNopLogger())
+
+	err := pool.Start()
+	assert.Nil(t, err)
+	defer pool.Stop()
+
+	waitForAvailable(t, pool, 2)
+
+	keyPair, err := pool.NewKeyPair(&crypto.KeyOptions{Curve: crypto.Nist256Curve, Encoding: crypto.HexEncoding})
+	assert.Nil(t, err)
+	assert.Equal(t, crypto.Nist256Curve, keyPair.Curve)
+	assert.Equal(t, crypto.HexEncoding, keyPair.Encoding)
+
+	// the pool is untouched as the options differ
+	assert.Equal(t, 2, pool.Available())
+}
+
+func TestPoolGeneratesWhenEmpty(t *testing.T) {
+	pool := keypool.NewPool(&keypool.Config{
+		Size: 2,
+	}, kitlog.NewNopLogger())
+
+	// the pool is not started so is empty
+	assert.Equal(t, 0, pool.Available())
+
+	keyPair, err := pool.NewKeyPair(nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", keyPair.PublicKey)
+}
+
+func TestPoolUsesGenerator(t *testing.T) {
+	generator, err := crypto.NewKeyGenerator(crypto.NativeBackend)
+	assert.Nil(t, err)
+
+	pool := keypool.NewPool(&keypool.Config{
+		Generator: generator,
+	}, kitlog.NewNopLogger())
+
+	keyPair, err := pool.NewKeyPair(nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", keyPair.PublicKey)
+
+	// the native generator only supports ec25519
+	_, err = pool.NewKeyPair(&crypto.KeyOptions{Curve: crypto.Nist256Curve})
+	assert.NotNil(t, err)
+}
+
+func TestStartStop(t *testing.T) {
+	pool := keypool.NewPool(&keypool.Config{}, kitlog.NewNopLogger())
+
+	err := pool.Start()
+	assert.Nil(t, err)
+
+	err = pool.Stop()
+	assert.Nil(t, err)
+}
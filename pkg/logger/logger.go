@@ -1,6 +1,7 @@
 package logger
 
 import (
+	"io"
 	"os"
 
 	kitlog "github.com/go-kit/kit/log"
@@ -10,7 +11,13 @@ import (
 // NewLogger is a simple helper function that returns a kitlog.Logger instance
 // ready for use.
 func NewLogger() kitlog.Logger {
-	logger := kitlog.NewJSONLogger(kitlog.NewSyncWriter(os.Stdout))
+	return NewLoggerWithWriter(os.Stdout)
+}
+
+// NewLoggerWithWriter returns a kitlog.Logger instance writing to the given
+// writer, for use by tasks whose own output is written to stdout.
+func NewLoggerWithWriter(w io.Writer) kitlog.Logger {
+	logger := kitlog.NewJSONLogger(kitlog.NewSyncWriter(w))
 	logger = kitlog.With(logger,
 		"service", version.BinaryName,
 		"ts", kitlog.DefaultTimestampUTC,
@@ -1,6 +1,7 @@
 // Code generated by go-bindata. DO NOT EDIT.
 // sources:
 // scripts/generatekeys.lua
+// scripts/session.lua
 package lua
 
 import (
@@ -66,7 +67,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var _generatekeysLua = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x4c\x8e\xe1\x0a\x82\x30\x14\x85\x7f\x7b\x9f\xe2\xfe\xdb\x06\x21\x14\x19\x24\xf8\x30\x3a\x0f\xb6\x8c\xcd\xe6\x56\x48\xf4\xee\x31\xc5\xd8\xcf\xf3\xdd\xef\x70\xae\xd3\x01\x81\x1b\xf6\x78\x46\xe3\xc1\x62\x05\x82\xa0\xfb\x5b\x8e\x53\x16\x74\x9f\x9d\xcd\x69\xca\x82\x68\xc4\xe2\x8d\x1d\xb8\xe1\xa4\x95\x16\x6f\x29\xa0\x4f\x55\x75\xbc\x0a\xb5\x5f\xeb\x11\xcb\x00\x2b\x15\x91\x8b\x61\x8a\x69\x35\xf5\x4b\x58\xed\x7a\xc8\x0f\x15\x53\xec\x1e\x46\x73\xc3\x7b\x65\x03\x52\xd5\x5d\x3b\xe3\x72\x96\xea\x40\xc5\x0c\xed\xd7\x97\xff\x92\x37\xaf\x36\x20\xb3\xe8\xab\x88\x26\x6f\x6c\x90\xdb\x94\xfa\x05\x00\x00\xff\xff\xb1\x60\xb9\xcf\xe7\x00\x00\x00")
+var _generatekeysLua = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x4c\x8f\x31\x4f\xc3\x30\x14\x84\xe7\xbe\x5f\x71\x5b\x6c\xa9\xc9\x84\x18\x2a\x65\x44\x0c\x8c\x4c\x8c\x8e\x73\x6a\x4d\xc1\x0e\xce\x73\x51\x85\xf8\xef\xc8\x09\x91\x32\xde\xbd\xf3\xa7\xcf\x6d\x8b\x67\x46\x66\xa7\x9c\xe1\x70\xe5\x1d\x93\x0b\x19\x29\x42\x2f\x84\x2f\xf9\x46\x44\xf7\xc9\x11\xc3\xfd\x3f\x86\x88\x97\xa7\xb7\xd7\x23\x52\xd1\xa9\xa8\x86\x78\xc6\x90\xf4\x22\x6d\x5b\x01\x33\x06\x37\xf3\xf1\x01\x8c\x3e\x8d\x1c\x3b\x91\xe4\x95\x8a\x1e\x99\x5f\x25\x64\xa2\x59\x8a\x46\xe8\xc7\xcb\xbe\xae\xb9\x91\xf7\x39\xc5\x7d\x5b\x73\x23\xb2\xa0\x7b\xd4\xd4\x8d\xac\x68\x53\x35\xec\x72\xc9\x55\xa2\x47\x05\x74\x91\xdf\xa6\x8e\xbb\x45\xd7\x6e\xe7\xd3\x95\xf7\x33\xa3\xb1\x22\xab\xf8\x06\x5b\x3d\xcd\x8f\x1c\xa6\x32\x7c\x04\x8f\x1e\xdb\x93\xb5\x30\xf6\xb4\x7e\xc9\xd8\xa3\x1c\x66\xfa\x4c\xdd\x8f\x72\xb8\x39\xe5\x6e\x25\xbf\x56\x64\xca\x21\xaa\x49\x45\xa7\xa2\xf6\x6f\x00\x81\x6f\x44\x02\x68\x01\x00\x00")
 
 func generatekeysLuaBytes() ([]byte, error) {
 	return bindataRead(
@@ -81,7 +82,27 @@ func generatekeysLua() (*asset, error) {
 		return nil, err
 	}
 
-	info := bindataFileInfo{name: "generatekeys.lua", size: 231, mode: os.FileMode(420), modTime: time.Unix(1528839167, 0)}
+	info := bindataFileInfo{name: "generatekeys.lua", size: 360, mode: os.FileMode(420), modTime: time.Unix(1792305869, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _sessionLua = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x54\x91\x3f\x8f\xd4\x40\x0c\xc5\xeb\xf3\xa7\x78\x12\x45\x32\x12\x9b\x0a\x51\x9c\x94\x06\x38\x09\x89\x82\x82\x8a\x72\x32\xf1\x5d\x06\xf6\xec\x30\xe3\xb9\x65\x85\xf8\xee\x68\x26\x89\x6e\x29\xfd\x6c\xff\x9e\xff\x9c\x4e\xf8\x5a\x6c\x2d\x96\x61\x0b\x23\x2f\x3e\xf1\x8c\xcc\x21\xb1\x41\x1f\xe1\x05\x0f\x1f\x3f\x7d\x06\xff\x0e\x8b\x97\x27\xc6\xc4\x76\x61\x16\xd8\x45\xf1\x93\xaf\x58\x7d\x4c\x79\xc0\x97\x87\xef\xdf\xe8\x74\xc2\x73\xc9\x86\xa0\x62\x3e\x0a\x54\x18\xab\x4f\x76\xed\x32\xd6\x14\x5f\xbc\x71\xeb\xf1\x79\x77\x78\x0b\x2f\x73\x33\x56\x5b\x38\xbd\x16\x97\xe9\x1c\x43\xe5\xed\xe5\x9b\x30\xe0\x83\xda\x52\x11\x79\x33\x9a\x18\x93\xcf\xfc\xfe\x1d\x58\x82\xce\x3c\x6f\x40\x95\xc6\x0c\x25\xbd\x30\xc4\x3f\xf3\x8c\xe9\x5a\x69\x4d\x19\x88\x34\x18\x1b\x46\x24\xfe\x55\x62\x62\x74\x4d\xe8\x88\xc3\xbc\xdc\xca\x35\xee\xe8\x47\x56\xb9\x55\x6b\xdc\x11\x3d\x16\x09\x16\x55\x30\x73\xf5\xee\xf7\x11\x1c\xdd\x9d\x35\xf8\x33\x14\x23\x1a\x77\x10\xbe\xf4\x6f\x5e\xd3\x7a\xbf\x0d\x7d\xd3\x91\xd8\x4a\x12\x28\xb1\xcc\x44\x6d\xc1\x11\xd5\x67\xd8\xe1\xf5\xbe\xae\x65\x52\x94\x27\x8c\xa8\xa3\x35\x70\x2d\x1e\xda\x62\xee\x48\xdf\xef\xc7\xee\xf7\xe6\x56\xb2\x5d\xdc\x39\x22\x6d\x0f\x3f\x0c\xb6\x21\xfa\x3f\x74\xb7\x7f\x7d\xc4\x81\xc9\x9c\x73\x54\xf9\x0f\xb3\x7d\xc2\xb9\x63\x07\x47\x7f\x1d\xd1\x9a\xa2\x58\xaf\xc5\xd6\x62\x8e\xfe\x0d\x00\x54\x36\xe0\x8c\x55\x02\x00\x00")
+
+func sessionLuaBytes() ([]byte, error) {
+	return bindataRead(
+		_sessionLua,
+		"session.lua",
+	)
+}
+
+func sessionLua() (*asset, error) {
+	bytes, err := sessionLuaBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "session.lua", size: 597, mode: os.FileMode(420), modTime: time.Unix(1792309373, 0)}
 	a := &asset{bytes: bytes, info: info}
 	return a, nil
 }
@@ -139,6 +160,7 @@ func AssetNames() []string {
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
 	"generatekeys.lua": generatekeysLua,
+	"session.lua": sessionLua,
 }
 
 // AssetDir returns the file names below a certain
@@ -182,6 +204,7 @@ type bintree struct {
 }
 var _bintree = &bintree{nil, map[string]*bintree{
 	"generatekeys.lua": &bintree{generatekeysLua, map[string]*bintree{}},
+	"session.lua": &bintree{sessionLua, map[string]*bintree{}},
 }}
 
 // RestoreAsset restores an asset under the given directory
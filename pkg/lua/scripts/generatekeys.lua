@@ -1,8 +1,13 @@
+-- Generates a key pair on the curve named by curve in KEYS, outputting both
+-- keys base64 encoded.
+
 octet = require 'octet'
 ecdh = require 'ecdh'
 json = require 'json'
 
-keyring = ecdh.new('ec25519')
+keys = json.decode(KEYS)
+
+keyring = ecdh.new(keys.curve)
 keyring:keygen()
 
 output = json.encode({
@@ -0,0 +1,25 @@
+-- Outputs the shared secret of an ECDH exchange between two key pairs. KEYS
+-- must contain one party's private key as secret, and the other party's public
+-- key as public. Both keys must be base64 encoded, and on the curve named by
+-- curve.
+
+octet = require 'octet'
+ecdh = require 'ecdh'
+json = require 'json'
+
+function decode(encoded)
+	local o = octet.new(#encoded)
+	o:base64(encoded)
+	return o
+end
+
+keys = json.decode(KEYS)
+
+keyring = ecdh.new(keys.curve)
+keyring:private(decode(keys.secret))
+
+output = json.encode({
+	secret = keyring:session(decode(keys.public)):base64()
+})
+
+print(output)
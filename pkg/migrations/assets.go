@@ -8,6 +8,26 @@
 // sql/20180607111841_add_updated_at_to_users.up.sql
 // sql/20180608141559_add_streams.down.sql
 // sql/20180608141559_add_streams.up.sql
+// sql/20180612093412_add_failed_compensations.down.sql
+// sql/20180612093412_add_failed_compensations.up.sql
+// sql/20180613101527_add_outbox.down.sql
+// sql/20180613101527_add_outbox.up.sql
+// sql/20180614113045_add_updated_at_to_devices.down.sql
+// sql/20180614113045_add_updated_at_to_devices.up.sql
+// sql/20180615094521_add_broker_to_devices.down.sql
+// sql/20180615094521_add_broker_to_devices.up.sql
+// sql/20180618102233_add_key_history.down.sql
+// sql/20180618102233_add_key_history.up.sql
+// sql/20180619143015_add_decrypt_private_key.down.sql
+// sql/20180619143015_add_decrypt_private_key.up.sql
+// sql/20180619143522_add_rekey_checkpoints.down.sql
+// sql/20180619143522_add_rekey_checkpoints.up.sql
+// sql/20180620101512_add_key_format.down.sql
+// sql/20180620101512_add_key_format.up.sql
+// sql/20180621093045_add_claim_challenges.down.sql
+// sql/20180621093045_add_claim_challenges.up.sql
+// sql/20180622101130_add_signing_keys.down.sql
+// sql/20180622101130_add_signing_keys.up.sql
 package migrations
 
 import (
@@ -233,6 +253,406 @@ func _20180608141559_add_streamsUpSql() (*asset, error) {
 	return a, nil
 }
 
+var __20180612093412_add_failed_compensationsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x28\x00\xd7\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x66\x61\x69\x6c\x65\x64\x5f\x63\x6f\x6d\x70\x65\x6e\x73\x61\x74\x69\x6f\x6e\x73\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x03\x00\x59\x39\x79\xe8\x28\x00\x00\x00")
+
+func _20180612093412_add_failed_compensationsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180612093412_add_failed_compensationsDownSql,
+		"20180612093412_add_failed_compensations.down.sql",
+	)
+}
+
+func _20180612093412_add_failed_compensationsDownSql() (*asset, error) {
+	bytes, err := _20180612093412_add_failed_compensationsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180612093412_add_failed_compensations.down.sql", size: 40, mode: os.FileMode(420), modTime: time.Unix(1792304363, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180612093412_add_failed_compensationsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x90\x31\x4f\xc3\x30\x10\x85\x77\xff\x8a\x37\x36\x12\x0b\x73\x27\x43\xaf\x60\xe1\x38\xc5\xb9\xa8\x29\x8b\x65\xd5\x46\xb2\x44\x9a\xc8\x71\x25\x7e\x3e\x4a\x85\xda\x01\x06\x18\xef\xde\xf7\x9d\x74\xef\xd1\x92\x64\x02\xcb\x07\x4d\x50\x5b\x98\x86\x41\xbd\x6a\xb9\xc5\xbb\x4f\x1f\x31\xb8\xe3\x38\x4c\xf1\x34\xfb\x92\xc6\xd3\x8c\x95\x00\x52\x40\x4b\x56\x49\x8d\x9d\x55\xb5\xb4\x07\xbc\xd0\xe1\x4e\x00\x73\xc9\xd1\x0f\xee\x9c\x02\x98\x7a\xbe\x1c\x33\x9d\xd6\x4b\x16\x73\x1e\xf3\xcf\xb5\x2f\x25\x0e\x53\x99\xa1\x0c\xd3\x13\xd9\x6b\x88\x0d\x6d\x65\xa7\x19\xf7\x0b\x76\xcc\xd1\x97\x18\x9c\x2f\x60\x55\x53\xcb\xb2\xde\x61\xaf\xf8\xf9\x32\xe2\xad\x31\x74\x15\x4c\xb3\x5f\x55\x8b\x74\x9e\xc2\xff\x24\x51\xad\x85\xf8\x6e\xa4\x33\xea\xb5\x23\x28\xb3\xa1\xfe\x0f\xc5\xb8\xdb\xef\x2e\x85\x4f\x01\x34\xe6\x57\x70\x75\x03\xab\xb5\xf8\x1a\x00\x43\x6e\xe8\x8a\x7f\x01\x00\x00")
+
+func _20180612093412_add_failed_compensationsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180612093412_add_failed_compensationsUpSql,
+		"20180612093412_add_failed_compensations.up.sql",
+	)
+}
+
+func _20180612093412_add_failed_compensationsUpSql() (*asset, error) {
+	bytes, err := _20180612093412_add_failed_compensationsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180612093412_add_failed_compensations.up.sql", size: 383, mode: os.FileMode(420), modTime: time.Unix(1792304363, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180613101527_add_outboxDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x1a\x00\xe5\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x6f\x75\x74\x62\x6f\x78\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x03\x00\x47\xc9\x68\x7d\x1a\x00\x00\x00")
+
+func _20180613101527_add_outboxDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180613101527_add_outboxDownSql,
+		"20180613101527_add_outbox.down.sql",
+	)
+}
+
+func _20180613101527_add_outboxDownSql() (*asset, error) {
+	bytes, err := _20180613101527_add_outboxDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180613101527_add_outbox.down.sql", size: 26, mode: os.FileMode(420), modTime: time.Unix(1792304446, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180613101527_add_outboxUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x90\x41\x6b\xc2\x40\x10\x85\xef\xfb\x2b\xde\xcd\x04\x7a\xe8\xdd\xd3\xb6\x8e\xed\xd2\x64\x23\xc9\x88\xb1\x97\x65\xdb\xec\x21\x50\x5d\xd9\x8c\x90\x9f\x5f\x22\x41\x50\x4b\x8f\xc3\xf7\xbe\xc7\xf0\x5e\x6b\xd2\x4c\x60\xfd\x52\x10\xcc\x1a\xb6\x62\x50\x6b\x1a\x6e\x10\xcf\xf2\x15\x47\x64\x0a\xe8\x3b\x34\x54\x1b\x5d\x60\x53\x9b\x52\xd7\x7b\x7c\xd0\xfe\x49\x01\xf1\x14\x92\x97\x3e\x1e\xc1\xd4\xf2\xc5\xb6\xdb\xa2\x98\xd0\x20\x29\xf8\x83\x3b\xf7\xdd\x23\xf3\x22\xe1\x70\x92\x01\xc6\x32\xbd\x51\x7d\x85\x58\xd1\x5a\x6f\x0b\xc6\xf3\x14\xfb\xf1\x83\xb8\x90\x52\x4c\xb7\x15\xd7\xd4\x62\x31\xc5\x8e\x61\x14\x37\x57\x3a\x2f\x60\x53\x52\xc3\xba\xdc\x60\x67\xf8\xfd\x72\xe2\xb3\xb2\xf4\xe8\xdb\x6a\x97\xe5\x53\xc5\x77\x0a\x5e\x42\xf7\xaf\x7d\x23\xa9\x7c\xa9\xd4\x3c\x9e\xb1\x2b\x6a\xff\x1c\xcf\xdd\xbd\xe6\xfa\x6e\x54\x40\x65\x67\x9e\xdd\xf1\x7c\xa9\x7e\x07\x00\x28\xf9\x56\x18\x91\x01\x00\x00")
+
+func _20180613101527_add_outboxUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180613101527_add_outboxUpSql,
+		"20180613101527_add_outbox.up.sql",
+	)
+}
+
+func _20180613101527_add_outboxUpSql() (*asset, error) {
+	bytes, err := _20180613101527_add_outboxUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180613101527_add_outbox.up.sql", size: 401, mode: os.FileMode(420), modTime: time.Unix(1792304446, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180614113045_add_updated_at_to_devicesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2d\x00\xd2\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x75\x70\x64\x61\x74\x65\x64\x5f\x61\x74\x3b\x03\x00\xc3\xdc\x29\x6c\x2d\x00\x00\x00")
+
+func _20180614113045_add_updated_at_to_devicesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180614113045_add_updated_at_to_devicesDownSql,
+		"20180614113045_add_updated_at_to_devices.down.sql",
+	)
+}
+
+func _20180614113045_add_updated_at_to_devicesDownSql() (*asset, error) {
+	bytes, err := _20180614113045_add_updated_at_to_devicesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180614113045_add_updated_at_to_devices.down.sql", size: 45, mode: os.FileMode(420), modTime: time.Unix(1792304667, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180614113045_add_updated_at_to_devicesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x53\x00\xac\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x75\x70\x64\x61\x74\x65\x64\x5f\x61\x74\x20\x54\x49\x4d\x45\x53\x54\x41\x4d\x50\x20\x57\x49\x54\x48\x20\x54\x49\x4d\x45\x20\x5a\x4f\x4e\x45\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x4e\x4f\x57\x28\x29\x3b\x03\x00\x67\x5c\xf4\x43\x53\x00\x00\x00")
+
+func _20180614113045_add_updated_at_to_devicesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180614113045_add_updated_at_to_devicesUpSql,
+		"20180614113045_add_updated_at_to_devices.up.sql",
+	)
+}
+
+func _20180614113045_add_updated_at_to_devicesUpSql() (*asset, error) {
+	bytes, err := _20180614113045_add_updated_at_to_devicesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180614113045_add_updated_at_to_devices.up.sql", size: 83, mode: os.FileMode(420), modTime: time.Unix(1792304667, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180615094521_add_broker_to_devicesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x29\x00\xd6\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x62\x72\x6f\x6b\x65\x72\x3b\x03\x00\x12\x62\xea\x1f\x29\x00\x00\x00")
+
+func _20180615094521_add_broker_to_devicesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180615094521_add_broker_to_devicesDownSql,
+		"20180615094521_add_broker_to_devices.down.sql",
+	)
+}
+
+func _20180615094521_add_broker_to_devicesDownSql() (*asset, error) {
+	bytes, err := _20180615094521_add_broker_to_devicesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180615094521_add_broker_to_devices.down.sql", size: 41, mode: os.FileMode(420), modTime: time.Unix(1792304736, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180615094521_add_broker_to_devicesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x41\x00\xbe\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x64\x65\x76\x69\x63\x65\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x62\x72\x6f\x6b\x65\x72\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x03\x00\xc9\xec\x28\xaa\x41\x00\x00\x00")
+
+func _20180615094521_add_broker_to_devicesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180615094521_add_broker_to_devicesUpSql,
+		"20180615094521_add_broker_to_devices.up.sql",
+	)
+}
+
+func _20180615094521_add_broker_to_devicesUpSql() (*asset, error) {
+	bytes, err := _20180615094521_add_broker_to_devicesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180615094521_add_broker_to_devices.up.sql", size: 65, mode: os.FileMode(420), modTime: time.Unix(1792304736, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180618102233_add_key_historyDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x1f\x00\xe0\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x6b\x65\x79\x5f\x68\x69\x73\x74\x6f\x72\x79\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x03\x00\x46\xf4\x32\xb0\x1f\x00\x00\x00")
+
+func _20180618102233_add_key_historyDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180618102233_add_key_historyDownSql,
+		"20180618102233_add_key_history.down.sql",
+	)
+}
+
+func _20180618102233_add_key_historyDownSql() (*asset, error) {
+	bytes, err := _20180618102233_add_key_historyDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180618102233_add_key_history.down.sql", size: 31, mode: os.FileMode(420), modTime: time.Unix(1792305028, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180618102233_add_key_historyUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x8f\xc1\x4a\x03\x31\x14\x45\xf7\xf9\x8a\xbb\x9c\x81\xfe\x41\x57\xd1\xbe\x62\x30\x93\x29\xc9\x2b\x9d\xba\x09\x6a\x02\x86\x8a\x2d\x31\x45\xf3\xf7\x62\x15\x22\x8c\x2e\x5c\x3e\x0e\xef\x70\xcf\xb5\x25\xc9\x04\x96\x57\x9a\xa0\xd6\x30\x23\x83\x26\xe5\xd8\xe1\x10\xab\x7f\x4a\xaf\xe5\x98\x2b\x3a\x01\xa4\x00\x47\x56\x49\x8d\x8d\x55\x83\xb4\x7b\xdc\xd2\x7e\x21\x80\xe3\xdb\x4b\xcc\xbe\xd4\x53\x04\xd3\xc4\x17\x87\xd9\x6a\xdd\xd8\x39\x85\x39\x3a\x9d\x1f\x9e\xd3\xa3\x3f\xc4\x3a\x67\x39\x96\x94\x63\xf0\xf7\x05\xac\x06\x72\x2c\x87\x0d\x76\x8a\x6f\x2e\x27\xee\x46\x43\x58\xd1\x5a\x6e\xf5\xe7\xdf\xae\xeb\x45\xbf\x14\xe2\x3b\x46\x99\x15\x4d\x7f\xc7\xf8\xaf\x4d\x29\xbc\x0b\x60\x34\x3f\x51\xd7\x52\x16\x6d\xfa\x3f\xd4\xad\xe9\x77\x7f\xe3\xfd\x52\x7c\x0c\x00\x7c\x00\x49\x80\x7c\x01\x00\x00")
+
+func _20180618102233_add_key_historyUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180618102233_add_key_historyUpSql,
+		"20180618102233_add_key_history.up.sql",
+	)
+}
+
+func _20180618102233_add_key_historyUpSql() (*asset, error) {
+	bytes, err := _20180618102233_add_key_historyUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180618102233_add_key_history.up.sql", size: 380, mode: os.FileMode(420), modTime: time.Unix(1792305028, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180619143015_add_decrypt_private_keyDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x3f\x00\xc0\xff\x44\x52\x4f\x50\x20\x46\x55\x4e\x43\x54\x49\x4f\x4e\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x63\x72\x79\x70\x74\x5f\x70\x72\x69\x76\x61\x74\x65\x5f\x6b\x65\x79\x28\x42\x59\x54\x45\x41\x2c\x20\x54\x45\x58\x54\x2c\x20\x54\x45\x58\x54\x29\x3b\x03\x00\xa2\x58\x9a\x25\x3f\x00\x00\x00")
+
+func _20180619143015_add_decrypt_private_keyDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180619143015_add_decrypt_private_keyDownSql,
+		"20180619143015_add_decrypt_private_key.down.sql",
+	)
+}
+
+func _20180619143015_add_decrypt_private_keyDownSql() (*asset, error) {
+	bytes, err := _20180619143015_add_decrypt_private_keyDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180619143015_add_decrypt_private_key.down.sql", size: 63, mode: os.FileMode(420), modTime: time.Unix(1792305160, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180619143015_add_decrypt_private_keyUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x84\x8f\xcb\x6a\xc3\x30\x10\x45\xf7\xfa\x8a\xbb\x30\xa4\x81\xfc\x81\xe8\x42\x76\xc6\xb6\x40\x91\x83\x1e\x34\x5d\x09\x53\x9b\x10\x9a\x12\xd5\x72\x53\xfc\xf7\xc5\xa6\x0f\xfa\x80\x2e\x67\x2e\x73\xcf\x99\xc2\x90\x70\x84\xc6\xc0\xd0\x5e\x89\x82\x50\x7a\x5d\x38\xd9\x68\x74\xfd\xc3\x30\xc5\x31\xc4\xe1\x74\x6d\xc7\x3e\x3c\xf6\xd3\x4d\xd7\x8e\x2d\xf2\x7b\x47\x62\x83\xd8\xa6\xf4\x7a\x19\x3a\x38\x3a\xb8\x0d\xe2\xd0\x5f\x4f\x97\x97\x14\xbe\xed\xd7\x0c\x30\xe4\xbc\xd1\x76\x99\x21\x2c\xb2\x8c\xe5\x54\x49\xfd\x19\x21\x1e\x63\x48\xd3\x53\x78\x47\x2e\x98\x2f\xc0\x9a\x33\x3a\x14\xb4\x5f\xac\xee\x6a\xd2\x68\x5c\x4d\xc6\xc2\xd5\x34\x97\xc8\xf2\x0f\xb8\xb4\xd0\x5e\xa9\xf9\xb3\xdf\xe1\x2d\x56\xab\x8f\x6b\xc0\x08\x69\x89\x33\x80\xf4\x16\xb2\xe4\xff\x7a\xfd\xec\x9b\x05\xf5\x96\xb3\x2c\x83\x12\xba\xf2\xa2\x22\xc4\x73\x3c\xa6\xe7\x33\xe4\x6e\xe7\x9d\xc8\x15\x71\xf6\x36\x00\x58\x11\x52\x7e\x6c\x01\x00\x00")
+
+func _20180619143015_add_decrypt_private_keyUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180619143015_add_decrypt_private_keyUpSql,
+		"20180619143015_add_decrypt_private_key.up.sql",
+	)
+}
+
+func _20180619143015_add_decrypt_private_keyUpSql() (*asset, error) {
+	bytes, err := _20180619143015_add_decrypt_private_keyUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180619143015_add_decrypt_private_key.up.sql", size: 364, mode: os.FileMode(420), modTime: time.Unix(1792305160, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180619143522_add_rekey_checkpointsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x25\x00\xda\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x72\x65\x6b\x65\x79\x5f\x63\x68\x65\x63\x6b\x70\x6f\x69\x6e\x74\x73\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x03\x00\xfd\xf1\xd8\x6b\x25\x00\x00\x00")
+
+func _20180619143522_add_rekey_checkpointsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180619143522_add_rekey_checkpointsDownSql,
+		"20180619143522_add_rekey_checkpoints.down.sql",
+	)
+}
+
+func _20180619143522_add_rekey_checkpointsDownSql() (*asset, error) {
+	bytes, err := _20180619143522_add_rekey_checkpointsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180619143522_add_rekey_checkpoints.down.sql", size: 37, mode: os.FileMode(420), modTime: time.Unix(1792305160, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180619143522_add_rekey_checkpointsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x1c\xcc\xc1\x8e\x82\x30\x14\x46\xe1\x7d\x9f\xe2\x5f\x42\x32\x6f\x30\xab\xce\x78\xd1\xc6\x52\x48\xb9\x04\x70\xd3\x54\x68\x22\x01\x91\x48\x5d\xf8\xf6\x46\x96\x27\x27\xf9\xfe\x2d\x49\x26\xb0\xfc\xd3\x04\x95\xc1\x14\x0c\x6a\x55\xc5\x15\x9e\x61\x0a\x6f\xd7\xdf\x42\x3f\xad\x8f\x71\x89\x1b\x12\x01\x44\x7f\x9d\x83\x5b\xfc\x3d\x80\xa9\x65\x94\x56\xe5\xd2\x76\x38\x53\xf7\x23\x80\xd9\x6f\xd1\x8d\x03\x94\x61\x3a\x92\xdd\x3d\x53\x6b\xfd\x7d\xaf\x75\xf0\x31\x0c\xce\x47\xb0\xca\xa9\x62\x99\x97\x68\x14\x9f\xf6\xc4\xa5\x30\x84\x03\x65\xb2\xd6\x0c\x53\x34\x49\x2a\xd2\x5f\xf1\x19\x00\xc5\x86\x0e\xdc\xa1\x00\x00\x00")
+
+func _20180619143522_add_rekey_checkpointsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180619143522_add_rekey_checkpointsUpSql,
+		"20180619143522_add_rekey_checkpoints.up.sql",
+	)
+}
+
+func _20180619143522_add_rekey_checkpointsUpSql() (*asset, error) {
+	bytes, err := _20180619143522_add_rekey_checkpointsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180619143522_add_rekey_checkpoints.up.sql", size: 161, mode: os.FileMode(420), modTime: time.Unix(1792305160, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180620101512_add_key_formatDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x49\x2d\xcb\x4c\x4e\x2d\xe6\x52\x50\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\xc8\x4e\xad\x8c\x4f\xcd\x4b\xce\x4f\xc9\xcc\x4b\xd7\xc1\x22\x97\x5c\x5a\x54\x96\x6a\xcd\xc5\x85\x6c\x52\x69\x71\x6a\x11\x99\xe6\x00\x06\x00\xe9\xf7\xe0\x9e\x91\x00\x00\x00")
+
+func _20180620101512_add_key_formatDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180620101512_add_key_formatDownSql,
+		"20180620101512_add_key_format.down.sql",
+	)
+}
+
+func _20180620101512_add_key_formatDownSql() (*asset, error) {
+	bytes, err := _20180620101512_add_key_formatDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180620101512_add_key_format.down.sql", size: 145, mode: os.FileMode(420), modTime: time.Unix(1792305891, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180620101512_add_key_formatUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2d\x4e\x2d\x2a\xe6\x52\x50\x70\x74\x71\x51\x70\xf6\xf7\x09\xf5\xf5\x53\xc8\x4e\xad\x8c\x4f\x2e\x2d\x2a\x4b\x55\x08\x71\x8d\x08\x51\xf0\xf3\x0f\x51\xf0\x0b\xf5\xf1\x51\x70\x71\x75\x73\x0c\xf5\x09\x51\x50\x4f\x4d\x36\x32\x35\x35\xb4\x54\xd7\xc1\xd4\x97\x9a\x97\x9c\x9f\x92\x99\x97\x8e\x4b\x6b\x52\x62\x71\xaa\x99\x89\xba\x35\x17\x17\xb2\x23\x52\x52\xcb\x32\x93\x53\x07\xc2\x19\x80\x01\x00\x0b\x66\x1e\x5f\x0b\x01\x00\x00")
+
+func _20180620101512_add_key_formatUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180620101512_add_key_formatUpSql,
+		"20180620101512_add_key_format.up.sql",
+	)
+}
+
+func _20180620101512_add_key_formatUpSql() (*asset, error) {
+	bytes, err := _20180620101512_add_key_formatUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180620101512_add_key_format.up.sql", size: 267, mode: os.FileMode(420), modTime: time.Unix(1792305891, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180621093045_add_claim_challengesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x48\x00\xb7\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x63\x6c\x61\x69\x6d\x5f\x63\x68\x61\x6c\x6c\x65\x6e\x67\x65\x73\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x0a\x0a\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x63\x6c\x61\x69\x6d\x5f\x73\x65\x63\x72\x65\x74\x73\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x0a\x03\x00\xa5\xec\x35\xf8\x48\x00\x00\x00")
+
+func _20180621093045_add_claim_challengesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180621093045_add_claim_challengesDownSql,
+		"20180621093045_add_claim_challenges.down.sql",
+	)
+}
+
+func _20180621093045_add_claim_challengesDownSql() (*asset, error) {
+	bytes, err := _20180621093045_add_claim_challengesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180621093045_add_claim_challenges.down.sql", size: 72, mode: os.FileMode(420), modTime: time.Unix(1792307495, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180621093045_add_claim_challengesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xbc\x90\x41\x4b\xc3\x40\x10\x85\xef\xfb\x2b\xde\x31\x05\xff\x41\x4f\x5b\x3b\xc5\xc5\x64\x53\x93\x09\x4d\xbc\x84\xb0\x19\x74\x31\x56\x49\x82\xf4\xe7\x4b\xab\x74\x53\xc5\x20\x08\xde\x66\x78\x8f\x6f\xde\xbc\xeb\x8c\x34\x13\x58\xaf\x62\x82\xd9\xc0\xa6\x0c\x2a\x4d\xce\x39\x5c\xd7\xf8\xe7\x7a\x10\xd7\xcb\x38\x20\x52\x80\x6f\x91\x53\x66\x74\x8c\x6d\x66\x12\x9d\x55\xb8\xa5\xea\x4a\x01\xad\xbc\x79\x27\xf5\xf8\xf2\x24\x7b\x30\x95\x7c\xe2\xd8\x22\x8e\x8f\xea\x07\x02\xab\x8a\x49\x5f\x08\xae\x97\x66\x94\xb6\x6e\x46\xb0\x49\x28\x67\x9d\x6c\xb1\x33\x7c\x73\x5a\x71\x9f\x5a\xc2\x9a\x36\xba\x88\x8f\xc0\x5d\xb4\x50\x8b\xa5\x52\x9f\x91\x0b\x6b\xee\x0a\x82\xb1\x6b\x2a\xe7\x92\xd7\xd3\x70\xb5\x6f\x0f\x0a\x48\xed\xa5\x27\x9a\x7a\x26\x37\x7e\xae\xc5\x3d\x36\x5d\x27\xfb\x07\xf9\x53\x33\x67\xca\x77\x49\x0e\xaf\xbe\x97\x61\xb6\x9b\xff\xeb\x32\xbc\x1b\xc6\xaf\x5d\x06\x4f\x74\x1e\x27\x37\x7e\x05\x0f\x5f\xcf\xd0\x83\x69\xb1\x54\xef\x03\x00\x04\xbe\xbd\xfb\xc1\x02\x00\x00")
+
+func _20180621093045_add_claim_challengesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180621093045_add_claim_challengesUpSql,
+		"20180621093045_add_claim_challenges.up.sql",
+	)
+}
+
+func _20180621093045_add_claim_challengesUpSql() (*asset, error) {
+	bytes, err := _20180621093045_add_claim_challengesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180621093045_add_claim_challenges.up.sql", size: 705, mode: os.FileMode(420), modTime: time.Unix(1792307495, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180622101130_add_signing_keysDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x21\x00\xde\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x73\x69\x67\x6e\x69\x6e\x67\x5f\x6b\x65\x79\x73\x20\x43\x41\x53\x43\x41\x44\x45\x3b\x0a\x03\x00\xa3\xb3\x16\x09\x21\x00\x00\x00")
+
+func _20180622101130_add_signing_keysDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180622101130_add_signing_keysDownSql,
+		"20180622101130_add_signing_keys.down.sql",
+	)
+}
+
+func _20180622101130_add_signing_keysDownSql() (*asset, error) {
+	bytes, err := _20180622101130_add_signing_keysDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180622101130_add_signing_keys.down.sql", size: 33, mode: os.FileMode(420), modTime: time.Unix(1792311430, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var __20180622101130_add_signing_keysUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x8f\x41\x6a\xc3\x30\x10\x45\xf7\x3a\xc5\x5f\xda\xd0\x1b\x64\xa5\x36\x13\x2a\x2a\xcb\xa9\x3d\x26\x4e\x37\x22\x95\x06\x23\x5c\x94\x92\x38\xa1\xb9\x7d\x71\xe8\x22\x25\xa5\xcb\xe1\xfd\x79\xf0\x9e\x1a\xd2\x4c\x60\xfd\x68\x09\x66\x05\x57\x33\xa8\x37\x2d\xb7\x38\xa6\x21\xa7\x3c\xf8\x51\x2e\x47\x14\x0a\x48\x11\x2d\x35\x46\x5b\xac\x1b\x53\xe9\x66\x8b\x17\xda\x3e\x28\x20\xca\x39\x05\xf1\xd3\x7e\x94\x0c\xa6\x9e\xaf\x1a\xd7\x59\x3b\xd3\xcf\xd3\xfb\x47\x0a\xb3\xe6\x9e\x8d\x72\xf1\xe1\x74\x38\xcb\xdf\x48\x72\xd8\xc7\x94\x87\x7b\x1a\x0e\xb2\x9b\x24\xfa\xdd\x04\x36\x15\xb5\xac\xab\x35\x36\x86\x9f\xaf\x27\xde\x6a\x47\x58\xd2\x4a\x77\x76\xfe\xdb\x14\xa5\x2a\x17\x4a\xfd\xd4\x76\xce\xbc\x76\x04\xe3\x96\xd4\xff\x13\xed\x6f\xbb\x7c\x8a\x5f\x0a\xa8\xdd\xaf\x49\x71\x3b\x29\x17\xea\x7b\x00\xf9\x57\x7f\xe1\x4f\x01\x00\x00")
+
+func _20180622101130_add_signing_keysUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20180622101130_add_signing_keysUpSql,
+		"20180622101130_add_signing_keys.up.sql",
+	)
+}
+
+func _20180622101130_add_signing_keysUpSql() (*asset, error) {
+	bytes, err := _20180622101130_add_signing_keysUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20180622101130_add_signing_keys.up.sql", size: 335, mode: os.FileMode(420), modTime: time.Unix(1792311430, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -293,6 +713,26 @@ var _bindata = map[string]func() (*asset, error){
 	"20180607111841_add_updated_at_to_users.up.sql": _20180607111841_add_updated_at_to_usersUpSql,
 	"20180608141559_add_streams.down.sql": _20180608141559_add_streamsDownSql,
 	"20180608141559_add_streams.up.sql": _20180608141559_add_streamsUpSql,
+	"20180612093412_add_failed_compensations.down.sql": _20180612093412_add_failed_compensationsDownSql,
+	"20180612093412_add_failed_compensations.up.sql": _20180612093412_add_failed_compensationsUpSql,
+	"20180613101527_add_outbox.down.sql": _20180613101527_add_outboxDownSql,
+	"20180613101527_add_outbox.up.sql": _20180613101527_add_outboxUpSql,
+	"20180614113045_add_updated_at_to_devices.down.sql": _20180614113045_add_updated_at_to_devicesDownSql,
+	"20180614113045_add_updated_at_to_devices.up.sql": _20180614113045_add_updated_at_to_devicesUpSql,
+	"20180615094521_add_broker_to_devices.down.sql": _20180615094521_add_broker_to_devicesDownSql,
+	"20180615094521_add_broker_to_devices.up.sql": _20180615094521_add_broker_to_devicesUpSql,
+	"20180618102233_add_key_history.down.sql": _20180618102233_add_key_historyDownSql,
+	"20180618102233_add_key_history.up.sql": _20180618102233_add_key_historyUpSql,
+	"20180619143015_add_decrypt_private_key.down.sql": _20180619143015_add_decrypt_private_keyDownSql,
+	"20180619143015_add_decrypt_private_key.up.sql": _20180619143015_add_decrypt_private_keyUpSql,
+	"20180619143522_add_rekey_checkpoints.down.sql": _20180619143522_add_rekey_checkpointsDownSql,
+	"20180619143522_add_rekey_checkpoints.up.sql": _20180619143522_add_rekey_checkpointsUpSql,
+	"20180620101512_add_key_format.down.sql": _20180620101512_add_key_formatDownSql,
+	"20180620101512_add_key_format.up.sql": _20180620101512_add_key_formatUpSql,
+	"20180621093045_add_claim_challenges.down.sql": _20180621093045_add_claim_challengesDownSql,
+	"20180621093045_add_claim_challenges.up.sql": _20180621093045_add_claim_challengesUpSql,
+	"20180622101130_add_signing_keys.down.sql": _20180622101130_add_signing_keysDownSql,
+	"20180622101130_add_signing_keys.up.sql": _20180622101130_add_signing_keysUpSql,
 }
 
 // AssetDir returns the file names below a certain
@@ -343,6 +783,26 @@ var _bintree = &bintree{nil, map[string]*bintree{
 	"20180607111841_add_updated_at_to_users.up.sql": &bintree{_20180607111841_add_updated_at_to_usersUpSql, map[string]*bintree{}},
 	"20180608141559_add_streams.down.sql": &bintree{_20180608141559_add_streamsDownSql, map[string]*bintree{}},
 	"20180608141559_add_streams.up.sql": &bintree{_20180608141559_add_streamsUpSql, map[string]*bintree{}},
+	"20180612093412_add_failed_compensations.down.sql": &bintree{_20180612093412_add_failed_compensationsDownSql, map[string]*bintree{}},
+	"20180612093412_add_failed_compensations.up.sql": &bintree{_20180612093412_add_failed_compensationsUpSql, map[string]*bintree{}},
+	"20180613101527_add_outbox.down.sql": &bintree{_20180613101527_add_outboxDownSql, map[string]*bintree{}},
+	"20180613101527_add_outbox.up.sql": &bintree{_20180613101527_add_outboxUpSql, map[string]*bintree{}},
+	"20180614113045_add_updated_at_to_devices.down.sql": &bintree{_20180614113045_add_updated_at_to_devicesDownSql, map[string]*bintree{}},
+	"20180614113045_add_updated_at_to_devices.up.sql": &bintree{_20180614113045_add_updated_at_to_devicesUpSql, map[string]*bintree{}},
+	"20180615094521_add_broker_to_devices.down.sql": &bintree{_20180615094521_add_broker_to_devicesDownSql, map[string]*bintree{}},
+	"20180615094521_add_broker_to_devices.up.sql": &bintree{_20180615094521_add_broker_to_devicesUpSql, map[string]*bintree{}},
+	"20180618102233_add_key_history.down.sql": &bintree{_20180618102233_add_key_historyDownSql, map[string]*bintree{}},
+	"20180618102233_add_key_history.up.sql": &bintree{_20180618102233_add_key_historyUpSql, map[string]*bintree{}},
+	"20180619143015_add_decrypt_private_key.down.sql": &bintree{_20180619143015_add_decrypt_private_keyDownSql, map[string]*bintree{}},
+	"20180619143015_add_decrypt_private_key.up.sql": &bintree{_20180619143015_add_decrypt_private_keyUpSql, map[string]*bintree{}},
+	"20180619143522_add_rekey_checkpoints.down.sql": &bintree{_20180619143522_add_rekey_checkpointsDownSql, map[string]*bintree{}},
+	"20180619143522_add_rekey_checkpoints.up.sql": &bintree{_20180619143522_add_rekey_checkpointsUpSql, map[string]*bintree{}},
+	"20180620101512_add_key_format.down.sql": &bintree{_20180620101512_add_key_formatDownSql, map[string]*bintree{}},
+	"20180620101512_add_key_format.up.sql": &bintree{_20180620101512_add_key_formatUpSql, map[string]*bintree{}},
+	"20180621093045_add_claim_challenges.down.sql": &bintree{_20180621093045_add_claim_challengesDownSql, map[string]*bintree{}},
+	"20180621093045_add_claim_challenges.up.sql": &bintree{_20180621093045_add_claim_challengesUpSql, map[string]*bintree{}},
+	"20180622101130_add_signing_keys.down.sql": &bintree{_20180622101130_add_signing_keysDownSql, map[string]*bintree{}},
+	"20180622101130_add_signing_keys.up.sql": &bintree{_20180622101130_add_signing_keysUpSql, map[string]*bintree{}},
 }}
 
 // RestoreAsset restores an asset under the given directory
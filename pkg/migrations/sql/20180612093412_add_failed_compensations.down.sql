@@ -0,0 +1 @@
+DROP TABLE failed_compensations CASCADE;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS failed_compensations (
+  id SERIAL PRIMARY KEY,
+  stream_uid TEXT NOT NULL,
+  error TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 1,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS failed_compensations_stream_uid_idx
+  ON failed_compensations(stream_uid);
@@ -0,0 +1 @@
+DROP TABLE outbox CASCADE;
\ No newline at end of file
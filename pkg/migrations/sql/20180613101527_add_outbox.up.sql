@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox (
+  id SERIAL PRIMARY KEY,
+  operation TEXT NOT NULL,
+  stream_uid TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS outbox_next_attempt_at_idx
+  ON outbox(next_attempt_at);
@@ -0,0 +1,2 @@
+ALTER TABLE devices
+  DROP COLUMN updated_at;
\ No newline at end of file
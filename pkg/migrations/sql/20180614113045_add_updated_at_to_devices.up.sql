@@ -0,0 +1,2 @@
+ALTER TABLE devices
+  ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
\ No newline at end of file
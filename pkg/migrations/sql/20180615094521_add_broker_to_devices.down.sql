@@ -0,0 +1,2 @@
+ALTER TABLE devices
+  DROP COLUMN broker;
\ No newline at end of file
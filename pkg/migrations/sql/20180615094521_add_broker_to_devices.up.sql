@@ -0,0 +1,2 @@
+ALTER TABLE devices
+  ADD COLUMN broker TEXT NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE key_history CASCADE;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS key_history (
+  id SERIAL PRIMARY KEY,
+  owner_type TEXT NOT NULL,
+  owner_uid TEXT NOT NULL,
+  public_key TEXT NOT NULL,
+  retired_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS key_history_owner_idx
+  ON key_history(owner_type, owner_uid);
+
+CREATE INDEX IF NOT EXISTS key_history_public_key_idx
+  ON key_history(public_key);
@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS decrypt_private_key(BYTEA, TEXT, TEXT);
\ No newline at end of file
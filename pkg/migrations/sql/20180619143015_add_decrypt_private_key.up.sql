@@ -0,0 +1,11 @@
+CREATE OR REPLACE FUNCTION decrypt_private_key(data BYTEA, password TEXT, previous_password TEXT)
+  RETURNS TEXT AS $$
+BEGIN
+  RETURN pgp_sym_decrypt(data, password);
+EXCEPTION WHEN OTHERS THEN
+  IF previous_password IS NULL OR previous_password = '' THEN
+    RAISE;
+  END IF;
+  RETURN pgp_sym_decrypt(data, previous_password);
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
@@ -0,0 +1 @@
+DROP TABLE rekey_checkpoints CASCADE;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS rekey_checkpoints (
+  table_name TEXT PRIMARY KEY,
+  last_id INTEGER NOT NULL,
+  updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,7 @@
+ALTER TABLE devices
+  DROP COLUMN key_encoding,
+  DROP COLUMN key_curve;
+
+ALTER TABLE users
+  DROP COLUMN key_encoding,
+  DROP COLUMN key_curve;
@@ -0,0 +1,7 @@
+ALTER TABLE users
+  ADD COLUMN key_curve TEXT NOT NULL DEFAULT 'ec25519',
+  ADD COLUMN key_encoding TEXT NOT NULL DEFAULT 'base64';
+
+ALTER TABLE devices
+  ADD COLUMN key_curve TEXT NOT NULL DEFAULT 'ec25519',
+  ADD COLUMN key_encoding TEXT NOT NULL DEFAULT 'base64';
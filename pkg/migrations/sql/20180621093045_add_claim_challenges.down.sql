@@ -0,0 +1,3 @@
+DROP TABLE claim_challenges CASCADE;
+
+DROP TABLE claim_secrets CASCADE;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS claim_secrets (
+  id SERIAL PRIMARY KEY,
+  device_token TEXT NOT NULL,
+  secret BYTEA NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS claim_secrets_device_token_idx
+  ON claim_secrets(device_token);
+
+CREATE TABLE IF NOT EXISTS claim_challenges (
+  id SERIAL PRIMARY KEY,
+  device_token TEXT NOT NULL,
+  challenge TEXT NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS claim_challenges_challenge_idx
+  ON claim_challenges(challenge);
+
+CREATE INDEX IF NOT EXISTS claim_challenges_expires_at_idx
+  ON claim_challenges(expires_at);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS signing_keys (
+  id SERIAL PRIMARY KEY,
+  device_token TEXT NOT NULL,
+  public_key TEXT NOT NULL,
+  key_curve TEXT NOT NULL,
+  key_encoding TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS signing_keys_device_token_idx
+  ON signing_keys(device_token);
@@ -0,0 +1,16 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type StreamLister struct {
+	mock.Mock
+}
+
+func (s *StreamLister) ListStreams(ctx context.Context) ([]string, error) {
+	args := s.Called(ctx)
+	return args.Get(0).([]string), args.Error(1)
+}
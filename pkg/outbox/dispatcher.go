@@ -0,0 +1,289 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/thingful/iotdevicereg/pkg/encoderclient"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+const (
+	// DefaultInterval is the default interval at which we poll the outbox for
+	// messages that are due for delivery.
+	DefaultInterval = 5 * time.Second
+
+	// DefaultBatchSize is the default maximum number of messages we claim for
+	// delivery at once.
+	DefaultBatchSize = 50
+
+	// DefaultLease is the default interval for which claimed messages are
+	// hidden from other dispatchers while we deliver them. It should comfortably
+	// exceed the time needed to deliver a whole batch, although as deleting a
+	// stream is idempotent a message delivered twice does no harm.
+	DefaultLease = 5 * time.Minute
+
+	// DefaultInitialBackoff is the default interval we wait before retrying a
+	// message after its first failed delivery. The interval doubles after each
+	// subsequent failure.
+	DefaultInitialBackoff = time.Second
+
+	// DefaultMaxBackoff is the default maximum interval we wait between delivery
+	// attempts for a message.
+	DefaultMaxBackoff = 10 * time.Minute
+
+	// requestTimeout is the maximum time we allow for a single call to the
+	// encoder.
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	// outboxDeliveries is a prometheus counter recording attempts to deliver
+	// outbox messages keyed by operation and status.
+	outboxDeliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_outbox_deliveries",
+			Help: "Counter of attempts to deliver outbox messages to the encoder",
+		},
+		[]string{
+			// which operation are we delivering
+			"operation",
+			// is the status of the delivery: success or error
+			"status",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(outboxDeliveries)
+}
+
+// Config is used to inject dependencies and configuration into the dispatcher.
+// Any zero valued durations or sizes are replaced with the package defaults.
+type Config struct {
+	DB             postgres.DB
+	EncoderClient  encoder.Encoder
+	Interval       time.Duration
+	BatchSize      int
+	Lease          time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Verbose        bool
+}
+
+// Dispatcher is a component that polls the outbox table for encoder operations
+// written by our RPC handlers, and delivers them to the encoder. Messages are
+// removed from the outbox once delivered, or rescheduled with an exponential
+// backoff if delivery fails.
+type Dispatcher struct {
+	db             postgres.DB
+	encoderClient  encoder.Encoder
+	interval       time.Duration
+	batchSize      int
+	lease          time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	verbose        bool
+	logger         kitlog.Logger
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewDispatcher returns a new Dispatcher instance configured with the given
+// config and logger. The dispatcher does not do anything until started.
+func NewDispatcher(config *Config, logger kitlog.Logger) *Dispatcher {
+	logger = kitlog.With(logger, "module", "outbox")
+	logger.Log("msg", "creating dispatcher")
+
+	d := &Dispatcher{
+		db:             config.DB,
+		encoderClient:  config.EncoderClient,
+		interval:       config.Interval,
+		batchSize:      config.BatchSize,
+		lease:          config.Lease,
+		initialBackoff: config.InitialBackoff,
+		maxBackoff:     config.MaxBackoff,
+		verbose:        config.Verbose,
+		logger:         logger,
+		quit:           make(chan struct{}),
+	}
+
+	if d.interval == 0 {
+		d.interval = DefaultInterval
+	}
+
+	if d.batchSize == 0 {
+		d.batchSize = DefaultBatchSize
+	}
+
+	if d.lease == 0 {
+		d.lease = DefaultLease
+	}
+
+	if d.initialBackoff == 0 {
+		d.initialBackoff = DefaultInitialBackoff
+	}
+
+	if d.maxBackoff == 0 {
+		d.maxBackoff = DefaultMaxBackoff
+	}
+
+	return d
+}
+
+// Start starts the dispatcher polling the outbox in a background goroutine.
+func (d *Dispatcher) Start() error {
+	d.logger.Log("msg", "starting dispatcher", "interval", d.interval)
+
+	d.wg.Add(1)
+	go d.run()
+
+	return nil
+}
+
+// Stop signals the dispatcher to stop, and waits for any in progress delivery
+// to complete.
+func (d *Dispatcher) Stop() error {
+	d.logger.Log("msg", "stopping dispatcher")
+
+	close(d.quit)
+	d.wg.Wait()
+
+	return nil
+}
+
+// run is the main loop of the dispatcher, dispatching pending messages every
+// interval until we are stopped.
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.quit:
+			return
+		case <-ticker.C:
+			_, err := d.Dispatch()
+			if err != nil {
+				d.logger.Log("msg", "failed to dispatch outbox messages", "err", err)
+			}
+		}
+	}
+}
+
+// Dispatch delivers all messages currently due for delivery, working through
+// the outbox in batches. It returns the number of messages successfully
+// delivered. Failed deliveries are rescheduled rather than returned as errors;
+// an error is only returned if we were unable to read or update the outbox.
+func (d *Dispatcher) Dispatch() (int, error) {
+	var delivered int
+
+	for {
+		n, count, err := d.dispatchBatch()
+		delivered = delivered + n
+		if err != nil {
+			return delivered, err
+		}
+
+		if count < d.batchSize {
+			return delivered, nil
+		}
+
+		select {
+		case <-d.quit:
+			return delivered, nil
+		default:
+		}
+	}
+}
+
+// dispatchBatch claims a single batch of messages and attempts to deliver
+// them. Claiming the batch commits a lease on its messages, so no transaction
+// is held open while we call the encoder, and each message is then deleted or
+// rescheduled by its own statement. Returns the number of messages delivered,
+// and the number of messages in the batch.
+func (d *Dispatcher) dispatchBatch() (int, int, error) {
+	messages, err := d.db.ClaimOutboxMessages(d.batchSize, d.lease)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var delivered int
+
+	for _, message := range messages {
+		derr := d.deliver(message)
+		if derr != nil {
+			outboxDeliveries.WithLabelValues(message.Operation, "error").Inc()
+
+			d.logger.Log("msg", "failed to deliver outbox message", "id", message.ID, "operation", message.Operation, "attempts", message.Attempts+1, "err", derr)
+
+			err = d.db.RescheduleOutboxMessage(message.ID, time.Now().Add(d.backoff(message.Attempts)), derr.Error())
+			if err != nil {
+				return delivered, len(messages), err
+			}
+
+			continue
+		}
+
+		outboxDeliveries.WithLabelValues(message.Operation, "success").Inc()
+
+		if d.verbose {
+			d.logger.Log("msg", "delivered outbox message", "id", message.ID, "operation", message.Operation, "streamUID", message.StreamUID)
+		}
+
+		err = d.db.DeleteOutboxMessage(message.ID)
+		if err != nil {
+			return delivered, len(messages), err
+		}
+
+		delivered++
+	}
+
+	return delivered, len(messages), nil
+}
+
+// deliver performs the encoder operation described by the given message.
+// Deleting a stream the encoder does not know about is treated as success so
+// that redelivery of a message is idempotent.
+func (d *Dispatcher) deliver(message *postgres.OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	switch message.Operation {
+	case postgres.DeleteStreamOperation:
+		_, err := d.encoderClient.DeleteStream(ctx, &encoder.DeleteStreamRequest{
+			StreamUid: message.StreamUID,
+		})
+		if err != nil && !encoderclient.IsNotFound(err) {
+			return err
+		}
+
+		return nil
+	default:
+		return errors.Errorf("unknown outbox operation: %s", message.Operation)
+	}
+}
+
+// backoff returns the interval to wait before the next delivery attempt for a
+// message that has previously failed the given number of times.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.initialBackoff
+
+	for i := 0; i < attempts; i++ {
+		backoff = backoff * 2
+		if backoff >= d.maxBackoff {
+			return d.maxBackoff
+		}
+	}
+
+	return backoff
+}
@@ -0,0 +1,152 @@
+package outbox_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/mocks"
+	"github.com/thingful/iotdevicereg/pkg/outbox"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/system"
+)
+
+type DispatcherSuite struct {
+	suite.Suite
+
+	db            postgres.DB
+	logger        kitlog.Logger
+	encoderClient *mocks.Encoder
+	rawDb         *sqlx.DB
+}
+
+func (s *DispatcherSuite) SetupTest() {
+	connStr := os.Getenv("DEVICEREG_DATABASE_URL")
+
+	s.logger = kitlog.NewNopLogger()
+	s.encoderClient = new(mocks.Encoder)
+
+	db, err := sqlx.Open("postgres", connStr)
+	if err != nil {
+		s.T().Fatalf("Failed to open raw db connection: %v", err)
+	}
+
+	s.rawDb = db
+
+	s.db = postgres.NewDB(
+		&postgres.Config{
+			ConnStr:            connStr,
+			EncryptionPassword: "password",
+		},
+		s.logger,
+	)
+
+	err = s.db.(system.Startable).Start()
+	if err != nil {
+		s.T().Fatalf("Failed to start db: %v", err)
+	}
+
+	err = s.db.MigrateDownAll()
+	if err != nil {
+		s.T().Fatalf("Failed to migrate db down: %v", err)
+	}
+
+	err = s.db.MigrateUp()
+	if err != nil {
+		s.T().Fatalf("Failed to migrate db up: %v", err)
+	}
+}
+
+func (s *DispatcherSuite) TearDownTest() {
+	err := s.db.(system.Stoppable).Stop()
+	if err != nil {
+		s.T().Fatalf("Failed to stop db: %v", err)
+	}
+
+	err = s.rawDb.Close()
+	if err != nil {
+		s.T().Fatalf("Failed to stop raw db: %v", err)
+	}
+}
+
+func (s *DispatcherSuite) TestDispatch() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	for _, uid := range []string{"abc", "def", "hij"} {
+		err = s.db.EnqueueOutboxMessage(tx, postgres.DeleteStreamOperation, uid)
+		assert.Nil(s.T(), err)
+	}
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	s.encoderClient.On(
+		"DeleteStream",
+		mock.Anything,
+		&encoder.DeleteStreamRequest{StreamUid: "abc"},
+	).Return(&encoder.DeleteStreamResponse{}, nil)
+
+	// a stream already deleted from the encoder counts as delivered
+	s.encoderClient.On(
+		"DeleteStream",
+		mock.Anything,
+		&encoder.DeleteStreamRequest{StreamUid: "def"},
+	).Return(&encoder.DeleteStreamResponse{}, twirp.NotFoundError("stream not found"))
+
+	s.encoderClient.On(
+		"DeleteStream",
+		mock.Anything,
+		&encoder.DeleteStreamRequest{StreamUid: "hij"},
+	).Return(&encoder.DeleteStreamResponse{}, errors.New("encoder unavailable"))
+
+	dispatcher := outbox.NewDispatcher(&outbox.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		BatchSize:     2,
+	}, s.logger)
+
+	delivered, err := dispatcher.Dispatch()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, delivered)
+
+	var message postgres.OutboxMessage
+	err = s.rawDb.Get(&message, `SELECT * FROM outbox`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "hij", message.StreamUID)
+	assert.Equal(s.T(), 1, message.Attempts)
+	assert.Equal(s.T(), "encoder unavailable", message.LastError)
+
+	// the failed message has been rescheduled so is not redelivered immediately
+	delivered, err = dispatcher.Dispatch()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, delivered)
+
+	s.encoderClient.AssertExpectations(s.T())
+	s.encoderClient.AssertNumberOfCalls(s.T(), "DeleteStream", 3)
+}
+
+func (s *DispatcherSuite) TestStartStop() {
+	dispatcher := outbox.NewDispatcher(&outbox.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	err := dispatcher.Start()
+	assert.Nil(s.T(), err)
+
+	err = dispatcher.Stop()
+	assert.Nil(s.T(), err)
+}
+
+func TestRunDispatcherSuite(t *testing.T) {
+	suite.Run(t, new(DispatcherSuite))
+}
@@ -0,0 +1,222 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// BackupTables is the list of tables included in a snapshot, in the order in
+// which they are restored so that foreign keys are satisfied. Rekey
+// checkpoints are deliberately excluded, as they only describe a rekey in
+// progress against the current encryption key.
+var BackupTables = []string{
+	"users",
+	"devices",
+	"streams",
+	"key_history",
+	"failed_compensations",
+	"outbox",
+	"claim_secrets",
+	"signing_keys",
+}
+
+// ErrNotEmpty is the error returned (wrapped) when attempting to restore a
+// snapshot into a database which already contains rows.
+var ErrNotEmpty = errors.New("database is not empty")
+
+// Row is a single row of a table within a snapshot, keyed by column name.
+type Row map[string]interface{}
+
+// Snapshot is the entire contents of the tables in BackupTables, keyed by
+// table name. Private keys and claim secrets within a snapshot are decrypted,
+// so that a snapshot may be restored using any key encryption backend, and so
+// a snapshot must only ever be persisted encrypted.
+type Snapshot struct {
+	Tables map[string][]Row `json:"tables"`
+}
+
+// ReadSnapshot is our implementation of the ReadSnapshot method defined in our
+// interface. All tables are read within a single repeatable read transaction,
+// so the snapshot is consistent.
+func (d *db) ReadSnapshot() (*Snapshot, error) {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`SET TRANSACTION ISOLATION LEVEL REPEATABLE READ, READ ONLY`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set transaction isolation level")
+	}
+
+	snapshot := &Snapshot{Tables: map[string][]Row{}}
+
+	for _, table := range BackupTables {
+		rows, err := d.readTable(tx, table)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshot.Tables[table] = rows
+	}
+
+	return snapshot, nil
+}
+
+// readTable reads every row of the given table ordered by id, decrypting any
+// encrypted column.
+func (d *db) readTable(tx *sqlx.Tx, table string) ([]Row, error) {
+	encryptedColumn := encryptedColumns[table]
+
+	rows, err := tx.Queryx(fmt.Sprintf(`SELECT * FROM %s ORDER BY id`, pq.QuoteIdentifier(table)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", table)
+	}
+
+	var result []Row
+
+	for rows.Next() {
+		row := Row{}
+
+		err = rows.MapScan(row)
+		if err != nil {
+			rows.Close()
+			return nil, errors.Wrapf(err, "failed to scan %s", table)
+		}
+
+		// the driver returns values of types it doesn't know, such as our
+		// disposition enum, as bytes, but the only binary columns are encrypted
+		for column, value := range row {
+			if b, ok := value.([]byte); ok && column != encryptedColumn {
+				row[column] = string(b)
+			}
+		}
+
+		result = append(result, row)
+	}
+
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, errors.Wrapf(rows.Err(), "failed to read %s", table)
+	}
+
+	// we decrypt once all rows are read, as the connection is busy until then
+	for _, row := range result {
+		encrypted, ok := row[encryptedColumn].([]byte)
+		if !ok {
+			continue
+		}
+
+		key, _ := row[contextColumns[table]].(string)
+
+		row[encryptedColumn], err = d.keys.Decrypt(tx, encrypted, encryptionContext(table, key))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// RestoreSnapshot is our implementation of the RestoreSnapshot method defined
+// in our interface.
+func (d *db) RestoreSnapshot(snapshot *Snapshot) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	for _, table := range BackupTables {
+		var exists bool
+
+		err = tx.Get(&exists, fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s)`, pq.QuoteIdentifier(table)))
+		if err != nil {
+			return errors.Wrapf(err, "failed to check %s is empty", table)
+		}
+
+		if exists {
+			return errors.Wrapf(ErrNotEmpty, "failed to restore %s", table)
+		}
+	}
+
+	for _, table := range BackupTables {
+		for _, row := range snapshot.Tables[table] {
+			err = d.restoreRow(tx, table, row)
+			if err != nil {
+				return err
+			}
+		}
+
+		// rows are inserted with their original ids, so the sequence must be
+		// moved past them
+		_, err = tx.Exec(`SELECT setval(pg_get_serial_sequence($1, 'id'), COALESCE(MAX(id), 1), MAX(id) IS NOT NULL)
+			FROM `+pq.QuoteIdentifier(table), table)
+		if err != nil {
+			return errors.Wrapf(err, "failed to reset %s id sequence", table)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "failed to commit restore")
+	}
+
+	return nil
+}
+
+// restoreRow inserts a single row into the given table, encrypting its private
+// key or secret if it has one. Column names are quoted, so a snapshot is unable to
+// inject SQL, and any column that does not exist in the table fails the
+// restore.
+func (d *db) restoreRow(tx *sqlx.Tx, table string, row Row) error {
+	columns := make([]string, 0, len(row))
+	placeholders := make([]string, 0, len(row))
+	args := make([]interface{}, 0, len(row))
+
+	encryptedColumn := encryptedColumns[table]
+	contextColumn := contextColumns[table]
+
+	for column, value := range row {
+		if column == encryptedColumn {
+			plaintext, ok := value.(string)
+			if !ok {
+				return errors.Errorf("failed to restore %s: invalid %s", table, column)
+			}
+
+			key, ok := row[contextColumn].(string)
+			if !ok {
+				return errors.Errorf("failed to restore %s: invalid %s", table, contextColumn)
+			}
+
+			encrypted, err := d.keys.Encrypt(tx, plaintext, encryptionContext(table, key))
+			if err != nil {
+				return err
+			}
+
+			value = encrypted
+		}
+
+		columns = append(columns, pq.QuoteIdentifier(column))
+		args = append(args, value)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+
+	sql := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`,
+		pq.QuoteIdentifier(table),
+		strings.Join(columns, ", "),
+		strings.Join(placeholders, ", "),
+	)
+
+	_, err := tx.Exec(sql, args...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to restore %s", table)
+	}
+
+	return nil
+}
@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RegisterClaimSecret is our implementation of the RegisterClaimSecret method
+// defined in our interface.
+func (d *db) RegisterClaimSecret(deviceToken, secret string) error {
+	encrypted, err := d.keys.Encrypt(d.DB, secret, encryptionContext("claim_secrets", deviceToken))
+	if err != nil {
+		return err
+	}
+
+	sql := `INSERT INTO claim_secrets (device_token, secret)
+		VALUES (:device_token, :secret)
+		ON CONFLICT (device_token) DO UPDATE
+		SET secret = EXCLUDED.secret, created_at = NOW()`
+
+	mapArgs := map[string]interface{}{
+		"device_token": deviceToken,
+		"secret":       encrypted,
+	}
+
+	_, err = d.DB.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to register claim secret")
+	}
+
+	return nil
+}
+
+// ClaimSecret is our implementation of the ClaimSecret method defined in our
+// interface.
+func (d *db) ClaimSecret(deviceToken string) (string, error) {
+	sql := `SELECT secret FROM claim_secrets WHERE device_token = :device_token`
+
+	mapArgs := map[string]interface{}{
+		"device_token": deviceToken,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to bind named query to read claim secret")
+	}
+
+	var encrypted []byte
+
+	err = d.DB.Get(&encrypted, sql, args...)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read claim secret")
+	}
+
+	return d.keys.Decrypt(d.DB, encrypted, encryptionContext("claim_secrets", deviceToken))
+}
+
+// ErrTooManyChallenges is the error returned (wrapped) when attempting to
+// create a claim challenge for a device which already has the maximum number
+// of unexpired challenges.
+var ErrTooManyChallenges = errors.New("too many outstanding claim challenges")
+
+// CreateClaimChallenge is our implementation of the CreateClaimChallenge
+// method defined in our interface. We lock the device's claim secret while
+// counting its challenges, so concurrent calls cannot exceed the limit.
+func (d *db) CreateClaimChallenge(deviceToken, challenge string, expiresAt time.Time, limit int) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+	defer tx.Rollback()
+
+	var id int
+
+	err = tx.Get(&id, `SELECT id FROM claim_secrets WHERE device_token = $1 FOR UPDATE`, deviceToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to lock claim secret")
+	}
+
+	_, err = tx.Exec(`DELETE FROM claim_challenges WHERE expires_at < NOW()`)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete expired claim challenges")
+	}
+
+	var outstanding int
+
+	err = tx.Get(&outstanding, `SELECT COUNT(*) FROM claim_challenges WHERE device_token = $1`, deviceToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to count claim challenges")
+	}
+
+	if outstanding >= limit {
+		return errors.Wrap(ErrTooManyChallenges, "failed to insert claim challenge")
+	}
+
+	sql := `INSERT INTO claim_challenges (device_token, challenge, expires_at)
+		VALUES (:device_token, :challenge, :expires_at)`
+
+	mapArgs := map[string]interface{}{
+		"device_token": deviceToken,
+		"challenge":    challenge,
+		"expires_at":   expiresAt,
+	}
+
+	_, err = tx.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert claim challenge")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+// ConsumeClaimChallenge is our implementation of the ConsumeClaimChallenge
+// method defined in our interface. Deleting the challenge is a single
+// statement, so concurrent claims cannot both consume the same challenge.
+func (d *db) ConsumeClaimChallenge(deviceToken, challenge string) error {
+	sql := `DELETE FROM claim_challenges
+		WHERE device_token = :device_token
+		AND challenge = :challenge
+		AND expires_at >= NOW()
+		RETURNING id`
+
+	mapArgs := map[string]interface{}{
+		"device_token": deviceToken,
+		"challenge":    challenge,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to bind named query to consume claim challenge")
+	}
+
+	var id int
+
+	err = d.DB.Get(&id, sql, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to consume claim challenge")
+	}
+
+	return nil
+}
@@ -0,0 +1,394 @@
+package postgres
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PgcryptoBackend is the name of the key encryption backend which encrypts
+	// private keys within Postgres using pgcrypto's symmetric encryption. This is
+	// the default backend.
+	PgcryptoBackend = "pgcrypto"
+
+	// EnvelopeBackend is the name of the key encryption backend which encrypts
+	// private keys within the application using AES-GCM, with a random data key
+	// per row that is itself wrapped by a master key supplied via config.
+	EnvelopeBackend = "envelope"
+
+	// FileKMSBackend is the name of the key encryption backend which performs
+	// envelope encryption as above, but with data keys wrapped by named master
+	// keys read from a local file. This is a stand-in for a real key management
+	// service, and allows master keys to be rotated.
+	FileKMSBackend = "filekms"
+
+	// envelopeVersion is the first byte of every envelope encrypted value,
+	// allowing the format to be changed in future.
+	envelopeVersion = 1
+
+	// dataKeySize is the size in bytes of the AES-256 keys we use.
+	dataKeySize = 32
+)
+
+// KeyEncrypter is the interface implemented by the backends we use to encrypt
+// private keys before they are written to the DB, and to decrypt them after
+// they are read. Each method is passed the queryer (either the DB or a
+// transaction) being used for the surrounding operation, which is used by
+// backends that delegate encryption to Postgres, along with additional data
+// identifying the row in which the encrypted value is stored (see
+// encryptionContext). Backends able to authenticate additional data bind each
+// value to its row, so that a value copied into another row cannot be
+// decrypted there.
+type KeyEncrypter interface {
+	// Encrypt returns the encrypted form of the given private key.
+	Encrypt(q sqlx.Queryer, privateKey string, aad []byte) ([]byte, error)
+
+	// Decrypt returns the private key contained in the given encrypted data,
+	// which must have been encrypted with the same additional data.
+	Decrypt(q sqlx.Queryer, data, aad []byte) (string, error)
+}
+
+// KeyWrapper is the interface implemented by the components that protect the
+// per row data keys used by envelope encryption.
+type KeyWrapper interface {
+	// WrapKey returns the encrypted form of the given data key.
+	WrapKey(dataKey []byte) ([]byte, error)
+
+	// UnwrapKey returns the data key contained in the given wrapped key.
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// EncrypterConfig is used to select and configure a key encryption backend.
+// Which fields are required depends on the backend: pgcrypto requires
+// EncryptionPassword (and optionally PreviousEncryptionPassword), envelope
+// requires MasterKey, and filekms requires KMSFile.
+type EncrypterConfig struct {
+	Backend                    string
+	EncryptionPassword         string
+	PreviousEncryptionPassword string
+	MasterKey                  string
+	KMSFile                    string
+}
+
+// NewKeyEncrypter returns a KeyEncrypter for the backend named in the given
+// config, or an error if the backend is unknown or misconfigured. An empty
+// backend name selects the pgcrypto backend.
+func NewKeyEncrypter(config *EncrypterConfig) (KeyEncrypter, error) {
+	switch config.Backend {
+	case "", PgcryptoBackend:
+		if config.EncryptionPassword == "" {
+			return nil, errors.New("pgcrypto key encryption requires an encryption password")
+		}
+
+		return &pgcryptoEncrypter{
+			password:         []byte(config.EncryptionPassword),
+			previousPassword: []byte(config.PreviousEncryptionPassword),
+		}, nil
+	case EnvelopeBackend:
+		key, err := base64.StdEncoding.DecodeString(config.MasterKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode master key")
+		}
+
+		if len(key) != dataKeySize {
+			return nil, errors.Errorf("master key must be %d bytes", dataKeySize)
+		}
+
+		return &envelopeEncrypter{
+			wrapper: &masterKeyWrapper{key: key},
+		}, nil
+	case FileKMSBackend:
+		wrapper, err := newFileKMS(config.KMSFile)
+		if err != nil {
+			return nil, err
+		}
+
+		return &envelopeEncrypter{
+			wrapper: wrapper,
+		}, nil
+	default:
+		return nil, errors.Errorf("unknown key encryption backend: %s", config.Backend)
+	}
+}
+
+// pgcryptoEncrypter is a KeyEncrypter that uses pgcrypto's symmetric
+// encryption. The password is sent to Postgres as a query parameter. While a
+// previous password is configured we are also able to decrypt values that
+// were encrypted with it. pgcrypto has no notion of additional data, so it is
+// ignored by this backend.
+type pgcryptoEncrypter struct {
+	password         []byte
+	previousPassword []byte
+}
+
+// Encrypt is our implementation of the KeyEncrypter interface.
+func (e *pgcryptoEncrypter) Encrypt(q sqlx.Queryer, privateKey string, aad []byte) ([]byte, error) {
+	var data []byte
+
+	err := sqlx.Get(q, &data, `SELECT pgp_sym_encrypt($1::TEXT, $2::TEXT)`, privateKey, e.password)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt private key")
+	}
+
+	return data, nil
+}
+
+// Decrypt is our implementation of the KeyEncrypter interface.
+func (e *pgcryptoEncrypter) Decrypt(q sqlx.Queryer, data, aad []byte) (string, error) {
+	var privateKey string
+
+	err := sqlx.Get(q, &privateKey, `SELECT decrypt_private_key($1, $2, $3)`, data, e.password, e.previousPassword)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt private key")
+	}
+
+	return privateKey, nil
+}
+
+// envelopeEncrypter is a KeyEncrypter that encrypts each private key within
+// the application with a newly generated data key, and stores the data key
+// alongside the encrypted private key once wrapped by a KeyWrapper. Nothing
+// secret is ever sent to Postgres.
+type envelopeEncrypter struct {
+	wrapper KeyWrapper
+}
+
+// Encrypt is our implementation of the KeyEncrypter interface. The returned
+// value consists of a version byte, the length of the wrapped data key as a
+// big endian uint16, the wrapped data key and finally the encrypted private
+// key, which is authenticated along with the given additional data.
+func (e *envelopeEncrypter) Encrypt(q sqlx.Queryer, privateKey string, aad []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+
+	_, err := io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate data key")
+	}
+
+	ciphertext, err := seal(dataKey, []byte(privateKey), aad)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := e.wrapper.WrapKey(dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to wrap data key")
+	}
+
+	data := make([]byte, 3, 3+len(wrapped)+len(ciphertext))
+	data[0] = envelopeVersion
+	binary.BigEndian.PutUint16(data[1:3], uint16(len(wrapped)))
+	data = append(data, wrapped...)
+	data = append(data, ciphertext...)
+
+	return data, nil
+}
+
+// Decrypt is our implementation of the KeyEncrypter interface.
+func (e *envelopeEncrypter) Decrypt(q sqlx.Queryer, data, aad []byte) (string, error) {
+	if len(data) < 3 || data[0] != envelopeVersion {
+		return "", errors.New("invalid envelope encrypted private key")
+	}
+
+	wrappedLen := int(binary.BigEndian.Uint16(data[1:3]))
+	if len(data) < 3+wrappedLen {
+		return "", errors.New("invalid envelope encrypted private key")
+	}
+
+	dataKey, err := e.wrapper.UnwrapKey(data[3 : 3+wrappedLen])
+	if err != nil {
+		return "", errors.Wrap(err, "failed to unwrap data key")
+	}
+
+	privateKey, err := open(dataKey, data[3+wrappedLen:], aad)
+	if err != nil {
+		return "", err
+	}
+
+	return string(privateKey), nil
+}
+
+// masterKeyWrapper is a KeyWrapper that wraps data keys with a single master
+// key using AES-GCM.
+type masterKeyWrapper struct {
+	key []byte
+}
+
+// WrapKey is our implementation of the KeyWrapper interface.
+func (w *masterKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(w.key, dataKey, nil)
+}
+
+// UnwrapKey is our implementation of the KeyWrapper interface.
+func (w *masterKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return open(w.key, wrapped, nil)
+}
+
+// fileKMS is a KeyWrapper standing in for a key management service. It reads
+// a set of named master keys from a JSON file of the form:
+//
+//	{"current": "key2", "keys": {"key1": "<base64>", "key2": "<base64>"}}
+//
+// New data keys are wrapped with the current master key, and the name of the
+// master key is stored with the wrapped key so that keys wrapped by any
+// master key in the file can be unwrapped. Master keys can therefore be
+// rotated by adding a new key, making it current, and running the rekey
+// command.
+type fileKMS struct {
+	current string
+	keys    map[string][]byte
+}
+
+// newFileKMS reads and validates the master keys in the file at the given
+// path.
+func newFileKMS(path string) (*fileKMS, error) {
+	if path == "" {
+		return nil, errors.New("filekms key encryption requires a key file")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read key file")
+	}
+
+	var file struct {
+		Current string            `json:"current"`
+		Keys    map[string]string `json:"keys"`
+	}
+
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse key file")
+	}
+
+	kms := &fileKMS{
+		current: file.Current,
+		keys:    make(map[string][]byte),
+	}
+
+	for name, encoded := range file.Keys {
+		if len(name) > 255 {
+			return nil, errors.Errorf("key name too long: %s", name)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode key: %s", name)
+		}
+
+		if len(key) != dataKeySize {
+			return nil, errors.Errorf("key %s must be %d bytes", name, dataKeySize)
+		}
+
+		kms.keys[name] = key
+	}
+
+	if _, ok := kms.keys[kms.current]; !ok {
+		return nil, errors.Errorf("current key not found in key file: %s", kms.current)
+	}
+
+	return kms, nil
+}
+
+// WrapKey is our implementation of the KeyWrapper interface. The returned
+// value consists of the length of the master key name as a single byte, the
+// name itself, and then the wrapped data key.
+func (k *fileKMS) WrapKey(dataKey []byte) ([]byte, error) {
+	sealed, err := seal(k.keys[k.current], dataKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := make([]byte, 0, 1+len(k.current)+len(sealed))
+	wrapped = append(wrapped, byte(len(k.current)))
+	wrapped = append(wrapped, k.current...)
+	wrapped = append(wrapped, sealed...)
+
+	return wrapped, nil
+}
+
+// UnwrapKey is our implementation of the KeyWrapper interface.
+func (k *fileKMS) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 1 || len(wrapped) < 1+int(wrapped[0]) {
+		return nil, errors.New("invalid wrapped key")
+	}
+
+	name := string(wrapped[1 : 1+int(wrapped[0])])
+
+	key, ok := k.keys[name]
+	if !ok {
+		return nil, errors.Errorf("unknown master key: %s", name)
+	}
+
+	return open(key, wrapped[1+int(wrapped[0]):], nil)
+}
+
+// seal encrypts the given plaintext with the given key using AES-GCM,
+// authenticating the given additional data, and returns the random nonce
+// followed by the ciphertext.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts a value created by seal with the given key and additional
+// data.
+func open(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value too short")
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt value")
+	}
+
+	return plaintext, nil
+}
+
+// encryptionContext returns the additional data binding an encrypted value to
+// the row of the given table identified by the given key, which is the value
+// of the table's column in contextColumns.
+func encryptionContext(table, key string) []byte {
+	return []byte(table + "\x00" + key)
+}
+
+// newGCM returns an AES-GCM AEAD for the given key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create gcm")
+	}
+
+	return gcm, nil
+}
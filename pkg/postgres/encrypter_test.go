@@ -0,0 +1,197 @@
+package postgres_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+func randomKey(t *testing.T) string {
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func writeKMSFile(t *testing.T, path, current string, keys map[string]string) {
+	contents := fmt.Sprintf(`{"current": %q, "keys": {`, current)
+
+	first := true
+	for name, key := range keys {
+		if !first {
+			contents = contents + ","
+		}
+		contents = contents + fmt.Sprintf(`%q: %q`, name, key)
+		first = false
+	}
+
+	contents = contents + "}}"
+
+	err := ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+}
+
+func TestEnvelopeEncrypter(t *testing.T) {
+	aad := []byte("users\x00abc123")
+
+	encrypter, err := postgres.NewKeyEncrypter(&postgres.EncrypterConfig{
+		Backend:   postgres.EnvelopeBackend,
+		MasterKey: randomKey(t),
+	})
+	assert.Nil(t, err)
+
+	data, err := encrypter.Encrypt(nil, "private", aad)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "private")
+
+	// each encryption uses a new data key
+	other, err := encrypter.Encrypt(nil, "private", aad)
+	assert.Nil(t, err)
+	assert.NotEqual(t, data, other)
+
+	privateKey, err := encrypter.Decrypt(nil, data, aad)
+	assert.Nil(t, err)
+	assert.Equal(t, "private", privateKey)
+
+	// a value is bound to the row it was encrypted for, so is unable to be
+	// decrypted once copied to another
+	_, err = encrypter.Decrypt(nil, data, []byte("users\x00def456"))
+	assert.NotNil(t, err)
+
+	// a different master key is unable to decrypt
+	encrypter, err = postgres.NewKeyEncrypter(&postgres.EncrypterConfig{
+		Backend:   postgres.EnvelopeBackend,
+		MasterKey: randomKey(t),
+	})
+	assert.Nil(t, err)
+
+	_, err = encrypter.Decrypt(nil, data, aad)
+	assert.NotNil(t, err)
+
+	_, err = encrypter.Decrypt(nil, []byte("garbage"), aad)
+	assert.NotNil(t, err)
+}
+
+func TestFileKMSEncrypter(t *testing.T) {
+	aad := []byte("devices\x00abc123")
+
+	dir, err := ioutil.TempDir("", "devicereg")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+	key1 := randomKey(t)
+	key2 := randomKey(t)
+
+	writeKMSFile(t, path, "key1", map[string]string{"key1": key1})
+
+	encrypter, err := postgres.NewKeyEncrypter(&postgres.EncrypterConfig{
+		Backend: postgres.FileKMSBackend,
+		KMSFile: path,
+	})
+	assert.Nil(t, err)
+
+	data, err := encrypter.Encrypt(nil, "private", aad)
+	assert.Nil(t, err)
+
+	privateKey, err := encrypter.Decrypt(nil, data, aad)
+	assert.Nil(t, err)
+	assert.Equal(t, "private", privateKey)
+
+	// after rotating the current key, keys wrapped by the old key can still be
+	// decrypted
+	writeKMSFile(t, path, "key2", map[string]string{"key1": key1, "key2": key2})
+
+	encrypter, err = postgres.NewKeyEncrypter(&postgres.EncrypterConfig{
+		Backend: postgres.FileKMSBackend,
+		KMSFile: path,
+	})
+	assert.Nil(t, err)
+
+	privateKey, err = encrypter.Decrypt(nil, data, aad)
+	assert.Nil(t, err)
+	assert.Equal(t, "private", privateKey)
+
+	rotated, err := encrypter.Encrypt(nil, "private", aad)
+	assert.Nil(t, err)
+
+	// once the old key is removed only keys wrapped by the new key decrypt
+	writeKMSFile(t, path, "key2", map[string]string{"key2": key2})
+
+	encrypter, err = postgres.NewKeyEncrypter(&postgres.EncrypterConfig{
+		Backend: postgres.FileKMSBackend,
+		KMSFile: path,
+	})
+	assert.Nil(t, err)
+
+	_, err = encrypter.Decrypt(nil, data, aad)
+	assert.NotNil(t, err)
+
+	privateKey, err = encrypter.Decrypt(nil, rotated, aad)
+	assert.Nil(t, err)
+	assert.Equal(t, "private", privateKey)
+}
+
+func TestNewKeyEncrypterErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devicereg")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+	writeKMSFile(t, path, "missing", map[string]string{"key1": randomKey(t)})
+
+	testcases := []struct {
+		label  string
+		config *postgres.EncrypterConfig
+	}{
+		{
+			label:  "unknown backend",
+			config: &postgres.EncrypterConfig{Backend: "unknown"},
+		},
+		{
+			label:  "missing password",
+			config: &postgres.EncrypterConfig{Backend: postgres.PgcryptoBackend},
+		},
+		{
+			label:  "invalid master key",
+			config: &postgres.EncrypterConfig{Backend: postgres.EnvelopeBackend, MasterKey: "not base64"},
+		},
+		{
+			label:  "short master key",
+			config: &postgres.EncrypterConfig{Backend: postgres.EnvelopeBackend, MasterKey: "c2hvcnQ="},
+		},
+		{
+			label:  "missing key file",
+			config: &postgres.EncrypterConfig{Backend: postgres.FileKMSBackend, KMSFile: filepath.Join(dir, "absent.json")},
+		},
+		{
+			label:  "missing current key",
+			config: &postgres.EncrypterConfig{Backend: postgres.FileKMSBackend, KMSFile: path},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := postgres.NewKeyEncrypter(tc.config)
+			assert.NotNil(t, err)
+		})
+	}
+}
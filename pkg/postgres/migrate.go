@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
@@ -71,6 +73,49 @@ func MigrateDownAll(db *sql.DB, logger kitlog.Logger) error {
 	return nil
 }
 
+// SchemaVersion returns the version of the last migration applied to
+// Postgres, and whether it failed leaving the schema dirty. A database to
+// which no migrations have been applied has version 0. It takes as parameters
+// an sql.DB instance, and a logger instance.
+func SchemaVersion(db *sql.DB, logger kitlog.Logger) (uint, bool, error) {
+	m, err := getMigrator(db, logger)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "failed to create migrator")
+	}
+
+	version, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		return 0, false, nil
+	}
+
+	if err != nil {
+		return 0, false, errors.Wrap(err, "failed to read schema version")
+	}
+
+	return version, dirty, nil
+}
+
+// LatestSchemaVersion returns the version of the latest migration compiled
+// into the binary, which is the schema version of a fully migrated database.
+func LatestSchemaVersion() (uint, error) {
+	var latest uint64
+
+	for _, name := range migrations.AssetNames() {
+		parts := strings.SplitN(name, "_", 2)
+
+		version, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid migration name: %s", name)
+		}
+
+		if version > latest {
+			latest = version
+		}
+	}
+
+	return uint(latest), nil
+}
+
 // NewMigration creates a new pair of files into which an SQL migration should
 // be written. All this is doing is ensuring files created are correctly named.
 func NewMigration(dirName, migrationName string, logger kitlog.Logger) error {
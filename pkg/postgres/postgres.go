@@ -1,9 +1,14 @@
 package postgres
 
 import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 
 	"github.com/thingful/iotdevicereg/pkg/crypto"
@@ -13,25 +18,53 @@ import (
 // stored in the database. Represents an individual DECODE user. A single User
 // may register multiple devices.
 type User struct {
-	ID         int    `db:"id"`
-	UID        string `db:"uid"`
-	PrivateKey string `db:"private_key"`
-	PublicKey  string `db:"public_key"`
+	ID          int    `db:"id"`
+	UID         string `db:"uid"`
+	PrivateKey  string `db:"private_key"`
+	PublicKey   string `db:"public_key"`
+	KeyCurve    string `db:"key_curve"`
+	KeyEncoding string `db:"key_encoding"`
+}
+
+// KeyOptions returns the curve and encoding of the user's key pair.
+func (u *User) KeyOptions() *crypto.KeyOptions {
+	return &crypto.KeyOptions{
+		Curve:    u.KeyCurve,
+		Encoding: u.KeyEncoding,
+	}
 }
 
 // Device is our exported local type that allows us to maniuplate Device records
 // that are stored in the DB. Represents an individual device that may be
 // registered. A single user may register multiple devices.
 type Device struct {
-	ID          int     `db:"id"`
-	Token       string  `db:"token"`
-	PrivateKey  string  `db:"private_key"`
-	PublicKey   string  `db:"public_key"`
-	Longitude   float64 `db:"longitude"`
-	Latitude    float64 `db:"latitude"`
-	Disposition string  `db:"disposition"`
+	ID          int       `db:"id"`
+	Token       string    `db:"token"`
+	PrivateKey  string    `db:"private_key"`
+	PublicKey   string    `db:"public_key"`
+	Longitude   float64   `db:"longitude"`
+	Latitude    float64   `db:"latitude"`
+	Disposition string    `db:"disposition"`
+	Broker      string    `db:"broker"`
+	KeyCurve    string    `db:"key_curve"`
+	KeyEncoding string    `db:"key_encoding"`
+	CreatedAt   time.Time `db:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at"`
+
+	// Existing is set by RegisterDevice when the device had already been
+	// registered by the same user, and so was not inserted.
+	Existing bool `db:"-"`
+
+	User    *User
+	Streams []*Stream
+}
 
-	User *User
+// KeyOptions returns the curve and encoding of the device's key pair.
+func (d *Device) KeyOptions() *crypto.KeyOptions {
+	return &crypto.KeyOptions{
+		Curve:    d.KeyCurve,
+		Encoding: d.KeyEncoding,
+	}
 }
 
 // Stream is the local representation of a created stream for a Device. We keep
@@ -43,6 +76,105 @@ type Stream struct {
 	Device *Device
 }
 
+// FailedCompensation is the local representation of a compensating action we
+// were unable to complete. Currently this records streams that were created on
+// the encoder for a claim that was subsequently rolled back, and which we then
+// failed to delete, so that the deletion can be replayed later.
+type FailedCompensation struct {
+	ID        int       `db:"id"`
+	StreamUID string    `db:"stream_uid"`
+	Error     string    `db:"error"`
+	Attempts  int       `db:"attempts"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// ExportFilter restricts the devices read by ExportDevices. Zero valued fields
+// do not restrict the devices read.
+type ExportFilter struct {
+	// UserUIDs restricts devices to those owned by any of the given users.
+	UserUIDs []string
+
+	// CreatedAfter restricts devices to those created at or after the given
+	// time.
+	CreatedAfter time.Time
+
+	// CreatedBefore restricts devices to those created before the given time.
+	CreatedBefore time.Time
+
+	// Disposition restricts devices to those with the given disposition.
+	Disposition string
+
+	// BoundingBox restricts devices to those located within the given box.
+	BoundingBox *BoundingBox
+}
+
+// BoundingBox is a rectangular area described by its minimum and maximum
+// longitude and latitude, inclusive.
+type BoundingBox struct {
+	MinLongitude float64
+	MinLatitude  float64
+	MaxLongitude float64
+	MaxLatitude  float64
+}
+
+// ErrDeviceClaimed is the error returned (wrapped) when attempting to register
+// a device that has already been registered by another user.
+var ErrDeviceClaimed = errors.New("device already claimed by another user")
+
+const (
+	// DeviceKeyOwner is the owner type recorded in the key history for retired
+	// device keys. The owner uid for these is the device token.
+	DeviceKeyOwner = "device"
+
+	// UserKeyOwner is the owner type recorded in the key history for retired
+	// user keys. The owner uid for these is the user uid.
+	UserKeyOwner = "user"
+)
+
+// RekeyTables is the list of tables containing encrypted private keys or
+// secrets, in the order in which they are rekeyed.
+var RekeyTables = []string{"users", "devices", "claim_secrets"}
+
+// encryptedColumns maps each of the RekeyTables to the column of that table
+// encrypted with our key encrypter.
+var encryptedColumns = map[string]string{
+	"users":         "private_key",
+	"devices":       "private_key",
+	"claim_secrets": "secret",
+}
+
+// contextColumns maps each of the RekeyTables to the column of that table
+// which identifies the row in the encryption context of its encrypted column.
+// These are natural keys rather than ids, as they are known before a row is
+// inserted and are preserved by a restore.
+var contextColumns = map[string]string{
+	"users":         "uid",
+	"devices":       "token",
+	"claim_secrets": "device_token",
+}
+
+// uniqueViolation is the Postgres error code raised when an insert violates a
+// unique constraint.
+const uniqueViolation = "23505"
+
+// DeleteStreamOperation is the outbox operation used to request that a stream
+// be deleted from the encoder.
+const DeleteStreamOperation = "delete_stream"
+
+// OutboxMessage is the local representation of an operation against the
+// encoder that has been written to our outbox within a transaction, and which
+// must be delivered by the outbox dispatcher once that transaction commits.
+type OutboxMessage struct {
+	ID            int       `db:"id"`
+	Operation     string    `db:"operation"`
+	StreamUID     string    `db:"stream_uid"`
+	Attempts      int       `db:"attempts"`
+	LastError     string    `db:"last_error"`
+	NextAttemptAt time.Time `db:"next_attempt_at"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
 // DB is our interface to Postgres. Exposes methods for inserting a new Device
 // (and associated Stream), listing all Devices, getting an individual Device,
 // and deleting a Stream
@@ -50,12 +182,11 @@ type DB interface {
 	// RegisterDevice takes as input a pointer to an instantiated Device instance
 	// populated from the incoming public ClaimDeviceRequest message. We persist
 	// the associated user into the DB if they aren't already registered, and then
-	// attempt to persist the Device. Attempting to register an already registered
-	// device will return an error.
-	//
-	// TODO: what should we do if the device is
-	// already registered by the same user? what should we do if the device is
-	// already registered for another user?
+	// attempt to persist the Device. If the device is already registered by the
+	// same user we return the existing device with its Existing flag set and its
+	// streams populated, leaving the stored device unchanged. If the device is
+	// already registered by another user we return an error wrapping
+	// ErrDeviceClaimed.
 	RegisterDevice(tx *sqlx.Tx, device *Device) (*Device, error)
 
 	// DeleteDevice attempts to delete a device identified by its token and the
@@ -65,11 +196,217 @@ type DB interface {
 	// the stream encoder.
 	DeleteDevice(tx *sqlx.Tx, token, publicKey string) ([]*Stream, error)
 
+	// UpdateDevice updates the location, disposition and broker of the device
+	// identified by the given device's token, but only if the device is owned by
+	// the user with the given public key. If the given device has an empty broker
+	// the stored broker is left unchanged. We return the updated device including
+	// its decrypted private key and a user populated with the owner's uid and
+	// public key, as these are needed to recreate the device's stream.
+	UpdateDevice(tx *sqlx.Tx, device *Device, publicKey string) (*Device, error)
+
+	// TransferDevice moves the device identified by the given device's token
+	// from the user with the given public key to the user identified by the given
+	// device's user uid, creating the new user if they aren't already registered.
+	// If the given device has a non empty broker the stored broker is replaced.
+	// The device is given a new key pair so that the previous owner's stream key
+	// cannot be used for the new owner's data, and if the previous owner has no
+	// remaining devices their user record is deleted. We return the updated
+	// device with its decrypted private key, and a user populated with the new
+	// owner's uid and public key only, as the transfer is requested by the
+	// previous owner. If no matching device exists we return an error wrapping
+	// sql.ErrNoRows.
+	TransferDevice(tx *sqlx.Tx, device *Device, publicKey string) (*Device, error)
+
+	// RotateDeviceKeys replaces the key pair of the device identified by the
+	// given token, but only if it is owned by the user with the given public key.
+	// The previous public key is recorded in the key history. We return the
+	// updated device with its new decrypted private key, and a user populated
+	// with the owner's uid and public key. If no matching device exists we return
+	// an error wrapping sql.ErrNoRows.
+	RotateDeviceKeys(tx *sqlx.Tx, token, publicKey string) (*Device, error)
+
+	// RotateUserKeys replaces the key pair of the user with the given public key,
+	// recording the previous public key in the key history. We return the user
+	// with their new decrypted key pair. If no matching user exists we return an
+	// error wrapping sql.ErrNoRows.
+	RotateUserKeys(tx *sqlx.Tx, publicKey string) (*User, error)
+
+	// UserDevices returns all devices owned by the user with the given public
+	// key, including their decrypted private keys, as needed to recreate their
+	// streams. Each device has a user populated with the owner's uid and public
+	// key.
+	UserDevices(tx *sqlx.Tx, publicKey string) ([]*Device, error)
+
+	// DeleteStreams deletes all stream records for the device with the given id,
+	// returning the deleted streams so that they can also be destroyed on the
+	// stream encoder.
+	DeleteStreams(tx *sqlx.Tx, deviceID int) ([]*Stream, error)
+
 	// CreateStream saves a new stream record into the local DB. These are objects
 	// that allow us to keep a handle on a created stream for a device such that we
 	// can later destroy all associated streams.
 	CreateStream(tx *sqlx.Tx, deviceID int, streamUID string) error
 
+	// StreamUIDs returns the uids of every stream recorded in the local DB,
+	// ordered by id.
+	StreamUIDs() ([]string, error)
+
+	// StreamDevice returns the device owning the stream with the given uid, with
+	// the device's decrypted private key and its owner's uid and public key, so
+	// that the stream can be recreated on the encoder. If no such stream exists
+	// we return an error wrapping sql.ErrNoRows.
+	StreamDevice(streamUID string) (*Device, error)
+
+	// ReplaceStreamUID updates the stream with the given uid to have a new uid,
+	// used when a stream has been recreated on the encoder. If no such stream
+	// exists we return an error wrapping sql.ErrNoRows.
+	ReplaceStreamUID(tx *sqlx.Tx, streamUID, newStreamUID string) error
+
+	// ListDevices returns up to limit devices registered by the user with the
+	// given public key, ordered by id, and starting after the device with the
+	// given id. Returned devices include their associated streams, but do not
+	// include any private key material. If no user exists with the given public
+	// key an empty slice is returned.
+	ListDevices(publicKey string, afterID, limit int) ([]*Device, error)
+
+	// GetDevice returns the device identified by the given token, but only if it
+	// is owned by the user with the given public key. The returned device
+	// includes its associated streams and the owning user's public key, but does
+	// not include any private key material. If no matching device exists we
+	// return an error wrapping sql.ErrNoRows.
+	GetDevice(token, publicKey string) (*Device, error)
+
+	// UserUID returns the uid of the user with the given public key, so that
+	// callers identifying a user by public key may be authorized. If no such
+	// user exists we return an error wrapping sql.ErrNoRows.
+	UserUID(publicKey string) (string, error)
+
+	// DeviceKeys returns the device identified by the given token with its
+	// decrypted private key and public key, along with the public key of the
+	// user who owns it. This is intended for verifying signatures, so no
+	// ownership check is performed and no streams are returned. If no matching
+	// device exists we return an error wrapping sql.ErrNoRows.
+	DeviceKeys(token string) (*Device, error)
+
+	// ExportDevices reads every device matching the given filter, ordered by id,
+	// calling fn with each in turn so that the registry may be exported without
+	// holding it all in memory. Each device includes its streams and a user
+	// populated with the owner's uid, public key, curve and encoding, but no
+	// private key material is read. If fn returns an error we stop reading and
+	// return it.
+	ExportDevices(filter *ExportFilter, fn func(*Device) error) error
+
+	// RekeyCheckpoint returns the id of the last row of the given table whose
+	// private key was re-encrypted by an interrupted rekey, or 0 if no rekey of
+	// the table is in progress. The table must be one of RekeyTables.
+	RekeyCheckpoint(table string) (int, error)
+
+	// RekeyBatch re-encrypts the private keys (or claim secrets) of up to limit
+	// rows of the given table with ids greater than afterID, decrypting and
+	// then encrypting them with our key encrypter. For the pgcrypto backend
+	// this means keys encrypted with either the current or previous password
+	// are re-encrypted with the current password, while for the filekms backend
+	// keys wrapped with any master key are re-wrapped with the current master
+	// key. If previous is not nil the rows are instead decrypted with it, which
+	// allows keys to be migrated from one backend to another. The batch is
+	// processed in its own transaction, which also records a checkpoint so that
+	// an interrupted rekey can be resumed. We return the id of the last row
+	// processed and the number of rows processed, which is less than limit once
+	// the table is exhausted.
+	RekeyBatch(table string, afterID, limit int, previous KeyEncrypter) (int, int, error)
+
+	// ClearRekeyCheckpoints deletes all rekey checkpoints, and should be called
+	// once every table has been successfully rekeyed.
+	ClearRekeyCheckpoints() error
+
+	// RecordFailedCompensation persists a record of a stream that we failed to
+	// delete from the encoder after the transaction that would have referenced it
+	// was rolled back. This deliberately runs outside of any transaction so that
+	// the record survives the rollback. Recording the same stream again
+	// increments the attempt counter and stores the latest error.
+	RecordFailedCompensation(streamUID, cause string) error
+
+	// FailedCompensations returns all currently recorded failed compensations
+	// ordered by the time they were first recorded.
+	FailedCompensations() ([]*FailedCompensation, error)
+
+	// DeleteFailedCompensation removes the record for the given stream once the
+	// compensating action has been successfully replayed.
+	DeleteFailedCompensation(streamUID string) error
+
+	// EnqueueOutboxMessage writes a new message to the outbox in the context of
+	// the given transaction. The message will only become visible to the outbox
+	// dispatcher once the transaction commits, meaning encoder operations are
+	// only attempted for changes that were successfully persisted.
+	EnqueueOutboxMessage(tx *sqlx.Tx, operation, streamUID string) error
+
+	// ClaimOutboxMessages returns up to limit outbox messages that are due for
+	// delivery, leasing them by moving their next attempt to the given lease
+	// duration in the future. The lease is committed before returning, so no
+	// rows stay locked while messages are delivered, and other dispatchers skip
+	// leased messages so multiple dispatchers may safely run concurrently. If a
+	// message is neither deleted nor rescheduled before its lease expires, for
+	// example because the dispatcher crashed, it becomes due again.
+	ClaimOutboxMessages(limit int, lease time.Duration) ([]*OutboxMessage, error)
+
+	// DeleteOutboxMessage removes a message from the outbox once it has been
+	// successfully delivered.
+	DeleteOutboxMessage(id int) error
+
+	// RescheduleOutboxMessage records a failed delivery attempt for a message,
+	// storing the error and the time at which delivery should next be attempted.
+	RescheduleOutboxMessage(id int, nextAttemptAt time.Time, cause string) error
+
+	// RegisterClaimSecret stores the given claim secret for the device with the
+	// given token, encrypted with our key encrypter, replacing any secret
+	// previously registered for the device. A device with a claim secret may only
+	// be claimed with proof that the claimant holds the secret.
+	RegisterClaimSecret(deviceToken, secret string) error
+
+	// ClaimSecret returns the decrypted claim secret registered for the device
+	// with the given token. If no secret is registered we return an error
+	// wrapping sql.ErrNoRows.
+	ClaimSecret(deviceToken string) (string, error)
+
+	// CreateClaimChallenge records a challenge issued for the device with the
+	// given token, which expires at the given time. Any expired challenges are
+	// deleted at the same time. If the device already has limit unexpired
+	// challenges we return an error wrapping ErrTooManyChallenges, and if it has
+	// no claim secret an error wrapping sql.ErrNoRows.
+	CreateClaimChallenge(deviceToken, challenge string, expiresAt time.Time, limit int) error
+
+	// ConsumeClaimChallenge deletes the given challenge if it was issued for the
+	// device with the given token and has not expired, so that it can only be
+	// used once. If there is no such challenge we return an error wrapping
+	// sql.ErrNoRows.
+	ConsumeClaimChallenge(deviceToken, challenge string) error
+
+	// RegisterSigningKey stores the public key with which the device identified
+	// by the key's token signs payloads, replacing any key previously registered
+	// for the device.
+	RegisterSigningKey(key *SigningKey) error
+
+	// SigningKey returns the signing key registered for the device with the
+	// given token. If no key is registered we return an error wrapping
+	// sql.ErrNoRows.
+	SigningKey(deviceToken string) (*SigningKey, error)
+
+	// ReadSnapshot returns the entire contents of the tables in BackupTables,
+	// read consistently within a single transaction, with all private keys and
+	// claim secrets decrypted.
+	ReadSnapshot() (*Snapshot, error)
+
+	// RestoreSnapshot inserts every row of the given snapshot, encrypting private
+	// keys and claim secrets with our key encrypter, all within a single
+	// transaction. Rows keep their original ids. If any of the tables in
+	// BackupTables already contains rows we return an error wrapping ErrNotEmpty
+	// without restoring anything.
+	RestoreSnapshot(snapshot *Snapshot) error
+
+	// SchemaVersion returns the version of the last migration applied to the
+	// database, and whether that migration failed leaving the schema dirty.
+	SchemaVersion() (uint, bool, error)
+
 	// MigrateUp is a helper method that attempts to run all up migrations against
 	// the underlying Postgres DB or returns an error.
 	MigrateUp() error
@@ -94,16 +431,36 @@ func Open(connStr string) (*sqlx.DB, error) {
 // db is our type that wraps an sqlx.DB instance and provides an API for the
 // data access functions we require.
 type db struct {
-	connStr            string
-	encryptionPassword []byte
-	DB                 *sqlx.DB
-	logger             kitlog.Logger
+	connStr    string
+	keys       KeyEncrypter
+	keyOptions *crypto.KeyOptions
+	keySource  crypto.KeyGenerator
+	DB         *sqlx.DB
+	logger     kitlog.Logger
 }
 
 // Config is used to carry package local configuration for Postgres DB module.
+// KeyEncrypter is the backend used to encrypt stored private keys. If not set
+// we use the pgcrypto backend with the given encryption passwords, where
+// PreviousEncryptionPassword is optional, and is only set while rotating the
+// encryption password. Private keys are always encrypted with
+// EncryptionPassword, but while PreviousEncryptionPassword is set we are also
+// able to decrypt keys that have not yet been re-encrypted.
+//
+// KeyOptions sets the curve and encoding of key pairs generated for new users,
+// defaulting to ec25519 and base64 if nil. Key pairs for devices are always
+// generated with the same curve and encoding as their owner's, as data is
+// encrypted using both key pairs together.
+//
+// KeySource is used to obtain new key pairs. If not set we generate key pairs
+// synchronously using Zenroom when required.
 type Config struct {
-	ConnStr            string
-	EncryptionPassword string
+	ConnStr                    string
+	KeyEncrypter               KeyEncrypter
+	EncryptionPassword         string
+	PreviousEncryptionPassword string
+	KeyOptions                 *crypto.KeyOptions
+	KeySource                  crypto.KeyGenerator
 }
 
 // NewDB creates a new DB instance with the given connection string. We also
@@ -113,10 +470,25 @@ func NewDB(config *Config, logger kitlog.Logger) DB {
 
 	logger.Log("msg", "creating DB instance")
 
+	keys := config.KeyEncrypter
+	if keys == nil {
+		keys = &pgcryptoEncrypter{
+			password:         []byte(config.EncryptionPassword),
+			previousPassword: []byte(config.PreviousEncryptionPassword),
+		}
+	}
+
+	keySource := config.KeySource
+	if keySource == nil {
+		keySource = crypto.DefaultKeyGenerator
+	}
+
 	return &db{
-		connStr:            config.ConnStr,
-		encryptionPassword: []byte(config.EncryptionPassword),
-		logger:             logger,
+		connStr:    config.ConnStr,
+		keys:       keys,
+		keyOptions: config.KeyOptions,
+		keySource:  keySource,
+		logger:     logger,
 	}
 }
 
@@ -145,94 +517,263 @@ func (d *db) Stop() error {
 // RegisterDevice is our implementation of the RegisterDevice method defined in
 // our interface.
 func (d *db) RegisterDevice(tx *sqlx.Tx, device *Device) (*Device, error) {
-	// user upsert sql, note we encrypt the private using postgres native symmetric
-	// encryption
-	sql := `INSERT INTO users
-		(uid, private_key, public_key)
-		VALUES
-			(:uid,
-			 pgp_sym_encrypt(:private_key, :encryption_password),
-			 :public_key
-			)
-		ON CONFLICT (uid) DO UPDATE
-		SET updated_at = NOW()
-		RETURNING id, public_key, pgp_sym_decrypt(private_key, :encryption_password) AS private_key`
-
-	userKeyPair, err := crypto.NewKeyPair()
+	user, err := d.upsertUser(tx, device.User.UID)
 	if err != nil {
 		return nil, err
 	}
 
-	mapArgs := map[string]interface{}{
-		"uid":                 device.User.UID,
-		"private_key":         userKeyPair.PrivateKey,
-		"public_key":          userKeyPair.PublicKey,
-		"encryption_password": d.encryptionPassword,
-	}
+	// we look for the device first, so that a key pair is only generated when
+	// the device is new
+	var ownerID int
 
-	var user User
+	err = tx.Get(&ownerID, `SELECT user_id FROM devices WHERE token = $1`, device.Token)
+	if err == nil {
+		if ownerID != user.ID {
+			return nil, errors.Wrap(ErrDeviceClaimed, "failed to insert device")
+		}
 
-	sql, args, err := tx.BindNamed(sql, mapArgs)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to bind named query for upserting user")
+		return d.existingDevice(tx, device.Token, user)
 	}
 
-	// we use a Get for the upsert so we get back the user id
-	err = tx.Get(&user, sql, args...)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to upsert user")
+	if err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "failed to read device")
 	}
 
 	// now attempt to insert the device
-	sql = `INSERT INTO devices
-			(token, user_id, private_key, public_key, longitude, latitude, disposition)
+	sql := `INSERT INTO devices
+			(token, user_id, private_key, public_key, longitude, latitude, disposition, broker,
+			key_curve, key_encoding)
 		VALUES (
 			:token,
 			:user_id,
-			pgp_sym_encrypt(:private_key, :encryption_password),
+			:private_key,
 			:public_key,
 			:longitude,
 			:latitude,
-			:disposition
+			:disposition,
+			:broker,
+			:key_curve,
+			:key_encoding
 		)
-		RETURNING id, pgp_sym_decrypt(private_key, :encryption_password) AS private_key, public_key`
+		RETURNING id, token, public_key, longitude, latitude, disposition, broker, key_curve, key_encoding,
+			created_at, updated_at`
 
-	deviceKeyPair, err := crypto.NewKeyPair()
+	deviceKeyPair, err := d.keySource.NewKeyPair(user.KeyOptions())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to generate device key pair")
 	}
 
-	mapArgs = map[string]interface{}{
-		"token":               device.Token,
-		"user_id":             user.ID,
-		"private_key":         deviceKeyPair.PrivateKey,
-		"public_key":          deviceKeyPair.PublicKey,
-		"longitude":           device.Longitude,
-		"latitude":            device.Latitude,
-		"disposition":         device.Disposition,
-		"encryption_password": d.encryptionPassword,
+	encryptedKey, err := d.keys.Encrypt(tx, deviceKeyPair.PrivateKey, encryptionContext("devices", device.Token))
+	if err != nil {
+		return nil, err
 	}
 
-	sql, args, err = tx.BindNamed(sql, mapArgs)
+	mapArgs := map[string]interface{}{
+		"token":        device.Token,
+		"user_id":      user.ID,
+		"private_key":  encryptedKey,
+		"public_key":   deviceKeyPair.PublicKey,
+		"longitude":    device.Longitude,
+		"latitude":     device.Latitude,
+		"disposition":  device.Disposition,
+		"broker":       device.Broker,
+		"key_curve":    deviceKeyPair.Curve,
+		"key_encoding": deviceKeyPair.Encoding,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to bind named query when inserting device")
 	}
 
+	// we insert the device within a savepoint, so that if the token was
+	// registered concurrently we are able to continue using the transaction to
+	// work out who registered it
+	_, err = tx.Exec(`SAVEPOINT register_device`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create savepoint")
+	}
+
 	var dv Device
 
 	err = tx.Get(&dv, sql, args...)
 	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			_, rerr := tx.Exec(`ROLLBACK TO SAVEPOINT register_device`)
+			if rerr != nil {
+				return nil, errors.Wrap(rerr, "failed to roll back to savepoint")
+			}
+
+			return d.existingDevice(tx, device.Token, user)
+		}
+
 		return nil, errors.Wrap(err, "failed to insert device")
 	}
 
+	_, err = tx.Exec(`RELEASE SAVEPOINT register_device`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to release savepoint")
+	}
+
+	dv.PrivateKey = deviceKeyPair.PrivateKey
 	dv.User = &User{
-		PrivateKey: user.PrivateKey,
-		PublicKey:  user.PublicKey,
+		PrivateKey:  user.PrivateKey,
+		PublicKey:   user.PublicKey,
+		KeyCurve:    user.KeyCurve,
+		KeyEncoding: user.KeyEncoding,
 	}
 
 	return &dv, err
 }
 
+// upsertUser inserts a user with the given uid and a newly generated key pair,
+// or if the user already exists reads the existing user. In either case we
+// return the user's id and decrypted key pair. We read the user first, so a
+// key pair is only generated when the user is new. We deliberately leave an
+// existing user row untouched, as updating it would lock the row until the
+// transaction ends, serializing concurrent claims by the same user.
+func (d *db) upsertUser(tx *sqlx.Tx, uid string) (*User, error) {
+	user, err := d.selectUser(tx, uid)
+	if err == nil {
+		return user, nil
+	}
+
+	if errors.Cause(err) != sql.ErrNoRows {
+		return nil, err
+	}
+
+	// user insert sql, note the private key is encrypted by our key encrypter
+	// before being inserted
+	query := `INSERT INTO users
+		(uid, private_key, public_key, key_curve, key_encoding)
+		VALUES
+			(:uid,
+			 :private_key,
+			 :public_key,
+			 :key_curve,
+			 :key_encoding
+			)
+		ON CONFLICT (uid) DO NOTHING
+		RETURNING id`
+
+	userKeyPair, err := d.keySource.NewKeyPair(d.keyOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := d.keys.Encrypt(tx, userKeyPair.PrivateKey, encryptionContext("users", uid))
+	if err != nil {
+		return nil, err
+	}
+
+	mapArgs := map[string]interface{}{
+		"uid":          uid,
+		"private_key":  encryptedKey,
+		"public_key":   userKeyPair.PublicKey,
+		"key_curve":    userKeyPair.Curve,
+		"key_encoding": userKeyPair.Encoding,
+	}
+
+	query, args, err := tx.BindNamed(query, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query for inserting user")
+	}
+
+	var id int
+
+	err = tx.Get(&id, query, args...)
+	if err != nil {
+		// no row is returned if a concurrent claim inserted the user after we
+		// looked for it, in which case we use the user it inserted
+		if err == sql.ErrNoRows {
+			return d.selectUser(tx, uid)
+		}
+
+		return nil, errors.Wrap(err, "failed to insert user")
+	}
+
+	return &User{
+		ID:          id,
+		UID:         uid,
+		PrivateKey:  userKeyPair.PrivateKey,
+		PublicKey:   userKeyPair.PublicKey,
+		KeyCurve:    userKeyPair.Curve,
+		KeyEncoding: userKeyPair.Encoding,
+	}, nil
+}
+
+// selectUser reads the user with the given uid along with their decrypted key
+// pair, returning an error wrapping sql.ErrNoRows if there is no such user.
+func (d *db) selectUser(tx *sqlx.Tx, uid string) (*User, error) {
+	var user User
+
+	err := tx.Get(&user, `SELECT id, uid, public_key, private_key, key_curve, key_encoding
+		FROM users WHERE uid = $1`, uid)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read user")
+	}
+
+	user.PrivateKey, err = d.keys.Decrypt(tx, []byte(user.PrivateKey), encryptionContext("users", user.UID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// existingDevice is called when registering a device whose token is already
+// registered. If the device belongs to the given user we return it along with
+// its streams, otherwise we return ErrDeviceClaimed.
+func (d *db) existingDevice(tx *sqlx.Tx, token string, user *User) (*Device, error) {
+	sql := `SELECT d.id, d.token, d.private_key, d.public_key, d.longitude, d.latitude, d.disposition,
+			d.broker, d.key_curve, d.key_encoding, d.created_at, d.updated_at, ` + streamUIDsColumn + `
+		FROM devices d
+		LEFT JOIN streams s ON s.device_id = d.id
+		WHERE d.token = :token
+		AND d.user_id = :user_id
+		GROUP BY d.id`
+
+	mapArgs := map[string]interface{}{
+		"token":   token,
+		"user_id": user.ID,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to read existing device")
+	}
+
+	rows, err := tx.Queryx(sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read existing device")
+	}
+
+	// no rows means the device is registered, but not by this user
+	if !rows.Next() {
+		rows.Close()
+		if rows.Err() != nil {
+			return nil, errors.Wrap(rows.Err(), "failed to read existing device")
+		}
+		return nil, errors.Wrap(ErrDeviceClaimed, "failed to insert device")
+	}
+
+	dv, err := scanDevice(rows, user.PublicKey)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	dv.PrivateKey, err = d.keys.Decrypt(tx, []byte(dv.PrivateKey), encryptionContext("devices", dv.Token))
+	if err != nil {
+		return nil, err
+	}
+
+	dv.Existing = true
+	dv.User.PrivateKey = user.PrivateKey
+
+	return dv, nil
+}
+
 // DeleteDevice finds the device identified by the given token, and deletes it
 // from the database. We also delete the associated user if they have no other
 // devices currently registered in the database.
@@ -282,64 +823,1191 @@ func (d *db) DeleteDevice(tx *sqlx.Tx, token, publicKey string) ([]*Stream, erro
 		return nil, errors.Wrap(err, "failed to delete device")
 	}
 
-	// now count devices for the user
-	sql = `SELECT COUNT(*) FROM devices WHERE user_id = :user_id`
+	err = d.deleteUserIfUnused(tx, userID)
+	if err != nil {
+		return nil, err
+	}
 
-	mapArgs = map[string]interface{}{
-		"user_id": userID,
+	return streams, nil
+}
+
+// UpdateDevice is our implementation of the UpdateDevice method defined in our
+// interface. Ownership is checked in the same way as DeleteDevice, by joining
+// to the users table on the supplied public key.
+func (d *db) UpdateDevice(tx *sqlx.Tx, device *Device, publicKey string) (*Device, error) {
+	sql := `UPDATE devices d
+		SET longitude = :longitude,
+			latitude = :latitude,
+			disposition = :disposition,
+			broker = COALESCE(NULLIF(:broker, ''), d.broker),
+			updated_at = NOW()
+		FROM users u
+		WHERE u.id = d.user_id
+		AND d.token = :token
+		AND u.public_key = :public_key
+		RETURNING d.id, d.token, d.private_key, d.public_key, d.longitude, d.latitude, d.disposition,
+			d.broker, d.key_curve, d.key_encoding, d.created_at, d.updated_at, u.uid AS user_uid`
+
+	mapArgs := map[string]interface{}{
+		"token":       device.Token,
+		"longitude":   device.Longitude,
+		"latitude":    device.Latitude,
+		"disposition": device.Disposition,
+		"broker":      device.Broker,
+		"public_key":  publicKey,
 	}
 
-	sql, args, err = tx.BindNamed(sql, mapArgs)
+	sql, args, err := tx.BindNamed(sql, mapArgs)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to bind named query to count devices")
+		return nil, errors.Wrap(err, "failed to bind named query to update device")
 	}
 
-	var deviceCount int
+	var row struct {
+		Device
+		UserUID string `db:"user_uid"`
+	}
 
-	err = tx.Get(&deviceCount, sql, args...)
+	err = tx.Get(&row, sql, args...)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to count remaining devices")
+		return nil, errors.Wrap(err, "failed to update device")
 	}
 
-	if deviceCount == 0 {
-		sql = `DELETE FROM users WHERE id = :id`
+	dv := row.Device
 
-		mapArgs = map[string]interface{}{
-			"id": userID,
-		}
+	dv.PrivateKey, err = d.keys.Decrypt(tx, []byte(dv.PrivateKey), encryptionContext("devices", dv.Token))
+	if err != nil {
+		return nil, err
+	}
 
-		_, err = tx.NamedExec(sql, mapArgs)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to delete user")
-		}
+	dv.User = &User{
+		UID:       row.UserUID,
+		PublicKey: publicKey,
 	}
 
-	return streams, nil
+	return &dv, nil
 }
 
-// CreateStream now attempts to insert to the streams table the stream id we
-// received back from the encoder.
-func (d *db) CreateStream(tx *sqlx.Tx, deviceID int, streamUID string) error {
-	sql := `INSERT INTO streams (device_id, uid) VALUES
-		(:device_id, :uid)`
+// TransferDevice is our implementation of the TransferDevice method defined in
+// our interface. Ownership is checked in the same way as DeleteDevice, by
+// joining to the users table on the supplied public key.
+func (d *db) TransferDevice(tx *sqlx.Tx, device *Device, publicKey string) (*Device, error) {
+	user, err := d.upsertUser(tx, device.User.UID)
+	if err != nil {
+		return nil, err
+	}
+
+	// note the users table here is joined on the device's existing user_id, so
+	// we return the id of the previous owner
+	sql := `UPDATE devices d
+		SET user_id = :user_id,
+			private_key = :private_key,
+			public_key = :public_key,
+			key_curve = :key_curve,
+			key_encoding = :key_encoding,
+			broker = COALESCE(NULLIF(:broker, ''), d.broker),
+			updated_at = NOW()
+		FROM users u
+		WHERE u.id = d.user_id
+		AND d.token = :token
+		AND u.public_key = :owner_public_key
+		RETURNING d.id, d.token, d.public_key, d.longitude, d.latitude, d.disposition, d.broker,
+			d.key_curve, d.key_encoding, d.created_at, d.updated_at, u.id AS previous_user_id`
+
+	// the device is given a key pair compatible with its new owner's
+	deviceKeyPair, err := d.keySource.NewKeyPair(user.KeyOptions())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate device key pair")
+	}
+
+	encryptedKey, err := d.keys.Encrypt(tx, deviceKeyPair.PrivateKey, encryptionContext("devices", device.Token))
+	if err != nil {
+		return nil, err
+	}
 
 	mapArgs := map[string]interface{}{
-		"device_id": deviceID,
-		"uid":       streamUID,
+		"user_id":          user.ID,
+		"private_key":      encryptedKey,
+		"public_key":       deviceKeyPair.PublicKey,
+		"key_curve":        deviceKeyPair.Curve,
+		"key_encoding":     deviceKeyPair.Encoding,
+		"broker":           device.Broker,
+		"token":            device.Token,
+		"owner_public_key": publicKey,
 	}
 
-	_, err := tx.NamedExec(sql, mapArgs)
+	sql, args, err := tx.BindNamed(sql, mapArgs)
 	if err != nil {
-		return errors.Wrap(err, "failed to insert stream")
+		return nil, errors.Wrap(err, "failed to bind named query to transfer device")
 	}
 
-	return nil
+	var row struct {
+		Device
+		PreviousUserID int `db:"previous_user_id"`
+	}
+
+	err = tx.Get(&row, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to transfer device")
+	}
+
+	err = d.deleteUserIfUnused(tx, row.PreviousUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the new owner's private key is deliberately left out, so that it cannot
+	// reach the previous owner
+	dv := row.Device
+	dv.PrivateKey = deviceKeyPair.PrivateKey
+	dv.User = &User{
+		ID:          user.ID,
+		UID:         user.UID,
+		PublicKey:   user.PublicKey,
+		KeyCurve:    user.KeyCurve,
+		KeyEncoding: user.KeyEncoding,
+	}
+
+	return &dv, nil
 }
 
-// MigrateUp is a convenience function to run all up migrations in the context
-// of an instantiated DB instance.
-func (d *db) MigrateUp() error {
-	return MigrateUp(d.DB.DB, d.logger)
+// RotateDeviceKeys is our implementation of the RotateDeviceKeys method
+// defined in our interface. We read the existing public key in a CTE so that
+// we are able to record it in the key history once replaced.
+func (d *db) RotateDeviceKeys(tx *sqlx.Tx, token, publicKey string) (*Device, error) {
+	sql := `WITH previous AS (
+			SELECT d.id, d.public_key, u.uid AS user_uid
+			FROM devices d
+			JOIN users u ON u.id = d.user_id
+			WHERE d.token = :token
+			AND u.public_key = :owner_public_key
+			FOR UPDATE OF d
+		)
+		UPDATE devices d
+		SET private_key = :private_key,
+			public_key = :public_key,
+			key_curve = :key_curve,
+			key_encoding = :key_encoding,
+			updated_at = NOW()
+		FROM previous
+		WHERE d.id = previous.id
+		RETURNING d.id, d.token, d.public_key, d.longitude, d.latitude, d.disposition, d.broker,
+			d.key_curve, d.key_encoding, d.created_at, d.updated_at,
+			previous.public_key AS previous_public_key, previous.user_uid`
+
+	options, err := d.userKeyOptions(tx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceKeyPair, err := d.keySource.NewKeyPair(options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate device key pair")
+	}
+
+	encryptedKey, err := d.keys.Encrypt(tx, deviceKeyPair.PrivateKey, encryptionContext("devices", token))
+	if err != nil {
+		return nil, err
+	}
+
+	mapArgs := map[string]interface{}{
+		"token":            token,
+		"owner_public_key": publicKey,
+		"private_key":      encryptedKey,
+		"public_key":       deviceKeyPair.PublicKey,
+		"key_curve":        deviceKeyPair.Curve,
+		"key_encoding":     deviceKeyPair.Encoding,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to rotate device keys")
+	}
+
+	var row struct {
+		Device
+		PreviousPublicKey string `db:"previous_public_key"`
+		UserUID           string `db:"user_uid"`
+	}
+
+	err = tx.Get(&row, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rotate device keys")
+	}
+
+	err = d.retireKey(tx, DeviceKeyOwner, token, row.PreviousPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dv := row.Device
+	dv.PrivateKey = deviceKeyPair.PrivateKey
+	dv.User = &User{
+		UID:         row.UserUID,
+		PublicKey:   publicKey,
+		KeyCurve:    options.Curve,
+		KeyEncoding: options.Encoding,
+	}
+
+	return &dv, nil
+}
+
+// RotateUserKeys is our implementation of the RotateUserKeys method defined
+// in our interface.
+func (d *db) RotateUserKeys(tx *sqlx.Tx, publicKey string) (*User, error) {
+	sql := `WITH previous AS (
+			SELECT id, public_key
+			FROM users
+			WHERE public_key = :owner_public_key
+			FOR UPDATE
+		)
+		UPDATE users u
+		SET private_key = :private_key,
+			public_key = :public_key,
+			updated_at = NOW()
+		FROM previous
+		WHERE u.id = previous.id
+		RETURNING u.id, u.uid, u.public_key, u.key_curve, u.key_encoding,
+			previous.public_key AS previous_public_key`
+
+	// we need the user's uid before the update, as their new private key is
+	// encrypted in the context of it
+	var uid string
+
+	err := tx.Get(&uid, `SELECT uid FROM users WHERE public_key = $1`, publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read user")
+	}
+
+	// the user keeps their curve and encoding so that their new key pair
+	// remains compatible with the key pairs of their devices
+	options, err := d.userKeyOptions(tx, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	userKeyPair, err := d.keySource.NewKeyPair(options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate user key pair")
+	}
+
+	encryptedKey, err := d.keys.Encrypt(tx, userKeyPair.PrivateKey, encryptionContext("users", uid))
+	if err != nil {
+		return nil, err
+	}
+
+	mapArgs := map[string]interface{}{
+		"owner_public_key": publicKey,
+		"private_key":      encryptedKey,
+		"public_key":       userKeyPair.PublicKey,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to rotate user keys")
+	}
+
+	var row struct {
+		User
+		PreviousPublicKey string `db:"previous_public_key"`
+	}
+
+	err = tx.Get(&row, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to rotate user keys")
+	}
+
+	err = d.retireKey(tx, UserKeyOwner, row.UID, row.PreviousPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	user := row.User
+	user.PrivateKey = userKeyPair.PrivateKey
+
+	return &user, nil
+}
+
+// userKeyOptions returns the curve and encoding of the key pair of the user
+// with the given public key. If no such user exists we return the configured
+// options, leaving it to the caller to report the missing user.
+func (d *db) userKeyOptions(tx *sqlx.Tx, publicKey string) (*crypto.KeyOptions, error) {
+	sql := `SELECT key_curve, key_encoding FROM users WHERE public_key = :public_key`
+
+	mapArgs := map[string]interface{}{
+		"public_key": publicKey,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to read user key options")
+	}
+
+	rows, err := tx.Queryx(sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read user key options")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if rows.Err() != nil {
+			return nil, errors.Wrap(rows.Err(), "failed to read user key options")
+		}
+
+		options := &crypto.KeyOptions{}
+		if d.keyOptions != nil {
+			*options = *d.keyOptions
+		}
+
+		return options, nil
+	}
+
+	var user User
+
+	err = rows.StructScan(&user)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan user key options")
+	}
+
+	return user.KeyOptions(), nil
+}
+
+// retireKey records a public key that has been replaced in the key history,
+// so that data encrypted under the key remains attributable to its owner.
+func (d *db) retireKey(tx *sqlx.Tx, ownerType, ownerUID, publicKey string) error {
+	sql := `INSERT INTO key_history (owner_type, owner_uid, public_key)
+		VALUES (:owner_type, :owner_uid, :public_key)`
+
+	mapArgs := map[string]interface{}{
+		"owner_type": ownerType,
+		"owner_uid":  ownerUID,
+		"public_key": publicKey,
+	}
+
+	_, err := tx.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert key history")
+	}
+
+	return nil
+}
+
+// UserDevices is our implementation of the UserDevices method defined in our
+// interface.
+func (d *db) UserDevices(tx *sqlx.Tx, publicKey string) ([]*Device, error) {
+	sql := `SELECT d.id, d.token, d.private_key, d.public_key, d.longitude, d.latitude, d.disposition,
+			d.broker, d.key_curve, d.key_encoding, d.created_at, d.updated_at, u.uid AS user_uid
+		FROM devices d
+		JOIN users u ON u.id = d.user_id
+		WHERE u.public_key = :public_key
+		ORDER BY d.id`
+
+	mapArgs := map[string]interface{}{
+		"public_key": publicKey,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to select user devices")
+	}
+
+	var rows []struct {
+		Device
+		UserUID string `db:"user_uid"`
+	}
+
+	err = tx.Select(&rows, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select user devices")
+	}
+
+	devices := make([]*Device, 0, len(rows))
+
+	for _, row := range rows {
+		dv := row.Device
+
+		dv.PrivateKey, err = d.keys.Decrypt(tx, []byte(dv.PrivateKey), encryptionContext("devices", dv.Token))
+		if err != nil {
+			return nil, err
+		}
+
+		dv.User = &User{
+			UID:       row.UserUID,
+			PublicKey: publicKey,
+		}
+
+		devices = append(devices, &dv)
+	}
+
+	return devices, nil
+}
+
+// DeleteStreams is our implementation of the DeleteStreams method defined in
+// our interface.
+func (d *db) DeleteStreams(tx *sqlx.Tx, deviceID int) ([]*Stream, error) {
+	sql := `DELETE FROM streams
+		WHERE device_id = :device_id
+		RETURNING id, uid`
+
+	mapArgs := map[string]interface{}{
+		"device_id": deviceID,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to delete streams")
+	}
+
+	streams := []*Stream{}
+
+	err = tx.Select(&streams, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete streams")
+	}
+
+	return streams, nil
+}
+
+// deleteUserIfUnused deletes the user with the given id if they have no
+// remaining registered devices.
+func (d *db) deleteUserIfUnused(tx *sqlx.Tx, userID int) error {
+	// now count devices for the user
+	sql := `SELECT COUNT(*) FROM devices WHERE user_id = :user_id`
+
+	mapArgs := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to bind named query to count devices")
+	}
+
+	var deviceCount int
+
+	err = tx.Get(&deviceCount, sql, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to count remaining devices")
+	}
+
+	if deviceCount == 0 {
+		sql = `DELETE FROM users WHERE id = :id`
+
+		mapArgs = map[string]interface{}{
+			"id": userID,
+		}
+
+		_, err = tx.NamedExec(sql, mapArgs)
+		if err != nil {
+			return errors.Wrap(err, "failed to delete user")
+		}
+	}
+
+	return nil
+}
+
+// CreateStream now attempts to insert to the streams table the stream id we
+// received back from the encoder.
+func (d *db) CreateStream(tx *sqlx.Tx, deviceID int, streamUID string) error {
+	sql := `INSERT INTO streams (device_id, uid) VALUES
+		(:device_id, :uid)`
+
+	mapArgs := map[string]interface{}{
+		"device_id": deviceID,
+		"uid":       streamUID,
+	}
+
+	_, err := tx.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert stream")
+	}
+
+	return nil
+}
+
+// StreamUIDs is our implementation of the StreamUIDs method defined in our
+// interface.
+func (d *db) StreamUIDs() ([]string, error) {
+	uids := []string{}
+
+	err := d.DB.Select(&uids, `SELECT uid FROM streams ORDER BY id`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read stream uids")
+	}
+
+	return uids, nil
+}
+
+// StreamDevice is our implementation of the StreamDevice method defined in our
+// interface.
+func (d *db) StreamDevice(streamUID string) (*Device, error) {
+	sql := `SELECT d.id, d.token, d.private_key, d.public_key, d.longitude, d.latitude,
+			d.disposition, d.broker, d.key_curve, d.key_encoding,
+			u.uid AS user_uid, u.public_key AS user_public_key
+		FROM streams s
+		JOIN devices d ON d.id = s.device_id
+		JOIN users u ON u.id = d.user_id
+		WHERE s.uid = :uid`
+
+	mapArgs := map[string]interface{}{
+		"uid": streamUID,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to read stream device")
+	}
+
+	var r struct {
+		Device
+		UserUID       string `db:"user_uid"`
+		UserPublicKey string `db:"user_public_key"`
+	}
+
+	err = d.DB.Get(&r, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read stream device")
+	}
+
+	device := r.Device
+	device.User = &User{
+		UID:       r.UserUID,
+		PublicKey: r.UserPublicKey,
+	}
+
+	device.PrivateKey, err = d.keys.Decrypt(d.DB, []byte(device.PrivateKey), encryptionContext("devices", device.Token))
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// ReplaceStreamUID is our implementation of the ReplaceStreamUID method
+// defined in our interface.
+func (d *db) ReplaceStreamUID(tx *sqlx.Tx, streamUID, newStreamUID string) error {
+	sql := `UPDATE streams SET uid = :new_uid
+		WHERE uid = :uid
+		RETURNING id`
+
+	mapArgs := map[string]interface{}{
+		"uid":     streamUID,
+		"new_uid": newStreamUID,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to bind named query to replace stream uid")
+	}
+
+	var id int
+
+	err = tx.Get(&id, sql, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to replace stream uid")
+	}
+
+	return nil
+}
+
+// ListDevices is our implementation of the ListDevices method defined in our
+// interface. Stream uids are aggregated into an array per device so that we
+// are able to return a page of devices with a single query.
+func (d *db) ListDevices(publicKey string, afterID, limit int) ([]*Device, error) {
+	sql := `SELECT d.id, d.token, d.public_key, d.longitude, d.latitude, d.disposition,
+			d.created_at, d.updated_at, ` + streamUIDsColumn + `
+		FROM devices d
+		JOIN users u ON u.id = d.user_id
+		LEFT JOIN streams s ON s.device_id = d.id
+		WHERE u.public_key = :public_key
+		AND d.id > :after_id
+		GROUP BY d.id
+		ORDER BY d.id
+		LIMIT :limit`
+
+	mapArgs := map[string]interface{}{
+		"public_key": publicKey,
+		"after_id":   afterID,
+		"limit":      limit,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to list devices")
+	}
+
+	rows, err := d.DB.Queryx(sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list devices")
+	}
+	defer rows.Close()
+
+	devices := []*Device{}
+
+	for rows.Next() {
+		device, err := scanDevice(rows, publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+// GetDevice is our implementation of the GetDevice method defined in our
+// interface. Ownership is checked in the same way as DeleteDevice, by joining
+// to the users table on the supplied public key.
+func (d *db) GetDevice(token, publicKey string) (*Device, error) {
+	sql := `SELECT d.id, d.token, d.public_key, d.longitude, d.latitude, d.disposition,
+			d.created_at, d.updated_at, ` + streamUIDsColumn + `
+		FROM devices d
+		JOIN users u ON u.id = d.user_id
+		LEFT JOIN streams s ON s.device_id = d.id
+		WHERE d.token = :token
+		AND u.public_key = :public_key
+		GROUP BY d.id`
+
+	mapArgs := map[string]interface{}{
+		"token":      token,
+		"public_key": publicKey,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to get device")
+	}
+
+	row := d.DB.QueryRowx(sql, args...)
+
+	device, err := scanDevice(row, publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get device")
+	}
+
+	return device, nil
+}
+
+// UserUID is our implementation of the UserUID method defined in our
+// interface.
+func (d *db) UserUID(publicKey string) (string, error) {
+	var uid string
+
+	err := d.DB.Get(&uid, `SELECT uid FROM users WHERE public_key = $1`, publicKey)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read user uid")
+	}
+
+	return uid, nil
+}
+
+// DeviceKeys is our implementation of the interface method.
+func (d *db) DeviceKeys(token string) (*Device, error) {
+	sql := `SELECT d.id, d.token, d.private_key, d.public_key, d.key_curve, d.key_encoding,
+			u.uid AS user_uid, u.public_key AS user_public_key,
+			u.key_curve AS user_key_curve, u.key_encoding AS user_key_encoding
+		FROM devices d
+		JOIN users u ON u.id = d.user_id
+		WHERE d.token = :token`
+
+	mapArgs := map[string]interface{}{
+		"token": token,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to read device keys")
+	}
+
+	var r struct {
+		Device
+		UserUID         string `db:"user_uid"`
+		UserPublicKey   string `db:"user_public_key"`
+		UserKeyCurve    string `db:"user_key_curve"`
+		UserKeyEncoding string `db:"user_key_encoding"`
+	}
+
+	err = d.DB.Get(&r, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read device keys")
+	}
+
+	device := r.Device
+	device.User = &User{
+		UID:         r.UserUID,
+		PublicKey:   r.UserPublicKey,
+		KeyCurve:    r.UserKeyCurve,
+		KeyEncoding: r.UserKeyEncoding,
+	}
+
+	device.PrivateKey, err = d.keys.Decrypt(d.DB, []byte(device.PrivateKey), encryptionContext("devices", device.Token))
+	if err != nil {
+		return nil, err
+	}
+
+	return &device, nil
+}
+
+// ExportDevices is our implementation of the ExportDevices method defined in
+// our interface. Conditions are only added to the query for the fields of the
+// filter that are set.
+func (d *db) ExportDevices(filter *ExportFilter, fn func(*Device) error) error {
+	conditions := []string{"TRUE"}
+	mapArgs := map[string]interface{}{}
+
+	if filter != nil {
+		if len(filter.UserUIDs) > 0 {
+			conditions = append(conditions, "u.uid = ANY(:user_uids)")
+			mapArgs["user_uids"] = pq.Array(filter.UserUIDs)
+		}
+
+		if !filter.CreatedAfter.IsZero() {
+			conditions = append(conditions, "d.created_at >= :created_after")
+			mapArgs["created_after"] = filter.CreatedAfter
+		}
+
+		if !filter.CreatedBefore.IsZero() {
+			conditions = append(conditions, "d.created_at < :created_before")
+			mapArgs["created_before"] = filter.CreatedBefore
+		}
+
+		if filter.Disposition != "" {
+			conditions = append(conditions, "d.disposition = :disposition")
+			mapArgs["disposition"] = filter.Disposition
+		}
+
+		if box := filter.BoundingBox; box != nil {
+			conditions = append(conditions, `d.longitude BETWEEN :min_longitude AND :max_longitude
+			AND d.latitude BETWEEN :min_latitude AND :max_latitude`)
+			mapArgs["min_longitude"] = box.MinLongitude
+			mapArgs["min_latitude"] = box.MinLatitude
+			mapArgs["max_longitude"] = box.MaxLongitude
+			mapArgs["max_latitude"] = box.MaxLatitude
+		}
+	}
+
+	sql := `SELECT d.id, d.token, d.public_key, d.longitude, d.latitude, d.disposition,
+			d.broker, d.key_curve, d.key_encoding, d.created_at, d.updated_at,
+			u.uid AS user_uid, u.public_key AS user_public_key,
+			u.key_curve AS user_key_curve, u.key_encoding AS user_key_encoding,
+			` + streamUIDsColumn + `
+		FROM devices d
+		JOIN users u ON u.id = d.user_id
+		LEFT JOIN streams s ON s.device_id = d.id
+		WHERE ` + strings.Join(conditions, "\n\t\tAND ") + `
+		GROUP BY d.id, u.id
+		ORDER BY d.id`
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to bind named query to export devices")
+	}
+
+	rows, err := d.DB.Queryx(sql, args...)
+	if err != nil {
+		return errors.Wrap(err, "failed to export devices")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r struct {
+			Device
+			UserUID         string         `db:"user_uid"`
+			UserPublicKey   string         `db:"user_public_key"`
+			UserKeyCurve    string         `db:"user_key_curve"`
+			UserKeyEncoding string         `db:"user_key_encoding"`
+			StreamUIDs      pq.StringArray `db:"stream_uids"`
+		}
+
+		err = rows.StructScan(&r)
+		if err != nil {
+			return errors.Wrap(err, "failed to scan exported device")
+		}
+
+		device := r.Device
+		device.User = &User{
+			UID:         r.UserUID,
+			PublicKey:   r.UserPublicKey,
+			KeyCurve:    r.UserKeyCurve,
+			KeyEncoding: r.UserKeyEncoding,
+		}
+		device.Streams = newStreams(&device, r.StreamUIDs)
+
+		err = fn(&device)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "failed to export devices")
+}
+
+// streamUIDsColumn is a select expression aggregating the uids of all streams
+// joined to a device into a single array column, so that we are able to read
+// devices along with their streams with a single query.
+const streamUIDsColumn = `COALESCE(array_agg(s.uid ORDER BY s.id) FILTER (WHERE s.uid IS NOT NULL), '{}') AS stream_uids`
+
+// scanDevice scans a single row selected with a streamUIDsColumn into a
+// Device, attaching its streams and a user with the given public key.
+func scanDevice(row interface{ StructScan(interface{}) error }, publicKey string) (*Device, error) {
+	var r struct {
+		Device
+		StreamUIDs pq.StringArray `db:"stream_uids"`
+	}
+
+	err := row.StructScan(&r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to scan device")
+	}
+
+	device := r.Device
+	device.User = &User{PublicKey: publicKey}
+	device.Streams = newStreams(&device, r.StreamUIDs)
+
+	return &device, nil
+}
+
+// newStreams returns a slice of Stream instances for the given device, one for
+// each of the given stream uids.
+func newStreams(device *Device, uids []string) []*Stream {
+	streams := make([]*Stream, 0, len(uids))
+
+	for _, uid := range uids {
+		streams = append(streams, &Stream{UID: uid, Device: device})
+	}
+
+	return streams
+}
+
+// RekeyCheckpoint is our implementation of the RekeyCheckpoint method defined
+// in our interface.
+func (d *db) RekeyCheckpoint(table string) (int, error) {
+	err := validateRekeyTable(table)
+	if err != nil {
+		return 0, err
+	}
+
+	sql := `SELECT COALESCE(MAX(last_id), 0) FROM rekey_checkpoints
+		WHERE table_name = :table_name`
+
+	mapArgs := map[string]interface{}{
+		"table_name": table,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to bind named query to read rekey checkpoint")
+	}
+
+	var lastID int
+
+	err = d.DB.Get(&lastID, sql, args...)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read rekey checkpoint")
+	}
+
+	return lastID, nil
+}
+
+// RekeyBatch is our implementation of the RekeyBatch method defined in our
+// interface. The table name is interpolated into the query, which is why we
+// only accept names from RekeyTables.
+func (d *db) RekeyBatch(table string, afterID, limit int, previous KeyEncrypter) (_ int, _ int, err error) {
+	err = validateRekeyTable(table)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	decrypter := d.keys
+	if previous != nil {
+		decrypter = previous
+	}
+
+	tx, err := d.BeginTX()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		if cerr := tx.Commit(); cerr != nil {
+			err = errors.Wrap(cerr, "failed to commit rekey batch")
+		}
+	}()
+
+	column := encryptedColumns[table]
+
+	sql := `SELECT id, ` + contextColumns[table] + ` AS context_key, ` + column + ` AS encrypted FROM ` + table + `
+		WHERE id > :after_id
+		ORDER BY id
+		LIMIT :limit
+		FOR UPDATE`
+
+	mapArgs := map[string]interface{}{
+		"after_id": afterID,
+		"limit":    limit,
+	}
+
+	sql, args, err := tx.BindNamed(sql, mapArgs)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to bind named query to select rekey batch")
+	}
+
+	var rows []struct {
+		ID         int    `db:"id"`
+		ContextKey string `db:"context_key"`
+		Encrypted  []byte `db:"encrypted"`
+	}
+
+	err = tx.Select(&rows, sql, args...)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed to select %s to rekey", table)
+	}
+
+	if len(rows) == 0 {
+		return afterID, 0, nil
+	}
+
+	for _, row := range rows {
+		aad := encryptionContext(table, row.ContextKey)
+
+		plaintext, err := decrypter.Decrypt(tx, row.Encrypted, aad)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "failed to decrypt %s %d", table, row.ID)
+		}
+
+		encrypted, err := d.keys.Encrypt(tx, plaintext, aad)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		sql = `UPDATE ` + table + ` SET ` + column + ` = :encrypted WHERE id = :id`
+
+		mapArgs = map[string]interface{}{
+			"id":        row.ID,
+			"encrypted": encrypted,
+		}
+
+		_, err = tx.NamedExec(sql, mapArgs)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "failed to rekey %s %d", table, row.ID)
+		}
+	}
+
+	lastID := rows[len(rows)-1].ID
+
+	sql = `INSERT INTO rekey_checkpoints (table_name, last_id)
+		VALUES (:table_name, :last_id)
+		ON CONFLICT (table_name) DO UPDATE
+		SET last_id = EXCLUDED.last_id, updated_at = NOW()`
+
+	mapArgs = map[string]interface{}{
+		"table_name": table,
+		"last_id":    lastID,
+	}
+
+	_, err = tx.NamedExec(sql, mapArgs)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to record rekey checkpoint")
+	}
+
+	return lastID, len(rows), nil
+}
+
+// ClearRekeyCheckpoints is our implementation of the ClearRekeyCheckpoints
+// method defined in our interface.
+func (d *db) ClearRekeyCheckpoints() error {
+	_, err := d.DB.Exec(`DELETE FROM rekey_checkpoints`)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete rekey checkpoints")
+	}
+
+	return nil
+}
+
+// validateRekeyTable returns an error if the given table is not one of
+// RekeyTables.
+func validateRekeyTable(table string) error {
+	for _, t := range RekeyTables {
+		if t == table {
+			return nil
+		}
+	}
+
+	return errors.Errorf("unknown rekey table: %s", table)
+}
+
+// RecordFailedCompensation inserts or updates a failed compensation record for
+// the given stream uid. Note this uses the DB pool rather than a transaction.
+func (d *db) RecordFailedCompensation(streamUID, cause string) error {
+	sql := `INSERT INTO failed_compensations (stream_uid, error)
+		VALUES (:stream_uid, :error)
+		ON CONFLICT (stream_uid) DO UPDATE
+		SET error = EXCLUDED.error,
+			attempts = failed_compensations.attempts + 1,
+			updated_at = NOW()`
+
+	mapArgs := map[string]interface{}{
+		"stream_uid": streamUID,
+		"error":      cause,
+	}
+
+	_, err := d.DB.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to record failed compensation")
+	}
+
+	return nil
+}
+
+// FailedCompensations returns a slice containing all recorded failed
+// compensations.
+func (d *db) FailedCompensations() ([]*FailedCompensation, error) {
+	sql := `SELECT id, stream_uid, error, attempts, created_at, updated_at
+		FROM failed_compensations
+		ORDER BY created_at, id`
+
+	compensations := []*FailedCompensation{}
+
+	err := d.DB.Select(&compensations, sql)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to select failed compensations")
+	}
+
+	return compensations, nil
+}
+
+// DeleteFailedCompensation deletes the failed compensation record for the
+// given stream uid.
+func (d *db) DeleteFailedCompensation(streamUID string) error {
+	sql := `DELETE FROM failed_compensations WHERE stream_uid = :stream_uid`
+
+	mapArgs := map[string]interface{}{
+		"stream_uid": streamUID,
+	}
+
+	_, err := d.DB.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete failed compensation")
+	}
+
+	return nil
+}
+
+// EnqueueOutboxMessage inserts a new message into the outbox within the given
+// transaction.
+func (d *db) EnqueueOutboxMessage(tx *sqlx.Tx, operation, streamUID string) error {
+	sql := `INSERT INTO outbox (operation, stream_uid)
+		VALUES (:operation, :stream_uid)`
+
+	mapArgs := map[string]interface{}{
+		"operation":  operation,
+		"stream_uid": streamUID,
+	}
+
+	_, err := tx.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to insert outbox message")
+	}
+
+	return nil
+}
+
+// ClaimOutboxMessages leases a batch of outbox messages that are due for
+// delivery in a single statement, so that the rows are only locked for the
+// duration of that statement.
+func (d *db) ClaimOutboxMessages(limit int, lease time.Duration) ([]*OutboxMessage, error) {
+	sql := `WITH due AS (
+			SELECT id FROM outbox
+			WHERE next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT :limit
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE outbox
+		SET next_attempt_at = NOW() + :lease * INTERVAL '1 second'
+		FROM due
+		WHERE outbox.id = due.id
+		RETURNING outbox.id, outbox.operation, outbox.stream_uid, outbox.attempts,
+			outbox.last_error, outbox.next_attempt_at, outbox.created_at`
+
+	mapArgs := map[string]interface{}{
+		"limit": limit,
+		"lease": lease.Seconds(),
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to claim outbox messages")
+	}
+
+	messages := []*OutboxMessage{}
+
+	err = d.DB.Select(&messages, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to claim outbox messages")
+	}
+
+	// rows returned by an update have no defined order
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].ID < messages[j].ID
+	})
+
+	return messages, nil
+}
+
+// DeleteOutboxMessage deletes the outbox message identified by the given id.
+func (d *db) DeleteOutboxMessage(id int) error {
+	sql := `DELETE FROM outbox WHERE id = :id`
+
+	mapArgs := map[string]interface{}{
+		"id": id,
+	}
+
+	_, err := d.DB.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete outbox message")
+	}
+
+	return nil
+}
+
+// RescheduleOutboxMessage increments the attempt count for the given message,
+// and records the error and the time of the next attempt.
+func (d *db) RescheduleOutboxMessage(id int, nextAttemptAt time.Time, cause string) error {
+	sql := `UPDATE outbox
+		SET attempts = attempts + 1,
+			last_error = :last_error,
+			next_attempt_at = :next_attempt_at
+		WHERE id = :id`
+
+	mapArgs := map[string]interface{}{
+		"id":              id,
+		"last_error":      cause,
+		"next_attempt_at": nextAttemptAt,
+	}
+
+	_, err := d.DB.NamedExec(sql, mapArgs)
+	if err != nil {
+		return errors.Wrap(err, "failed to reschedule outbox message")
+	}
+
+	return nil
+}
+
+// MigrateUp is a convenience function to run all up migrations in the context
+// of an instantiated DB instance.
+func (d *db) MigrateUp() error {
+	return MigrateUp(d.DB.DB, d.logger)
+}
+
+// SchemaVersion is a convenience function to read the current schema version
+// in the context of an instantiated DB instance.
+func (d *db) SchemaVersion() (uint, bool, error) {
+	return SchemaVersion(d.DB.DB, d.logger)
 }
 
 // MigrateDownAll is a convenience function to run all down migrations in the
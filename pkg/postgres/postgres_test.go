@@ -1,13 +1,19 @@
 package postgres_test
 
 import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/thingful/iotdevicereg/pkg/crypto"
 	"github.com/thingful/iotdevicereg/pkg/postgres"
 	"github.com/thingful/iotencoder/pkg/system"
 )
@@ -142,7 +148,7 @@ func (s *PostgresSuite) TestDuplicateTokenError() {
 	tx, err := s.db.BeginTX()
 	assert.Nil(s.T(), err)
 
-	_, err = s.db.RegisterDevice(tx, &postgres.Device{
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
 		Token:       "abc123",
 		Longitude:   2.3,
 		Latitude:    23.3,
@@ -153,18 +159,51 @@ func (s *PostgresSuite) TestDuplicateTokenError() {
 	})
 
 	assert.Nil(s.T(), err)
+	assert.False(s.T(), device.Existing)
+
+	err = s.db.CreateStream(tx, device.ID, "stream1")
+	assert.Nil(s.T(), err)
+
+	// registering again by the same user returns the existing device
+	existing, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   4.5,
+		Latitude:    45.6,
+		Disposition: "outdoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), existing.Existing)
+	assert.Equal(s.T(), device.ID, existing.ID)
+	assert.Equal(s.T(), device.PrivateKey, existing.PrivateKey)
+	assert.Equal(s.T(), device.PublicKey, existing.PublicKey)
+	assert.Equal(s.T(), device.User.PrivateKey, existing.User.PrivateKey)
+	assert.Equal(s.T(), device.User.PublicKey, existing.User.PublicKey)
+	assert.Equal(s.T(), 2.3, existing.Longitude)
+	assert.Equal(s.T(), "indoor", existing.Disposition)
+	assert.Len(s.T(), existing.Streams, 1)
 
+	// registering by another user is an error, but leaves the transaction usable
 	_, err = s.db.RegisterDevice(tx, &postgres.Device{
 		Token:       "abc123",
 		Longitude:   2.3,
 		Latitude:    23.3,
 		Disposition: "indoor",
 		User: &postgres.User{
-			UID: "alice",
+			UID: "bob",
 		},
 	})
 
 	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), postgres.ErrDeviceClaimed, errors.Cause(err))
+
+	var count int
+	err = tx.Get(&count, `SELECT COUNT(*) FROM devices`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
 
 	tx.Rollback()
 }
@@ -255,6 +294,1093 @@ func (s *PostgresSuite) TestDuplicateStreamError() {
 	tx.Rollback()
 }
 
+func (s *PostgresSuite) TestFailedCompensations() {
+	err := s.db.RecordFailedCompensation("abc", "encoder unavailable")
+	assert.Nil(s.T(), err)
+
+	err = s.db.RecordFailedCompensation("hij", "encoder unavailable")
+	assert.Nil(s.T(), err)
+
+	err = s.db.RecordFailedCompensation("abc", "encoder timeout")
+	assert.Nil(s.T(), err)
+
+	compensations, err := s.db.FailedCompensations()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), compensations, 2)
+
+	assert.Equal(s.T(), "abc", compensations[0].StreamUID)
+	assert.Equal(s.T(), "encoder timeout", compensations[0].Error)
+	assert.Equal(s.T(), 2, compensations[0].Attempts)
+
+	assert.Equal(s.T(), "hij", compensations[1].StreamUID)
+	assert.Equal(s.T(), 1, compensations[1].Attempts)
+
+	err = s.db.DeleteFailedCompensation("abc")
+	assert.Nil(s.T(), err)
+
+	compensations, err = s.db.FailedCompensations()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), compensations, 1)
+	assert.Equal(s.T(), "hij", compensations[0].StreamUID)
+}
+
+func (s *PostgresSuite) TestOutbox() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	err = s.db.EnqueueOutboxMessage(tx, postgres.DeleteStreamOperation, "abc")
+	assert.Nil(s.T(), err)
+
+	err = s.db.EnqueueOutboxMessage(tx, postgres.DeleteStreamOperation, "hij")
+	assert.Nil(s.T(), err)
+
+	err = s.db.EnqueueOutboxMessage(tx, postgres.DeleteStreamOperation, "klm")
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	messages, err := s.db.ClaimOutboxMessages(2, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), messages, 2)
+
+	assert.Equal(s.T(), postgres.DeleteStreamOperation, messages[0].Operation)
+	assert.Equal(s.T(), "abc", messages[0].StreamUID)
+	assert.Equal(s.T(), 0, messages[0].Attempts)
+	assert.Equal(s.T(), "hij", messages[1].StreamUID)
+
+	// leased messages are not claimed again while their lease is current, so
+	// only the remaining message is claimed here, with a lease that has
+	// already expired
+	leased, err := s.db.ClaimOutboxMessages(10, -time.Second)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), leased, 1)
+	assert.Equal(s.T(), "klm", leased[0].StreamUID)
+
+	err = s.db.DeleteOutboxMessage(messages[0].ID)
+	assert.Nil(s.T(), err)
+
+	err = s.db.RescheduleOutboxMessage(messages[1].ID, time.Now().Add(-time.Second), "encoder unavailable")
+	assert.Nil(s.T(), err)
+
+	// a rescheduled message is claimed again once it is due, as is a message
+	// whose lease has expired
+	messages, err = s.db.ClaimOutboxMessages(10, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), messages, 2)
+	assert.Equal(s.T(), "hij", messages[0].StreamUID)
+	assert.Equal(s.T(), 1, messages[0].Attempts)
+	assert.Equal(s.T(), "encoder unavailable", messages[0].LastError)
+	assert.Equal(s.T(), "klm", messages[1].StreamUID)
+	assert.Equal(s.T(), 0, messages[1].Attempts)
+}
+
+func (s *PostgresSuite) TestListDevices() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	var devices []*postgres.Device
+
+	for _, token := range []string{"abc123", "def456", "hij789"} {
+		device, err := s.db.RegisterDevice(tx, &postgres.Device{
+			Token:       token,
+			Longitude:   2.3,
+			Latitude:    23.3,
+			Disposition: "outdoor",
+			User: &postgres.User{
+				UID: "alice",
+			},
+		})
+		assert.Nil(s.T(), err)
+
+		devices = append(devices, device)
+	}
+
+	bob, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "klm012",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "bob",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, devices[0].ID, "stream1")
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, devices[0].ID, "stream2")
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, bob.ID, "stream3")
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	publicKey := devices[0].User.PublicKey
+
+	page, err := s.db.ListDevices(publicKey, 0, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page, 2)
+
+	assert.Equal(s.T(), "abc123", page[0].Token)
+	assert.Equal(s.T(), devices[0].PublicKey, page[0].PublicKey)
+	assert.Equal(s.T(), "", page[0].PrivateKey)
+	assert.Equal(s.T(), "outdoor", page[0].Disposition)
+	assert.Equal(s.T(), 2.3, page[0].Longitude)
+	assert.Equal(s.T(), 23.3, page[0].Latitude)
+	assert.False(s.T(), page[0].CreatedAt.IsZero())
+	assert.Len(s.T(), page[0].Streams, 2)
+	assert.Equal(s.T(), "stream1", page[0].Streams[0].UID)
+	assert.Equal(s.T(), "stream2", page[0].Streams[1].UID)
+
+	assert.Equal(s.T(), "def456", page[1].Token)
+	assert.Len(s.T(), page[1].Streams, 0)
+
+	page, err = s.db.ListDevices(publicKey, page[1].ID, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page, 1)
+	assert.Equal(s.T(), "hij789", page[0].Token)
+
+	page, err = s.db.ListDevices("unknown", 0, 2)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page, 0)
+}
+
+func (s *PostgresSuite) TestGetDevice() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, device.ID, "stream1")
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	got, err := s.db.GetDevice("abc123", device.User.PublicKey)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), device.ID, got.ID)
+	assert.Equal(s.T(), device.PublicKey, got.PublicKey)
+	assert.Equal(s.T(), "", got.PrivateKey)
+	assert.Equal(s.T(), "indoor", got.Disposition)
+	assert.Equal(s.T(), device.User.PublicKey, got.User.PublicKey)
+	assert.False(s.T(), got.CreatedAt.IsZero())
+	assert.False(s.T(), got.UpdatedAt.IsZero())
+	assert.Len(s.T(), got.Streams, 1)
+	assert.Equal(s.T(), "stream1", got.Streams[0].UID)
+
+	_, err = s.db.GetDevice("abc123", "foobar")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "failed to get device: failed to scan device: sql: no rows in result set", err.Error())
+
+	_, err = s.db.GetDevice("foobar", device.User.PublicKey)
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestDeviceKeys() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	got, err := s.db.DeviceKeys("abc123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), device.ID, got.ID)
+	assert.Equal(s.T(), device.PrivateKey, got.PrivateKey)
+	assert.Equal(s.T(), device.PublicKey, got.PublicKey)
+	assert.Equal(s.T(), "alice", got.User.UID)
+	assert.Equal(s.T(), device.User.PublicKey, got.User.PublicKey)
+	assert.Equal(s.T(), "", got.User.PrivateKey)
+
+	_, err = s.db.DeviceKeys("foobar")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "failed to read device keys: sql: no rows in result set", err.Error())
+}
+
+func (s *PostgresSuite) TestExportDevices() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	devices := []*postgres.Device{
+		{Token: "abc123", Longitude: 2.15, Latitude: 41.39, Disposition: "indoor", User: &postgres.User{UID: "alice"}},
+		{Token: "def456", Longitude: 2.17, Latitude: 41.40, Disposition: "outdoor", User: &postgres.User{UID: "alice"}},
+		{Token: "hij789", Longitude: -0.12, Latitude: 51.5, Disposition: "outdoor", User: &postgres.User{UID: "bob"}},
+	}
+
+	for _, device := range devices {
+		registered, err := s.db.RegisterDevice(tx, device)
+		assert.Nil(s.T(), err)
+
+		err = s.db.CreateStream(tx, registered.ID, "stream-"+device.Token)
+		assert.Nil(s.T(), err)
+	}
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	export := func(filter *postgres.ExportFilter) []*postgres.Device {
+		exported := []*postgres.Device{}
+
+		err := s.db.ExportDevices(filter, func(device *postgres.Device) error {
+			exported = append(exported, device)
+			return nil
+		})
+		assert.Nil(s.T(), err)
+
+		return exported
+	}
+
+	exported := export(nil)
+	assert.Len(s.T(), exported, 3)
+	assert.Equal(s.T(), "abc123", exported[0].Token)
+	assert.Equal(s.T(), "", exported[0].PrivateKey)
+	assert.NotEqual(s.T(), "", exported[0].PublicKey)
+	assert.Equal(s.T(), "alice", exported[0].User.UID)
+	assert.NotEqual(s.T(), "", exported[0].User.PublicKey)
+	assert.Equal(s.T(), "", exported[0].User.PrivateKey)
+	assert.Len(s.T(), exported[0].Streams, 1)
+	assert.Equal(s.T(), "stream-abc123", exported[0].Streams[0].UID)
+
+	exported = export(&postgres.ExportFilter{UserUIDs: []string{"bob"}})
+	assert.Len(s.T(), exported, 1)
+	assert.Equal(s.T(), "hij789", exported[0].Token)
+
+	exported = export(&postgres.ExportFilter{Disposition: "outdoor"})
+	assert.Len(s.T(), exported, 2)
+
+	exported = export(&postgres.ExportFilter{
+		BoundingBox: &postgres.BoundingBox{MinLongitude: 2.05, MinLatitude: 41.3, MaxLongitude: 2.25, MaxLatitude: 41.47},
+	})
+	assert.Len(s.T(), exported, 2)
+
+	exported = export(&postgres.ExportFilter{
+		UserUIDs:    []string{"alice", "bob"},
+		Disposition: "outdoor",
+		BoundingBox: &postgres.BoundingBox{MinLongitude: 2.05, MinLatitude: 41.3, MaxLongitude: 2.25, MaxLatitude: 41.47},
+	})
+	assert.Len(s.T(), exported, 1)
+	assert.Equal(s.T(), "def456", exported[0].Token)
+
+	exported = export(&postgres.ExportFilter{CreatedAfter: time.Now().Add(time.Hour)})
+	assert.Len(s.T(), exported, 0)
+
+	exported = export(&postgres.ExportFilter{CreatedBefore: time.Now().Add(time.Hour)})
+	assert.Len(s.T(), exported, 3)
+
+	// an error from the callback stops the export
+	err = s.db.ExportDevices(nil, func(device *postgres.Device) error {
+		return errors.New("stop")
+	})
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "stop", err.Error())
+}
+
+func (s *PostgresSuite) TestSnapshot() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	registered, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.15,
+		Latitude:    41.39,
+		Disposition: "indoor",
+		User:        &postgres.User{UID: "alice"},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, registered.ID, "stream-abc123")
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	original, err := s.db.DeviceKeys("abc123")
+	assert.Nil(s.T(), err)
+
+	snapshot, err := s.db.ReadSnapshot()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), snapshot.Tables["users"], 1)
+	assert.Len(s.T(), snapshot.Tables["devices"], 1)
+	assert.Len(s.T(), snapshot.Tables["streams"], 1)
+	assert.Equal(s.T(), original.PrivateKey, snapshot.Tables["devices"][0]["private_key"])
+
+	// snapshots are persisted as JSON so we restore what we read back
+	b, err := json.Marshal(snapshot)
+	assert.Nil(s.T(), err)
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var decoded postgres.Snapshot
+	err = decoder.Decode(&decoded)
+	assert.Nil(s.T(), err)
+
+	// a snapshot may not be restored over existing rows
+	err = s.db.RestoreSnapshot(&decoded)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), postgres.ErrNotEmpty, errors.Cause(err))
+
+	err = s.db.MigrateDownAll()
+	assert.Nil(s.T(), err)
+
+	err = s.db.MigrateUp()
+	assert.Nil(s.T(), err)
+
+	err = s.db.RestoreSnapshot(&decoded)
+	assert.Nil(s.T(), err)
+
+	restored, err := s.db.DeviceKeys("abc123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), original.PrivateKey, restored.PrivateKey)
+	assert.Equal(s.T(), original.PublicKey, restored.PublicKey)
+	assert.Equal(s.T(), original.User.PublicKey, restored.User.PublicKey)
+	assert.Equal(s.T(), "indoor", restored.Disposition)
+
+	// ids continue from those restored
+	tx, err = s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "def456",
+		Longitude:   2.15,
+		Latitude:    41.39,
+		Disposition: "indoor",
+		User:        &postgres.User{UID: "alice"},
+	})
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), device.ID > registered.ID)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestStreams() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.15,
+		Latitude:    41.39,
+		Disposition: "indoor",
+		Broker:      "tcp://broker:1883",
+		User:        &postgres.User{UID: "alice"},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, device.ID, "abc")
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, device.ID, "def")
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	uids, err := s.db.StreamUIDs()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"abc", "def"}, uids)
+
+	keys, err := s.db.DeviceKeys("abc123")
+	assert.Nil(s.T(), err)
+
+	streamDevice, err := s.db.StreamDevice("def")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "abc123", streamDevice.Token)
+	assert.Equal(s.T(), keys.PrivateKey, streamDevice.PrivateKey)
+	assert.Equal(s.T(), "tcp://broker:1883", streamDevice.Broker)
+	assert.Equal(s.T(), "indoor", streamDevice.Disposition)
+	assert.Equal(s.T(), "alice", streamDevice.User.UID)
+	assert.Equal(s.T(), keys.User.PublicKey, streamDevice.User.PublicKey)
+
+	_, err = s.db.StreamDevice("missing")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+
+	tx, err = s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	err = s.db.ReplaceStreamUID(tx, "abc", "hij")
+	assert.Nil(s.T(), err)
+
+	err = s.db.ReplaceStreamUID(tx, "missing", "klm")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+
+	err = tx.Rollback()
+	assert.Nil(s.T(), err)
+
+	tx, err = s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	err = s.db.ReplaceStreamUID(tx, "abc", "hij")
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	uids, err = s.db.StreamUIDs()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"hij", "def"}, uids)
+}
+
+func (s *PostgresSuite) TestClaimChallenges() {
+	_, err := s.db.ClaimSecret("abc123")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+
+	err = s.db.RegisterClaimSecret("abc123", "first secret value")
+	assert.Nil(s.T(), err)
+
+	secret, err := s.db.ClaimSecret("abc123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "first secret value", secret)
+
+	// registering again replaces the previous secret
+	err = s.db.RegisterClaimSecret("abc123", "second secret value")
+	assert.Nil(s.T(), err)
+
+	secret, err = s.db.ClaimSecret("abc123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "second secret value", secret)
+
+	err = s.db.CreateClaimChallenge("abc123", "challenge1", time.Now().Add(time.Minute), 2)
+	assert.Nil(s.T(), err)
+
+	err = s.db.ConsumeClaimChallenge("def456", "challenge1")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+
+	err = s.db.ConsumeClaimChallenge("abc123", "challenge1")
+	assert.Nil(s.T(), err)
+
+	// a challenge can only be consumed once
+	err = s.db.ConsumeClaimChallenge("abc123", "challenge1")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+
+	err = s.db.CreateClaimChallenge("abc123", "challenge2", time.Now().Add(-time.Minute), 2)
+	assert.Nil(s.T(), err)
+
+	err = s.db.ConsumeClaimChallenge("abc123", "challenge2")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+
+	// only limit unexpired challenges may be outstanding at once
+	err = s.db.CreateClaimChallenge("abc123", "challenge3", time.Now().Add(time.Minute), 2)
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateClaimChallenge("abc123", "challenge4", time.Now().Add(time.Minute), 2)
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateClaimChallenge("abc123", "challenge5", time.Now().Add(time.Minute), 2)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), postgres.ErrTooManyChallenges, errors.Cause(err))
+
+	// and challenges are only issued for devices with a claim secret
+	err = s.db.CreateClaimChallenge("def456", "challenge6", time.Now().Add(time.Minute), 2)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+}
+
+func (s *PostgresSuite) TestSigningKeys() {
+	_, err := s.db.SigningKey("abc123")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), sql.ErrNoRows, errors.Cause(err))
+
+	err = s.db.RegisterSigningKey(&postgres.SigningKey{
+		DeviceToken: "abc123",
+		PublicKey:   "first",
+		KeyCurve:    "ec25519",
+		KeyEncoding: "base64",
+	})
+	assert.Nil(s.T(), err)
+
+	key, err := s.db.SigningKey("abc123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "first", key.PublicKey)
+	assert.Equal(s.T(), "ec25519", key.KeyCurve)
+
+	// registering again replaces the previous key
+	err = s.db.RegisterSigningKey(&postgres.SigningKey{
+		DeviceToken: "abc123",
+		PublicKey:   "second",
+		KeyCurve:    "nist256",
+		KeyEncoding: "hex",
+	})
+	assert.Nil(s.T(), err)
+
+	key, err = s.db.SigningKey("abc123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "second", key.PublicKey)
+	assert.Equal(s.T(), "nist256", key.KeyCurve)
+	assert.Equal(s.T(), "hex", key.KeyEncoding)
+}
+
+func (s *PostgresSuite) TestUpdateDevice() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		Broker:      "tcp://mqtt.local:1883",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "tcp://mqtt.local:1883", device.Broker)
+
+	err = s.db.CreateStream(tx, device.ID, "stream1")
+	assert.Nil(s.T(), err)
+
+	updated, err := s.db.UpdateDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   4.5,
+		Latitude:    45.6,
+		Disposition: "outdoor",
+	}, device.User.PublicKey)
+	assert.Nil(s.T(), err)
+
+	assert.Equal(s.T(), device.ID, updated.ID)
+	assert.Equal(s.T(), device.PrivateKey, updated.PrivateKey)
+	assert.Equal(s.T(), device.PublicKey, updated.PublicKey)
+	assert.Equal(s.T(), 4.5, updated.Longitude)
+	assert.Equal(s.T(), 45.6, updated.Latitude)
+	assert.Equal(s.T(), "outdoor", updated.Disposition)
+	assert.Equal(s.T(), "tcp://mqtt.local:1883", updated.Broker)
+	assert.Equal(s.T(), "alice", updated.User.UID)
+	assert.Equal(s.T(), device.User.PublicKey, updated.User.PublicKey)
+
+	updated, err = s.db.UpdateDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   4.5,
+		Latitude:    45.6,
+		Disposition: "outdoor",
+		Broker:      "tcp://mqtt.example.com:1883",
+	}, device.User.PublicKey)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "tcp://mqtt.example.com:1883", updated.Broker)
+
+	_, err = s.db.UpdateDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   4.5,
+		Latitude:    45.6,
+		Disposition: "outdoor",
+	}, "foobar")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "failed to update device: sql: no rows in result set", err.Error())
+
+	tx.Rollback()
+}
+
+func (s *PostgresSuite) TestTransferDevice() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		Broker:      "tcp://mqtt.local:1883",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	transferred, err := s.db.TransferDevice(tx, &postgres.Device{
+		Token: "abc123",
+		User: &postgres.User{
+			UID: "bob",
+		},
+	}, device.User.PublicKey)
+	assert.Nil(s.T(), err)
+
+	assert.Equal(s.T(), device.ID, transferred.ID)
+	assert.NotEqual(s.T(), device.PrivateKey, transferred.PrivateKey)
+	assert.NotEqual(s.T(), device.PublicKey, transferred.PublicKey)
+	assert.Equal(s.T(), "tcp://mqtt.local:1883", transferred.Broker)
+	assert.Equal(s.T(), 2.3, transferred.Longitude)
+	assert.Equal(s.T(), "bob", transferred.User.UID)
+	assert.Equal(s.T(), "", transferred.User.PrivateKey)
+	assert.NotEqual(s.T(), device.User.PublicKey, transferred.User.PublicKey)
+
+	// alice has no devices left so has been deleted
+	var uids []string
+	err = tx.Select(&uids, `SELECT uid FROM users`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"bob"}, uids)
+
+	// alice can no longer transfer the device
+	_, err = s.db.TransferDevice(tx, &postgres.Device{
+		Token: "abc123",
+		User: &postgres.User{
+			UID: "carol",
+		},
+	}, device.User.PublicKey)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "failed to transfer device: sql: no rows in result set", err.Error())
+
+	tx.Rollback()
+}
+
+func (s *PostgresSuite) TestRotateKeys() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device1, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		Broker:      "tcp://mqtt.local:1883",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	device2, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "def456",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		Broker:      "tcp://mqtt.local:1883",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	rotated, err := s.db.RotateDeviceKeys(tx, "abc123", device1.User.PublicKey)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), device1.ID, rotated.ID)
+	assert.NotEqual(s.T(), device1.PrivateKey, rotated.PrivateKey)
+	assert.NotEqual(s.T(), device1.PublicKey, rotated.PublicKey)
+	assert.Equal(s.T(), "alice", rotated.User.UID)
+	assert.Equal(s.T(), device1.User.PublicKey, rotated.User.PublicKey)
+
+	_, err = s.db.RotateDeviceKeys(tx, "abc123", "foobar")
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "failed to rotate device keys: sql: no rows in result set", err.Error())
+
+	user, err := s.db.RotateUserKeys(tx, device1.User.PublicKey)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "alice", user.UID)
+	assert.NotEqual(s.T(), device1.User.PrivateKey, user.PrivateKey)
+	assert.NotEqual(s.T(), device1.User.PublicKey, user.PublicKey)
+
+	_, err = s.db.RotateUserKeys(tx, device1.User.PublicKey)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "failed to rotate user keys: sql: no rows in result set", err.Error())
+
+	devices, err := s.db.UserDevices(tx, user.PublicKey)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 2)
+	assert.Equal(s.T(), rotated.PrivateKey, devices[0].PrivateKey)
+	assert.Equal(s.T(), device2.PrivateKey, devices[1].PrivateKey)
+	assert.Equal(s.T(), "alice", devices[1].User.UID)
+	assert.Equal(s.T(), user.PublicKey, devices[1].User.PublicKey)
+
+	var history []struct {
+		OwnerType string `db:"owner_type"`
+		OwnerUID  string `db:"owner_uid"`
+		PublicKey string `db:"public_key"`
+	}
+
+	err = tx.Select(&history, `SELECT owner_type, owner_uid, public_key FROM key_history ORDER BY id`)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), history, 2)
+	assert.Equal(s.T(), postgres.DeviceKeyOwner, history[0].OwnerType)
+	assert.Equal(s.T(), "abc123", history[0].OwnerUID)
+	assert.Equal(s.T(), device1.PublicKey, history[0].PublicKey)
+	assert.Equal(s.T(), postgres.UserKeyOwner, history[1].OwnerType)
+	assert.Equal(s.T(), "alice", history[1].OwnerUID)
+	assert.Equal(s.T(), device1.User.PublicKey, history[1].PublicKey)
+
+	tx.Rollback()
+}
+
+func (s *PostgresSuite) TestDeleteStreams() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, device.ID, "stream1")
+	assert.Nil(s.T(), err)
+
+	err = s.db.CreateStream(tx, device.ID, "stream2")
+	assert.Nil(s.T(), err)
+
+	streams, err := s.db.DeleteStreams(tx, device.ID)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), streams, 2)
+
+	var count int
+	err = tx.Get(&count, `SELECT COUNT(*) FROM streams`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, count)
+
+	tx.Rollback()
+}
+
+// countingGenerator is a key generator recording how many key pairs it has
+// generated.
+type countingGenerator struct {
+	count int
+}
+
+func (c *countingGenerator) NewKeyPair(options *crypto.KeyOptions) (*crypto.KeyPair, error) {
+	c.count++
+	return crypto.DefaultKeyGenerator.NewKeyPair(options)
+}
+
+func (s *PostgresSuite) TestUserKeyGeneration() {
+	generator := &countingGenerator{}
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:            os.Getenv("DEVICEREG_DATABASE_URL"),
+		EncryptionPassword: "password",
+		KeySource:          generator,
+	}, kitlog.NewNopLogger())
+
+	err := db.(system.Startable).Start()
+	assert.Nil(s.T(), err)
+	defer db.(system.Stoppable).Stop()
+
+	tx, err := db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	first, err := db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Disposition: "indoor",
+		User:        &postgres.User{UID: "alice"},
+	})
+	assert.Nil(s.T(), err)
+
+	// one key pair for the new user and one for the device
+	assert.Equal(s.T(), 2, generator.count)
+
+	second, err := db.RegisterDevice(tx, &postgres.Device{
+		Token:       "def456",
+		Disposition: "indoor",
+		User:        &postgres.User{UID: "alice"},
+	})
+	assert.Nil(s.T(), err)
+
+	// the existing user's key pair is reused, so only the device needs one
+	assert.Equal(s.T(), 3, generator.count)
+	assert.Equal(s.T(), first.User.PublicKey, second.User.PublicKey)
+	assert.Equal(s.T(), first.User.PrivateKey, second.User.PrivateKey)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestKeyOptions() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	// users created before key options are configured keep the defaults
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), crypto.Ec25519Curve, device.KeyCurve)
+	assert.Equal(s.T(), crypto.Base64Encoding, device.KeyEncoding)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:            os.Getenv("DEVICEREG_DATABASE_URL"),
+		EncryptionPassword: "password",
+		KeyOptions: &crypto.KeyOptions{
+			Curve:    crypto.Nist256Curve,
+			Encoding: crypto.HexEncoding,
+		},
+	}, kitlog.NewNopLogger())
+
+	err = db.(system.Startable).Start()
+	assert.Nil(s.T(), err)
+	defer db.(system.Stoppable).Stop()
+
+	tx, err = db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err = db.RegisterDevice(tx, &postgres.Device{
+		Token:       "def456",
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "bob",
+		},
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), crypto.Nist256Curve, device.KeyCurve)
+	assert.Equal(s.T(), crypto.HexEncoding, device.KeyEncoding)
+	assert.Equal(s.T(), crypto.Nist256Curve, device.User.KeyCurve)
+	assert.Equal(s.T(), crypto.HexEncoding, device.User.KeyEncoding)
+
+	// devices of existing users are given keys matching their owner's
+	device, err = db.RegisterDevice(tx, &postgres.Device{
+		Token:       "hij789",
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), crypto.Ec25519Curve, device.KeyCurve)
+	assert.Equal(s.T(), crypto.Base64Encoding, device.KeyEncoding)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	got, err := db.DeviceKeys("def456")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), crypto.Nist256Curve, got.KeyCurve)
+	assert.Equal(s.T(), crypto.HexEncoding, got.KeyEncoding)
+	assert.Equal(s.T(), crypto.Nist256Curve, got.User.KeyCurve)
+	assert.Equal(s.T(), crypto.HexEncoding, got.User.KeyEncoding)
+
+	// rotating keys preserves the curve and encoding
+	tx, err = db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	user, err := db.RotateUserKeys(tx, device.User.PublicKey)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), crypto.Ec25519Curve, user.KeyCurve)
+	assert.Equal(s.T(), crypto.Base64Encoding, user.KeyEncoding)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestRekey() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	var devices []*postgres.Device
+
+	for _, token := range []string{"abc123", "def456", "hij789"} {
+		device, err := s.db.RegisterDevice(tx, &postgres.Device{
+			Token:       token,
+			Longitude:   2.3,
+			Latitude:    23.3,
+			Disposition: "indoor",
+			User: &postgres.User{
+				UID: "alice",
+			},
+		})
+		assert.Nil(s.T(), err)
+
+		devices = append(devices, device)
+	}
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	newDB := func(password, previousPassword string) postgres.DB {
+		db := postgres.NewDB(&postgres.Config{
+			ConnStr:                    os.Getenv("DEVICEREG_DATABASE_URL"),
+			EncryptionPassword:         password,
+			PreviousEncryptionPassword: previousPassword,
+		}, kitlog.NewNopLogger())
+
+		err := db.(system.Startable).Start()
+		assert.Nil(s.T(), err)
+
+		return db
+	}
+
+	userDevices := func(db postgres.DB) ([]*postgres.Device, error) {
+		tx, err := db.BeginTX()
+		assert.Nil(s.T(), err)
+		defer tx.Rollback()
+
+		return db.UserDevices(tx, devices[0].User.PublicKey)
+	}
+
+	rolloverDB := newDB("newpassword", "password")
+	defer rolloverDB.(system.Stoppable).Stop()
+
+	updatedDB := newDB("newpassword", "")
+	defer updatedDB.(system.Stoppable).Stop()
+
+	// before rekeying only the old password works
+	_, err = userDevices(updatedDB)
+	assert.NotNil(s.T(), err)
+
+	got, err := userDevices(rolloverDB)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), devices[0].PrivateKey, got[0].PrivateKey)
+
+	lastID, err := rolloverDB.RekeyCheckpoint("devices")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, lastID)
+
+	lastID, count, err := rolloverDB.RekeyBatch("devices", lastID, 2, nil)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, count)
+	assert.Equal(s.T(), devices[1].ID, lastID)
+
+	// during the rollover keys encrypted with either password are readable
+	got, err = userDevices(rolloverDB)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), got, 3)
+	assert.Equal(s.T(), devices[1].PrivateKey, got[1].PrivateKey)
+	assert.Equal(s.T(), devices[2].PrivateKey, got[2].PrivateKey)
+
+	// an interrupted rekey resumes from the checkpoint
+	checkpoint, err := rolloverDB.RekeyCheckpoint("devices")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), lastID, checkpoint)
+
+	lastID, count, err = rolloverDB.RekeyBatch("devices", checkpoint, 2, nil)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+	assert.Equal(s.T(), devices[2].ID, lastID)
+
+	_, count, err = rolloverDB.RekeyBatch("users", 0, 2, nil)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	err = rolloverDB.ClearRekeyCheckpoints()
+	assert.Nil(s.T(), err)
+
+	checkpoint, err = rolloverDB.RekeyCheckpoint("devices")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, checkpoint)
+
+	// after rekeying only the new password is needed
+	got, err = userDevices(updatedDB)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), got, 3)
+	assert.Equal(s.T(), devices[0].PrivateKey, got[0].PrivateKey)
+
+	_, _, err = rolloverDB.RekeyBatch("streams", 0, 2, nil)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "unknown rekey table: streams", err.Error())
+}
+
+func (s *PostgresSuite) TestRekeyFromPreviousBackend() {
+	tx, err := s.db.BeginTX()
+	assert.Nil(s.T(), err)
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	err = tx.Commit()
+	assert.Nil(s.T(), err)
+
+	previous, err := postgres.NewKeyEncrypter(&postgres.EncrypterConfig{
+		Backend:            postgres.PgcryptoBackend,
+		EncryptionPassword: "password",
+	})
+	assert.Nil(s.T(), err)
+
+	current, err := postgres.NewKeyEncrypter(&postgres.EncrypterConfig{
+		Backend:   postgres.EnvelopeBackend,
+		MasterKey: randomKey(s.T()),
+	})
+	assert.Nil(s.T(), err)
+
+	envelopeDB := postgres.NewDB(&postgres.Config{
+		ConnStr:      os.Getenv("DEVICEREG_DATABASE_URL"),
+		KeyEncrypter: current,
+	}, kitlog.NewNopLogger())
+
+	err = envelopeDB.(system.Startable).Start()
+	assert.Nil(s.T(), err)
+	defer envelopeDB.(system.Stoppable).Stop()
+
+	// the new backend cannot decrypt our own keys
+	_, _, err = envelopeDB.RekeyBatch("devices", 0, 10, nil)
+	assert.NotNil(s.T(), err)
+
+	for _, table := range postgres.RekeyTables {
+		_, _, err = envelopeDB.RekeyBatch(table, 0, 10, previous)
+		assert.Nil(s.T(), err)
+	}
+
+	err = envelopeDB.ClearRekeyCheckpoints()
+	assert.Nil(s.T(), err)
+
+	tx, err = envelopeDB.BeginTX()
+	assert.Nil(s.T(), err)
+	defer tx.Rollback()
+
+	got, err := envelopeDB.UserDevices(tx, device.User.PublicKey)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), got, 1)
+	assert.Equal(s.T(), device.PrivateKey, got[0].PrivateKey)
+
+	// claiming another device reads the migrated user key
+	another, err := envelopeDB.RegisterDevice(tx, &postgres.Device{
+		Token:       "def456",
+		Longitude:   2.3,
+		Latitude:    23.3,
+		Disposition: "indoor",
+		User: &postgres.User{
+			UID: "alice",
+		},
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), device.User.PrivateKey, another.User.PrivateKey)
+}
+
 func TestRunPostgresSuite(t *testing.T) {
 	suite.Run(t, new(PostgresSuite))
 }
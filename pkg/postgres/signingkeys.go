@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+)
+
+// SigningKey is the public key with which a device signs payloads, generated
+// and held by the device itself so that its private key is never known to us.
+type SigningKey struct {
+	ID          int    `db:"id"`
+	DeviceToken string `db:"device_token"`
+	PublicKey   string `db:"public_key"`
+	KeyCurve    string `db:"key_curve"`
+	KeyEncoding string `db:"key_encoding"`
+}
+
+// KeyOptions returns the curve and encoding of the signing key.
+func (s *SigningKey) KeyOptions() *crypto.KeyOptions {
+	return &crypto.KeyOptions{
+		Curve:    s.KeyCurve,
+		Encoding: s.KeyEncoding,
+	}
+}
+
+// RegisterSigningKey is our implementation of the RegisterSigningKey method
+// defined in our interface.
+func (d *db) RegisterSigningKey(key *SigningKey) error {
+	sql := `INSERT INTO signing_keys (device_token, public_key, key_curve, key_encoding)
+		VALUES (:device_token, :public_key, :key_curve, :key_encoding)
+		ON CONFLICT (device_token) DO UPDATE
+		SET public_key = EXCLUDED.public_key,
+			key_curve = EXCLUDED.key_curve,
+			key_encoding = EXCLUDED.key_encoding,
+			created_at = NOW()`
+
+	_, err := d.DB.NamedExec(sql, key)
+	if err != nil {
+		return errors.Wrap(err, "failed to register signing key")
+	}
+
+	return nil
+}
+
+// SigningKey is our implementation of the SigningKey method defined in our
+// interface.
+func (d *db) SigningKey(deviceToken string) (*SigningKey, error) {
+	sql := `SELECT id, device_token, public_key, key_curve, key_encoding
+		FROM signing_keys
+		WHERE device_token = :device_token`
+
+	mapArgs := map[string]interface{}{
+		"device_token": deviceToken,
+	}
+
+	sql, args, err := d.DB.BindNamed(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to bind named query to read signing key")
+	}
+
+	var key SigningKey
+
+	err = d.DB.Get(&key, sql, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read signing key")
+	}
+
+	return &key, nil
+}
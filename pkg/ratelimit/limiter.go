@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// sweepInterval is the interval at which we discard the buckets of keys
+	// that have been idle long enough for their buckets to refill.
+	sweepInterval = time.Minute
+)
+
+var (
+	// rateLimitRejections is a prometheus counter recording requests rejected
+	// by a rate limiter keyed by limiter and method.
+	rateLimitRejections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_ratelimit_rejections",
+			Help: "Counter of requests rejected for exceeding a rate limit",
+		},
+		[]string{
+			// which limit was exceeded: ip or user
+			"limit",
+			// which method was called
+			"method",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rateLimitRejections)
+}
+
+// Config is used to configure the limiter.
+type Config struct {
+	// Name identifies the limiter in metrics.
+	Name string
+
+	// Rate is the number of requests per second each key may make on average.
+	Rate float64
+
+	// Burst is the number of requests each key may make at once, after being
+	// idle.
+	Burst int
+}
+
+// bucket holds the tokens available to a single key, as of the time it was
+// last updated.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Limiter is a token bucket rate limiter holding a separate bucket for each
+// key, such as a client IP address or user uid. Each bucket holds up to Burst
+// tokens and is refilled at Rate tokens per second, and each allowed request
+// takes a token.
+type Limiter struct {
+	name  string
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewLimiter returns a new Limiter with the given config.
+func NewLimiter(config *Config) *Limiter {
+	return &Limiter{
+		name:      config.Name,
+		rate:      config.Rate,
+		burst:     float64(config.Burst),
+		buckets:   map[string]*bucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the given key, returning false if it
+// has none available, in which case the request should be rejected. The
+// method is only used to label rejections in our metrics.
+func (l *Limiter) Allow(key, method string) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.updated = now
+
+	if b.tokens < 1 {
+		rateLimitRejections.With(prometheus.Labels{"limit": l.name, "method": method}).Inc()
+		return false
+	}
+
+	b.tokens = b.tokens - 1
+
+	return true
+}
+
+// refill returns the tokens in the given bucket at the given time.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+
+	return tokens
+}
+
+// sweep discards full buckets, as they are no different from the bucket a key
+// is given on its next request, so that memory use is bounded by the number of
+// recently active keys. Must be called with the lock held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.lastSweep = now
+}
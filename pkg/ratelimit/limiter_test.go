@@ -0,0 +1,58 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/ratelimit"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := ratelimit.NewLimiter(&ratelimit.Config{
+		Name:  "test",
+		Rate:  20,
+		Burst: 3,
+	})
+
+	// a new key may make up to the burst at once
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.Allow("alice", "ClaimDevice"))
+	}
+
+	assert.False(t, limiter.Allow("alice", "ClaimDevice"))
+
+	// other keys have their own buckets
+	assert.True(t, limiter.Allow("bob", "ClaimDevice"))
+
+	// tokens are replenished at the configured rate
+	time.Sleep(100 * time.Millisecond)
+
+	assert.True(t, limiter.Allow("alice", "ClaimDevice"))
+}
+
+func TestAllowConcurrent(t *testing.T) {
+	limiter := ratelimit.NewLimiter(&ratelimit.Config{
+		Name:  "test",
+		Rate:  0.001,
+		Burst: 10,
+	})
+
+	allowed := make(chan bool, 50)
+
+	for i := 0; i < 50; i++ {
+		go func() {
+			allowed <- limiter.Allow("alice", "ClaimDevice")
+		}()
+	}
+
+	count := 0
+	for i := 0; i < 50; i++ {
+		if <-allowed {
+			count++
+		}
+	}
+
+	assert.Equal(t, 10, count)
+}
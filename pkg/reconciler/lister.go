@@ -0,0 +1,93 @@
+package reconciler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+)
+
+// listStreamsPath is the path of the encoder's ListStreams method.
+const listStreamsPath = "/twirp/encoder.Encoder/ListStreams"
+
+// StreamLister is the interface of a client able to list every stream the
+// encoder is currently running.
+type StreamLister interface {
+	// ListStreams returns the uids of all streams known to the encoder.
+	ListStreams(ctx context.Context) ([]string, error)
+}
+
+// listStreamsResponse is the body returned by the encoder's ListStreams
+// method.
+type listStreamsResponse struct {
+	StreamUIDs []string `json:"stream_uids"`
+}
+
+// twirpError is the body returned by a twirp server for a failed request.
+type twirpError struct {
+	Code string `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// streamLister is our implementation of StreamLister, calling the encoder over
+// HTTP.
+type streamLister struct {
+	url    string
+	client *http.Client
+}
+
+// NewStreamLister returns a StreamLister calling the ListStreams method of the
+// encoder listening at the given address. Our vendored encoder client predates
+// this method, so we call it directly using twirp's JSON encoding.
+func NewStreamLister(addr string, client *http.Client) StreamLister {
+	return &streamLister{
+		url:    strings.TrimSuffix(addr, "/") + listStreamsPath,
+		client: client,
+	}
+}
+
+// ListStreams is our implementation of the method defined in the StreamLister
+// interface. Errors returned by the encoder are returned as twirp errors, so
+// callers can inspect their code.
+func (s *streamLister) ListStreams(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewBufferString("{}"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create list streams request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list encoder streams")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var twerr twirpError
+
+		err = json.NewDecoder(resp.Body).Decode(&twerr)
+		if err != nil || twerr.Code == "" {
+			return nil, errors.Errorf("failed to list encoder streams: unexpected status %d", resp.StatusCode)
+		}
+
+		return nil, twirp.NewError(twirp.ErrorCode(twerr.Code), twerr.Msg)
+	}
+
+	var body listStreamsResponse
+
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode list streams response")
+	}
+
+	if body.StreamUIDs == nil {
+		return []string{}, nil
+	}
+
+	return body.StreamUIDs, nil
+}
@@ -0,0 +1,80 @@
+package reconciler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/reconciler"
+)
+
+func TestListStreams(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/twirp/encoder.Encoder/ListStreams", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		w.Write([]byte(`{"stream_uids":["abc","def"]}`))
+	}))
+	defer ts.Close()
+
+	lister := reconciler.NewStreamLister(ts.URL+"/", http.DefaultClient)
+
+	uids, err := lister.ListStreams(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"abc", "def"}, uids)
+}
+
+func TestListStreamsEmpty(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	uids, err := reconciler.NewStreamLister(ts.URL, http.DefaultClient).ListStreams(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{}, uids)
+}
+
+func TestListStreamsErrors(t *testing.T) {
+	testcases := []struct {
+		label        string
+		status       int
+		body         string
+		expectedCode twirp.ErrorCode
+	}{
+		{
+			label:        "twirp error",
+			status:       http.StatusNotFound,
+			body:         `{"code":"bad_route","msg":"no handler for path"}`,
+			expectedCode: twirp.BadRoute,
+		},
+		{
+			label:  "other error",
+			status: http.StatusBadGateway,
+			body:   `bad gateway`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer ts.Close()
+
+			_, err := reconciler.NewStreamLister(ts.URL, http.DefaultClient).ListStreams(context.Background())
+			assert.NotNil(t, err)
+
+			if tc.expectedCode != "" {
+				twerr, ok := err.(twirp.Error)
+				assert.True(t, ok)
+				assert.Equal(t, tc.expectedCode, twerr.Code())
+			}
+		})
+	}
+}
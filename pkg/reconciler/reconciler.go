@@ -0,0 +1,437 @@
+package reconciler
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/thingful/iotdevicereg/pkg/encoderclient"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/rpc"
+)
+
+const (
+	// DefaultInterval is the default interval at which we reconcile our streams
+	// with the encoder.
+	DefaultInterval = time.Hour
+
+	// MissingOnEncoder labels streams recorded locally which the encoder is not
+	// running.
+	MissingOnEncoder = "missing_on_encoder"
+
+	// OrphanedOnEncoder labels streams run by the encoder which have no local
+	// record.
+	OrphanedOnEncoder = "orphaned_on_encoder"
+
+	// requestTimeout is the maximum time we allow for a single call to the
+	// encoder.
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	// reconcileDrift is a prometheus gauge recording the number of streams found
+	// to differ between our DB and the encoder by the last reconciliation.
+	reconcileDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "decode_reconcile_drift",
+			Help: "Number of streams found to differ between the DB and the encoder by the last reconciliation",
+		},
+		[]string{
+			// is the kind of drift: missing_on_encoder or orphaned_on_encoder
+			"kind",
+		},
+	)
+
+	// reconcileRepairs is a prometheus counter recording attempts to repair
+	// drifted streams keyed by kind and status.
+	reconcileRepairs = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_reconcile_repairs",
+			Help: "Counter of attempts to repair streams that differ between the DB and the encoder",
+		},
+		[]string{
+			// is the kind of drift: missing_on_encoder or orphaned_on_encoder
+			"kind",
+			// is the status of the repair: success or error
+			"status",
+		},
+	)
+
+	// reconcileRuns is a prometheus counter recording reconciliations keyed by
+	// status.
+	reconcileRuns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_reconcile_runs",
+			Help: "Counter of reconciliations between the DB and the encoder",
+		},
+		[]string{
+			// is the status of the reconciliation: success or error
+			"status",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(reconcileDrift)
+	prometheus.MustRegister(reconcileRepairs)
+	prometheus.MustRegister(reconcileRuns)
+}
+
+// Config is used to inject dependencies and configuration into the
+// reconciler. A zero valued interval is replaced with the package default.
+// Drift is only repaired if Repair is true, otherwise it is just reported.
+type Config struct {
+	DB            postgres.DB
+	EncoderClient encoder.Encoder
+	StreamLister  StreamLister
+	Interval      time.Duration
+	Repair        bool
+	Verbose       bool
+}
+
+// Report describes the drift found by a single reconciliation, and the outcome
+// of any repairs.
+type Report struct {
+	// MissingOnEncoder contains the uids of streams recorded locally which the
+	// encoder is not running.
+	MissingOnEncoder []string
+
+	// OrphanedOnEncoder contains the uids of streams run by the encoder which
+	// have no local record.
+	OrphanedOnEncoder []string
+
+	// Repaired is the number of streams successfully repaired.
+	Repaired int
+
+	// Failed is the number of streams we attempted but failed to repair.
+	Failed int
+}
+
+// Reconciler is a component that compares the streams recorded in our DB with
+// those run by the encoder, reporting and optionally repairing any drift.
+// Streams missing on the encoder are recreated and their local record updated
+// with the new uid, and streams orphaned on the encoder are deleted.
+//
+// As claims create streams on the encoder before committing them locally, a
+// stream may briefly appear orphaned while a claim is in progress. We
+// therefore only repair drift which was also found by the previous
+// reconciliation.
+type Reconciler struct {
+	db            postgres.DB
+	encoderClient encoder.Encoder
+	streamLister  StreamLister
+	interval      time.Duration
+	repair        bool
+	verbose       bool
+	logger        kitlog.Logger
+
+	mu        sync.Mutex
+	suspected map[string]string
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewReconciler returns a new Reconciler instance configured with the given
+// config and logger. The reconciler does not do anything until started.
+func NewReconciler(config *Config, logger kitlog.Logger) *Reconciler {
+	logger = kitlog.With(logger, "module", "reconciler")
+	logger.Log("msg", "creating reconciler")
+
+	r := &Reconciler{
+		db:            config.DB,
+		encoderClient: config.EncoderClient,
+		streamLister:  config.StreamLister,
+		interval:      config.Interval,
+		repair:        config.Repair,
+		verbose:       config.Verbose,
+		logger:        logger,
+		suspected:     map[string]string{},
+		quit:          make(chan struct{}),
+	}
+
+	if r.interval == 0 {
+		r.interval = DefaultInterval
+	}
+
+	return r
+}
+
+// Start starts the reconciler running every interval in a background
+// goroutine.
+func (r *Reconciler) Start() error {
+	r.logger.Log("msg", "starting reconciler", "interval", r.interval, "repair", r.repair)
+
+	r.wg.Add(1)
+	go r.run()
+
+	return nil
+}
+
+// Stop signals the reconciler to stop, and waits for any in progress
+// reconciliation to complete.
+func (r *Reconciler) Stop() error {
+	r.logger.Log("msg", "stopping reconciler")
+
+	close(r.quit)
+	r.wg.Wait()
+
+	return nil
+}
+
+// run is the main loop of the reconciler, reconciling every interval until we
+// are stopped.
+func (r *Reconciler) run() {
+	defer r.wg.Done()
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		case <-ticker.C:
+			_, err := r.Reconcile(context.Background())
+			if err != nil {
+				r.logger.Log("msg", "failed to reconcile streams", "err", err)
+			}
+		}
+	}
+}
+
+// Reconcile compares our streams with those of the encoder once, returning a
+// report of the drift found. If configured to repair, drift which was also
+// found by the previous call is repaired. Failed repairs are logged and
+// counted in the report rather than returned; an error is only returned if we
+// were unable to read streams from either side.
+func (r *Reconciler) Reconcile(ctx context.Context) (*Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report, err := r.compare(ctx)
+	if err != nil {
+		reconcileRuns.WithLabelValues("error").Inc()
+		return nil, err
+	}
+
+	reconcileRuns.WithLabelValues("success").Inc()
+	reconcileDrift.WithLabelValues(MissingOnEncoder).Set(float64(len(report.MissingOnEncoder)))
+	reconcileDrift.WithLabelValues(OrphanedOnEncoder).Set(float64(len(report.OrphanedOnEncoder)))
+
+	if len(report.MissingOnEncoder) > 0 || len(report.OrphanedOnEncoder) > 0 {
+		r.logger.Log("msg", "found stream drift", MissingOnEncoder, len(report.MissingOnEncoder), OrphanedOnEncoder, len(report.OrphanedOnEncoder))
+	} else if r.verbose {
+		r.logger.Log("msg", "found no stream drift")
+	}
+
+	suspected := map[string]string{}
+
+	for _, uid := range report.MissingOnEncoder {
+		suspected[uid] = MissingOnEncoder
+	}
+
+	for _, uid := range report.OrphanedOnEncoder {
+		suspected[uid] = OrphanedOnEncoder
+	}
+
+	previous := r.suspected
+	r.suspected = suspected
+
+	if !r.repair {
+		return report, nil
+	}
+
+	for _, uid := range report.MissingOnEncoder {
+		if previous[uid] != MissingOnEncoder {
+			continue
+		}
+
+		r.recordRepair(report, uid, MissingOnEncoder, r.recreateStream(ctx, uid))
+	}
+
+	for _, uid := range report.OrphanedOnEncoder {
+		if previous[uid] != OrphanedOnEncoder {
+			continue
+		}
+
+		r.recordRepair(report, uid, OrphanedOnEncoder, r.deleteStream(ctx, uid))
+	}
+
+	return report, nil
+}
+
+// compare reads streams from our DB and the encoder, returning a report of the
+// differences. We read our streams both before and after listing the encoder's
+// streams; only streams present in both reads can be missing on the encoder,
+// and only streams absent from the second read can be orphaned on it. This
+// avoids reporting streams created or deleted while we were comparing.
+func (r *Reconciler) compare(ctx context.Context) (*Report, error) {
+	before, err := r.db.StreamUIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	remote, err := r.streamLister.ListStreams(listCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := r.db.StreamUIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	remoteSet := toSet(remote)
+	afterSet := toSet(after)
+
+	report := &Report{
+		MissingOnEncoder:  []string{},
+		OrphanedOnEncoder: []string{},
+	}
+
+	for _, uid := range before {
+		if afterSet[uid] && !remoteSet[uid] {
+			report.MissingOnEncoder = append(report.MissingOnEncoder, uid)
+		}
+	}
+
+	for uid := range remoteSet {
+		if !afterSet[uid] {
+			report.OrphanedOnEncoder = append(report.OrphanedOnEncoder, uid)
+		}
+	}
+
+	sort.Strings(report.OrphanedOnEncoder)
+
+	return report, nil
+}
+
+// recordRepair records the outcome of a single repair in our report, metrics
+// and logs.
+func (r *Reconciler) recordRepair(report *Report, uid, kind string, err error) {
+	if err != nil {
+		report.Failed++
+		reconcileRepairs.WithLabelValues(kind, "error").Inc()
+		r.logger.Log("msg", "failed to repair stream", "streamUID", uid, "kind", kind, "err", err)
+		return
+	}
+
+	report.Repaired++
+	reconcileRepairs.WithLabelValues(kind, "success").Inc()
+
+	if r.verbose {
+		r.logger.Log("msg", "repaired stream", "streamUID", uid, "kind", kind)
+	}
+}
+
+// recreateStream creates a new stream on the encoder for the device owning the
+// local stream with the given uid, and then updates the local stream with the
+// uid of the new stream. If the local update fails we attempt to delete the
+// new stream, recording a failed compensation if we cannot.
+func (r *Reconciler) recreateStream(ctx context.Context, uid string) error {
+	device, err := r.db.StreamDevice(uid)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			// the stream was deleted after we compared, so nothing needs repairing
+			return nil
+		}
+
+		return err
+	}
+
+	if device.Broker == "" {
+		return errors.Errorf("no broker recorded for device %s", device.Token)
+	}
+
+	createCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	resp, err := r.encoderClient.CreateStream(createCtx, rpc.NewCreateStreamRequest(device, device.Broker, device.User.UID))
+	if err != nil {
+		return errors.Wrap(err, "failed to create stream")
+	}
+
+	err = r.replaceStreamUID(uid, resp.StreamUid)
+	if err != nil {
+		r.compensateCreateStream(ctx, resp.StreamUid)
+		return err
+	}
+
+	return nil
+}
+
+// replaceStreamUID updates the local stream with the given uid to have a new
+// uid within its own transaction.
+func (r *Reconciler) replaceStreamUID(uid, newUID string) error {
+	tx, err := r.db.BeginTX()
+	if err != nil {
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+
+	err = r.db.ReplaceStreamUID(tx, uid, newUID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "failed to commit transaction")
+	}
+
+	return nil
+}
+
+// compensateCreateStream deletes a stream we created on the encoder but failed
+// to record locally, recording a failed compensation if we cannot.
+func (r *Reconciler) compensateCreateStream(ctx context.Context, uid string) {
+	err := r.deleteStream(ctx, uid)
+	if err == nil {
+		return
+	}
+
+	r.logger.Log("msg", "failed to delete orphaned stream", "streamUID", uid, "err", err)
+
+	rerr := r.db.RecordFailedCompensation(uid, err.Error())
+	if rerr != nil {
+		r.logger.Log("msg", "failed to record failed compensation", "streamUID", uid, "err", rerr)
+	}
+}
+
+// deleteStream deletes the stream with the given uid from the encoder. A
+// stream the encoder does not know about is treated as deleted.
+func (r *Reconciler) deleteStream(ctx context.Context, uid string) error {
+	deleteCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	_, err := r.encoderClient.DeleteStream(deleteCtx, &encoder.DeleteStreamRequest{
+		StreamUid: uid,
+	})
+	if err != nil && !encoderclient.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete stream")
+	}
+
+	return nil
+}
+
+// toSet returns a set containing the given strings.
+func toSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+
+	for _, value := range values {
+		set[value] = true
+	}
+
+	return set
+}
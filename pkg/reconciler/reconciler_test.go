@@ -0,0 +1,195 @@
+package reconciler_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/mocks"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/reconciler"
+	"github.com/thingful/iotdevicereg/pkg/system"
+)
+
+type ReconcilerSuite struct {
+	suite.Suite
+
+	db            postgres.DB
+	logger        kitlog.Logger
+	encoderClient *mocks.Encoder
+	streamLister  *mocks.StreamLister
+	rawDb         *sqlx.DB
+}
+
+func (s *ReconcilerSuite) SetupTest() {
+	connStr := os.Getenv("DEVICEREG_DATABASE_URL")
+
+	s.logger = kitlog.NewNopLogger()
+	s.encoderClient = new(mocks.Encoder)
+	s.streamLister = new(mocks.StreamLister)
+
+	db, err := sqlx.Open("postgres", connStr)
+	if err != nil {
+		s.T().Fatalf("Failed to open raw db connection: %v", err)
+	}
+
+	s.rawDb = db
+
+	s.db = postgres.NewDB(
+		&postgres.Config{
+			ConnStr:            connStr,
+			EncryptionPassword: "password",
+		},
+		s.logger,
+	)
+
+	err = s.db.(system.Startable).Start()
+	if err != nil {
+		s.T().Fatalf("Failed to start db: %v", err)
+	}
+
+	err = s.db.MigrateDownAll()
+	if err != nil {
+		s.T().Fatalf("Failed to migrate db down: %v", err)
+	}
+
+	err = s.db.MigrateUp()
+	if err != nil {
+		s.T().Fatalf("Failed to migrate db up: %v", err)
+	}
+
+	tx, err := s.db.BeginTX()
+	if err != nil {
+		s.T().Fatalf("Failed to begin transaction: %v", err)
+	}
+
+	device, err := s.db.RegisterDevice(tx, &postgres.Device{
+		Token:       "abc123",
+		Longitude:   2.15,
+		Latitude:    41.39,
+		Disposition: "indoor",
+		Broker:      "tcp://broker:1883",
+		User:        &postgres.User{UID: "alice"},
+	})
+	if err != nil {
+		s.T().Fatalf("Failed to register device: %v", err)
+	}
+
+	for _, uid := range []string{"local-1", "local-2"} {
+		err = s.db.CreateStream(tx, device.ID, uid)
+		if err != nil {
+			s.T().Fatalf("Failed to create stream: %v", err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		s.T().Fatalf("Failed to commit transaction: %v", err)
+	}
+
+	s.streamLister.On("ListStreams", mock.Anything).Return([]string{"local-2", "remote-1"}, nil)
+}
+
+func (s *ReconcilerSuite) TearDownTest() {
+	err := s.db.(system.Stoppable).Stop()
+	if err != nil {
+		s.T().Fatalf("Failed to stop db: %v", err)
+	}
+
+	err = s.rawDb.Close()
+	if err != nil {
+		s.T().Fatalf("Failed to stop raw db: %v", err)
+	}
+}
+
+func (s *ReconcilerSuite) TestReconcile() {
+	r := reconciler.NewReconciler(&reconciler.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		StreamLister:  s.streamLister,
+	}, s.logger)
+
+	report, err := r.Reconcile(context.Background())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"local-1"}, report.MissingOnEncoder)
+	assert.Equal(s.T(), []string{"remote-1"}, report.OrphanedOnEncoder)
+	assert.Equal(s.T(), 0, report.Repaired)
+
+	// without repair we only report drift, so nothing is repaired even once
+	// confirmed
+	report, err = r.Reconcile(context.Background())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, report.Repaired)
+
+	s.encoderClient.AssertNotCalled(s.T(), "CreateStream", mock.Anything, mock.Anything)
+	s.encoderClient.AssertNotCalled(s.T(), "DeleteStream", mock.Anything, mock.Anything)
+}
+
+func (s *ReconcilerSuite) TestReconcileRepair() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.MatchedBy(func(req *encoder.CreateStreamRequest) bool {
+			return req.BrokerAddress == "tcp://broker:1883" && req.UserUid == "alice" && req.DevicePrivateKey != ""
+		}),
+	).Return(&encoder.CreateStreamResponse{StreamUid: "local-3"}, nil)
+
+	// a stream already deleted from the encoder counts as repaired
+	s.encoderClient.On(
+		"DeleteStream",
+		mock.Anything,
+		&encoder.DeleteStreamRequest{StreamUid: "remote-1"},
+	).Return(&encoder.DeleteStreamResponse{}, twirp.NotFoundError("stream not found"))
+
+	r := reconciler.NewReconciler(&reconciler.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		StreamLister:  s.streamLister,
+		Repair:        true,
+	}, s.logger)
+
+	// drift is only repaired once confirmed by a second reconciliation
+	report, err := r.Reconcile(context.Background())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, report.Repaired)
+	s.encoderClient.AssertNotCalled(s.T(), "CreateStream", mock.Anything, mock.Anything)
+
+	report, err = r.Reconcile(context.Background())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"local-1"}, report.MissingOnEncoder)
+	assert.Equal(s.T(), []string{"remote-1"}, report.OrphanedOnEncoder)
+	assert.Equal(s.T(), 2, report.Repaired)
+	assert.Equal(s.T(), 0, report.Failed)
+
+	uids, err := s.db.StreamUIDs()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"local-3", "local-2"}, uids)
+
+	s.encoderClient.AssertExpectations(s.T())
+}
+
+func (s *ReconcilerSuite) TestStartStop() {
+	r := reconciler.NewReconciler(&reconciler.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		StreamLister:  s.streamLister,
+	}, s.logger)
+
+	err := r.Start()
+	assert.Nil(s.T(), err)
+
+	err = r.Stop()
+	assert.Nil(s.T(), err)
+}
+
+func TestRunReconcilerSuite(t *testing.T) {
+	suite.Run(t, new(ReconcilerSuite))
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/auth"
+)
+
+// authorizeUser returns a permission denied error unless the principal
+// authenticated for the request may act for the user with the given uid. A
+// context without a principal is allowed, as requests only lack a principal
+// when the server runs without authentication, or when we are called directly
+// by trusted code such as the import command.
+func authorizeUser(ctx context.Context, userUID string) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	if !principal.CanActFor(userUID) {
+		return twirp.NewError(twirp.PermissionDenied, "not permitted to act for user_uid")
+	}
+
+	return nil
+}
+
+// authorizeAdmin returns a permission denied error unless the principal
+// authenticated for the request is a trusted caller acting for all users.
+// Unlike authorizeUser a context without a principal is denied, so that admin
+// methods are never available when the server runs without authentication.
+func authorizeAdmin(ctx context.Context) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok || !principal.AllUsers {
+		return twirp.NewError(twirp.PermissionDenied, "only permitted for callers acting for all users")
+	}
+
+	return nil
+}
+
+// authorizeOwner returns a permission denied error unless the principal
+// authenticated for the request may act for the user with the given public
+// key, for requests which identify the owner of a device by their public key
+// rather than their uid. As with authorizeUser a context without a principal
+// is allowed, in which case we do not look up the user. If no user has the
+// public key we return a not found error, just as the request itself would.
+func (d *deviceRegImpl) authorizeOwner(ctx context.Context, publicKey string) error {
+	_, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	userUID, err := d.db.UserUID(publicKey)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return twirp.NotFoundError("device not found")
+		}
+		return twirp.InternalErrorWith(err)
+	}
+
+	return authorizeUser(ctx, userUID)
+}
+
+// clientName returns the common name of the verified certificate of a client
+// connected using mutual TLS, for use in logs. It is empty for clients without
+// a certificate.
+func clientName(ctx context.Context) string {
+	cert, ok := auth.CertificateFromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return cert.Subject.CommonName
+}
@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+)
+
+const (
+	// defaultClaimConcurrency is the number of devices ClaimDevices claims
+	// concurrently if not configured.
+	defaultClaimConcurrency = 8
+
+	// maxClaimBatchSize is the maximum number of devices a client may claim in a
+	// single call to ClaimDevices.
+	maxClaimBatchSize = 500
+)
+
+// ClaimDevices is our implementation of the ClaimDevices method defined for
+// our Twirp service. Each device is claimed exactly as by ClaimDevice, each
+// within its own transaction, and a failure to claim one device is recorded in
+// its result rather than failing the whole call. The first claims are made one
+// at a time until one succeeds, so that the user is created by a single
+// transaction, and we then claim the remaining devices concurrently, with at
+// most the configured number of claims against the encoder in flight at once.
+func (d *deviceRegImpl) ClaimDevices(ctx context.Context, req *devicereg.ClaimDevicesRequest) (*devicereg.ClaimDevicesResponse, error) {
+	err := validateClaimDevicesRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizeUser(ctx, req.UserUid)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "ClaimDevices", "userUID", req.UserUid, "devices", len(req.Devices), "client", clientName(ctx))
+	}
+
+	resp := &devicereg.ClaimDevicesResponse{
+		Results: make([]*devicereg.ClaimDeviceResult, len(req.Devices)),
+	}
+
+	next := 0
+
+	for ; next < len(req.Devices) && resp.UserPublicKey == ""; next++ {
+		result, claimed := d.claimBatchDevice(ctx, req.UserUid, req.Devices[next])
+		if claimed != nil {
+			resp.UserPrivateKey = claimed.UserPrivateKey
+			resp.UserPublicKey = claimed.UserPublicKey
+		}
+
+		resp.Results[next] = result
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, d.claimConcurrency)
+
+	for i := next; i < len(req.Devices); i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			resp.Results[i], _ = d.claimBatchDevice(ctx, req.UserUid, req.Devices[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	return resp, nil
+}
+
+// claimBatchDevice claims a single device on behalf of the given user,
+// returning the outcome as a result, along with the response from ClaimDevice
+// if the claim succeeded.
+func (d *deviceRegImpl) claimBatchDevice(ctx context.Context, userUID string, device *devicereg.ClaimDeviceRequest) (*devicereg.ClaimDeviceResult, *devicereg.ClaimDeviceResponse) {
+	if device == nil {
+		return failedClaim("", twirp.InvalidArgumentError("devices", "must not contain empty devices")), nil
+	}
+
+	if device.UserUid != "" && device.UserUid != userUID {
+		return failedClaim(device.DeviceToken, twirp.InvalidArgumentError("user_uid", "must match the user_uid of the batch")), nil
+	}
+
+	claimed, err := d.ClaimDevice(ctx, &devicereg.ClaimDeviceRequest{
+		DeviceToken:       device.DeviceToken,
+		UserUid:           userUID,
+		Location:          device.Location,
+		Disposition:       device.Disposition,
+		Broker:            device.Broker,
+		Challenge:         device.Challenge,
+		ChallengeResponse: device.ChallengeResponse,
+	})
+	if err != nil {
+		return failedClaim(device.DeviceToken, err), nil
+	}
+
+	return &devicereg.ClaimDeviceResult{
+		DeviceToken:     device.DeviceToken,
+		DevicePublicKey: claimed.DevicePublicKey,
+	}, claimed
+}
+
+// failedClaim returns the result recording that the claim of the device with
+// the given token failed with the given error.
+func failedClaim(token string, err error) *devicereg.ClaimDeviceResult {
+	twerr, ok := err.(twirp.Error)
+	if !ok {
+		twerr = twirp.InternalErrorWith(err)
+	}
+
+	return &devicereg.ClaimDeviceResult{
+		DeviceToken:  token,
+		ErrorCode:    string(twerr.Code()),
+		ErrorMessage: twerr.Msg(),
+	}
+}
+
+// validateClaimDevicesRequest validates the incoming batch claim request. The
+// individual devices are validated as each is claimed, so that an invalid
+// device fails only its own claim.
+func validateClaimDevicesRequest(req *devicereg.ClaimDevicesRequest) error {
+	if req.UserUid == "" {
+		return twirp.RequiredArgumentError("user_uid")
+	}
+
+	if len(req.Devices) == 0 {
+		return twirp.RequiredArgumentError("devices")
+	}
+
+	if len(req.Devices) > maxClaimBatchSize {
+		return twirp.InvalidArgumentError("devices", fmt.Sprintf("must contain no more than %d devices", maxClaimBatchSize))
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package rpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+const (
+	// defaultClaimChallengeTTL is the default time for which a claim challenge
+	// is valid after being issued.
+	defaultClaimChallengeTTL = 5 * time.Minute
+
+	// challengeSize is the number of random bytes in a claim challenge.
+	challengeSize = 32
+
+	// maxClaimChallenges is the maximum number of unexpired challenges we hold
+	// for a device, so that callers cannot fill our database with challenges.
+	maxClaimChallenges = 5
+)
+
+// IssueClaimChallenge is our implementation of the method defined on the
+// DeviceRegistration service interface. We issue a random one-time challenge
+// for a device with a registered claim secret, which expires after our
+// configured TTL. As challenges are issued to unauthenticated callers, a device
+// may only have a few unexpired challenges at once.
+func (d *deviceRegImpl) IssueClaimChallenge(ctx context.Context, req *devicereg.IssueClaimChallengeRequest) (*devicereg.IssueClaimChallengeResponse, error) {
+	if req.DeviceToken == "" {
+		return nil, twirp.RequiredArgumentError("device_token")
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "IssueClaimChallenge", "deviceToken", req.DeviceToken, "client", clientName(ctx))
+	}
+
+	_, err := d.db.ClaimSecret(req.DeviceToken)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NewError(twirp.FailedPrecondition, "no claim secret registered for device")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	b := make([]byte, challengeSize)
+
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	challenge := hex.EncodeToString(b)
+	expiresAt := time.Now().Add(d.claimChallengeTTL)
+
+	err = d.db.CreateClaimChallenge(req.DeviceToken, challenge, expiresAt, maxClaimChallenges)
+	if err != nil {
+		switch errors.Cause(err) {
+		case postgres.ErrTooManyChallenges:
+			return nil, twirp.NewError(twirp.ResourceExhausted, "too many outstanding challenges for device")
+		case sql.ErrNoRows:
+			return nil, twirp.NewError(twirp.FailedPrecondition, "no claim secret registered for device")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &devicereg.IssueClaimChallengeResponse{
+		Challenge: challenge,
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// verifyClaimProof checks that a claim request for a device with a registered
+// claim secret carries an unexpired challenge issued for the device, and a
+// valid response to it. The challenge is consumed by the check whether or not
+// the response is valid, so each challenge allows a single attempt. Devices
+// without a claim secret may be claimed without proof unless we are
+// configured to require it.
+func (d *deviceRegImpl) verifyClaimProof(req *devicereg.ClaimDeviceRequest) error {
+	secret, err := d.db.ClaimSecret(req.DeviceToken)
+	if err != nil {
+		if errors.Cause(err) != sql.ErrNoRows {
+			return twirp.InternalErrorWith(err)
+		}
+
+		if d.requireClaimProof {
+			return twirp.NewError(twirp.FailedPrecondition, "no claim secret registered for device")
+		}
+
+		return nil
+	}
+
+	if req.Challenge == "" {
+		return twirp.RequiredArgumentError("challenge")
+	}
+
+	if req.ChallengeResponse == "" {
+		return twirp.RequiredArgumentError("challenge_response")
+	}
+
+	response, err := hex.DecodeString(req.ChallengeResponse)
+	if err != nil {
+		return twirp.InvalidArgumentError("challenge_response", "must be hex encoded")
+	}
+
+	err = d.db.ConsumeClaimChallenge(req.DeviceToken, req.Challenge)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return twirp.NewError(twirp.PermissionDenied, "invalid or expired challenge")
+		}
+		return twirp.InternalErrorWith(err)
+	}
+
+	if !hmac.Equal(response, ClaimChallengeResponse(secret, req.Challenge, req.UserUid)) {
+		return twirp.NewError(twirp.PermissionDenied, "invalid challenge response")
+	}
+
+	return nil
+}
+
+// ClaimChallengeResponse returns the response to the given challenge proving
+// possession of the given claim secret, for a claim by the user with the given
+// uid. This is the HMAC-SHA256, keyed with the secret, of the challenge
+// followed by a colon and the user uid. Including the user uid means a
+// response intercepted in transit cannot be used to claim the device for
+// anyone else.
+func ClaimChallengeResponse(secret, challenge, userUID string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(challenge + ":" + userUID))
+
+	return mac.Sum(nil)
+}
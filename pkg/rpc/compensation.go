@@ -0,0 +1,185 @@
+package rpc
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/encoderclient"
+)
+
+const (
+	// compensationAttempts is the number of times we attempt to delete an
+	// orphaned stream from the encoder before giving up and recording the failure.
+	compensationAttempts = 3
+
+	// compensationBackoff is the initial interval we wait between attempts to
+	// delete an orphaned stream. The interval doubles after each failed attempt.
+	compensationBackoff = 100 * time.Millisecond
+
+	// compensationTimeout is the maximum time we allow for all attempts to
+	// delete an orphaned stream.
+	compensationTimeout = 10 * time.Second
+)
+
+// finishTx is deferred by methods which write within the given transaction
+// after creating streams on the encoder, being passed pointers to the method's
+// error result and to the uids of the streams it created. If the method failed
+// we roll back, otherwise we commit, with any commit error becoming the
+// method's error. Either way on failure each created stream is compensated, as
+// it was not recorded locally.
+func (d *deviceRegImpl) finishTx(tx *sqlx.Tx, err *error, streamUIDs *[]string) {
+	if *err == nil {
+		cerr := tx.Commit()
+		if cerr == nil {
+			return
+		}
+
+		*err = twirp.InternalErrorWith(cerr)
+	} else {
+		tx.Rollback()
+	}
+
+	if streamUIDs == nil {
+		return
+	}
+
+	for _, streamUID := range *streamUIDs {
+		d.compensateCreateStream(streamUID)
+	}
+}
+
+// compensateCreateStream is called when a stream has been created on the
+// encoder, but the transaction that would have recorded it locally failed. We
+// attempt to delete the now orphaned stream from the encoder with retries, and
+// if this fails we record the stream in the DB so the deletion can be replayed
+// later. Note we deliberately do not use the context of the incoming request
+// here as it may already have been cancelled.
+func (d *deviceRegImpl) compensateCreateStream(streamUID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), compensationTimeout)
+	defer cancel()
+
+	err := retry(ctx, compensationAttempts, compensationBackoff, func() error {
+		return d.deleteOrphanedStream(ctx, streamUID)
+	})
+
+	if err == nil {
+		if d.verbose {
+			d.logger.Log("msg", "deleted orphaned stream", "streamUID", streamUID)
+		}
+		return
+	}
+
+	d.logger.Log("msg", "failed to delete orphaned stream", "streamUID", streamUID, "err", err)
+
+	rerr := d.db.RecordFailedCompensation(streamUID, err.Error())
+	if rerr != nil {
+		d.logger.Log("msg", "failed to record failed compensation", "streamUID", streamUID, "err", rerr)
+	}
+}
+
+// deleteOrphanedStream deletes a stream from the encoder, treating a not found
+// response as success as this means the stream is already gone.
+func (d *deviceRegImpl) deleteOrphanedStream(ctx context.Context, streamUID string) error {
+	err := d.deleteStream(ctx, streamUID)
+	if err != nil && !encoderclient.IsNotFound(err) {
+		return err
+	}
+
+	return nil
+}
+
+// ReplayFailedCompensations attempts to replay all compensations previously
+// recorded as failed, i.e. it attempts to delete from the encoder all orphaned
+// streams we were previously unable to delete. Each successfully replayed
+// compensation is removed from the DB. We return the number of compensations
+// that were replayed, and an error if any compensation could not be replayed.
+func ReplayFailedCompensations(ctx context.Context, config *Config, logger kitlog.Logger) (int, error) {
+	d := &deviceRegImpl{
+		db:            config.DB,
+		encoderClient: config.EncoderClient,
+		logger:        kitlog.With(logger, "module", "rpc"),
+		verbose:       config.Verbose,
+	}
+
+	compensations, err := d.db.FailedCompensations()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		replayed int
+		failed   int
+	)
+
+	for _, compensation := range compensations {
+		err = retry(ctx, compensationAttempts, compensationBackoff, func() error {
+			return d.deleteOrphanedStream(ctx, compensation.StreamUID)
+		})
+
+		if err != nil {
+			d.logger.Log("msg", "failed to replay compensation", "streamUID", compensation.StreamUID, "err", err)
+			failed++
+
+			rerr := d.db.RecordFailedCompensation(compensation.StreamUID, err.Error())
+			if rerr != nil {
+				return replayed, rerr
+			}
+
+			continue
+		}
+
+		err = d.db.DeleteFailedCompensation(compensation.StreamUID)
+		if err != nil {
+			return replayed, err
+		}
+
+		replayed++
+	}
+
+	if failed > 0 {
+		return replayed, errors.Errorf("failed to replay %d compensations", failed)
+	}
+
+	return replayed, nil
+}
+
+// retry calls the given function up to attempts times until it returns a nil
+// error. Between attempts we wait for an interval starting at backoff and
+// doubling after every failure. We return the last error returned by the
+// function if all attempts fail or the context is done.
+func retry(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	var err error
+
+	for i := 0; i < attempts; i++ {
+		err = fn()
+		if err == nil || i == attempts-1 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff = backoff * 2
+	}
+
+	return err
+}
+
+// encoderError returns the twirp error we return for the given error from a
+// call to the encoder. An unavailable encoder is reported as such so that
+// clients know to retry later, and any other error is an internal error.
+func encoderError(err error) twirp.Error {
+	if twerr, ok := err.(twirp.Error); ok && twerr.Code() == twirp.Unavailable {
+		return twerr
+	}
+
+	return twirp.InternalErrorWith(err)
+}
@@ -2,19 +2,40 @@ package rpc
 
 import (
 	"context"
+	"database/sql"
+	"encoding/base64"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
-	devicereg "github.com/thingful/twirp-devicereg-go"
 	encoder "github.com/thingful/twirp-encoder-go"
 	"github.com/twitchtv/twirp"
 
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
 	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/ratelimit"
 )
 
+const (
+	// defaultPageSize is the number of devices returned by ListDevices if the
+	// client does not specify a page size.
+	defaultPageSize = 20
+
+	// maxPageSize is the maximum number of devices a client may request in a
+	// single call to ListDevices.
+	maxPageSize = 100
+)
+
+// samplePayload is the payload encrypted by EncryptTestPayload if the client
+// does not supply one.
+var samplePayload = []byte(`{"temperature":21.5,"humidity":48}`)
+
 var (
 	// encoderWrites is a prometheus histogram recording writes and durations of
 	// calls to the encoder keyed by method and status.
@@ -38,17 +59,31 @@ func init() {
 
 // deviceRegImpl is our implementation of the device registration rpc server
 type deviceRegImpl struct {
-	logger        kitlog.Logger
-	db            postgres.DB
-	encoderClient encoder.Encoder
-	verbose       bool
+	logger            kitlog.Logger
+	db                postgres.DB
+	encoderClient     encoder.Encoder
+	claimConcurrency  int
+	claimChallengeTTL time.Duration
+	requireClaimProof bool
+	userRateLimiter   *ratelimit.Limiter
+	verbose           bool
 }
 
-// Config is a struct used to inject dependencies into our rpc service implementation
+// Config is a struct used to inject dependencies into our rpc service
+// implementation. ClaimConcurrency is the maximum number of devices claimed
+// concurrently by a single ClaimDevices call, defaulting to 8 if zero.
+// ClaimChallengeTTL is the time for which claim challenges are valid,
+// defaulting to 5 minutes if zero, and if RequireClaimProof is true devices
+// without a registered claim secret cannot be claimed. If UserRateLimiter is
+// not nil, claims and revocations are rate limited per user.
 type Config struct {
-	DB            postgres.DB
-	EncoderClient encoder.Encoder
-	Verbose       bool
+	DB                postgres.DB
+	EncoderClient     encoder.Encoder
+	ClaimConcurrency  int
+	ClaimChallengeTTL time.Duration
+	RequireClaimProof bool
+	UserRateLimiter   *ratelimit.Limiter
+	Verbose           bool
 }
 
 // NewDeviceReg constructs a new DeviceRegistration instance. We pass in the
@@ -57,11 +92,25 @@ func NewDeviceReg(config *Config, logger kitlog.Logger) devicereg.DeviceRegistra
 	logger = kitlog.With(logger, "module", "rpc")
 	logger.Log("msg", "creating devicereg")
 
+	claimConcurrency := config.ClaimConcurrency
+	if claimConcurrency == 0 {
+		claimConcurrency = defaultClaimConcurrency
+	}
+
+	claimChallengeTTL := config.ClaimChallengeTTL
+	if claimChallengeTTL == 0 {
+		claimChallengeTTL = defaultClaimChallengeTTL
+	}
+
 	return &deviceRegImpl{
-		db:            config.DB,
-		encoderClient: config.EncoderClient,
-		logger:        logger,
-		verbose:       config.Verbose,
+		db:                config.DB,
+		encoderClient:     config.EncoderClient,
+		claimConcurrency:  claimConcurrency,
+		claimChallengeTTL: claimChallengeTTL,
+		requireClaimProof: config.RequireClaimProof,
+		userRateLimiter:   config.UserRateLimiter,
+		logger:            logger,
+		verbose:           config.Verbose,
 	}
 }
 
@@ -81,15 +130,37 @@ func (d *deviceRegImpl) Stop() error {
 // Twirp service. As a result of this call the system as a whole should have
 // created some key pairs for the user and the device, and created a new
 // encrypted stream for the device on the encoder. THis service will maintain a
-// store of the generated keys.
+// store of the generated keys. Claiming a device already claimed by the same
+// user returns the existing keys, while claiming a device claimed by another
+// user returns an already exists error; ownership of a device can only be
+// changed via TransferDevice. If the request was authenticated, the caller
+// must be permitted to act for the given user uid, and if the device has a
+// registered claim secret the caller must prove they hold it. Claims are rate
+// limited by user uid if configured, with each device claimed via ClaimDevices
+// counted as a separate claim.
 func (d *deviceRegImpl) ClaimDevice(ctx context.Context, req *devicereg.ClaimDeviceRequest) (_ *devicereg.ClaimDeviceResponse, err error) {
 	device, err := createValidDevice(req)
 	if err != nil {
 		return nil, err
 	}
 
+	err = authorizeUser(ctx, req.UserUid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.allowUser(req.UserUid, "ClaimDevice")
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.verifyClaimProof(req)
+	if err != nil {
+		return nil, err
+	}
+
 	if d.verbose {
-		d.logger.Log("method", "ClaimDevice", "deviceToken", req.DeviceToken, "broker", req.Broker)
+		d.logger.Log("method", "ClaimDevice", "deviceToken", req.DeviceToken, "broker", req.Broker, "client", clientName(ctx))
 	}
 
 	tx, err := d.db.BeginTX()
@@ -97,50 +168,41 @@ func (d *deviceRegImpl) ClaimDevice(ctx context.Context, req *devicereg.ClaimDev
 		return nil, twirp.InternalErrorWith(err)
 	}
 
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-			return
-		}
-		if cerr := tx.Commit(); cerr != nil {
-			err = twirp.InternalErrorWith(cerr)
-		}
-	}()
+	// streamUIDs is set once the encoder has created a stream for us, so that if
+	// the local transaction subsequently fails we know which stream to clean up.
+	var streamUIDs []string
+
+	defer d.finishTx(tx, &err, &streamUIDs)
 
 	// insert the device in the context of the current transaction
 	device, err = d.db.RegisterDevice(tx, device)
 	if err != nil {
+		if errors.Cause(err) == postgres.ErrDeviceClaimed {
+			return nil, twirp.NewError(twirp.AlreadyExists, "device already claimed by another user")
+		}
 		return nil, twirp.InternalErrorWith(err)
 	}
 
-	start := time.Now()
+	// re-claiming a device by the same user is idempotent, we simply return the
+	// existing keys without creating another stream
+	if device.Existing {
+		return &devicereg.ClaimDeviceResponse{
+			UserPrivateKey:  device.User.PrivateKey,
+			UserPublicKey:   device.User.PublicKey,
+			DevicePublicKey: device.PublicKey,
+		}, nil
+	}
 
 	// attempt to create the default stream - if this fails we can roll back the
 	// transaction
-	resp, err := d.encoderClient.CreateStream(ctx, &encoder.CreateStreamRequest{
-		BrokerAddress:      req.Broker,
-		DeviceTopic:        fmt.Sprintf("device/sck/%s/readings", req.DeviceToken),
-		DevicePrivateKey:   device.PrivateKey,
-		RecipientPublicKey: device.User.PublicKey,
-		UserUid:            req.UserUid,
-		Location: &encoder.CreateStreamRequest_Location{
-			Longitude: req.Location.Longitude,
-			Latitude:  req.Location.Latitude,
-		},
-		Disposition: encoder.CreateStreamRequest_Disposition(encoder.CreateStreamRequest_Disposition_value[req.Disposition.String()]),
-	})
-
-	duration := time.Since(start)
-
+	streamUID, err := d.createStream(ctx, device, req.Broker, req.UserUid)
 	if err != nil {
-		encoderWrites.WithLabelValues("CreateStream", "error").Observe(duration.Seconds())
-
-		return nil, twirp.InternalErrorWith(err)
+		return nil, encoderError(err)
 	}
 
-	encoderWrites.WithLabelValues("CreateStream", "success").Observe(duration.Seconds())
+	streamUIDs = append(streamUIDs, streamUID)
 
-	err = d.db.CreateStream(tx, device.ID, resp.StreamUid)
+	err = d.db.CreateStream(tx, device.ID, streamUID)
 	if err != nil {
 		return nil, twirp.InternalErrorWith(err)
 	}
@@ -154,16 +216,30 @@ func (d *deviceRegImpl) ClaimDevice(ctx context.Context, req *devicereg.ClaimDev
 
 // RevokeDevice is our implementation of the method defined on the
 // DeviceRegistration service interface. Calling this causes the specified
-// device to be deleted, and the system will also call down to the encoder in
-// order to delete associated streams.
+// device to be deleted. Rather than calling the encoder directly, we write an
+// outbox message for each associated stream within the same transaction, and
+// the outbox dispatcher then delivers these deletions to the encoder. This
+// means the encoder is only ever asked to delete streams for devices whose
+// deletion was committed locally. Revocations are rate limited if configured,
+// sharing the limit of the user whose public key is given with their claims.
 func (d *deviceRegImpl) RevokeDevice(ctx context.Context, req *devicereg.RevokeDeviceRequest) (_ *devicereg.RevokeDeviceResponse, err error) {
 	err = validateRevokeRequest(req)
 	if err != nil {
 		return nil, err
 	}
 
+	err = d.authorizeOwner(ctx, req.UserPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.allowOwner(req.UserPublicKey, "RevokeDevice")
+	if err != nil {
+		return nil, err
+	}
+
 	if d.verbose {
-		d.logger.Log("method", "RevokeDevice", "deviceToken", req.DeviceToken)
+		d.logger.Log("method", "RevokeDevice", "deviceToken", req.DeviceToken, "client", clientName(ctx))
 	}
 
 	tx, err := d.db.BeginTX()
@@ -171,39 +247,541 @@ func (d *deviceRegImpl) RevokeDevice(ctx context.Context, req *devicereg.RevokeD
 		return nil, twirp.InternalErrorWith(err)
 	}
 
-	defer func() {
+	defer d.finishTx(tx, &err, nil)
+
+	streams, err := d.db.DeleteDevice(tx, req.DeviceToken, req.UserPublicKey)
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	for _, stream := range streams {
+		err = d.db.EnqueueOutboxMessage(tx, postgres.DeleteStreamOperation, stream.UID)
 		if err != nil {
-			tx.Rollback()
-			return
+			return nil, twirp.InternalErrorWith(err)
 		}
-		if cerr := tx.Commit(); cerr != nil {
-			err = twirp.InternalErrorWith(cerr)
+	}
+
+	return &devicereg.RevokeDeviceResponse{}, err
+}
+
+// ListDevices is our implementation of the method defined on the
+// DeviceRegistration service interface. It returns a page of the devices
+// registered by the user identified by the given public key, along with a
+// cursor that can be used to fetch the next page. No private key material is
+// returned.
+func (d *deviceRegImpl) ListDevices(ctx context.Context, req *devicereg.ListDevicesRequest) (*devicereg.ListDevicesResponse, error) {
+	pageSize, afterID, err := validateListRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.authorizeOwner(ctx, req.UserPublicKey)
+	if err != nil {
+		// an unknown public key is that of a user without devices, so has an
+		// empty page rather than an error just as without authentication
+		if twerr, ok := err.(twirp.Error); ok && twerr.Code() == twirp.NotFound {
+			return &devicereg.ListDevicesResponse{Devices: []*devicereg.Device{}}, nil
 		}
-	}()
+		return nil, err
+	}
 
-	streams, err := d.db.DeleteDevice(tx, req.DeviceToken, req.UserPublicKey)
+	if d.verbose {
+		d.logger.Log("method", "ListDevices", "pageSize", pageSize, "cursor", req.Cursor, "client", clientName(ctx))
+	}
+
+	// we fetch one more device than requested so we know whether there is a
+	// further page to return a cursor for
+	devices, err := d.db.ListDevices(req.UserPublicKey, afterID, pageSize+1)
 	if err != nil {
 		return nil, twirp.InternalErrorWith(err)
 	}
 
-	for _, stream := range streams {
-		start := time.Now()
+	resp := &devicereg.ListDevicesResponse{
+		Devices: []*devicereg.Device{},
+	}
+
+	if len(devices) > pageSize {
+		devices = devices[:pageSize]
+		resp.NextCursor = encodeCursor(devices[pageSize-1].ID)
+	}
+
+	for _, device := range devices {
+		resp.Devices = append(resp.Devices, newDevice(device))
+	}
+
+	return resp, nil
+}
+
+// GetDevice is our implementation of the method defined on the
+// DeviceRegistration service interface. It returns the device identified by
+// the given token as long as the supplied public key is that of the user who
+// claimed the device, otherwise a not found error is returned. We deliberately
+// do not distinguish between a device that doesn't exist and one owned by
+// another user.
+func (d *deviceRegImpl) GetDevice(ctx context.Context, req *devicereg.GetDeviceRequest) (*devicereg.GetDeviceResponse, error) {
+	err := validateGetRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.authorizeOwner(ctx, req.UserPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "GetDevice", "deviceToken", req.DeviceToken, "client", clientName(ctx))
+	}
+
+	device, err := d.db.GetDevice(req.DeviceToken, req.UserPublicKey)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NotFoundError("device not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &devicereg.GetDeviceResponse{
+		Device:        newDevice(device),
+		UserPublicKey: device.User.PublicKey,
+	}, nil
+}
+
+// UpdateDevice is our implementation of the method defined on the
+// DeviceRegistration service interface. We update the device's metadata, and
+// then replace its stream on the encoder with one configured with the new
+// metadata, keeping the device's existing key pair. As with ClaimDevice the new
+// stream is created before we commit, and is compensated if the transaction
+// fails; deletion of the old streams is written to the outbox so that it only
+// happens once the update is committed.
+func (d *deviceRegImpl) UpdateDevice(ctx context.Context, req *devicereg.UpdateDeviceRequest) (_ *devicereg.UpdateDeviceResponse, err error) {
+	device, err := createValidUpdate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.authorizeOwner(ctx, req.UserPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "UpdateDevice", "deviceToken", req.DeviceToken, "broker", req.Broker, "client", clientName(ctx))
+	}
+
+	tx, err := d.db.BeginTX()
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
 
-		_, err = d.encoderClient.DeleteStream(ctx, &encoder.DeleteStreamRequest{
-			StreamUid: stream.UID,
-		})
+	var streamUIDs []string
 
-		duration := time.Since(start)
+	defer d.finishTx(tx, &err, &streamUIDs)
 
+	device, err = d.db.UpdateDevice(tx, device, req.UserPublicKey)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NotFoundError("device not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	// devices claimed before we recorded brokers have none stored, so in that
+	// case the client must supply one
+	if device.Broker == "" {
+		return nil, twirp.RequiredArgumentError("broker")
+	}
+
+	streamUID, err := d.replaceStreams(ctx, tx, device)
+	if streamUID != "" {
+		streamUIDs = append(streamUIDs, streamUID)
+	}
+	if err != nil {
+		return nil, encoderError(err)
+	}
+
+	device.Streams = []*postgres.Stream{
+		{UID: streamUID, Device: device},
+	}
+
+	return &devicereg.UpdateDeviceResponse{
+		Device: newDevice(device),
+	}, err
+}
+
+// TransferDevice is our implementation of the method defined on the
+// DeviceRegistration service interface. We move the device to the new owner
+// with a new key pair, and then replace its stream on the encoder with one
+// encrypted for the new owner. As with UpdateDevice the new stream is
+// compensated if the transaction fails, and deletion of the old streams is
+// written to the outbox. The response goes to the previous owner so carries
+// only public keys; the new owner obtains their private key by claiming the
+// device themselves.
+func (d *deviceRegImpl) TransferDevice(ctx context.Context, req *devicereg.TransferDeviceRequest) (_ *devicereg.TransferDeviceResponse, err error) {
+	device, err := createValidTransfer(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.authorizeOwner(ctx, req.UserPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the caller must also be able to act for the new owner, so that devices
+	// cannot be pushed onto users who never asked for them
+	err = authorizeUser(ctx, req.NewUserUid)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "TransferDevice", "deviceToken", req.DeviceToken, "newUserUid", req.NewUserUid, "client", clientName(ctx))
+	}
+
+	tx, err := d.db.BeginTX()
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	var streamUIDs []string
+
+	defer d.finishTx(tx, &err, &streamUIDs)
+
+	device, err = d.db.TransferDevice(tx, device, req.UserPublicKey)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NotFoundError("device not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	if device.Broker == "" {
+		return nil, twirp.RequiredArgumentError("broker")
+	}
+
+	streamUID, err := d.replaceStreams(ctx, tx, device)
+	if streamUID != "" {
+		streamUIDs = append(streamUIDs, streamUID)
+	}
+	if err != nil {
+		return nil, encoderError(err)
+	}
+
+	return &devicereg.TransferDeviceResponse{
+		UserPublicKey:   device.User.PublicKey,
+		DevicePublicKey: device.PublicKey,
+	}, err
+}
+
+// RotateKeys is our implementation of the method defined on the
+// DeviceRegistration service interface. We give the device a new key pair, and
+// if requested also the user. Every stream encrypted with a rotated key is
+// then replaced: just the device's stream if only the device keys were
+// rotated, or the streams of all of the user's devices if the user's keys were
+// rotated. New streams are compensated if the transaction fails, and deletion
+// of the old streams is written to the outbox.
+func (d *deviceRegImpl) RotateKeys(ctx context.Context, req *devicereg.RotateKeysRequest) (_ *devicereg.RotateKeysResponse, err error) {
+	err = validateRotateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.authorizeOwner(ctx, req.UserPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "RotateKeys", "deviceToken", req.DeviceToken, "rotateUserKeys", req.RotateUserKeys, "client", clientName(ctx))
+	}
+
+	tx, err := d.db.BeginTX()
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	// streamUIDs collects every stream created on the encoder, so that all of
+	// them can be cleaned up if the transaction fails
+	var streamUIDs []string
+
+	defer d.finishTx(tx, &err, &streamUIDs)
+
+	resp := &devicereg.RotateKeysResponse{
+		UserPublicKey: req.UserPublicKey,
+	}
+
+	if req.RotateUserKeys {
+		user, err := d.db.RotateUserKeys(tx, req.UserPublicKey)
 		if err != nil {
-			encoderWrites.WithLabelValues("DeleteStream", "error").Observe(duration.Seconds())
+			if errors.Cause(err) == sql.ErrNoRows {
+				return nil, twirp.NotFoundError("device not found")
+			}
 			return nil, twirp.InternalErrorWith(err)
 		}
 
-		encoderWrites.WithLabelValues("DeleteStream", "success").Observe(duration.Seconds())
+		resp.UserPrivateKey = user.PrivateKey
+		resp.UserPublicKey = user.PublicKey
 	}
 
-	return &devicereg.RevokeDeviceResponse{}, err
+	device, err := d.db.RotateDeviceKeys(tx, req.DeviceToken, resp.UserPublicKey)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NotFoundError("device not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	resp.DevicePublicKey = device.PublicKey
+
+	devices := []*postgres.Device{device}
+
+	if req.RotateUserKeys {
+		// all of the user's devices, including the one whose keys we have just
+		// rotated
+		devices, err = d.db.UserDevices(tx, resp.UserPublicKey)
+		if err != nil {
+			return nil, twirp.InternalErrorWith(err)
+		}
+	}
+
+	for _, device := range devices {
+		if device.Broker == "" {
+			return nil, twirp.NewError(twirp.FailedPrecondition, fmt.Sprintf("no broker recorded for device %s", device.Token))
+		}
+
+		streamUID, err := d.replaceStreams(ctx, tx, device)
+		if streamUID != "" {
+			streamUIDs = append(streamUIDs, streamUID)
+		}
+		if err != nil {
+			return nil, encoderError(err)
+		}
+	}
+
+	return resp, err
+}
+
+// VerifyDeviceSignature is our implementation of the method defined on the
+// DeviceRegistration service interface. We read the signing key registered for
+// the identified device, whose private key is held only by the device, and
+// check the signature against it. An invalid signature is not an error, but is
+// reported via the response.
+func (d *deviceRegImpl) VerifyDeviceSignature(ctx context.Context, req *devicereg.VerifyDeviceSignatureRequest) (*devicereg.VerifyDeviceSignatureResponse, error) {
+	err := validateVerifyRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "VerifyDeviceSignature", "deviceToken", req.DeviceToken, "client", clientName(ctx))
+	}
+
+	device, err := d.db.DeviceKeys(req.DeviceToken)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NotFoundError("device not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	err = d.authorizeOwner(ctx, device.User.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := d.db.SigningKey(device.Token)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NewError(twirp.FailedPrecondition, "no signing key is registered for the device")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	valid, err := crypto.Verify(req.Payload, req.Signature, key.PublicKey, key.KeyOptions())
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &devicereg.VerifyDeviceSignatureResponse{
+		Valid: valid,
+	}, nil
+}
+
+// EncryptTestPayload is our implementation of the method defined on the
+// DeviceRegistration service interface. This is an admin method, only
+// available to callers acting for all users. We encrypt the payload with the
+// device's private key for the user who owns it, returning everything the
+// user requires to decrypt it. As with GetDevice we do not distinguish between
+// a device that doesn't exist and one owned by another user.
+func (d *deviceRegImpl) EncryptTestPayload(ctx context.Context, req *devicereg.EncryptTestPayloadRequest) (*devicereg.EncryptTestPayloadResponse, error) {
+	err := validateEncryptTestRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = authorizeAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.verbose {
+		d.logger.Log("method", "EncryptTestPayload", "deviceToken", req.DeviceToken, "client", clientName(ctx))
+	}
+
+	device, err := d.db.DeviceKeys(req.DeviceToken)
+	if err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			return nil, twirp.NotFoundError("device not found")
+		}
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	if device.User.PublicKey != req.UserPublicKey {
+		return nil, twirp.NotFoundError("device not found")
+	}
+
+	payload := req.Payload
+	if len(payload) == 0 {
+		payload = samplePayload
+	}
+
+	userPublicKey, err := compatiblePublicKey(device)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := crypto.Encrypt(payload, []byte(device.Token), device.PrivateKey, userPublicKey, device.KeyOptions())
+	if err != nil {
+		return nil, twirp.InternalErrorWith(err)
+	}
+
+	return &devicereg.EncryptTestPayloadResponse{
+		Payload:         payload,
+		Ciphertext:      encrypted.Ciphertext,
+		Iv:              encrypted.IV,
+		Header:          encrypted.Header,
+		DevicePublicKey: device.PublicKey,
+	}, nil
+}
+
+// compatiblePublicKey returns the public key of the device's owner encoded in
+// the same way as the device's keys, so that the keys may be used together. We
+// return an error if the keys are on different curves, which should only be
+// possible if the keys were modified outside of the registry.
+func compatiblePublicKey(device *postgres.Device) (string, error) {
+	deviceOptions := device.KeyOptions()
+	userOptions := device.User.KeyOptions()
+
+	if deviceOptions.Curve != userOptions.Curve {
+		return "", twirp.NewError(twirp.FailedPrecondition, "device and user keys are on different curves")
+	}
+
+	if deviceOptions.Encoding == userOptions.Encoding {
+		return device.User.PublicKey, nil
+	}
+
+	publicKey, err := crypto.ConvertKey(device.User.PublicKey, userOptions.Encoding, deviceOptions.Encoding)
+	if err != nil {
+		return "", twirp.InternalErrorWith(err)
+	}
+
+	return publicKey, nil
+}
+
+// replaceStreams replaces the streams of the given device with a single new
+// stream created using the device's current metadata and keys. Deletion of
+// the old streams from the encoder is written to the outbox, so only happens
+// if the given transaction commits. We return the uid of the new stream
+// whenever it was created on the encoder, even if we subsequently fail to
+// record it, so that the caller is able to compensate it.
+func (d *deviceRegImpl) replaceStreams(ctx context.Context, tx *sqlx.Tx, device *postgres.Device) (string, error) {
+	streams, err := d.db.DeleteStreams(tx, device.ID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, stream := range streams {
+		err = d.db.EnqueueOutboxMessage(tx, postgres.DeleteStreamOperation, stream.UID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	streamUID, err := d.createStream(ctx, device, device.Broker, device.User.UID)
+	if err != nil {
+		return "", err
+	}
+
+	return streamUID, d.db.CreateStream(tx, device.ID, streamUID)
+}
+
+// createStream calls the encoder to create a stream for the given device,
+// which must have its private key and owning user's public key populated. The
+// outcome is recorded in our encoder histogram, and we return the uid of the
+// created stream.
+func (d *deviceRegImpl) createStream(ctx context.Context, device *postgres.Device, broker, userUID string) (string, error) {
+	start := time.Now()
+
+	resp, err := d.encoderClient.CreateStream(ctx, NewCreateStreamRequest(device, broker, userUID))
+
+	duration := time.Since(start)
+
+	if err != nil {
+		encoderWrites.WithLabelValues("CreateStream", "error").Observe(duration.Seconds())
+		return "", err
+	}
+
+	encoderWrites.WithLabelValues("CreateStream", "success").Observe(duration.Seconds())
+
+	return resp.StreamUid, nil
+}
+
+// NewCreateStreamRequest returns the request sent to the encoder to create a
+// stream for the given device, which must have its private key and owning
+// user's public key populated.
+func NewCreateStreamRequest(device *postgres.Device, broker, userUID string) *encoder.CreateStreamRequest {
+	return &encoder.CreateStreamRequest{
+		BrokerAddress:      broker,
+		DeviceTopic:        deviceTopic(device.Token),
+		DevicePrivateKey:   device.PrivateKey,
+		RecipientPublicKey: device.User.PublicKey,
+		UserUid:            userUID,
+		Location: &encoder.CreateStreamRequest_Location{
+			Longitude: device.Longitude,
+			Latitude:  device.Latitude,
+		},
+		Disposition: encoder.CreateStreamRequest_Disposition(encoder.CreateStreamRequest_Disposition_value[strings.ToUpper(device.Disposition)]),
+	}
+}
+
+// deleteStream calls the encoder to delete the stream identified by the given
+// uid, recording the outcome in our encoder histogram.
+func (d *deviceRegImpl) deleteStream(ctx context.Context, streamUID string) error {
+	start := time.Now()
+
+	_, err := d.encoderClient.DeleteStream(ctx, &encoder.DeleteStreamRequest{
+		StreamUid: streamUID,
+	})
+
+	duration := time.Since(start)
+
+	if err != nil {
+		encoderWrites.WithLabelValues("DeleteStream", "error").Observe(duration.Seconds())
+		return err
+	}
+
+	encoderWrites.WithLabelValues("DeleteStream", "success").Observe(duration.Seconds())
+
+	return nil
+}
+
+// ValidateClaimDeviceRequest returns the error ClaimDevice would return for
+// an invalid request, without claiming the device. This allows claims to be
+// checked in advance, for example by a dry run of an import.
+func ValidateClaimDeviceRequest(req *devicereg.ClaimDeviceRequest) error {
+	_, err := createValidDevice(req)
+	return err
 }
 
 // createValidDevice both validates the incoming request, and returns an
@@ -221,24 +799,37 @@ func createValidDevice(req *devicereg.ClaimDeviceRequest) (*postgres.Device, err
 		return nil, twirp.RequiredArgumentError("user_uid")
 	}
 
-	if req.Location == nil {
-		return nil, twirp.RequiredArgumentError("location")
+	err := validateLocation(req.Location)
+	if err != nil {
+		return nil, err
 	}
 
-	if req.Location.Longitude == 0 {
-		return nil, twirp.RequiredArgumentError("longitude")
-	}
+	return &postgres.Device{
+		Token:       req.DeviceToken,
+		Longitude:   req.Location.Longitude,
+		Latitude:    req.Location.Latitude,
+		Disposition: strings.ToLower(req.Disposition.String()),
+		Broker:      req.Broker,
+		User: &postgres.User{
+			UID: req.UserUid,
+		},
+	}, nil
+}
 
-	if req.Location.Latitude == 0 {
-		return nil, twirp.RequiredArgumentError("latitude")
+// createValidUpdate both validates the incoming update request, and returns a
+// Device object populated with the new values ready for saving.
+func createValidUpdate(req *devicereg.UpdateDeviceRequest) (*postgres.Device, error) {
+	if req.DeviceToken == "" {
+		return nil, twirp.RequiredArgumentError("device_token")
 	}
 
-	if req.Location.Longitude < -180 || req.Location.Longitude > 180 {
-		return nil, twirp.InternalError("longitude must be between -180 and 180 degrees")
+	if req.UserPublicKey == "" {
+		return nil, twirp.RequiredArgumentError("user_public_key")
 	}
 
-	if req.Location.Latitude < -90 || req.Location.Latitude > 90 {
-		return nil, twirp.InternalError("latitude must be between -90 and 90 degrees")
+	err := validateLocation(req.Location)
+	if err != nil {
+		return nil, err
 	}
 
 	return &postgres.Device{
@@ -246,12 +837,80 @@ func createValidDevice(req *devicereg.ClaimDeviceRequest) (*postgres.Device, err
 		Longitude:   req.Location.Longitude,
 		Latitude:    req.Location.Latitude,
 		Disposition: strings.ToLower(req.Disposition.String()),
+		Broker:      req.Broker,
+	}, nil
+}
+
+// validateRotateRequest validates the incoming request, returning an error if
+// any required fields are missing.
+func validateRotateRequest(req *devicereg.RotateKeysRequest) error {
+	if req.DeviceToken == "" {
+		return twirp.RequiredArgumentError("device_token")
+	}
+
+	if req.UserPublicKey == "" {
+		return twirp.RequiredArgumentError("user_public_key")
+	}
+
+	return nil
+}
+
+// createValidTransfer both validates the incoming transfer request, and
+// returns a Device object populated with the new owner ready for saving.
+func createValidTransfer(req *devicereg.TransferDeviceRequest) (*postgres.Device, error) {
+	if req.DeviceToken == "" {
+		return nil, twirp.RequiredArgumentError("device_token")
+	}
+
+	if req.UserPublicKey == "" {
+		return nil, twirp.RequiredArgumentError("user_public_key")
+	}
+
+	if req.NewUserUid == "" {
+		return nil, twirp.RequiredArgumentError("new_user_uid")
+	}
+
+	return &postgres.Device{
+		Token:  req.DeviceToken,
+		Broker: req.Broker,
 		User: &postgres.User{
-			UID: req.UserUid,
+			UID: req.NewUserUid,
 		},
 	}, nil
 }
 
+// validateLocation validates a location supplied in an incoming request,
+// returning an error if it is missing or out of range.
+func validateLocation(location *devicereg.ClaimDeviceRequest_Location) error {
+	if location == nil {
+		return twirp.RequiredArgumentError("location")
+	}
+
+	if location.Longitude == 0 {
+		return twirp.RequiredArgumentError("longitude")
+	}
+
+	if location.Latitude == 0 {
+		return twirp.RequiredArgumentError("latitude")
+	}
+
+	if location.Longitude < -180 || location.Longitude > 180 {
+		return twirp.InvalidArgumentError("location", "must have a longitude between -180 and 180 degrees")
+	}
+
+	if location.Latitude < -90 || location.Latitude > 90 {
+		return twirp.InvalidArgumentError("location", "must have a latitude between -90 and 90 degrees")
+	}
+
+	return nil
+}
+
+// deviceTopic returns the MQTT topic on which the device with the given token
+// publishes its readings.
+func deviceTopic(token string) string {
+	return fmt.Sprintf("device/sck/%s/readings", token)
+}
+
 // validateRevokeRequest validates the incoming request, returning an error if
 // any required fields are missing.
 func validateRevokeRequest(req *devicereg.RevokeDeviceRequest) error {
@@ -265,3 +924,126 @@ func validateRevokeRequest(req *devicereg.RevokeDeviceRequest) error {
 
 	return nil
 }
+
+// validateGetRequest validates the incoming request, returning an error if any
+// required fields are missing.
+func validateGetRequest(req *devicereg.GetDeviceRequest) error {
+	if req.DeviceToken == "" {
+		return twirp.RequiredArgumentError("device_token")
+	}
+
+	if req.UserPublicKey == "" {
+		return twirp.RequiredArgumentError("user_public_key")
+	}
+
+	return nil
+}
+
+// validateVerifyRequest validates the incoming request, returning an error if
+// any required field is missing.
+func validateVerifyRequest(req *devicereg.VerifyDeviceSignatureRequest) error {
+	if req.DeviceToken == "" {
+		return twirp.RequiredArgumentError("device_token")
+	}
+
+	if len(req.Payload) == 0 {
+		return twirp.RequiredArgumentError("payload")
+	}
+
+	if req.Signature == "" {
+		return twirp.RequiredArgumentError("signature")
+	}
+
+	return nil
+}
+
+// validateEncryptTestRequest validates the incoming request, returning an
+// error if any required field is missing.
+func validateEncryptTestRequest(req *devicereg.EncryptTestPayloadRequest) error {
+	if req.DeviceToken == "" {
+		return twirp.RequiredArgumentError("device_token")
+	}
+
+	if req.UserPublicKey == "" {
+		return twirp.RequiredArgumentError("user_public_key")
+	}
+
+	return nil
+}
+
+// validateListRequest validates the incoming request, returning the page size
+// to use and the id of the device after which the page starts, or an error if
+// the request is invalid.
+func validateListRequest(req *devicereg.ListDevicesRequest) (int, int, error) {
+	if req.UserPublicKey == "" {
+		return 0, 0, twirp.RequiredArgumentError("user_public_key")
+	}
+
+	pageSize := int(req.PageSize)
+
+	if pageSize < 0 || pageSize > maxPageSize {
+		return 0, 0, twirp.InvalidArgumentError("page_size", fmt.Sprintf("must be between 0 and %d", maxPageSize))
+	}
+
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	afterID, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return 0, 0, twirp.InvalidArgumentError("cursor", "is not a valid cursor")
+	}
+
+	return pageSize, afterID, nil
+}
+
+// encodeCursor returns an opaque cursor string for the given device id.
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+// decodeCursor returns the device id encoded within the given cursor. An empty
+// cursor decodes to 0, i.e. the start of the list.
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, err
+	}
+
+	if id < 0 {
+		return 0, fmt.Errorf("invalid cursor id: %d", id)
+	}
+
+	return id, nil
+}
+
+// newDevice converts a device read from the DB into the device type returned
+// to clients.
+func newDevice(device *postgres.Device) *devicereg.Device {
+	streamUIDs := []string{}
+	for _, stream := range device.Streams {
+		streamUIDs = append(streamUIDs, stream.UID)
+	}
+
+	return &devicereg.Device{
+		DeviceToken: device.Token,
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: device.Longitude,
+			Latitude:  device.Latitude,
+		},
+		Disposition:     devicereg.ClaimDeviceRequest_Disposition(devicereg.ClaimDeviceRequest_Disposition_value[strings.ToUpper(device.Disposition)]),
+		DevicePublicKey: device.PublicKey,
+		CreatedAt:       device.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       device.UpdatedAt.Format(time.RFC3339),
+		StreamUids:      streamUIDs,
+	}
+}
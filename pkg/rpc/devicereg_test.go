@@ -2,20 +2,27 @@ package rpc_test
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"os"
 	"testing"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/jmoiron/sqlx"
-	devicereg "github.com/thingful/twirp-devicereg-go"
 	encoder "github.com/thingful/twirp-encoder-go"
+	"github.com/twitchtv/twirp"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 
+	"github.com/thingful/iotdevicereg/pkg/auth"
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
 	"github.com/thingful/iotdevicereg/pkg/mocks"
 	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/ratelimit"
 	"github.com/thingful/iotdevicereg/pkg/rpc"
 	"github.com/thingful/iotdevicereg/pkg/system"
 )
@@ -90,14 +97,1820 @@ func (s *DeviceRegistrationSuite) TestFullLifecycle() {
 		nil,
 	)
 
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	err := dr.(system.Startable).Start()
+	assert.Nil(s.T(), err, "starting devicereg")
+	defer func() {
+		err := dr.(system.Stoppable).Stop()
+		assert.Nil(s.T(), err, "stopping devicereg")
+	}()
+
+	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+
+	assert.Nil(s.T(), err, "claiming device")
+	assert.NotEqual(s.T(), "", claimResp.DevicePublicKey)
+	assert.NotEqual(s.T(), "", claimResp.UserPrivateKey)
+	assert.NotEqual(s.T(), "", claimResp.UserPublicKey)
+
+	// verify that user, and device have been created in the DB
+	var count int
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM users`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM devices`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	// verify that the stream id saved is the mocked value above
+	var streamUid string
+	err = s.rawDb.Get(&streamUid, `SELECT uid FROM streams WHERE uid = $1`, "foobar")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "foobar", streamUid)
+
+	_, err = dr.RevokeDevice(context.Background(), &devicereg.RevokeDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	assert.Nil(s.T(), err)
+
+	// verify that deletion of the stream has been written to the outbox rather
+	// than sent directly to the encoder
+	err = s.rawDb.Get(&streamUid, `SELECT stream_uid FROM outbox WHERE operation = $1`, postgres.DeleteStreamOperation)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "foobar", streamUid)
+
+	// verify that we called encoder client with expected params
+	s.encoderClient.AssertExpectations(s.T())
+	s.encoderClient.AssertNotCalled(s.T(), "DeleteStream", mock.Anything, mock.Anything)
+}
+
+func (s *DeviceRegistrationSuite) TestClaimDeviceAuthorization() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "bob", UserUID: "bob"})
+
+	req := &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	}
+
+	// a caller may not claim devices for another user
+	_, err := dr.ClaimDevice(ctx, req)
+	assert.NotNil(s.T(), err)
+
+	twerr, ok := err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.PermissionDenied, twerr.Code())
+
+	_, err = dr.ClaimDevices(ctx, &devicereg.ClaimDevicesRequest{
+		UserUid: "alice",
+		Devices: []*devicereg.ClaimDeviceRequest{req},
+	})
+	assert.NotNil(s.T(), err)
+
+	twerr, ok = err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.PermissionDenied, twerr.Code())
+
+	s.encoderClient.AssertNotCalled(s.T(), "CreateStream", mock.Anything, mock.Anything)
+
+	// but may claim devices for their own user
+	ctx = auth.NewContext(context.Background(), &auth.Principal{Name: "alice", UserUID: "alice"})
+
+	_, err = dr.ClaimDevice(ctx, req)
+	assert.Nil(s.T(), err)
+}
+
+// claimForAuthorization claims the device abc123 for alice without a
+// principal, returning the claim response, for the authorization tests of the
+// methods acting on claimed devices.
+func (s *DeviceRegistrationSuite) claimForAuthorization(dr devicereg.DeviceRegistration) *devicereg.ClaimDeviceResponse {
+	for _, uid := range []string{"stream1", "stream2"} {
+		s.encoderClient.On(
+			"CreateStream",
+			mock.Anything,
+			mock.Anything,
+		).Return(
+			&encoder.CreateStreamResponse{StreamUid: uid},
+			nil,
+		).Once()
+	}
+
+	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+	assert.Nil(s.T(), err)
+
+	return claimResp
+}
+
+// assertErrorCode asserts that the given error is a twirp error with the given
+// code.
+func (s *DeviceRegistrationSuite) assertErrorCode(code twirp.ErrorCode, err error) {
+	assert.NotNil(s.T(), err)
+
+	twerr, ok := err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), code, twerr.Code())
+}
+
+func (s *DeviceRegistrationSuite) TestUpdateDeviceAuthorization() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	claimResp := s.claimForAuthorization(dr)
+
+	req := &devicereg.UpdateDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 14.5,
+			Latitude:  34.5,
+		},
+	}
+
+	// a caller may not update another user's devices
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "bob", UserUID: "bob"})
+
+	_, err := dr.UpdateDevice(ctx, req)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	s.encoderClient.AssertNumberOfCalls(s.T(), "CreateStream", 1)
+
+	// nor learn anything from a public key no user has
+	_, err = dr.UpdateDevice(ctx, &devicereg.UpdateDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: "foobar",
+		Location:      req.Location,
+	})
+	s.assertErrorCode(twirp.NotFound, err)
+
+	// but may update their own
+	ctx = auth.NewContext(context.Background(), &auth.Principal{Name: "alice", UserUID: "alice"})
+
+	_, err = dr.UpdateDevice(ctx, req)
+	assert.Nil(s.T(), err)
+}
+
+func (s *DeviceRegistrationSuite) TestTransferDeviceAuthorization() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	claimResp := s.claimForAuthorization(dr)
+
+	req := &devicereg.TransferDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+		NewUserUid:    "bob",
+	}
+
+	// a caller may not take another user's devices
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "bob", UserUID: "bob"})
+
+	_, err := dr.TransferDevice(ctx, req)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	// nor give their own devices to a user they do not act for
+	ctx = auth.NewContext(context.Background(), &auth.Principal{Name: "alice", UserUID: "alice"})
+
+	_, err = dr.TransferDevice(ctx, req)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	s.encoderClient.AssertNumberOfCalls(s.T(), "CreateStream", 1)
+
+	// but a trusted caller acting for both users may transfer it
+	ctx = auth.NewContext(context.Background(), &auth.Principal{Name: "importer", AllUsers: true})
+
+	_, err = dr.TransferDevice(ctx, req)
+	assert.Nil(s.T(), err)
+}
+
+func (s *DeviceRegistrationSuite) TestRotateKeysAuthorization() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	claimResp := s.claimForAuthorization(dr)
+
+	req := &devicereg.RotateKeysRequest{
+		DeviceToken:    "abc123",
+		UserPublicKey:  claimResp.UserPublicKey,
+		RotateUserKeys: true,
+	}
+
+	// a caller may not rotate another user's keys
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "bob", UserUID: "bob"})
+
+	_, err := dr.RotateKeys(ctx, req)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	s.encoderClient.AssertNumberOfCalls(s.T(), "CreateStream", 1)
+
+	// but may rotate their own
+	ctx = auth.NewContext(context.Background(), &auth.Principal{Name: "alice", UserUID: "alice"})
+
+	rotateResp, err := dr.RotateKeys(ctx, req)
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), claimResp.UserPublicKey, rotateResp.UserPublicKey)
+}
+
+func (s *DeviceRegistrationSuite) TestRevokeDeviceAuthorization() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	claimResp := s.claimForAuthorization(dr)
+
+	req := &devicereg.RevokeDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	}
+
+	// a caller may not revoke another user's devices
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "bob", UserUID: "bob"})
+
+	_, err := dr.RevokeDevice(ctx, req)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	_, err = dr.GetDevice(context.Background(), &devicereg.GetDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	assert.Nil(s.T(), err)
+
+	// but may revoke their own
+	ctx = auth.NewContext(context.Background(), &auth.Principal{Name: "alice", UserUID: "alice"})
+
+	_, err = dr.RevokeDevice(ctx, req)
+	assert.Nil(s.T(), err)
+
+	_, err = dr.GetDevice(context.Background(), &devicereg.GetDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	s.assertErrorCode(twirp.NotFound, err)
+}
+
+func (s *DeviceRegistrationSuite) TestReadAuthorization() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	claimResp := s.claimForAuthorization(dr)
+
+	payload := []byte(`{"temperature": 21.5}`)
+
+	signer, err := crypto.NewKeyPair(nil)
+	assert.Nil(s.T(), err)
+
+	err = s.db.RegisterSigningKey(&postgres.SigningKey{
+		DeviceToken: "abc123",
+		PublicKey:   signer.PublicKey,
+		KeyCurve:    crypto.Ec25519Curve,
+		KeyEncoding: crypto.Base64Encoding,
+	})
+	assert.Nil(s.T(), err)
+
+	signature, err := crypto.Sign(payload, signer.PrivateKey, nil)
+	assert.Nil(s.T(), err)
+
+	listReq := &devicereg.ListDevicesRequest{UserPublicKey: claimResp.UserPublicKey}
+	getReq := &devicereg.GetDeviceRequest{DeviceToken: "abc123", UserPublicKey: claimResp.UserPublicKey}
+	verifyReq := &devicereg.VerifyDeviceSignatureRequest{DeviceToken: "abc123", Payload: payload, Signature: signature}
+
+	// a caller may not read another user's devices
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "bob", UserUID: "bob"})
+
+	_, err = dr.ListDevices(ctx, listReq)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	_, err = dr.GetDevice(ctx, getReq)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	_, err = dr.VerifyDeviceSignature(ctx, verifyReq)
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	// a public key no user has has no devices to list
+	listResp, err := dr.ListDevices(ctx, &devicereg.ListDevicesRequest{UserPublicKey: "foobar"})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), listResp.Devices, 0)
+
+	// but may read their own
+	ctx = auth.NewContext(context.Background(), &auth.Principal{Name: "alice", UserUID: "alice"})
+
+	listResp, err = dr.ListDevices(ctx, listReq)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), listResp.Devices, 1)
+
+	_, err = dr.GetDevice(ctx, getReq)
+	assert.Nil(s.T(), err)
+
+	verifyResp, err := dr.VerifyDeviceSignature(ctx, verifyReq)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), verifyResp.Valid)
+}
+
+func (s *DeviceRegistrationSuite) TestClaimDeviceChallenge() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	ctx := context.Background()
+
+	// no challenge can be issued for a device without a claim secret
+	_, err := dr.IssueClaimChallenge(ctx, &devicereg.IssueClaimChallengeRequest{DeviceToken: "abc123"})
+	assert.NotNil(s.T(), err)
+
+	twerr, ok := err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.FailedPrecondition, twerr.Code())
+
+	secret := "device side secret"
+
+	err = s.db.RegisterClaimSecret("abc123", secret)
+	assert.Nil(s.T(), err)
+
+	req := &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	}
+
+	// a device with a claim secret cannot be claimed without a challenge
+	_, err = dr.ClaimDevice(ctx, req)
+	assert.NotNil(s.T(), err)
+
+	twerr, ok = err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.InvalidArgument, twerr.Code())
+
+	resp, err := dr.IssueClaimChallenge(ctx, &devicereg.IssueClaimChallengeRequest{DeviceToken: "abc123"})
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), "", resp.Challenge)
+	assert.NotEqual(s.T(), "", resp.ExpiresAt)
+
+	// a response computed with the wrong secret is rejected, and uses up the
+	// challenge
+	req.Challenge = resp.Challenge
+	req.ChallengeResponse = hex.EncodeToString(rpc.ClaimChallengeResponse("wrong secret", resp.Challenge, "alice"))
+
+	_, err = dr.ClaimDevice(ctx, req)
+	assert.NotNil(s.T(), err)
+
+	twerr, ok = err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.PermissionDenied, twerr.Code())
+
+	req.ChallengeResponse = hex.EncodeToString(rpc.ClaimChallengeResponse(secret, resp.Challenge, "alice"))
+
+	_, err = dr.ClaimDevice(ctx, req)
+	assert.NotNil(s.T(), err)
+
+	twerr, ok = err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.PermissionDenied, twerr.Code())
+
+	resp, err = dr.IssueClaimChallenge(ctx, &devicereg.IssueClaimChallengeRequest{DeviceToken: "abc123"})
+	assert.Nil(s.T(), err)
+
+	req.Challenge = resp.Challenge
+	req.ChallengeResponse = hex.EncodeToString(rpc.ClaimChallengeResponse(secret, resp.Challenge, "alice"))
+
+	_, err = dr.ClaimDevice(ctx, req)
+	assert.Nil(s.T(), err)
+
+	s.encoderClient.AssertNumberOfCalls(s.T(), "CreateStream", 1)
+
+	// when proof is required a device without a claim secret cannot be claimed
+	dr = rpc.NewDeviceReg(&rpc.Config{
+		DB:                s.db,
+		EncoderClient:     s.encoderClient,
+		RequireClaimProof: true,
+	}, s.logger)
+
+	req.DeviceToken = "def456"
+
+	_, err = dr.ClaimDevice(ctx, req)
+	assert.NotNil(s.T(), err)
+
+	twerr, ok = err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.FailedPrecondition, twerr.Code())
+}
+
+func (s *DeviceRegistrationSuite) TestIssueClaimChallengeLimit() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	err := s.db.RegisterClaimSecret("abc123", "device side secret")
+	assert.Nil(s.T(), err)
+
+	req := &devicereg.IssueClaimChallengeRequest{DeviceToken: "abc123"}
+
+	for i := 0; i < 5; i++ {
+		_, err = dr.IssueClaimChallenge(context.Background(), req)
+		assert.Nil(s.T(), err)
+	}
+
+	// only a few challenges may be outstanding for a device at once
+	_, err = dr.IssueClaimChallenge(context.Background(), req)
+	s.assertErrorCode(twirp.ResourceExhausted, err)
+}
+
+func (s *DeviceRegistrationSuite) TestClaimDeviceRateLimit() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		UserRateLimiter: ratelimit.NewLimiter(&ratelimit.Config{
+			Name:  "user",
+			Rate:  0.001,
+			Burst: 1,
+		}),
+	}, s.logger)
+
+	newRequest := func(token, userUID string) *devicereg.ClaimDeviceRequest {
+		return &devicereg.ClaimDeviceRequest{
+			Broker:      "tcp://mqtt.local:1883",
+			DeviceToken: token,
+			UserUid:     userUID,
+			Location: &devicereg.ClaimDeviceRequest_Location{
+				Longitude: 12.2,
+				Latitude:  32.1,
+			},
+			Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+		}
+	}
+
+	_, err := dr.ClaimDevice(context.Background(), newRequest("abc123", "alice"))
+	assert.Nil(s.T(), err)
+
+	_, err = dr.ClaimDevice(context.Background(), newRequest("def456", "alice"))
+	assert.NotNil(s.T(), err)
+
+	twerr, ok := err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.ResourceExhausted, twerr.Code())
+
+	// other users are limited separately
+	claimResp, err := dr.ClaimDevice(context.Background(), newRequest("def456", "bob"))
+	assert.Nil(s.T(), err)
+
+	s.encoderClient.AssertNumberOfCalls(s.T(), "CreateStream", 2)
+
+	// revocations identify the user by public key, but share their limit
+	_, err = dr.RevokeDevice(context.Background(), &devicereg.RevokeDeviceRequest{
+		DeviceToken:   "def456",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	s.assertErrorCode(twirp.ResourceExhausted, err)
+}
+
+func (s *DeviceRegistrationSuite) TestClaimDeviceEncoderUnavailable() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{},
+		twirp.NewError(twirp.Unavailable, "encoder is unavailable"),
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	_, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+	assert.NotNil(s.T(), err)
+
+	// an unavailable encoder is reported as such rather than as an internal error
+	twerr, ok := err.(twirp.Error)
+	assert.True(s.T(), ok)
+	assert.Equal(s.T(), twirp.Unavailable, twerr.Code())
+}
+
+func (s *DeviceRegistrationSuite) TestClaimDeviceCompensation() {
+	// the encoder returns a stream uid that is already recorded locally, so
+	// inserting the stream fails after the encoder has created it
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	s.encoderClient.On(
+		"DeleteStream",
+		mock.Anything,
+		&encoder.DeleteStreamRequest{
+			StreamUid: "foobar",
+		},
+	).Return(
+		&encoder.DeleteStreamResponse{},
+		errors.New("encoder unavailable"),
+	).Times(3)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	_, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+	assert.Nil(s.T(), err)
+
+	_, err = dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "hij567",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+	assert.NotNil(s.T(), err)
+
+	// the second device should have been rolled back
+	var count int
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM devices`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	// and as we failed to delete the orphaned stream it should be recorded
+	compensations, err := s.db.FailedCompensations()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), compensations, 1)
+	assert.Equal(s.T(), "foobar", compensations[0].StreamUID)
+	assert.Equal(s.T(), "encoder unavailable", compensations[0].Error)
+
+	// now the encoder recovers so replaying should succeed
+	s.encoderClient.On(
+		"DeleteStream",
+		mock.Anything,
+		&encoder.DeleteStreamRequest{
+			StreamUid: "foobar",
+		},
+	).Return(
+		&encoder.DeleteStreamResponse{},
+		nil,
+	).Once()
+
+	replayed, err := rpc.ReplayFailedCompensations(context.Background(), &rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, replayed)
+
+	compensations, err = s.db.FailedCompensations()
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), compensations, 0)
+
+	s.encoderClient.AssertExpectations(s.T())
+}
+
+func (s *DeviceRegistrationSuite) TestReclaimDevice() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	).Once()
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	req := &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	}
+
+	claimResp, err := dr.ClaimDevice(context.Background(), req)
+	assert.Nil(s.T(), err)
+
+	// claiming again as the same user returns the same keys
+	reclaimResp, err := dr.ClaimDevice(context.Background(), req)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), claimResp, reclaimResp)
+
+	// claiming as a different user is rejected
+	req.UserUid = "bob"
+
+	_, err = dr.ClaimDevice(context.Background(), req)
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "twirp error already_exists: device already claimed by another user", err.Error())
+
+	var count int
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM users`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM streams`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	// only the first claim created a stream on the encoder
+	s.encoderClient.AssertNumberOfCalls(s.T(), "CreateStream", 1)
+}
+
+func (s *DeviceRegistrationSuite) TestClaimDevices() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.MatchedBy(func(req *encoder.CreateStreamRequest) bool {
+			return req.DeviceTopic == "device/sck/broken/readings"
+		}),
+	).Return(
+		&encoder.CreateStreamResponse{},
+		errors.New("encoder unavailable"),
+	)
+
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:               s.db,
+		EncoderClient:    s.encoderClient,
+		ClaimConcurrency: 2,
+		Verbose:          true,
+	}, s.logger)
+
+	location := &devicereg.ClaimDeviceRequest_Location{
+		Longitude: 12.2,
+		Latitude:  32.1,
+	}
+
+	// a device already claimed by another user
+	_, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "taken",
+		UserUid:     "bob",
+		Location:    location,
+	})
+	assert.Nil(s.T(), err)
+
+	devices := []*devicereg.ClaimDeviceRequest{
+		{DeviceToken: "taken", Broker: "tcp://mqtt.local:1883", Location: location},
+		{DeviceToken: "abc123", Broker: "tcp://mqtt.local:1883", Location: location},
+		{DeviceToken: "nobroker", Location: location},
+		{DeviceToken: "broken", Broker: "tcp://mqtt.local:1883", Location: location},
+		{DeviceToken: "def456", Broker: "tcp://mqtt.local:1883", Location: location, UserUid: "alice"},
+		{DeviceToken: "hij789", Broker: "tcp://mqtt.local:1883", Location: location, UserUid: "bob"},
+		{DeviceToken: "klm012", Broker: "tcp://mqtt.local:1883", Location: location},
+	}
+
+	resp, err := dr.ClaimDevices(context.Background(), &devicereg.ClaimDevicesRequest{
+		UserUid: "alice",
+		Devices: devices,
+	})
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), "", resp.UserPrivateKey)
+	assert.NotEqual(s.T(), "", resp.UserPublicKey)
+	assert.Len(s.T(), resp.Results, len(devices))
+
+	expected := []struct {
+		token     string
+		errorCode string
+	}{
+		{"taken", "already_exists"},
+		{"abc123", ""},
+		{"nobroker", "invalid_argument"},
+		{"broken", "internal"},
+		{"def456", ""},
+		{"hij789", "invalid_argument"},
+		{"klm012", ""},
+	}
+
+	for i, e := range expected {
+		result := resp.Results[i]
+		assert.Equal(s.T(), e.token, result.DeviceToken)
+		assert.Equal(s.T(), e.errorCode, result.ErrorCode)
+
+		if e.errorCode == "" {
+			assert.NotEqual(s.T(), "", result.DevicePublicKey)
+			assert.Equal(s.T(), "", result.ErrorMessage)
+		} else {
+			assert.Equal(s.T(), "", result.DevicePublicKey)
+			assert.NotEqual(s.T(), "", result.ErrorMessage)
+		}
+	}
+
+	var count int
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM devices d JOIN users u ON u.id = d.user_id WHERE u.uid = 'alice'`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM users`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, count)
+
+	// the batch user's keys are those of an ordinary claim
+	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location:    location,
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), resp.UserPublicKey, claimResp.UserPublicKey)
+	assert.Equal(s.T(), resp.Results[1].DevicePublicKey, claimResp.DevicePublicKey)
+}
+
+func (s *DeviceRegistrationSuite) TestClaimDevicesChallenge() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	ctx := context.Background()
+	secret := "device side secret"
+
+	for _, token := range []string{"abc123", "def456"} {
+		err := s.db.RegisterClaimSecret(token, secret)
+		assert.Nil(s.T(), err)
+	}
+
+	location := &devicereg.ClaimDeviceRequest_Location{
+		Longitude: 12.2,
+		Latitude:  32.1,
+	}
+
+	challenge, err := dr.IssueClaimChallenge(ctx, &devicereg.IssueClaimChallengeRequest{DeviceToken: "abc123"})
+	assert.Nil(s.T(), err)
+
+	// the challenge and response of each device are checked as for ClaimDevice
+	resp, err := dr.ClaimDevices(ctx, &devicereg.ClaimDevicesRequest{
+		UserUid: "alice",
+		Devices: []*devicereg.ClaimDeviceRequest{
+			{
+				DeviceToken:       "abc123",
+				Broker:            "tcp://mqtt.local:1883",
+				Location:          location,
+				Challenge:         challenge.Challenge,
+				ChallengeResponse: hex.EncodeToString(rpc.ClaimChallengeResponse(secret, challenge.Challenge, "alice")),
+			},
+			{DeviceToken: "def456", Broker: "tcp://mqtt.local:1883", Location: location},
+		},
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), resp.Results, 2)
+
+	assert.Equal(s.T(), "abc123", resp.Results[0].DeviceToken)
+	assert.Equal(s.T(), "", resp.Results[0].ErrorCode)
+	assert.NotEqual(s.T(), "", resp.Results[0].DevicePublicKey)
+
+	assert.Equal(s.T(), "def456", resp.Results[1].DeviceToken)
+	assert.Equal(s.T(), "invalid_argument", resp.Results[1].ErrorCode)
+
+	s.encoderClient.AssertNumberOfCalls(s.T(), "CreateStream", 1)
+}
+
+func (s *DeviceRegistrationSuite) TestInvalidClaimDevicesRequests() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+	}, s.logger)
+
+	tooMany := make([]*devicereg.ClaimDeviceRequest, 501)
+	for i := range tooMany {
+		tooMany[i] = &devicereg.ClaimDeviceRequest{}
+	}
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.ClaimDevicesRequest
+		expectedErr string
+	}{
+		{
+			label: "missing user_uid",
+			req: &devicereg.ClaimDevicesRequest{
+				Devices: []*devicereg.ClaimDeviceRequest{{DeviceToken: "abc123"}},
+			},
+			expectedErr: "twirp error invalid_argument: user_uid is required",
+		},
+		{
+			label: "missing devices",
+			req: &devicereg.ClaimDevicesRequest{
+				UserUid: "alice",
+			},
+			expectedErr: "twirp error invalid_argument: devices is required",
+		},
+		{
+			label: "too many devices",
+			req: &devicereg.ClaimDevicesRequest{
+				UserUid: "alice",
+				Devices: tooMany,
+			},
+			expectedErr: "twirp error invalid_argument: devices must contain no more than 500 devices",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.ClaimDevices(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestInvalidClaimRequests() {
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+	err := dr.(system.Startable).Start()
+	assert.Nil(s.T(), err, "starting devicereg")
+	defer func() {
+		err := dr.(system.Stoppable).Stop()
+		assert.Nil(s.T(), err, "stopping devicereg")
+	}()
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.ClaimDeviceRequest
+		expectedErr string
+	}{
+		{
+			label: "missing device token",
+			req: &devicereg.ClaimDeviceRequest{
+				//DeviceToken: "abc123",
+				Broker:  "tcp://broker:1883",
+				UserUid: "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 12.2,
+					Latitude:  32.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: device_token is required",
+		},
+		{
+			label: "missing broker",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				//Broker: "tcp://broker:1883",
+				UserUid: "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 12.2,
+					Latitude:  32.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: broker is required",
+		},
+		{
+			label: "missing user_uid",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				//UserUid: "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 12.2,
+					Latitude:  32.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: user_uid is required",
+		},
+		{
+			label: "missing location",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				UserUid:     "alice",
+				//Location: &devicereg.ClaimDeviceRequest_Location{
+				//	Longitude: 12.2,
+				//	Latitude:  32.1,
+				//},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: location is required",
+		},
+		{
+			label: "missing longitude",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				UserUid:     "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					//	Longitude: 12.2,
+					Latitude: 32.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: longitude is required",
+		},
+		{
+			label: "missing latitude",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				UserUid:     "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 12.2,
+					//Latitude:  32.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: latitude is required",
+		},
+		{
+			label: "invalid large longitude",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				UserUid:     "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 180.1,
+					Latitude:  32.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: location must have a longitude between -180 and 180 degrees",
+		},
+		{
+			label: "invalid small longitude",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				UserUid:     "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: -180.1,
+					Latitude:  32.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: location must have a longitude between -180 and 180 degrees",
+		},
+		{
+			label: "invalid large latitude",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				UserUid:     "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 80.1,
+					Latitude:  90.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: location must have a latitude between -90 and 90 degrees",
+		},
+		{
+			label: "invalid small latitude",
+			req: &devicereg.ClaimDeviceRequest{
+				DeviceToken: "abc123",
+				Broker:      "tcp://broker:1883",
+				UserUid:     "alice",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 80.1,
+					Latitude:  -90.1,
+				},
+				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			},
+			expectedErr: "twirp error invalid_argument: location must have a latitude between -90 and 90 degrees",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.ClaimDevice(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestInvalidRevokeRequests() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+	err := dr.(system.Startable).Start()
+	assert.Nil(s.T(), err, "starting devicereg")
+	defer func() {
+		err := dr.(system.Stoppable).Stop()
+		assert.Nil(s.T(), err, "stopping devicereg")
+	}()
+
+	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+
+	assert.Nil(s.T(), err)
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.RevokeDeviceRequest
+		expectedErr string
+	}{
+		{
+			label: "invalid user public key",
+			req: &devicereg.RevokeDeviceRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: "foobar",
+			},
+			expectedErr: "twirp error internal: failed to delete device: sql: no rows in result set",
+		},
+		{
+			label: "invalid device token",
+			req: &devicereg.RevokeDeviceRequest{
+				DeviceToken:   "foobar",
+				UserPublicKey: claimResp.UserPublicKey,
+			},
+			expectedErr: "twirp error internal: failed to delete device: sql: no rows in result set",
+		},
+		{
+			label: "missing device token",
+			req: &devicereg.RevokeDeviceRequest{
+				//DeviceToken:   "foobar",
+				UserPublicKey: claimResp.UserPublicKey,
+			},
+			expectedErr: "twirp error invalid_argument: device_token is required",
+		},
+		{
+			label: "missing device token",
+			req: &devicereg.RevokeDeviceRequest{
+				DeviceToken: "abc123",
+				//UserPublicKey: claimResp.UserPublicKey,
+			},
+			expectedErr: "twirp error invalid_argument: user_public_key is required",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.RevokeDevice(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestListDevices() {
+	for _, uid := range []string{"stream1", "stream2", "stream3"} {
+		s.encoderClient.On(
+			"CreateStream",
+			mock.Anything,
+			mock.Anything,
+		).Return(
+			&encoder.CreateStreamResponse{StreamUid: uid},
+			nil,
+		).Once()
+	}
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	var claimResp *devicereg.ClaimDeviceResponse
+
+	for _, token := range []string{"abc123", "def456", "hij789"} {
+		resp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+			Broker:      "tcp://mqtt.local:1883",
+			DeviceToken: token,
+			UserUid:     "alice",
+			Location: &devicereg.ClaimDeviceRequest_Location{
+				Longitude: 12.2,
+				Latitude:  32.1,
+			},
+			Disposition: devicereg.ClaimDeviceRequest_OUTDOOR,
+		})
+		assert.Nil(s.T(), err)
+
+		claimResp = resp
+	}
+
+	listResp, err := dr.ListDevices(context.Background(), &devicereg.ListDevicesRequest{
+		UserPublicKey: claimResp.UserPublicKey,
+		PageSize:      2,
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), listResp.Devices, 2)
+	assert.NotEqual(s.T(), "", listResp.NextCursor)
+
+	device := listResp.Devices[0]
+	assert.Equal(s.T(), "abc123", device.DeviceToken)
+	assert.Equal(s.T(), 12.2, device.Location.Longitude)
+	assert.Equal(s.T(), 32.1, device.Location.Latitude)
+	assert.Equal(s.T(), devicereg.ClaimDeviceRequest_OUTDOOR, device.Disposition)
+	assert.NotEqual(s.T(), "", device.DevicePublicKey)
+	assert.NotEqual(s.T(), "", device.CreatedAt)
+	assert.Equal(s.T(), []string{"stream1"}, device.StreamUids)
+
+	listResp, err = dr.ListDevices(context.Background(), &devicereg.ListDevicesRequest{
+		UserPublicKey: claimResp.UserPublicKey,
+		PageSize:      2,
+		Cursor:        listResp.NextCursor,
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), listResp.Devices, 1)
+	assert.Equal(s.T(), "hij789", listResp.Devices[0].DeviceToken)
+	assert.Equal(s.T(), "", listResp.NextCursor)
+
+	// an unknown user simply has no devices
+	listResp, err = dr.ListDevices(context.Background(), &devicereg.ListDevicesRequest{
+		UserPublicKey: "foobar",
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), listResp.Devices, 0)
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.ListDevicesRequest
+		expectedErr string
+	}{
+		{
+			label:       "missing user public key",
+			req:         &devicereg.ListDevicesRequest{},
+			expectedErr: "twirp error invalid_argument: user_public_key is required",
+		},
+		{
+			label: "page size too large",
+			req: &devicereg.ListDevicesRequest{
+				UserPublicKey: claimResp.UserPublicKey,
+				PageSize:      101,
+			},
+			expectedErr: "twirp error invalid_argument: page_size must be between 0 and 100",
+		},
+		{
+			label: "invalid cursor",
+			req: &devicereg.ListDevicesRequest{
+				UserPublicKey: claimResp.UserPublicKey,
+				Cursor:        "foobar",
+			},
+			expectedErr: "twirp error invalid_argument: cursor is not a valid cursor",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.ListDevices(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestGetDevice() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_OUTDOOR,
+	})
+	assert.Nil(s.T(), err)
+
+	getResp, err := dr.GetDevice(context.Background(), &devicereg.GetDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), claimResp.UserPublicKey, getResp.UserPublicKey)
+
+	device := getResp.Device
+	assert.Equal(s.T(), "abc123", device.DeviceToken)
+	assert.Equal(s.T(), claimResp.DevicePublicKey, device.DevicePublicKey)
+	assert.Equal(s.T(), 12.2, device.Location.Longitude)
+	assert.Equal(s.T(), 32.1, device.Location.Latitude)
+	assert.Equal(s.T(), devicereg.ClaimDeviceRequest_OUTDOOR, device.Disposition)
+	assert.NotEqual(s.T(), "", device.CreatedAt)
+	assert.NotEqual(s.T(), "", device.UpdatedAt)
+	assert.Equal(s.T(), []string{"foobar"}, device.StreamUids)
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.GetDeviceRequest
+		expectedErr string
+	}{
+		{
+			label: "invalid user public key",
+			req: &devicereg.GetDeviceRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: "foobar",
+			},
+			expectedErr: "twirp error not_found: device not found",
+		},
+		{
+			label: "invalid device token",
+			req: &devicereg.GetDeviceRequest{
+				DeviceToken:   "foobar",
+				UserPublicKey: claimResp.UserPublicKey,
+			},
+			expectedErr: "twirp error not_found: device not found",
+		},
+		{
+			label: "missing device token",
+			req: &devicereg.GetDeviceRequest{
+				UserPublicKey: claimResp.UserPublicKey,
+			},
+			expectedErr: "twirp error invalid_argument: device_token is required",
+		},
+		{
+			label: "missing user public key",
+			req: &devicereg.GetDeviceRequest{
+				DeviceToken: "abc123",
+			},
+			expectedErr: "twirp error invalid_argument: user_public_key is required",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.GetDevice(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestUpdateDevice() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.MatchedBy(func(req *encoder.CreateStreamRequest) bool {
+			return req.Location.Longitude == 12.2
+		}),
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "stream1"},
+		nil,
+	)
+
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.MatchedBy(func(req *encoder.CreateStreamRequest) bool {
+			return req.Location.Longitude == 14.5 &&
+				req.Location.Latitude == 34.5 &&
+				req.Disposition == encoder.CreateStreamRequest_OUTDOOR &&
+				req.BrokerAddress == "tcp://mqtt.local:1883" &&
+				req.DeviceTopic == "device/sck/abc123/readings" &&
+				req.UserUid == "alice"
+		}),
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "stream2"},
+		nil,
+	)
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+	assert.Nil(s.T(), err)
+
+	updateResp, err := dr.UpdateDevice(context.Background(), &devicereg.UpdateDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 14.5,
+			Latitude:  34.5,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_OUTDOOR,
+	})
+	assert.Nil(s.T(), err)
+
+	device := updateResp.Device
+	assert.Equal(s.T(), "abc123", device.DeviceToken)
+	assert.Equal(s.T(), claimResp.DevicePublicKey, device.DevicePublicKey)
+	assert.Equal(s.T(), 14.5, device.Location.Longitude)
+	assert.Equal(s.T(), 34.5, device.Location.Latitude)
+	assert.Equal(s.T(), devicereg.ClaimDeviceRequest_OUTDOOR, device.Disposition)
+	assert.Equal(s.T(), []string{"stream2"}, device.StreamUids)
+
+	// the new stream replaces the old one locally, and deletion of the old
+	// stream from the encoder is written to the outbox
+	var streamUID string
+	err = s.rawDb.Get(&streamUID, `SELECT s.uid FROM streams s JOIN devices d ON d.id = s.device_id WHERE d.token = $1`, "abc123")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "stream2", streamUID)
+
+	err = s.rawDb.Get(&streamUID, `SELECT stream_uid FROM outbox WHERE operation = $1`, postgres.DeleteStreamOperation)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "stream1", streamUID)
+
+	s.encoderClient.AssertExpectations(s.T())
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.UpdateDeviceRequest
+		expectedErr string
+	}{
+		{
+			label: "invalid user public key",
+			req: &devicereg.UpdateDeviceRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: "foobar",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 14.5,
+					Latitude:  34.5,
+				},
+			},
+			expectedErr: "twirp error not_found: device not found",
+		},
+		{
+			label: "missing device token",
+			req: &devicereg.UpdateDeviceRequest{
+				UserPublicKey: claimResp.UserPublicKey,
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 14.5,
+					Latitude:  34.5,
+				},
+			},
+			expectedErr: "twirp error invalid_argument: device_token is required",
+		},
+		{
+			label: "missing user public key",
+			req: &devicereg.UpdateDeviceRequest{
+				DeviceToken: "abc123",
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 14.5,
+					Latitude:  34.5,
+				},
+			},
+			expectedErr: "twirp error invalid_argument: user_public_key is required",
+		},
+		{
+			label: "missing location",
+			req: &devicereg.UpdateDeviceRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: claimResp.UserPublicKey,
+			},
+			expectedErr: "twirp error invalid_argument: location is required",
+		},
+		{
+			label: "invalid latitude",
+			req: &devicereg.UpdateDeviceRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: claimResp.UserPublicKey,
+				Location: &devicereg.ClaimDeviceRequest_Location{
+					Longitude: 14.5,
+					Latitude:  134.5,
+				},
+			},
+			expectedErr: "twirp error invalid_argument: location must have a latitude between -90 and 90 degrees",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.UpdateDevice(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestTransferDevice() {
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.MatchedBy(func(req *encoder.CreateStreamRequest) bool {
+			return req.UserUid == "alice"
+		}),
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "stream1"},
+		nil,
+	)
+
+	var transferReq *encoder.CreateStreamRequest
+
+	s.encoderClient.On(
+		"CreateStream",
+		mock.Anything,
+		mock.MatchedBy(func(req *encoder.CreateStreamRequest) bool {
+			return req.UserUid == "bob"
+		}),
+	).Return(
+		&encoder.CreateStreamResponse{StreamUid: "stream2"},
+		nil,
+	).Run(func(args mock.Arguments) {
+		transferReq = args.Get(1).(*encoder.CreateStreamRequest)
+	})
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	claimReq := &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "abc123",
+		UserUid:     "alice",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	}
+
+	claimResp, err := dr.ClaimDevice(context.Background(), claimReq)
+	assert.Nil(s.T(), err)
+
+	// the new owner already has a key pair from another device
+	bobResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+		Broker:      "tcp://mqtt.local:1883",
+		DeviceToken: "def456",
+		UserUid:     "bob",
+		Location: &devicereg.ClaimDeviceRequest_Location{
+			Longitude: 12.2,
+			Latitude:  32.1,
+		},
+		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+	})
+	assert.Nil(s.T(), err)
+
+	transferResp, err := dr.TransferDevice(context.Background(), &devicereg.TransferDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+		NewUserUid:    "bob",
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), bobResp.UserPublicKey, transferResp.UserPublicKey)
+	assert.NotEqual(s.T(), claimResp.DevicePublicKey, transferResp.DevicePublicKey)
+
+	// the previous owner is never given the new owner's private key
+	encoded, err := proto.Marshal(transferResp)
+	assert.Nil(s.T(), err)
+	assert.NotContains(s.T(), string(encoded), bobResp.UserPrivateKey)
+
+	// which the new owner obtains by claiming the device themselves
+	claimReq.UserUid = "bob"
+
+	reclaimResp, err := dr.ClaimDevice(context.Background(), claimReq)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), bobResp.UserPrivateKey, reclaimResp.UserPrivateKey)
+	assert.Equal(s.T(), transferResp.DevicePublicKey, reclaimResp.DevicePublicKey)
+
+	// the new stream is encrypted for the new owner
+	assert.NotNil(s.T(), transferReq)
+	assert.Equal(s.T(), transferResp.UserPublicKey, transferReq.RecipientPublicKey)
+	assert.Equal(s.T(), "tcp://mqtt.local:1883", transferReq.BrokerAddress)
+	assert.Equal(s.T(), "device/sck/abc123/readings", transferReq.DeviceTopic)
+
+	var streamUID string
+	err = s.rawDb.Get(&streamUID, `SELECT uid FROM streams`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "stream2", streamUID)
+
+	err = s.rawDb.Get(&streamUID, `SELECT stream_uid FROM outbox WHERE operation = $1`, postgres.DeleteStreamOperation)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "stream1", streamUID)
+
+	// the new owner is now able to fetch the device
+	_, err = dr.GetDevice(context.Background(), &devicereg.GetDeviceRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: transferResp.UserPublicKey,
+	})
+	assert.Nil(s.T(), err)
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.TransferDeviceRequest
+		expectedErr string
+	}{
+		{
+			label: "previous owner public key",
+			req: &devicereg.TransferDeviceRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: claimResp.UserPublicKey,
+				NewUserUid:    "carol",
+			},
+			expectedErr: "twirp error not_found: device not found",
+		},
+		{
+			label: "missing device token",
+			req: &devicereg.TransferDeviceRequest{
+				UserPublicKey: transferResp.UserPublicKey,
+				NewUserUid:    "carol",
+			},
+			expectedErr: "twirp error invalid_argument: device_token is required",
+		},
+		{
+			label: "missing user public key",
+			req: &devicereg.TransferDeviceRequest{
+				DeviceToken: "abc123",
+				NewUserUid:  "carol",
+			},
+			expectedErr: "twirp error invalid_argument: user_public_key is required",
+		},
+		{
+			label: "missing new user uid",
+			req: &devicereg.TransferDeviceRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: transferResp.UserPublicKey,
+			},
+			expectedErr: "twirp error invalid_argument: new_user_uid is required",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.TransferDevice(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestRotateKeys() {
+	for _, uid := range []string{"stream1", "stream2", "stream3", "stream4", "stream5"} {
+		s.encoderClient.On(
+			"CreateStream",
+			mock.Anything,
+			mock.Anything,
+		).Return(
+			&encoder.CreateStreamResponse{StreamUid: uid},
+			nil,
+		).Once()
+	}
+
+	dr := rpc.NewDeviceReg(&rpc.Config{
+		DB:            s.db,
+		EncoderClient: s.encoderClient,
+		Verbose:       true,
+	}, s.logger)
+
+	var claimResp *devicereg.ClaimDeviceResponse
+
+	for _, token := range []string{"abc123", "def456"} {
+		resp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
+			Broker:      "tcp://mqtt.local:1883",
+			DeviceToken: token,
+			UserUid:     "alice",
+			Location: &devicereg.ClaimDeviceRequest_Location{
+				Longitude: 12.2,
+				Latitude:  32.1,
+			},
+			Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+		})
+		assert.Nil(s.T(), err)
+
+		claimResp = resp
+	}
+
+	// rotating just the device keys replaces just the device's stream
+	rotateResp, err := dr.RotateKeys(context.Background(), &devicereg.RotateKeysRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "", rotateResp.UserPrivateKey)
+	assert.Equal(s.T(), claimResp.UserPublicKey, rotateResp.UserPublicKey)
+	assert.NotEqual(s.T(), "", rotateResp.DevicePublicKey)
+
+	var streamUIDs []string
+	err = s.rawDb.Select(&streamUIDs, `SELECT uid FROM streams ORDER BY uid`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"stream2", "stream3"}, streamUIDs)
+
+	// rotating the user keys replaces the streams of all the user's devices
+	rotateResp, err = dr.RotateKeys(context.Background(), &devicereg.RotateKeysRequest{
+		DeviceToken:    "abc123",
+		UserPublicKey:  claimResp.UserPublicKey,
+		RotateUserKeys: true,
+	})
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), "", rotateResp.UserPrivateKey)
+	assert.NotEqual(s.T(), claimResp.UserPublicKey, rotateResp.UserPublicKey)
+
+	streamUIDs = nil
+	err = s.rawDb.Select(&streamUIDs, `SELECT uid FROM streams ORDER BY uid`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"stream4", "stream5"}, streamUIDs)
+
+	var outboxUIDs []string
+	err = s.rawDb.Select(&outboxUIDs, `SELECT stream_uid FROM outbox ORDER BY stream_uid`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"stream1", "stream2", "stream3"}, outboxUIDs)
+
+	var count int
+	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM key_history`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	// the new user key is used to encrypt the new streams
+	s.encoderClient.AssertCalled(s.T(), "CreateStream", mock.Anything, mock.MatchedBy(func(req *encoder.CreateStreamRequest) bool {
+		return req.RecipientPublicKey == rotateResp.UserPublicKey
+	}))
+
+	testcases := []struct {
+		label       string
+		req         *devicereg.RotateKeysRequest
+		expectedErr string
+	}{
+		{
+			label: "previous user public key",
+			req: &devicereg.RotateKeysRequest{
+				DeviceToken:   "abc123",
+				UserPublicKey: claimResp.UserPublicKey,
+			},
+			expectedErr: "twirp error not_found: device not found",
+		},
+		{
+			label: "missing device token",
+			req: &devicereg.RotateKeysRequest{
+				UserPublicKey: rotateResp.UserPublicKey,
+			},
+			expectedErr: "twirp error invalid_argument: device_token is required",
+		},
+		{
+			label: "missing user public key",
+			req: &devicereg.RotateKeysRequest{
+				DeviceToken: "abc123",
+			},
+			expectedErr: "twirp error invalid_argument: user_public_key is required",
+		},
+	}
+
+	for _, tc := range testcases {
+		s.T().Run(tc.label, func(t *testing.T) {
+			_, err := dr.RotateKeys(context.Background(), tc.req)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func (s *DeviceRegistrationSuite) TestVerifyDeviceSignature() {
 	s.encoderClient.On(
-		"DeleteStream",
+		"CreateStream",
+		mock.Anything,
 		mock.Anything,
-		&encoder.DeleteStreamRequest{
-			StreamUid: "foobar",
-		},
 	).Return(
-		&encoder.DeleteStreamResponse{},
+		&encoder.CreateStreamResponse{StreamUid: "foobar"},
 		nil,
 	)
 
@@ -107,13 +1920,6 @@ func (s *DeviceRegistrationSuite) TestFullLifecycle() {
 		Verbose:       true,
 	}, s.logger)
 
-	err := dr.(system.Startable).Start()
-	assert.Nil(s.T(), err, "starting devicereg")
-	defer func() {
-		err := dr.(system.Stoppable).Stop()
-		assert.Nil(s.T(), err, "stopping devicereg")
-	}()
-
 	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
 		Broker:      "tcp://mqtt.local:1883",
 		DeviceToken: "abc123",
@@ -122,210 +1928,129 @@ func (s *DeviceRegistrationSuite) TestFullLifecycle() {
 			Longitude: 12.2,
 			Latitude:  32.1,
 		},
-		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+		Disposition: devicereg.ClaimDeviceRequest_OUTDOOR,
 	})
+	assert.Nil(s.T(), err)
 
-	assert.Nil(s.T(), err, "claiming device")
-	assert.NotEqual(s.T(), "", claimResp.DevicePublicKey)
-	assert.NotEqual(s.T(), "", claimResp.UserPrivateKey)
-	assert.NotEqual(s.T(), "", claimResp.UserPublicKey)
+	payload := []byte(`{"temperature": 21.5}`)
 
-	// verify that user, and device have been created in the DB
-	var count int
-	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM users`)
+	// the device generates its own signing key pair, of which only the public
+	// key is registered
+	signer, err := crypto.NewKeyPair(nil)
 	assert.Nil(s.T(), err)
-	assert.Equal(s.T(), 1, count)
 
-	err = s.rawDb.Get(&count, `SELECT COUNT(*) FROM devices`)
+	signature, err := crypto.Sign(payload, signer.PrivateKey, nil)
 	assert.Nil(s.T(), err)
-	assert.Equal(s.T(), 1, count)
 
-	// verify that the stream id saved is the mocked value above
-	var streamUid string
-	err = s.rawDb.Get(&streamUid, `SELECT uid FROM streams WHERE uid = $1`, "foobar")
+	_, err = dr.VerifyDeviceSignature(context.Background(), &devicereg.VerifyDeviceSignatureRequest{
+		DeviceToken: "abc123",
+		Payload:     payload,
+		Signature:   signature,
+	})
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "twirp error failed_precondition: no signing key is registered for the device", err.Error())
+
+	err = s.db.RegisterSigningKey(&postgres.SigningKey{
+		DeviceToken: "abc123",
+		PublicKey:   signer.PublicKey,
+		KeyCurve:    crypto.Ec25519Curve,
+		KeyEncoding: crypto.Base64Encoding,
+	})
 	assert.Nil(s.T(), err)
-	assert.Equal(s.T(), "foobar", streamUid)
 
-	_, err = dr.RevokeDevice(context.Background(), &devicereg.RevokeDeviceRequest{
-		DeviceToken:   "abc123",
-		UserPublicKey: claimResp.UserPublicKey,
+	resp, err := dr.VerifyDeviceSignature(context.Background(), &devicereg.VerifyDeviceSignatureRequest{
+		DeviceToken: "abc123",
+		Payload:     payload,
+		Signature:   signature,
 	})
 	assert.Nil(s.T(), err)
+	assert.True(s.T(), resp.Valid)
 
-	// verify that we called encoder client with expected params
-	s.encoderClient.AssertExpectations(s.T())
-}
+	resp, err = dr.VerifyDeviceSignature(context.Background(), &devicereg.VerifyDeviceSignatureRequest{
+		DeviceToken: "abc123",
+		Payload:     []byte(`{"temperature": 31.5}`),
+		Signature:   signature,
+	})
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), resp.Valid)
 
-func (s *DeviceRegistrationSuite) TestInvalidClaimRequests() {
-	dr := rpc.NewDeviceReg(&rpc.Config{
-		DB:            s.db,
-		EncoderClient: s.encoderClient,
-		Verbose:       true,
-	}, s.logger)
-	err := dr.(system.Startable).Start()
-	assert.Nil(s.T(), err, "starting devicereg")
-	defer func() {
-		err := dr.(system.Stoppable).Stop()
-		assert.Nil(s.T(), err, "stopping devicereg")
-	}()
+	// a signature made with the device's generated key or the owner's key is
+	// not the device's signature
+	device, err := s.db.DeviceKeys("abc123")
+	assert.Nil(s.T(), err)
+
+	deviceSignature, err := crypto.Sign(payload, device.PrivateKey, nil)
+	assert.Nil(s.T(), err)
+
+	resp, err = dr.VerifyDeviceSignature(context.Background(), &devicereg.VerifyDeviceSignatureRequest{
+		DeviceToken: "abc123",
+		Payload:     payload,
+		Signature:   deviceSignature,
+	})
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), resp.Valid)
+
+	userSignature, err := crypto.Sign(payload, claimResp.UserPrivateKey, nil)
+	assert.Nil(s.T(), err)
+
+	resp, err = dr.VerifyDeviceSignature(context.Background(), &devicereg.VerifyDeviceSignatureRequest{
+		DeviceToken: "abc123",
+		Payload:     payload,
+		Signature:   userSignature,
+	})
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), resp.Valid)
 
 	testcases := []struct {
 		label       string
-		req         *devicereg.ClaimDeviceRequest
+		req         *devicereg.VerifyDeviceSignatureRequest
 		expectedErr string
 	}{
 		{
-			label: "missing device token",
-			req: &devicereg.ClaimDeviceRequest{
-				//DeviceToken: "abc123",
-				Broker:  "tcp://broker:1883",
-				UserUid: "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: 12.2,
-					Latitude:  32.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
-			},
-			expectedErr: "twirp error invalid_argument: device_token is required",
-		},
-		{
-			label: "missing broker",
-			req: &devicereg.ClaimDeviceRequest{
-				DeviceToken: "abc123",
-				//Broker: "tcp://broker:1883",
-				UserUid: "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: 12.2,
-					Latitude:  32.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
-			},
-			expectedErr: "twirp error invalid_argument: broker is required",
-		},
-		{
-			label: "missing user_uid",
-			req: &devicereg.ClaimDeviceRequest{
-				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				//UserUid: "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: 12.2,
-					Latitude:  32.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
-			},
-			expectedErr: "twirp error invalid_argument: user_uid is required",
-		},
-		{
-			label: "missing location",
-			req: &devicereg.ClaimDeviceRequest{
-				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				UserUid:     "alice",
-				//Location: &devicereg.ClaimDeviceRequest_Location{
-				//	Longitude: 12.2,
-				//	Latitude:  32.1,
-				//},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
-			},
-			expectedErr: "twirp error invalid_argument: location is required",
-		},
-		{
-			label: "missing longitude",
-			req: &devicereg.ClaimDeviceRequest{
-				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				UserUid:     "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					//	Longitude: 12.2,
-					Latitude: 32.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
-			},
-			expectedErr: "twirp error invalid_argument: longitude is required",
-		},
-		{
-			label: "missing latitude",
-			req: &devicereg.ClaimDeviceRequest{
-				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				UserUid:     "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: 12.2,
-					//Latitude:  32.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
-			},
-			expectedErr: "twirp error invalid_argument: latitude is required",
-		},
-		{
-			label: "invalid large longitude",
-			req: &devicereg.ClaimDeviceRequest{
-				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				UserUid:     "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: 180.1,
-					Latitude:  32.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			label: "unknown device token",
+			req: &devicereg.VerifyDeviceSignatureRequest{
+				DeviceToken: "foobar",
+				Payload:     payload,
+				Signature:   signature,
 			},
-			expectedErr: "twirp error internal: longitude must be between -180 and 180 degrees",
+			expectedErr: "twirp error not_found: device not found",
 		},
 		{
-			label: "invalid small longitude",
-			req: &devicereg.ClaimDeviceRequest{
-				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				UserUid:     "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: -180.1,
-					Latitude:  32.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+			label: "missing device token",
+			req: &devicereg.VerifyDeviceSignatureRequest{
+				Payload:   payload,
+				Signature: signature,
 			},
-			expectedErr: "twirp error internal: longitude must be between -180 and 180 degrees",
+			expectedErr: "twirp error invalid_argument: device_token is required",
 		},
 		{
-			label: "invalid large latitude",
-			req: &devicereg.ClaimDeviceRequest{
+			label: "missing payload",
+			req: &devicereg.VerifyDeviceSignatureRequest{
 				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				UserUid:     "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: 80.1,
-					Latitude:  90.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+				Signature:   signature,
 			},
-			expectedErr: "twirp error internal: latitude must be between -90 and 90 degrees",
+			expectedErr: "twirp error invalid_argument: payload is required",
 		},
 		{
-			label: "invalid small latitude",
-			req: &devicereg.ClaimDeviceRequest{
+			label: "missing signature",
+			req: &devicereg.VerifyDeviceSignatureRequest{
 				DeviceToken: "abc123",
-				Broker:      "tcp://broker:1883",
-				UserUid:     "alice",
-				Location: &devicereg.ClaimDeviceRequest_Location{
-					Longitude: 80.1,
-					Latitude:  -90.1,
-				},
-				Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+				Payload:     payload,
 			},
-			expectedErr: "twirp error internal: latitude must be between -90 and 90 degrees",
+			expectedErr: "twirp error invalid_argument: signature is required",
 		},
 	}
 
 	for _, tc := range testcases {
 		s.T().Run(tc.label, func(t *testing.T) {
-			_, err := dr.ClaimDevice(context.Background(), tc.req)
+			_, err := dr.VerifyDeviceSignature(context.Background(), tc.req)
 			assert.NotNil(t, err)
 			assert.Equal(t, tc.expectedErr, err.Error())
 		})
 	}
 }
 
-func (s *DeviceRegistrationSuite) TestInvalidRevokeRequests() {
+func (s *DeviceRegistrationSuite) TestEncryptTestPayload() {
 	s.encoderClient.On(
 		"CreateStream",
 		mock.Anything,
@@ -340,12 +2065,6 @@ func (s *DeviceRegistrationSuite) TestInvalidRevokeRequests() {
 		EncoderClient: s.encoderClient,
 		Verbose:       true,
 	}, s.logger)
-	err := dr.(system.Startable).Start()
-	assert.Nil(s.T(), err, "starting devicereg")
-	defer func() {
-		err := dr.(system.Stoppable).Stop()
-		assert.Nil(s.T(), err, "stopping devicereg")
-	}()
 
 	claimResp, err := dr.ClaimDevice(context.Background(), &devicereg.ClaimDeviceRequest{
 		Broker:      "tcp://mqtt.local:1883",
@@ -355,45 +2074,83 @@ func (s *DeviceRegistrationSuite) TestInvalidRevokeRequests() {
 			Longitude: 12.2,
 			Latitude:  32.1,
 		},
-		Disposition: devicereg.ClaimDeviceRequest_INDOOR,
+		Disposition: devicereg.ClaimDeviceRequest_OUTDOOR,
+	})
+	assert.Nil(s.T(), err)
+
+	// only callers acting for all users may encrypt test payloads, so not even
+	// the owner, nor any caller when authentication is disabled
+	_, err = dr.EncryptTestPayload(context.Background(), &devicereg.EncryptTestPayloadRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	_, err = dr.EncryptTestPayload(auth.NewContext(context.Background(), &auth.Principal{Name: "alice", UserUID: "alice"}), &devicereg.EncryptTestPayloadRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	s.assertErrorCode(twirp.PermissionDenied, err)
+
+	ctx := auth.NewContext(context.Background(), &auth.Principal{Name: "admin", AllUsers: true})
+
+	resp, err := dr.EncryptTestPayload(ctx, &devicereg.EncryptTestPayloadRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+		Payload:       []byte("hello"),
 	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []byte("hello"), resp.Payload)
+	assert.Equal(s.T(), claimResp.DevicePublicKey, resp.DevicePublicKey)
 
+	decrypted, err := crypto.Decrypt(&crypto.EncryptedPayload{
+		Ciphertext: resp.Ciphertext,
+		IV:         resp.Iv,
+		Header:     resp.Header,
+	}, claimResp.UserPrivateKey, resp.DevicePublicKey, nil)
 	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []byte("hello"), decrypted)
+
+	// a sample payload is encrypted if none is given
+	resp, err = dr.EncryptTestPayload(ctx, &devicereg.EncryptTestPayloadRequest{
+		DeviceToken:   "abc123",
+		UserPublicKey: claimResp.UserPublicKey,
+	})
+	assert.Nil(s.T(), err)
+	assert.NotEmpty(s.T(), resp.Payload)
 
 	testcases := []struct {
 		label       string
-		req         *devicereg.RevokeDeviceRequest
+		req         *devicereg.EncryptTestPayloadRequest
 		expectedErr string
 	}{
 		{
 			label: "invalid user public key",
-			req: &devicereg.RevokeDeviceRequest{
+			req: &devicereg.EncryptTestPayloadRequest{
 				DeviceToken:   "abc123",
 				UserPublicKey: "foobar",
 			},
-			expectedErr: "twirp error internal: failed to delete device: sql: no rows in result set",
+			expectedErr: "twirp error not_found: device not found",
 		},
 		{
 			label: "invalid device token",
-			req: &devicereg.RevokeDeviceRequest{
+			req: &devicereg.EncryptTestPayloadRequest{
 				DeviceToken:   "foobar",
 				UserPublicKey: claimResp.UserPublicKey,
 			},
-			expectedErr: "twirp error internal: failed to delete device: sql: no rows in result set",
+			expectedErr: "twirp error not_found: device not found",
 		},
 		{
 			label: "missing device token",
-			req: &devicereg.RevokeDeviceRequest{
-				//DeviceToken:   "foobar",
+			req: &devicereg.EncryptTestPayloadRequest{
 				UserPublicKey: claimResp.UserPublicKey,
 			},
 			expectedErr: "twirp error invalid_argument: device_token is required",
 		},
 		{
-			label: "missing device token",
-			req: &devicereg.RevokeDeviceRequest{
+			label: "missing user public key",
+			req: &devicereg.EncryptTestPayloadRequest{
 				DeviceToken: "abc123",
-				//UserPublicKey: claimResp.UserPublicKey,
 			},
 			expectedErr: "twirp error invalid_argument: user_public_key is required",
 		},
@@ -401,7 +2158,7 @@ func (s *DeviceRegistrationSuite) TestInvalidRevokeRequests() {
 
 	for _, tc := range testcases {
 		s.T().Run(tc.label, func(t *testing.T) {
-			_, err := dr.RevokeDevice(context.Background(), tc.req)
+			_, err := dr.EncryptTestPayload(ctx, tc.req)
 			assert.NotNil(t, err)
 			assert.Equal(t, tc.expectedErr, err.Error())
 		})
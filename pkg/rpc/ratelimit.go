@@ -0,0 +1,44 @@
+package rpc
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+)
+
+// allowUser returns a resource exhausted error if the user with the given uid
+// has exceeded our per user rate limit, counting one call to the given method.
+// Calls are always allowed if we have no user rate limiter.
+func (d *deviceRegImpl) allowUser(userUID, method string) error {
+	if d.userRateLimiter == nil {
+		return nil
+	}
+
+	if !d.userRateLimiter.Allow(userUID, method) {
+		return twirp.NewError(twirp.ResourceExhausted, "rate limit exceeded for user")
+	}
+
+	return nil
+}
+
+// allowOwner is allowUser for requests which identify the user by their public
+// key, which we resolve to their uid so that each user has a single limit
+// however they are identified. A public key no user has cannot be confused
+// with any user, so is limited by the key itself.
+func (d *deviceRegImpl) allowOwner(publicKey, method string) error {
+	if d.userRateLimiter == nil {
+		return nil
+	}
+
+	userUID, err := d.db.UserUID(publicKey)
+	if err != nil {
+		if errors.Cause(err) != sql.ErrNoRows {
+			return twirp.InternalErrorWith(err)
+		}
+
+		userUID = publicKey
+	}
+
+	return d.allowUser(userUID, method)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/auth"
+)
+
+// AuthMiddleware returns a handler authenticating every request before passing
+// it to the given handler, with the authenticated principal placed into the
+// request's context. Each authenticator is tried in turn, and the first to
+// find credentials it checks decides whether the request is authenticated.
+// Requests without valid credentials are rejected with a twirp
+// Unauthenticated error.
+func AuthMiddleware(authenticators []auth.Authenticator, next http.Handler, logger kitlog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, authenticator := range authenticators {
+			principal, err := authenticator.Authenticate(r)
+			if err == auth.ErrNoCredentials {
+				continue
+			}
+
+			if err != nil {
+				logger.Log("msg", "rejected request", "path", r.URL.Path, "err", err)
+
+				// we don't tell the caller why their credentials were rejected
+				writeTwirpError(w, twirp.NewError(twirp.Unauthenticated, "invalid credentials"))
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+			return
+		}
+
+		writeTwirpError(w, twirp.NewError(twirp.Unauthenticated, "missing credentials"))
+	})
+}
+
+// writeTwirpError writes the given error to the response in the JSON format
+// used by twirp, so that clients handle it as any other error from our API.
+func writeTwirpError(w http.ResponseWriter, twerr twirp.Error) {
+	body, err := json.Marshal(struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+	}{
+		Code: string(twerr.Code()),
+		Msg:  twerr.Msg(),
+	})
+	if err != nil {
+		http.Error(w, errors.Wrap(err, "failed to encode error").Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(twirp.ServerHTTPStatusFromErrorCode(twerr.Code()))
+	w.Write(body)
+}
@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/auth"
+	"github.com/thingful/iotdevicereg/pkg/server"
+)
+
+// headerAuthenticator is a stand-in authenticator accepting the single token
+// it is configured with, presented via its header.
+type headerAuthenticator struct {
+	header    string
+	token     string
+	principal *auth.Principal
+}
+
+func (h *headerAuthenticator) Authenticate(r *http.Request) (*auth.Principal, error) {
+	token := r.Header.Get(h.header)
+	if token == "" {
+		return nil, auth.ErrNoCredentials
+	}
+
+	if token != h.token {
+		return nil, errors.Wrap(auth.ErrInvalidCredentials, "wrong token")
+	}
+
+	return h.principal, nil
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	authenticators := []auth.Authenticator{
+		&headerAuthenticator{header: "X-First", token: "abc", principal: &auth.Principal{Name: "first", AllUsers: true}},
+		&headerAuthenticator{header: "X-Second", token: "def", principal: &auth.Principal{Name: "second", UserUID: "alice"}},
+	}
+
+	var principal *auth.Principal
+
+	handler := server.AuthMiddleware(authenticators, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = auth.FromContext(r.Context())
+	}), kitlog.NewNopLogger())
+
+	testcases := []struct {
+		label             string
+		headers           map[string]string
+		expectedStatus    int
+		expectedCode      string
+		expectedPrincipal string
+	}{
+		{
+			label:             "first authenticator",
+			headers:           map[string]string{"X-First": "abc"},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "first",
+		},
+		{
+			label:             "second authenticator",
+			headers:           map[string]string{"X-Second": "def"},
+			expectedStatus:    http.StatusOK,
+			expectedPrincipal: "second",
+		},
+		{
+			label:          "invalid credentials",
+			headers:        map[string]string{"X-First": "wrong", "X-Second": "def"},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "unauthenticated",
+		},
+		{
+			label:          "missing credentials",
+			headers:        map[string]string{},
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "unauthenticated",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			principal = nil
+
+			req, err := http.NewRequest(http.MethodPost, "/twirp/devicereg.DeviceRegistration/ClaimDevice", nil)
+			assert.Nil(t, err)
+
+			for header, value := range tc.headers {
+				req.Header.Set(header, value)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+
+			if tc.expectedCode != "" {
+				var body map[string]string
+				err = json.Unmarshal(rr.Body.Bytes(), &body)
+				assert.Nil(t, err)
+				assert.Equal(t, tc.expectedCode, body["code"])
+				assert.Nil(t, principal)
+				return
+			}
+
+			assert.NotNil(t, principal)
+			assert.Equal(t, tc.expectedPrincipal, principal.Name)
+		})
+	}
+}
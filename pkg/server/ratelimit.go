@@ -0,0 +1,61 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+	"github.com/thingful/iotdevicereg/pkg/ratelimit"
+)
+
+// RateLimitedMethods are the methods of our API limited by client IP address
+// when rate limiting is enabled, being those that generate keys and create
+// streams on the encoder, or delete them, along with IssueClaimChallenge which
+// writes a challenge for any caller able to name a device.
+var RateLimitedMethods = []string{"ClaimDevice", "ClaimDevices", "RevokeDevice", "IssueClaimChallenge"}
+
+// RateLimitMiddleware returns a handler limiting calls to the given methods of
+// our API by client IP address, rejecting calls exceeding the limit with a
+// twirp ResourceExhausted error. Calls to other paths are passed to the given
+// handler unlimited. If trustForwardedFor is true the client address is taken
+// from the last entry of the X-Forwarded-For header, which should only be
+// enabled when we are behind a proxy that sets it, as otherwise clients can
+// choose their own address.
+func RateLimitMiddleware(limiter *ratelimit.Limiter, methods []string, trustForwardedFor bool, next http.Handler) http.Handler {
+	limited := map[string]string{}
+	for _, method := range methods {
+		limited[devicereg.DeviceRegistrationPathPrefix+method] = method
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, ok := limited[r.URL.Path]
+		if ok && !limiter.Allow(clientIP(r, trustForwardedFor), method) {
+			writeTwirpError(w, twirp.NewError(twirp.ResourceExhausted, "rate limit exceeded"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP address of the client making the given request.
+func clientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		// the header may be repeated, in which case our proxy's entry is at the
+		// end of the last one
+		if values := r.Header["X-Forwarded-For"]; len(values) > 0 {
+			addrs := strings.Split(values[len(values)-1], ",")
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
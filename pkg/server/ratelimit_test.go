@@ -0,0 +1,99 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/thingful/iotdevicereg/pkg/ratelimit"
+	"github.com/thingful/iotdevicereg/pkg/server"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limited := twirp.ServerHTTPStatusFromErrorCode(twirp.ResourceExhausted)
+
+	testcases := []struct {
+		label             string
+		trustForwardedFor bool
+		path              string
+		remoteAddrs       []string
+		forwardedFor      []string
+		expectedStatus    []int
+	}{
+		{
+			label:          "limited by remote address",
+			path:           "/twirp/devicereg.DeviceRegistration/ClaimDevice",
+			remoteAddrs:    []string{"10.0.0.1:1234", "10.0.0.1:2345", "10.0.0.2:1234"},
+			expectedStatus: []int{http.StatusOK, limited, http.StatusOK},
+		},
+		{
+			label:          "limited claim challenges",
+			path:           "/twirp/devicereg.DeviceRegistration/IssueClaimChallenge",
+			remoteAddrs:    []string{"10.0.0.1:1234", "10.0.0.1:2345"},
+			expectedStatus: []int{http.StatusOK, limited},
+		},
+		{
+			label:          "unlimited method",
+			path:           "/twirp/devicereg.DeviceRegistration/ListDevices",
+			remoteAddrs:    []string{"10.0.0.1:1234", "10.0.0.1:2345"},
+			expectedStatus: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			label:          "forwarded for ignored",
+			path:           "/twirp/devicereg.DeviceRegistration/RevokeDevice",
+			remoteAddrs:    []string{"10.0.0.1:1234", "10.0.0.1:2345"},
+			forwardedFor:   []string{"192.168.0.1", "192.168.0.2"},
+			expectedStatus: []int{http.StatusOK, limited},
+		},
+		{
+			label:             "forwarded for trusted",
+			trustForwardedFor: true,
+			path:              "/twirp/devicereg.DeviceRegistration/RevokeDevice",
+			remoteAddrs:       []string{"10.0.0.1:1234", "10.0.0.1:2345", "10.0.0.1:3456"},
+			forwardedFor:      []string{"1.2.3.4, 192.168.0.1", "5.6.7.8, 192.168.0.2", "192.168.0.1"},
+			expectedStatus:    []int{http.StatusOK, http.StatusOK, limited},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			limiter := ratelimit.NewLimiter(&ratelimit.Config{
+				Name:  "ip",
+				Rate:  0.001,
+				Burst: 1,
+			})
+
+			handler := server.RateLimitMiddleware(
+				limiter,
+				server.RateLimitedMethods,
+				tc.trustForwardedFor,
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			)
+
+			for i, remoteAddr := range tc.remoteAddrs {
+				req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+				req.RemoteAddr = remoteAddr
+
+				if tc.forwardedFor != nil {
+					req.Header.Set("X-Forwarded-For", tc.forwardedFor[i])
+				}
+
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+
+				assert.Equal(t, tc.expectedStatus[i], rr.Code)
+
+				if rr.Code == limited {
+					var body map[string]string
+					err := json.Unmarshal(rr.Body.Bytes(), &body)
+					assert.Nil(t, err)
+					assert.Equal(t, "resource_exhausted", body["code"])
+				}
+			}
+		})
+	}
+}
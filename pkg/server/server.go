@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
@@ -12,30 +13,76 @@ import (
 	twrpprom "github.com/joneskoo/twirp-serverhook-prometheus"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	devicereg "github.com/thingful/twirp-devicereg-go"
 	encoder "github.com/thingful/twirp-encoder-go"
 
+	"github.com/thingful/iotdevicereg/pkg/auth"
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+	"github.com/thingful/iotdevicereg/pkg/devicereg"
+	"github.com/thingful/iotdevicereg/pkg/encoderclient"
+	"github.com/thingful/iotdevicereg/pkg/keypool"
+	"github.com/thingful/iotdevicereg/pkg/outbox"
 	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/ratelimit"
+	"github.com/thingful/iotdevicereg/pkg/reconciler"
 	"github.com/thingful/iotdevicereg/pkg/rpc"
 	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/tlsconfig"
 )
 
 // Config is a top level config object. Populated by viper in the command setup,
-// we then pass down config to the right places.
+// we then pass down config to the right places. KeyGenerator is used to
+// generate all key pairs, and if KeyPoolSize is 0 we do not pre-generate key
+// pairs, but instead generate them as required. If ReconcileInterval is 0 we do
+// not periodically reconcile our streams with the encoder. Any zero valued
+// encoder settings are replaced with the defaults of the encoderclient
+// package. If Authenticators is empty our API does not require authentication.
+// A zero ClaimChallengeTTL is replaced with the default of the rpc package.
+// If TLS is nil we serve plain HTTP, otherwise we serve HTTPS using its
+// certificate, verifying client certificates as given by ClientAuth. If
+// EncoderTLS is nil we connect to the encoder using the default HTTP
+// transport. If IPRateLimit or UserRateLimit is 0, we do not rate limit claims
+// and revocations by client IP address or user respectively.
 type Config struct {
-	ListenAddr         string
-	ConnStr            string
-	EncryptionPassword string
-	EncoderAddr        string
-	Verbose            bool
+	ListenAddr              string
+	ConnStr                 string
+	KeyEncrypter            postgres.KeyEncrypter
+	KeyOptions              *crypto.KeyOptions
+	KeyGenerator            crypto.KeyGenerator
+	KeyPoolSize             int
+	KeyPoolLowWatermark     int
+	ClaimConcurrency        int
+	ClaimChallengeTTL       time.Duration
+	RequireClaimProof       bool
+	EncoderAddr             string
+	EncoderTimeout          time.Duration
+	EncoderMaxAttempts      int
+	EncoderBreakerThreshold int
+	EncoderBreakerCooldown  time.Duration
+	ReconcileInterval       time.Duration
+	ReconcileRepair         bool
+	Authenticators          []auth.Authenticator
+	TLS                     *tlsconfig.Reloader
+	ClientAuth              tls.ClientAuthType
+	EncoderTLS              *tlsconfig.Reloader
+	IPRateLimit             float64
+	IPRateBurst             int
+	UserRateLimit           float64
+	UserRateBurst           int
+	TrustForwardedFor       bool
+	Verbose                 bool
 }
 
 // Server is our top level type, contains all other components, is responsible
 // for starting and stopping them in the correct order.
 type Server struct {
-	srv    *http.Server
-	db     postgres.DB
-	logger kitlog.Logger
+	srv        *http.Server
+	db         postgres.DB
+	keyPool    *keypool.Pool
+	dispatcher *outbox.Dispatcher
+	reconciler *reconciler.Reconciler
+	tls        *tlsconfig.Reloader
+	encoderTLS *tlsconfig.Reloader
+	logger     kitlog.Logger
 }
 
 // PulseHandler is the simplest possible handler function - used to expose an
@@ -49,30 +96,113 @@ func PulseHandler(w http.ResponseWriter, r *http.Request) {
 // components, and injecting them into the right place. This perhaps belongs
 // elsewhere, but leaving here for now.
 func NewServer(config *Config, logger kitlog.Logger) *Server {
-	db := postgres.NewDB(&postgres.Config{
-		ConnStr:            config.ConnStr,
-		EncryptionPassword: config.EncryptionPassword,
-	}, logger)
+	dbConfig := &postgres.Config{
+		ConnStr:      config.ConnStr,
+		KeyEncrypter: config.KeyEncrypter,
+		KeyOptions:   config.KeyOptions,
+		KeySource:    config.KeyGenerator,
+	}
 
-	encoderClient := encoder.NewEncoderProtobufClient(
-		config.EncoderAddr,
-		&http.Client{
-			Timeout: time.Second * 10,
-		},
-	)
+	var keyPool *keypool.Pool
+	if config.KeyPoolSize > 0 {
+		keyPool = keypool.NewPool(&keypool.Config{
+			KeyOptions:   config.KeyOptions,
+			Generator:    config.KeyGenerator,
+			Size:         config.KeyPoolSize,
+			LowWatermark: config.KeyPoolLowWatermark,
+			Verbose:      config.Verbose,
+		}, logger)
 
-	deviceReg := rpc.NewDeviceReg(&rpc.Config{
+		dbConfig.KeySource = keyPool
+	}
+
+	db := postgres.NewDB(dbConfig, logger)
+
+	var encoderTransport http.RoundTripper = http.DefaultTransport
+	if config.EncoderTLS != nil {
+		encoderTransport = config.EncoderTLS.Transport()
+	}
+
+	// each call to the encoder is given a deadline by our resilient client, so
+	// the underlying http client has no timeout of its own
+	encoderClient := encoderclient.NewClient(&encoderclient.Config{
+		EncoderClient:    encoder.NewEncoderProtobufClient(config.EncoderAddr, &http.Client{Transport: encoderTransport}),
+		Timeout:          config.EncoderTimeout,
+		MaxAttempts:      config.EncoderMaxAttempts,
+		BreakerThreshold: config.EncoderBreakerThreshold,
+		BreakerCooldown:  config.EncoderBreakerCooldown,
+	}, logger)
+
+	dispatcher := outbox.NewDispatcher(&outbox.Config{
 		DB:            db,
 		EncoderClient: encoderClient,
 		Verbose:       config.Verbose,
 	}, logger)
 
+	var streamReconciler *reconciler.Reconciler
+	if config.ReconcileInterval > 0 {
+		streamReconciler = reconciler.NewReconciler(&reconciler.Config{
+			DB:            db,
+			EncoderClient: encoderClient,
+			StreamLister: reconciler.NewStreamLister(
+				config.EncoderAddr,
+				&http.Client{
+					Transport: encoderTransport,
+					Timeout:   time.Second * 10,
+				},
+			),
+			Interval: config.ReconcileInterval,
+			Repair:   config.ReconcileRepair,
+			Verbose:  config.Verbose,
+		}, logger)
+	}
+
+	var userRateLimiter *ratelimit.Limiter
+	if config.UserRateLimit > 0 {
+		userRateLimiter = ratelimit.NewLimiter(&ratelimit.Config{
+			Name:  "user",
+			Rate:  config.UserRateLimit,
+			Burst: config.UserRateBurst,
+		})
+	}
+
+	deviceReg := rpc.NewDeviceReg(&rpc.Config{
+		DB:                db,
+		EncoderClient:     encoderClient,
+		ClaimConcurrency:  config.ClaimConcurrency,
+		ClaimChallengeTTL: config.ClaimChallengeTTL,
+		RequireClaimProof: config.RequireClaimProof,
+		UserRateLimiter:   userRateLimiter,
+		Verbose:           config.Verbose,
+	}, logger)
+
 	hooks := twrpprom.NewServerHooks(nil)
 
 	logger = kitlog.With(logger, "module", "server")
-	logger.Log("msg", "creating server", "encoder", config.EncoderAddr)
+	logger.Log("msg", "creating server", "encoder", config.EncoderAddr, "tls", config.TLS != nil)
 
-	twirpHandler := devicereg.NewDeviceRegistrationServer(deviceReg, hooks)
+	var twirpHandler http.Handler = devicereg.NewDeviceRegistrationServer(deviceReg, hooks)
+
+	if len(config.Authenticators) > 0 {
+		twirpHandler = AuthMiddleware(config.Authenticators, twirpHandler, logger)
+	} else {
+		logger.Log("msg", "authentication is disabled, so any caller may act for any user")
+	}
+
+	// calls are limited by IP before being authenticated, so that callers
+	// without valid credentials are limited too
+	if config.IPRateLimit > 0 {
+		twirpHandler = RateLimitMiddleware(
+			ratelimit.NewLimiter(&ratelimit.Config{
+				Name:  "ip",
+				Rate:  config.IPRateLimit,
+				Burst: config.IPRateBurst,
+			}),
+			RateLimitedMethods,
+			config.TrustForwardedFor,
+			twirpHandler,
+		)
+	}
 
 	// multiplex twirp handler into a mux with our other handlers
 	mux := http.NewServeMux()
@@ -86,11 +216,21 @@ func NewServer(config *Config, logger kitlog.Logger) *Server {
 		Handler: mux,
 	}
 
+	if config.TLS != nil {
+		srv.Handler = ClientCertMiddleware(mux)
+		srv.TLSConfig = config.TLS.ServerConfig(config.ClientAuth)
+	}
+
 	// return the instantiated server
 	return &Server{
-		srv:    srv,
-		db:     db,
-		logger: logger,
+		srv:        srv,
+		db:         db,
+		keyPool:    keyPool,
+		dispatcher: dispatcher,
+		reconciler: streamReconciler,
+		tls:        config.TLS,
+		encoderTLS: config.EncoderTLS,
+		logger:     logger,
 	}
 }
 
@@ -101,6 +241,16 @@ func NewServer(config *Config, logger kitlog.Logger) *Server {
 // We also create a channel listening for interrupt signals before gracefully
 // shutting down.
 func (s *Server) Start() error {
+	// start watching our certificates for changes if we use TLS
+	for _, reloader := range []*tlsconfig.Reloader{s.tls, s.encoderTLS} {
+		if reloader != nil {
+			err := reloader.Start()
+			if err != nil {
+				return errors.Wrap(err, "failed to start certificate reloader")
+			}
+		}
+	}
+
 	// start the postgres connection pool
 	err := s.db.(system.Startable).Start()
 	if err != nil {
@@ -113,13 +263,42 @@ func (s *Server) Start() error {
 		return errors.Wrap(err, "failed to migrate the database")
 	}
 
+	// start pre-generating key pairs if enabled
+	if s.keyPool != nil {
+		err = s.keyPool.Start()
+		if err != nil {
+			return errors.Wrap(err, "failed to start key pool")
+		}
+	}
+
+	// start the outbox dispatcher delivering pending encoder operations
+	err = s.dispatcher.Start()
+	if err != nil {
+		return errors.Wrap(err, "failed to start outbox dispatcher")
+	}
+
+	// start periodically reconciling our streams with the encoder if enabled
+	if s.reconciler != nil {
+		err = s.reconciler.Start()
+		if err != nil {
+			return errors.Wrap(err, "failed to start reconciler")
+		}
+	}
+
 	// add signal handling stuff to shutdown gracefully
-	stopChan := make(chan os.Signal)
+	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt)
 
 	go func() {
 		s.logger.Log("listenAddr", s.srv.Addr, "msg", "starting server")
-		if err := s.srv.ListenAndServe(); err != nil {
+
+		// our TLS config supplies the certificate, so no files are passed here
+		listen := s.srv.ListenAndServe
+		if s.tls != nil {
+			listen = func() error { return s.srv.ListenAndServeTLS("", "") }
+		}
+
+		if err := listen(); err != nil {
 			s.logger.Log("err", err)
 			os.Exit(1)
 		}
@@ -134,10 +313,38 @@ func (s *Server) Stop() error {
 	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelFn()
 
-	err := s.db.(system.Stoppable).Stop()
+	if s.reconciler != nil {
+		err := s.reconciler.Stop()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.dispatcher.Stop()
+	if err != nil {
+		return err
+	}
+
+	if s.keyPool != nil {
+		err = s.keyPool.Stop()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.db.(system.Stoppable).Stop()
 	if err != nil {
 		return err
 	}
 
+	for _, reloader := range []*tlsconfig.Reloader{s.tls, s.encoderTLS} {
+		if reloader != nil {
+			err = reloader.Stop()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	return s.srv.Shutdown(ctx)
 }
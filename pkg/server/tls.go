@@ -0,0 +1,21 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/thingful/iotdevicereg/pkg/auth"
+)
+
+// ClientCertMiddleware returns a handler placing the verified certificate of a
+// client connected using mutual TLS into the request's context before passing
+// it to the given handler, so our handlers can identify the client. Requests
+// without a verified certificate are passed on unchanged.
+func ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			r = r.WithContext(auth.NewCertificateContext(r.Context(), r.TLS.VerifiedChains[0][0]))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
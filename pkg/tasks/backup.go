@@ -0,0 +1,243 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/thingful/iotdevicereg/pkg/backup"
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+
+	backupCmd.Flags().StringP("output", "o", "", "Path of the archive file to write")
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Write an encrypted backup archive of the database",
+	Long: fmt.Sprintf(`This command writes a consistent snapshot of all users, devices, streams and
+their associated records to an archive file which can be restored with the
+restore command.
+
+Private keys are decrypted when read, so an archive may be restored using any
+key encryption backend, and the archive as a whole is encrypted with a key
+derived from the passphrase supplied via $DEVICEREG_BACKUP_PASSPHRASE. This
+passphrase should differ from $DEVICEREG_ENCRYPTION_PASSWORD, and must be kept
+safe as without it the archive cannot be restored. Any modification to the
+archive is detected on restore.
+
+The database must be fully migrated to the schema of this binary. For
+example:
+
+    $ DEVICEREG_BACKUP_PASSPHRASE=secret %s backup --output devicereg.backup`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outputPath, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+
+		if outputPath == "" {
+			return errors.New("Missing required flag: --output")
+		}
+
+		passphrase, err := backupPassphrase()
+		if err != nil {
+			return err
+		}
+
+		db, err := newBackupDB()
+		if err != nil {
+			return err
+		}
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		schemaVersion, err := checkSchemaVersion(db)
+		if err != nil {
+			return err
+		}
+
+		snapshot, err := db.ReadSnapshot()
+		if err != nil {
+			return err
+		}
+
+		// we write to a temporary file so that a failed backup never leaves a
+		// partial archive behind
+		tmpPath := outputPath + ".tmp"
+
+		file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpPath)
+
+		err = backup.Write(file, &backup.Archive{
+			SchemaVersion: schemaVersion,
+			CreatedAt:     time.Now(),
+			Snapshot:      snapshot,
+		}, passphrase)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		err = file.Close()
+		if err != nil {
+			return err
+		}
+
+		err = os.Rename(tmpPath, outputPath)
+		if err != nil {
+			return err
+		}
+
+		logger.NewLogger().Log("msg", "wrote backup", "path", outputPath, "schemaVersion", schemaVersion, "devices", len(snapshot.Tables["devices"]))
+
+		return nil
+	},
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore FILE",
+	Short: "Restore a backup archive into an empty database",
+	Long: fmt.Sprintf(`This command restores an archive written by the backup command, decrypting it
+with the passphrase supplied via $DEVICEREG_BACKUP_PASSPHRASE. Private keys
+are encrypted on restore using the key encryption backend currently
+configured, which need not be the one in use when the archive was written.
+
+The archive must have been written by a database with the same schema
+version as this binary. Migrations are run before restoring, and the restore
+is refused unless the database is then empty. All rows are restored within a
+single transaction, so a failed restore leaves the database unchanged. For
+example:
+
+    $ DEVICEREG_BACKUP_PASSPHRASE=secret %s restore devicereg.backup`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		passphrase, err := backupPassphrase()
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		archive, err := backup.Read(file, passphrase)
+		if err != nil {
+			return err
+		}
+
+		latest, err := postgres.LatestSchemaVersion()
+		if err != nil {
+			return err
+		}
+
+		if archive.SchemaVersion != latest {
+			return fmt.Errorf("Archive has schema version %d but this binary requires version %d", archive.SchemaVersion, latest)
+		}
+
+		db, err := newBackupDB()
+		if err != nil {
+			return err
+		}
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		err = db.MigrateUp()
+		if err != nil {
+			return err
+		}
+
+		_, err = checkSchemaVersion(db)
+		if err != nil {
+			return err
+		}
+
+		err = db.RestoreSnapshot(archive.Snapshot)
+		if err != nil {
+			return err
+		}
+
+		logger.NewLogger().Log("msg", "restored backup", "path", args[0], "createdAt", archive.CreatedAt, "devices", len(archive.Snapshot.Tables["devices"]))
+
+		return nil
+	},
+}
+
+// backupPassphrase returns the passphrase used to encrypt backup archives, read
+// from $DEVICEREG_BACKUP_PASSPHRASE.
+func backupPassphrase() (string, error) {
+	passphrase := viper.GetString("backup_passphrase")
+	if passphrase == "" {
+		return "", errors.New("Missing required environment variable: $DEVICEREG_BACKUP_PASSPHRASE")
+	}
+
+	return passphrase, nil
+}
+
+// newBackupDB returns an unstarted DB instance configured from the environment
+// with our key encrypter.
+func newBackupDB() (postgres.DB, error) {
+	connStr := viper.GetString("database_url")
+	if connStr == "" {
+		return nil, errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+	}
+
+	keyEncrypter, err := newKeyEncrypter()
+	if err != nil {
+		return nil, err
+	}
+
+	return postgres.NewDB(&postgres.Config{
+		ConnStr:      connStr,
+		KeyEncrypter: keyEncrypter,
+	}, logger.NewLogger()), nil
+}
+
+// checkSchemaVersion returns the schema version of the given database, or an
+// error if it is dirty or not migrated to the latest version compiled into the
+// binary.
+func checkSchemaVersion(db postgres.DB) (uint, error) {
+	latest, err := postgres.LatestSchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	current, dirty, err := db.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty {
+		return 0, fmt.Errorf("Database schema version %d is dirty", current)
+	}
+
+	if current != latest {
+		return 0, fmt.Errorf("Database has schema version %d but this binary requires version %d", current, latest)
+	}
+
+	return current, nil
+}
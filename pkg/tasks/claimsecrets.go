@@ -0,0 +1,150 @@
+package tasks
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/version"
+)
+
+// minClaimSecretLength is the minimum length of a claim secret we accept.
+const minClaimSecretLength = 16
+
+func init() {
+	rootCmd.AddCommand(claimSecretsCmd)
+	claimSecretsCmd.AddCommand(claimSecretsRegisterCmd)
+}
+
+var claimSecretsCmd = &cobra.Command{
+	Use:   "claim-secrets",
+	Short: "Manage device claim secrets",
+	Long: `This task provides subcommands for working with the secrets held by devices
+with which a claimant proves possession of a device.
+
+A device with a registered claim secret can only be claimed by a caller who
+first obtains a challenge via IssueClaimChallenge, and then passes it to
+ClaimDevice along with the hex encoded HMAC-SHA256, keyed with the secret, of
+the challenge followed by a colon and the user uid.`,
+}
+
+var claimSecretsRegisterCmd = &cobra.Command{
+	Use:   "register [FILE]",
+	Short: "Register claim secrets for devices",
+	Long: fmt.Sprintf(`This command registers a claim secret for each device listed in the given
+CSV file, or read from stdin if no file is given. The file must have a header
+row naming the device_token and secret columns. Registering a secret for a
+device that already has one replaces it.
+
+Secrets are encrypted before being stored using the backend selected via
+$DEVICEREG_KEY_ENCRYPTION, exactly as private keys are. For example:
+
+    $ %s claim-secrets register secrets.csv`, version.BinaryName),
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr := viper.GetString("database_url")
+		if connStr == "" {
+			return errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+		}
+
+		keyEncrypter, err := newKeyEncrypter()
+		if err != nil {
+			return err
+		}
+
+		var input io.Reader = os.Stdin
+
+		if len(args) > 0 {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			input = file
+		}
+
+		logger := logger.NewLogger()
+
+		db := postgres.NewDB(&postgres.Config{
+			ConnStr:      connStr,
+			KeyEncrypter: keyEncrypter,
+		}, logger)
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		err = db.MigrateUp()
+		if err != nil {
+			return err
+		}
+
+		reader := csv.NewReader(input)
+
+		header, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("Failed to read header: %v", err)
+		}
+
+		tokenIndex, secretIndex := -1, -1
+
+		for i, column := range header {
+			switch column {
+			case "device_token":
+				tokenIndex = i
+			case "secret":
+				secretIndex = i
+			}
+		}
+
+		if tokenIndex == -1 || secretIndex == -1 {
+			return errors.New("Header must name the device_token and secret columns")
+		}
+
+		var row, registered int
+
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			row++
+			token, secret := record[tokenIndex], record[secretIndex]
+
+			if token == "" {
+				return fmt.Errorf("Missing device_token in row %d", row)
+			}
+
+			if len(secret) < minClaimSecretLength {
+				return fmt.Errorf("Secret for device %s must be at least %d characters", token, minClaimSecretLength)
+			}
+
+			err = db.RegisterClaimSecret(token, secret)
+			if err != nil {
+				return err
+			}
+
+			registered++
+		}
+
+		logger.Log("msg", "registered claim secrets", "registered", registered)
+
+		return nil
+	},
+}
@@ -0,0 +1,93 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/rpc"
+	"github.com/thingful/iotdevicereg/pkg/system"
+)
+
+func init() {
+	rootCmd.AddCommand(compensationsCmd)
+	compensationsCmd.AddCommand(compensationsReplayCmd)
+
+	compensationsReplayCmd.Flags().StringP("encoder", "e", "", "Address at which the encoder is listening")
+}
+
+var compensationsCmd = &cobra.Command{
+	Use:   "compensations",
+	Short: "Manage failed compensating actions",
+	Long: `This task provides subcommands for working with compensating actions that
+could not be completed at the time they were attempted.
+
+When claiming a device we first create a stream on the encoder before
+committing our local transaction. If the local transaction then fails we
+attempt to delete the stream from the encoder, but if that also fails we
+record the orphaned stream so that the deletion can be retried later.`,
+}
+
+var compensationsReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay all recorded failed compensations",
+	Long: `This command attempts to delete from the encoder every orphaned stream
+recorded as a failed compensation. Records are removed once the encoder
+confirms the stream has been deleted, so the command may safely be run
+repeatedly.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		encoderAddr, err := cmd.Flags().GetString("encoder")
+		if err != nil {
+			return err
+		}
+
+		if encoderAddr == "" {
+			encoderAddr = viper.GetString("encoder")
+		}
+
+		if encoderAddr == "" {
+			return errors.New("Must provide encoder address")
+		}
+
+		connStr := viper.GetString("database_url")
+		if connStr == "" {
+			return errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+		}
+
+		logger := logger.NewLogger()
+
+		db := postgres.NewDB(&postgres.Config{
+			ConnStr: connStr,
+		}, logger)
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		encoderClient := encoder.NewEncoderProtobufClient(
+			encoderAddr,
+			&http.Client{
+				Timeout: time.Second * 10,
+			},
+		)
+
+		replayed, err := rpc.ReplayFailedCompensations(context.Background(), &rpc.Config{
+			DB:            db,
+			EncoderClient: encoderClient,
+			Verbose:       true,
+		}, logger)
+
+		logger.Log("msg", "replayed failed compensations", "replayed", replayed)
+
+		return err
+	},
+}
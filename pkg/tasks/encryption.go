@@ -0,0 +1,102 @@
+package tasks
+
+import (
+	"errors"
+
+	"github.com/spf13/viper"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+)
+
+// newKeyEncrypter returns the backend used to encrypt stored private keys,
+// selected via $DEVICEREG_KEY_ENCRYPTION and configured by whichever further
+// environment variables that backend requires.
+func newKeyEncrypter() (postgres.KeyEncrypter, error) {
+	return newBackendKeyEncrypter(viper.GetString("key_encryption"))
+}
+
+// newPreviousKeyEncrypter returns the backend from which stored private keys
+// are being migrated, selected via $DEVICEREG_PREVIOUS_KEY_ENCRYPTION and
+// configured by the same environment variables as our current backend. It
+// returns nil if no previous backend is set.
+func newPreviousKeyEncrypter() (postgres.KeyEncrypter, error) {
+	backend := viper.GetString("previous_key_encryption")
+	if backend == "" {
+		return nil, nil
+	}
+
+	if backend == normalizeBackend(viper.GetString("key_encryption")) {
+		return nil, errors.New("The previous key encryption backend must differ from $DEVICEREG_KEY_ENCRYPTION")
+	}
+
+	return newBackendKeyEncrypter(backend)
+}
+
+// normalizeBackend returns the name of the given key encryption backend,
+// which defaults to pgcrypto.
+func normalizeBackend(backend string) string {
+	if backend == "" {
+		return postgres.PgcryptoBackend
+	}
+
+	return backend
+}
+
+// newBackendKeyEncrypter returns the given key encryption backend, configured
+// by whichever environment variables that backend requires.
+func newBackendKeyEncrypter(backend string) (postgres.KeyEncrypter, error) {
+	config := &postgres.EncrypterConfig{
+		Backend:                    backend,
+		EncryptionPassword:         viper.GetString("encryption_password"),
+		PreviousEncryptionPassword: viper.GetString("previous_encryption_password"),
+		MasterKey:                  viper.GetString("master_key"),
+		KMSFile:                    viper.GetString("kms_file"),
+	}
+
+	switch config.Backend {
+	case "", postgres.PgcryptoBackend:
+		if config.EncryptionPassword == "" {
+			return nil, errors.New("Missing required environment variable: $DEVICEREG_ENCRYPTION_PASSWORD")
+		}
+	case postgres.EnvelopeBackend:
+		if config.MasterKey == "" {
+			return nil, errors.New("Missing required environment variable: $DEVICEREG_MASTER_KEY")
+		}
+	case postgres.FileKMSBackend:
+		if config.KMSFile == "" {
+			return nil, errors.New("Missing required environment variable: $DEVICEREG_KMS_FILE")
+		}
+	}
+
+	return postgres.NewKeyEncrypter(config)
+}
+
+// newKeyGeneration returns the options of key pairs generated for new users,
+// read from $DEVICEREG_KEY_CURVE and $DEVICEREG_KEY_ENCODING, along with the
+// key generator selected via $DEVICEREG_KEY_GENERATOR (or the server's
+// --key-generator flag).
+func newKeyGeneration() (*crypto.KeyOptions, crypto.KeyGenerator, error) {
+	keyOptions := &crypto.KeyOptions{
+		Curve:    viper.GetString("key_curve"),
+		Encoding: viper.GetString("key_encoding"),
+	}
+
+	err := keyOptions.Validate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backend := viper.GetString("key_generator")
+
+	keyGenerator, err := crypto.NewKeyGenerator(backend)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if backend == crypto.NativeBackend && keyOptions.Curve != "" && keyOptions.Curve != crypto.Ec25519Curve {
+		return nil, nil, errors.New("The native key generator only supports the ec25519 curve")
+	}
+
+	return keyOptions, keyGenerator, nil
+}
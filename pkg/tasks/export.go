@@ -0,0 +1,188 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/thingful/iotdevicereg/pkg/exporter"
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringP("format", "f", exporter.JSONLinesFormat, "Output format, either jsonl or csv")
+	exportCmd.Flags().StringP("output", "o", "", "Path of the file to write to (default stdout)")
+	exportCmd.Flags().String("private-keys", exporter.OmitPrivateKeys, "Whether private keys are omitted from the output (omit) or replaced with a placeholder (redact)")
+	exportCmd.Flags().StringSlice("user", []string{}, "Only export devices owned by this user uid (may be repeated)")
+	exportCmd.Flags().String("created-after", "", "Only export devices created at or after this date or RFC3339 timestamp")
+	exportCmd.Flags().String("created-before", "", "Only export devices created before this date or RFC3339 timestamp")
+	exportCmd.Flags().String("disposition", "", "Only export devices with this disposition, either indoor or outdoor")
+	exportCmd.Flags().String("bbox", "", "Only export devices within the bounding box min_lon,min_lat,max_lon,max_lat")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export registered devices, users and streams",
+	Long: fmt.Sprintf(`This command writes every registered device along with its owner and streams,
+one device per line, as either JSON lines or CSV. Private keys are never
+exported, but with --private-keys redact the private key columns are included
+with a placeholder value, so showing which keys are held.
+
+Devices may be filtered by owner, creation time, disposition and location,
+with all given filters applied together. The columns describing each device
+match those read by the import command. For example:
+
+    $ %s export --format csv --disposition outdoor --bbox 2.05,41.3,2.25,41.47`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+
+		privateKeys, err := cmd.Flags().GetString("private-keys")
+		if err != nil {
+			return err
+		}
+
+		if privateKeys != exporter.OmitPrivateKeys && privateKeys != exporter.RedactPrivateKeys {
+			return fmt.Errorf("Private keys must be one of: %s", strings.Join(exporter.PrivateKeyModes, ", "))
+		}
+
+		filter, err := newExportFilter(cmd)
+		if err != nil {
+			return err
+		}
+
+		connStr := viper.GetString("database_url")
+		if connStr == "" {
+			return errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+		}
+
+		outputPath, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return err
+		}
+
+		var output io.Writer = os.Stdout
+
+		if outputPath != "" {
+			file, err := os.Create(outputPath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			output = file
+		}
+
+		writer, err := exporter.NewWriter(output, format, privateKeys == exporter.RedactPrivateKeys)
+		if err != nil {
+			return err
+		}
+
+		// our output may be written to stdout so we log to stderr
+		logger := logger.NewLoggerWithWriter(os.Stderr)
+
+		db := postgres.NewDB(&postgres.Config{
+			ConnStr: connStr,
+		}, logger)
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		var exported int
+
+		err = db.ExportDevices(filter, func(device *postgres.Device) error {
+			exported++
+			return writer.Write(exporter.NewRecord(device, privateKeys == exporter.RedactPrivateKeys))
+		})
+		if err != nil {
+			return err
+		}
+
+		err = writer.Close()
+		if err != nil {
+			return err
+		}
+
+		logger.Log("msg", "export finished", "devices", exported)
+
+		return nil
+	},
+}
+
+// newExportFilter returns the filter described by the flags of the export
+// command.
+func newExportFilter(cmd *cobra.Command) (*postgres.ExportFilter, error) {
+	filter := &postgres.ExportFilter{}
+
+	userUIDs, err := cmd.Flags().GetStringSlice("user")
+	if err != nil {
+		return nil, err
+	}
+
+	filter.UserUIDs = userUIDs
+
+	createdAfter, err := cmd.Flags().GetString("created-after")
+	if err != nil {
+		return nil, err
+	}
+
+	if createdAfter != "" {
+		filter.CreatedAfter, err = exporter.ParseTime(createdAfter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	createdBefore, err := cmd.Flags().GetString("created-before")
+	if err != nil {
+		return nil, err
+	}
+
+	if createdBefore != "" {
+		filter.CreatedBefore, err = exporter.ParseTime(createdBefore)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	disposition, err := cmd.Flags().GetString("disposition")
+	if err != nil {
+		return nil, err
+	}
+
+	disposition = strings.ToLower(disposition)
+	if disposition != "" && disposition != "indoor" && disposition != "outdoor" {
+		return nil, errors.New("Disposition must be either indoor or outdoor")
+	}
+
+	filter.Disposition = disposition
+
+	bbox, err := cmd.Flags().GetString("bbox")
+	if err != nil {
+		return nil, err
+	}
+
+	if bbox != "" {
+		filter.BoundingBox, err = exporter.ParseBoundingBox(bbox)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return filter, nil
+}
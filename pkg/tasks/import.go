@@ -0,0 +1,215 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/thingful/iotdevicereg/pkg/importer"
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/rpc"
+	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringP("encoder", "e", "", "Address at which the encoder is listening")
+	importCmd.Flags().StringP("format", "f", "", "Format of the input file, either csv or jsonl (detected from the file extension if not set)")
+	importCmd.Flags().Bool("dry-run", false, "Validate every row without claiming any devices")
+	importCmd.Flags().String("checkpoint", "", "Path of the checkpoint file used to resume an interrupted import (default FILE.checkpoint)")
+	importCmd.Flags().String("report", "", "Path of the CSV report of rows that failed to import (default FILE.failures.csv)")
+	importCmd.Flags().Int("progress", importer.DefaultProgressInterval, "Number of rows between each progress message")
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Claim devices listed in a CSV or JSON lines file",
+	Long: fmt.Sprintf(`This command claims every device listed in the given file, exactly as if each
+had been claimed via the ClaimDevice API.
+
+The file may either be CSV with a header row, or contain a JSON object per
+line, with the following columns or keys:
+
+    device_token  the token of the device (required)
+    user_uid      the DECODE user id of the user claiming the device (required)
+    latitude      the latitude of the device, also accepted as lat
+    longitude     the longitude of the device, also accepted as lon or lng
+    disposition   either indoor or outdoor (default indoor)
+    broker        the address of the MQTT broker the device publishes to
+    challenge     a challenge issued via IssueClaimChallenge, required for
+                  devices with a registered claim secret
+    challenge_response
+                  the response to the challenge computed from the secret
+
+Rows that fail to parse or be claimed are written to a CSV report along with
+the reason for the failure, and do not stop the import. With --dry-run every
+row is validated but no devices are claimed.
+
+The line of the last row processed is recorded in a checkpoint file, so if
+the import is interrupted running the same command again resumes after it.
+The checkpoint is removed once the import completes. For example:
+
+    $ %s import --encoder encoder:8081 devices.csv`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return err
+		}
+
+		if format == "" {
+			format, err = importer.DetectFormat(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		checkpointPath, err := cmd.Flags().GetString("checkpoint")
+		if err != nil {
+			return err
+		}
+
+		if checkpointPath == "" {
+			checkpointPath = path + ".checkpoint"
+		}
+
+		reportPath, err := cmd.Flags().GetString("report")
+		if err != nil {
+			return err
+		}
+
+		if reportPath == "" {
+			reportPath = path + ".failures.csv"
+		}
+
+		progress, err := cmd.Flags().GetInt("progress")
+		if err != nil {
+			return err
+		}
+
+		if progress < 1 {
+			return errors.New("Progress interval must be greater than 0")
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		reader, err := importer.NewReader(file, format)
+		if err != nil {
+			return err
+		}
+
+		logger := logger.NewLogger()
+
+		config := &importer.Config{
+			Validate:         rpc.ValidateClaimDeviceRequest,
+			DryRun:           dryRun,
+			CheckpointPath:   checkpointPath,
+			ReportPath:       reportPath,
+			ProgressInterval: progress,
+		}
+
+		if !dryRun {
+			deviceReg, stop, err := newImportDeviceReg(cmd)
+			if err != nil {
+				return err
+			}
+			defer stop()
+
+			config.Claimer = deviceReg
+		}
+
+		summary, err := importer.NewImporter(config, logger).Import(context.Background(), reader)
+		if summary != nil {
+			logger.Log("msg", "import finished", "claimed", summary.Claimed, "failed", summary.Failed, "skipped", summary.Skipped, "dryRun", dryRun, "report", reportPath)
+		}
+
+		return err
+	},
+}
+
+// newImportDeviceReg returns our RPC service implementation configured as the
+// server would be, along with a function which stops the DB it uses.
+func newImportDeviceReg(cmd *cobra.Command) (importer.Claimer, func() error, error) {
+	encoderAddr, err := cmd.Flags().GetString("encoder")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if encoderAddr == "" {
+		encoderAddr = viper.GetString("encoder")
+	}
+
+	if encoderAddr == "" {
+		return nil, nil, errors.New("Must provide encoder address")
+	}
+
+	connStr := viper.GetString("database_url")
+	if connStr == "" {
+		return nil, nil, errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+	}
+
+	keyEncrypter, err := newKeyEncrypter()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyOptions, keyGenerator, err := newKeyGeneration()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger := logger.NewLogger()
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:      connStr,
+		KeyEncrypter: keyEncrypter,
+		KeyOptions:   keyOptions,
+		KeySource:    keyGenerator,
+	}, logger)
+
+	err = db.(system.Startable).Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = db.MigrateUp()
+	if err != nil {
+		db.(system.Stoppable).Stop()
+		return nil, nil, err
+	}
+
+	encoderClient := encoder.NewEncoderProtobufClient(
+		encoderAddr,
+		&http.Client{
+			Timeout: time.Second * 10,
+		},
+	)
+
+	deviceReg := rpc.NewDeviceReg(&rpc.Config{
+		DB:            db,
+		EncoderClient: encoderClient,
+	}, logger)
+
+	return deviceReg, db.(system.Stoppable).Stop, nil
+}
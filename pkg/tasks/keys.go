@@ -0,0 +1,154 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysRekeyCmd)
+
+	keysRekeyCmd.Flags().IntP("batch-size", "b", 100, "Number of rows to re-encrypt within each transaction")
+}
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage stored private keys",
+	Long: `This task provides subcommands for working with the private keys we store
+for users and devices.`,
+}
+
+var keysRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt all stored private keys with the current encryption key",
+	Long: fmt.Sprintf(`This command re-encrypts every stored private key, along with every device
+claim secret, with the current key of the backend selected via
+$DEVICEREG_KEY_ENCRYPTION.
+
+For the pgcrypto backend the new password must be supplied via
+$DEVICEREG_ENCRYPTION_PASSWORD and the password currently in use via
+$DEVICEREG_PREVIOUS_ENCRYPTION_PASSWORD. For the filekms backend, keys wrapped
+with any master key in the key file are re-wrapped with the current one, so
+the current key should be changed in the file before running this command.
+
+To migrate keys from one backend to another, set $DEVICEREG_KEY_ENCRYPTION to
+the new backend and $DEVICEREG_PREVIOUS_KEY_ENCRYPTION to the backend
+currently in use, along with the environment variables each requires. Every
+key is then decrypted with the previous backend and encrypted with the new
+one. As the server can only read keys from a single backend, stop it before
+migrating and restart it with the new backend once this command completes.
+For example, to migrate from pgcrypto to envelope encryption:
+
+    $ DEVICEREG_KEY_ENCRYPTION=envelope \
+      DEVICEREG_MASTER_KEY=... \
+      DEVICEREG_PREVIOUS_KEY_ENCRYPTION=pgcrypto \
+      DEVICEREG_ENCRYPTION_PASSWORD=... \
+      %[1]s keys rekey
+
+Keys are re-encrypted in batches, each within its own transaction, and a
+checkpoint is recorded with each batch so that if the command is interrupted
+it resumes from where it stopped when run again.
+
+To rotate the password without downtime, first restart the server with both
+the new and previous passwords set, as the server is then able to read keys
+encrypted with either. Then run this command, and once it completes restart
+the server without the previous password. For example:
+
+    $ DEVICEREG_ENCRYPTION_PASSWORD=new \
+      DEVICEREG_PREVIOUS_ENCRYPTION_PASSWORD=old \
+      %[1]s keys rekey`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		batchSize, err := cmd.Flags().GetInt("batch-size")
+		if err != nil {
+			return err
+		}
+
+		if batchSize < 1 {
+			return errors.New("Batch size must be greater than 0")
+		}
+
+		connStr := viper.GetString("database_url")
+		if connStr == "" {
+			return errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+		}
+
+		previous, err := newPreviousKeyEncrypter()
+		if err != nil {
+			return err
+		}
+
+		backend := viper.GetString("key_encryption")
+		if previous == nil && (backend == "" || backend == postgres.PgcryptoBackend) {
+			if viper.GetString("previous_encryption_password") == "" {
+				return errors.New("Missing required environment variable: $DEVICEREG_PREVIOUS_ENCRYPTION_PASSWORD")
+			}
+		}
+
+		keyEncrypter, err := newKeyEncrypter()
+		if err != nil {
+			return err
+		}
+
+		logger := logger.NewLogger()
+
+		db := postgres.NewDB(&postgres.Config{
+			ConnStr:      connStr,
+			KeyEncrypter: keyEncrypter,
+		}, logger)
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		// the function we use to decrypt with either password is created by a
+		// migration
+		err = db.MigrateUp()
+		if err != nil {
+			return err
+		}
+
+		for _, table := range postgres.RekeyTables {
+			lastID, err := db.RekeyCheckpoint(table)
+			if err != nil {
+				return err
+			}
+
+			if lastID > 0 {
+				logger.Log("msg", "resuming rekey", "table", table, "lastID", lastID)
+			}
+
+			var total int
+
+			for {
+				id, count, err := db.RekeyBatch(table, lastID, batchSize, previous)
+				if err != nil {
+					return err
+				}
+
+				lastID = id
+				total = total + count
+
+				logger.Log("msg", "rekeyed batch", "table", table, "rows", count, "lastID", lastID)
+
+				if count < batchSize {
+					break
+				}
+			}
+
+			logger.Log("msg", "rekeyed table", "table", table, "rows", total)
+		}
+
+		return db.ClearRekeyCheckpoints()
+	},
+}
@@ -0,0 +1,161 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	encoder "github.com/thingful/twirp-encoder-go"
+
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/reconciler"
+	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+
+	reconcileCmd.Flags().StringP("encoder", "e", "", "Address at which the encoder is listening")
+	reconcileCmd.Flags().Bool("repair", false, "Repair any streams found to differ from the encoder")
+	reconcileCmd.Flags().Duration("settle", 30*time.Second, "Time to wait before confirming drift found when repairing")
+}
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Compare our streams with those run by the encoder",
+	Long: fmt.Sprintf(`This command compares the streams recorded in our database with the streams
+the encoder is running, and reports any found on only one side.
+
+With --repair, streams we have recorded but the encoder is not running are
+recreated on the encoder and their records updated with the new stream uid,
+and streams the encoder is running but we have no record of are deleted from
+the encoder.
+
+As claims create streams on the encoder before recording them, drift is only
+repaired if it is found again after waiting for --settle, so that streams
+belonging to claims in progress are left alone. The encoder must support the
+ListStreams method. If the encoder address is an https URL, the encoder is
+connected to using the CA bundle and client certificate given by
+$DEVICEREG_ENCODER_TLS_CA, $DEVICEREG_ENCODER_TLS_CERT and
+$DEVICEREG_ENCODER_TLS_KEY, as for the server. For example:
+
+    $ %s reconcile --encoder http://encoder:8081 --repair`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		encoderAddr, err := cmd.Flags().GetString("encoder")
+		if err != nil {
+			return err
+		}
+
+		if encoderAddr == "" {
+			encoderAddr = viper.GetString("encoder")
+		}
+
+		if encoderAddr == "" {
+			return errors.New("Must provide encoder address")
+		}
+
+		repair, err := cmd.Flags().GetBool("repair")
+		if err != nil {
+			return err
+		}
+
+		settle, err := cmd.Flags().GetDuration("settle")
+		if err != nil {
+			return err
+		}
+
+		connStr := viper.GetString("database_url")
+		if connStr == "" {
+			return errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+		}
+
+		var keyEncrypter postgres.KeyEncrypter
+
+		// private keys are only read to recreate streams
+		if repair {
+			keyEncrypter, err = newKeyEncrypter()
+			if err != nil {
+				return err
+			}
+		}
+
+		logger := logger.NewLogger()
+
+		db := postgres.NewDB(&postgres.Config{
+			ConnStr:      connStr,
+			KeyEncrypter: keyEncrypter,
+		}, logger)
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		encoderTLS, err := newEncoderTLS(encoderAddr)
+		if err != nil {
+			return err
+		}
+
+		httpClient := &http.Client{
+			Timeout: time.Second * 10,
+		}
+
+		if encoderTLS != nil {
+			httpClient.Transport = encoderTLS.Transport()
+		}
+
+		r := reconciler.NewReconciler(&reconciler.Config{
+			DB:            db,
+			EncoderClient: encoder.NewEncoderProtobufClient(encoderAddr, httpClient),
+			StreamLister:  reconciler.NewStreamLister(encoderAddr, httpClient),
+			Repair:        repair,
+			Verbose:       true,
+		}, logger)
+
+		report, err := r.Reconcile(context.Background())
+		if err != nil {
+			return err
+		}
+
+		drifted := len(report.MissingOnEncoder) + len(report.OrphanedOnEncoder)
+
+		if repair && drifted > 0 {
+			logger.Log("msg", "waiting to confirm drift", "settle", settle)
+			time.Sleep(settle)
+
+			report, err = r.Reconcile(context.Background())
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, uid := range report.MissingOnEncoder {
+			logger.Log("msg", "stream missing on encoder", "streamUID", uid)
+		}
+
+		for _, uid := range report.OrphanedOnEncoder {
+			logger.Log("msg", "stream orphaned on encoder", "streamUID", uid)
+		}
+
+		logger.Log(
+			"msg", "reconciled streams",
+			reconciler.MissingOnEncoder, len(report.MissingOnEncoder),
+			reconciler.OrphanedOnEncoder, len(report.OrphanedOnEncoder),
+			"repaired", report.Repaired,
+			"failed", report.Failed,
+		)
+
+		if report.Failed > 0 {
+			return fmt.Errorf("Failed to repair %d streams", report.Failed)
+		}
+
+		return nil
+	},
+}
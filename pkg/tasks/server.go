@@ -2,10 +2,15 @@ package tasks
 
 import (
 	"errors"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/thingful/iotdevicereg/pkg/auth"
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+	"github.com/thingful/iotdevicereg/pkg/encoderclient"
+	"github.com/thingful/iotdevicereg/pkg/keypool"
 	"github.com/thingful/iotdevicereg/pkg/logger"
 	"github.com/thingful/iotdevicereg/pkg/server"
 )
@@ -14,11 +19,65 @@ func init() {
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.Flags().StringP("addr", "a", "0.0.0.0:8080", "Address to which the HTTP server binds")
 	serverCmd.Flags().StringP("encoder", "e", "", "Address at which the encoder is listening")
+	serverCmd.Flags().Duration("encoder-timeout", encoderclient.DefaultTimeout, "Maximum time allowed for a single attempt to call the encoder")
+	serverCmd.Flags().Int("encoder-max-attempts", encoderclient.DefaultMaxAttempts, "Maximum number of attempts made for each call to the encoder")
+	serverCmd.Flags().Int("encoder-breaker-threshold", encoderclient.DefaultBreakerThreshold, "Number of consecutive failed calls to the encoder after which calls fail fast")
+	serverCmd.Flags().Duration("encoder-breaker-cooldown", encoderclient.DefaultBreakerCooldown, "Time for which calls to the encoder fail fast before the encoder is tried again")
+	serverCmd.Flags().String("encoder-tls-ca", "", "Path of a PEM encoded CA bundle used to verify the encoder's certificate, instead of the system roots")
+	serverCmd.Flags().String("encoder-tls-cert", "", "Path of a PEM encoded certificate presented to the encoder")
+	serverCmd.Flags().String("encoder-tls-key", "", "Path of the PEM encoded private key of the certificate presented to the encoder")
+	serverCmd.Flags().String("tls-cert", "", "Path of a PEM encoded certificate with which to serve TLS")
+	serverCmd.Flags().String("tls-key", "", "Path of the PEM encoded private key of the certificate with which to serve TLS")
+	serverCmd.Flags().String("tls-client-ca", "", "Path of a PEM encoded CA bundle used to verify client certificates")
+	serverCmd.Flags().Bool("tls-require-client-cert", false, "Reject clients not presenting a certificate signed by the client CA bundle")
+	serverCmd.Flags().Float64("ip-rate-limit", 0, "Average number of claims, claim challenges and revocations per second allowed from each client IP address, or 0 to disable")
+	serverCmd.Flags().Int("ip-rate-burst", 20, "Number of claims, claim challenges and revocations allowed at once from each client IP address")
+	serverCmd.Flags().Float64("user-rate-limit", 0, "Average number of claims and revocations per second allowed for each user, or 0 to disable")
+	serverCmd.Flags().Int("user-rate-burst", 20, "Number of claims and revocations allowed at once for each user")
+	serverCmd.Flags().Bool("trust-forwarded-for", false, "Take client IP addresses from the X-Forwarded-For header set by a proxy")
+	serverCmd.Flags().String("api-keys-file", "", "Path of a JSON file listing the API keys accepted by the API")
+	serverCmd.Flags().String("jwt-keys-file", "", "Path of a JSON file listing the secrets with which bearer tokens accepted by the API are signed")
+	serverCmd.Flags().Bool("allow-unauthenticated", false, "Serve the API without authentication if neither --api-keys-file nor --jwt-keys-file is given")
 	serverCmd.Flags().Bool("verbose", false, "Enable verbose output")
+	serverCmd.Flags().Int("claim-concurrency", 8, "Maximum number of devices claimed concurrently by a single ClaimDevices call")
+	serverCmd.Flags().Duration("claim-challenge-ttl", 5*time.Minute, "Time for which a challenge issued to claim a device remains valid")
+	serverCmd.Flags().Bool("require-claim-proof", false, "Refuse to claim devices without a registered claim secret")
+	serverCmd.Flags().String("key-generator", crypto.ZenroomBackend, "Backend used to generate key pairs, either zenroom or native")
+	serverCmd.Flags().Int("key-pool-size", keypool.DefaultSize, "Number of key pairs to pre-generate, or 0 to generate key pairs on demand")
+	serverCmd.Flags().Int("key-pool-low-watermark", keypool.DefaultLowWatermark, "Number of pre-generated key pairs at or below which the key pool is refilled")
+	serverCmd.Flags().Duration("reconcile-interval", 0, "Interval at which streams are reconciled with the encoder, or 0 to disable reconciliation")
+	serverCmd.Flags().Bool("reconcile-repair", false, "Repair streams found to differ from the encoder when reconciling")
 
 	viper.BindPFlag("addr", serverCmd.Flags().Lookup("addr"))
 	viper.BindPFlag("encoder", serverCmd.Flags().Lookup("encoder"))
+	viper.BindPFlag("encoder_timeout", serverCmd.Flags().Lookup("encoder-timeout"))
+	viper.BindPFlag("encoder_max_attempts", serverCmd.Flags().Lookup("encoder-max-attempts"))
+	viper.BindPFlag("encoder_breaker_threshold", serverCmd.Flags().Lookup("encoder-breaker-threshold"))
+	viper.BindPFlag("encoder_breaker_cooldown", serverCmd.Flags().Lookup("encoder-breaker-cooldown"))
+	viper.BindPFlag("encoder_tls_ca", serverCmd.Flags().Lookup("encoder-tls-ca"))
+	viper.BindPFlag("encoder_tls_cert", serverCmd.Flags().Lookup("encoder-tls-cert"))
+	viper.BindPFlag("encoder_tls_key", serverCmd.Flags().Lookup("encoder-tls-key"))
+	viper.BindPFlag("tls_cert", serverCmd.Flags().Lookup("tls-cert"))
+	viper.BindPFlag("tls_key", serverCmd.Flags().Lookup("tls-key"))
+	viper.BindPFlag("tls_client_ca", serverCmd.Flags().Lookup("tls-client-ca"))
+	viper.BindPFlag("tls_require_client_cert", serverCmd.Flags().Lookup("tls-require-client-cert"))
+	viper.BindPFlag("ip_rate_limit", serverCmd.Flags().Lookup("ip-rate-limit"))
+	viper.BindPFlag("ip_rate_burst", serverCmd.Flags().Lookup("ip-rate-burst"))
+	viper.BindPFlag("user_rate_limit", serverCmd.Flags().Lookup("user-rate-limit"))
+	viper.BindPFlag("user_rate_burst", serverCmd.Flags().Lookup("user-rate-burst"))
+	viper.BindPFlag("trust_forwarded_for", serverCmd.Flags().Lookup("trust-forwarded-for"))
+	viper.BindPFlag("api_keys_file", serverCmd.Flags().Lookup("api-keys-file"))
+	viper.BindPFlag("jwt_keys_file", serverCmd.Flags().Lookup("jwt-keys-file"))
+	viper.BindPFlag("allow_unauthenticated", serverCmd.Flags().Lookup("allow-unauthenticated"))
 	viper.BindPFlag("verbose", serverCmd.Flags().Lookup("verbose"))
+	viper.BindPFlag("claim_concurrency", serverCmd.Flags().Lookup("claim-concurrency"))
+	viper.BindPFlag("claim_challenge_ttl", serverCmd.Flags().Lookup("claim-challenge-ttl"))
+	viper.BindPFlag("require_claim_proof", serverCmd.Flags().Lookup("require-claim-proof"))
+	viper.BindPFlag("key_generator", serverCmd.Flags().Lookup("key-generator"))
+	viper.BindPFlag("key_pool_size", serverCmd.Flags().Lookup("key-pool-size"))
+	viper.BindPFlag("key_pool_low_watermark", serverCmd.Flags().Lookup("key-pool-low-watermark"))
+	viper.BindPFlag("reconcile_interval", serverCmd.Flags().Lookup("reconcile-interval"))
+	viper.BindPFlag("reconcile_repair", serverCmd.Flags().Lookup("reconcile-repair"))
 }
 
 var serverCmd = &cobra.Command{
@@ -32,7 +91,106 @@ to be shared under certain conditions with specific parties.
 
 The server uses Twirp to expose both a JSON API along with a more performant
 Protocol Buffer API. The JSON API is not intended for use other than for
-clients unable to use the Protocol Buffer API.`,
+clients unable to use the Protocol Buffer API.
+
+If --tls-cert and --tls-key are given the server serves HTTPS. Clients may
+then be required to present a certificate signed by a CA in --tls-client-ca by
+setting --tls-require-client-cert, or if only --tls-client-ca is given any
+certificate a client presents is verified. The subject of a verified client
+certificate is available to our handlers, and is logged in verbose mode.
+
+To connect to the encoder over TLS give its address as an https URL. The
+encoder's certificate is verified against the system roots, or the CA bundle
+given by --encoder-tls-ca, and if the encoder requires client certificates one
+may be given via --encoder-tls-cert and --encoder-tls-key. As the encoder is
+sent device private keys, TLS should be used unless the encoder is only
+reachable over a trusted network.
+
+All certificate, key and CA files are reloaded whenever they change, so
+certificates may be rotated without restarting the server. New connections use
+the reloaded files, while connections already open continue with the
+certificates they were opened with.
+
+Callers of the API are authenticated if either --api-keys-file or
+--jwt-keys-file is given, and may then only claim, list, read, update,
+transfer, rotate the keys of, verify signatures of or revoke devices owned by
+the user they act for. Transfers must also be to that user. If neither is given
+the server refuses to start unless --allow-unauthenticated is set, in which
+case any caller may act for any user. The diagnostic EncryptTestPayload method
+is only available to callers acting for all users, so never without
+authentication. API keys are presented via the X-API-Key header, and the key file
+lists the SHA-256 hash of each key, along with the user uid for which it may
+act, or all_users for trusted services:
+
+    {"keys": [{"name": "importer", "sha256": "9f86d0...", "all_users": true}]}
+
+Bearer tokens are JSON web tokens presented via the Authorization header,
+signed using HMAC with one of the base64 encoded secrets in the key file, and
+act for the user uid given as their subject. Tokens must have an expiry time.
+
+    {"keys": [{"id": "2018-06", "secret": "c2VjcmV0..."}]}
+
+Devices may have a claim secret registered via the claim-secrets command, in
+which case claiming the device requires proof of possession of the secret: a
+challenge must first be obtained via IssueClaimChallenge, which is then passed
+to ClaimDevice along with an HMAC over it computed with the secret. Each
+challenge may be used once, and expires after --claim-challenge-ttl. With
+--require-claim-proof, devices without a claim secret cannot be claimed.
+
+Calls to ClaimDevice, ClaimDevices and RevokeDevice may be rate limited by
+client IP address via --ip-rate-limit, and by user via --user-rate-limit, each
+given as an average number of calls per second, with bursts of up to
+--ip-rate-burst and --user-rate-burst calls allowed. Each device claimed by
+ClaimDevices counts as a claim by the user, and revocations count against the
+same limit as the user's claims. Calls exceeding a limit fail with a resource exhausted
+error. Calls to IssueClaimChallenge are also limited by client IP address,
+as they are made before any user is known. If the server is behind a proxy,
+set --trust-forwarded-for to limit by the client address the proxy adds to the
+X-Forwarded-For header.
+
+Stored private keys are encrypted using the backend named by
+$DEVICEREG_KEY_ENCRYPTION, which may be one of:
+
+    pgcrypto  encrypt within Postgres using $DEVICEREG_ENCRYPTION_PASSWORD
+              (and optionally $DEVICEREG_PREVIOUS_ENCRYPTION_PASSWORD while
+              rotating the password). This is the default.
+    envelope  encrypt within the application using a per row data key wrapped
+              by the base64 encoded 32 byte key in $DEVICEREG_MASTER_KEY.
+              Each encrypted key is bound to its row, so is unable to be
+              decrypted if copied into another.
+    filekms   as envelope, but with data keys wrapped by the current named
+              master key read from the JSON file at $DEVICEREG_KMS_FILE.
+
+Key pairs for new users are generated on the curve named by
+$DEVICEREG_KEY_CURVE, which may be either ec25519 (the default) or nist256,
+and encoded using the encoding named by $DEVICEREG_KEY_ENCODING, which may be
+one of base64 (the default), hex or multibase. Key pairs for devices always match the curve and encoding of their
+owner's key pair.
+
+Key pairs are generated using Zenroom by default, or in pure Go if
+--key-generator is native. The native backend only supports the ec25519 curve,
+for which it produces keys in the same format as Zenroom, so may only be
+selected if every user's key pair is on that curve.
+
+To keep key generation out of the path of requests, key pairs for the
+configured curve and encoding are pre-generated in the background and held in
+memory, up to the number given by --key-pool-size. Once the number available
+falls to --key-pool-low-watermark the pool is refilled, and if it is ever empty
+key pairs are generated on demand.
+
+Calls to the encoder are each given --encoder-timeout to complete, and calls
+which fail because the encoder is unavailable are retried with a backoff up to
+--encoder-max-attempts times. Deleting a stream is always retried, but creating
+a stream is only retried if the encoder could not be reached, to avoid creating
+duplicate streams. After --encoder-breaker-threshold consecutive failures
+calls fail fast with an unavailable error for --encoder-breaker-cooldown, after
+which a single call is allowed through to test whether the encoder has
+recovered.
+
+If --reconcile-interval is set, the streams we have recorded are periodically
+compared with those run by the encoder, and any drift is exported as metrics.
+With --reconcile-repair drift is also repaired, as described by the reconcile
+command.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		addr := viper.GetString("addr")
 		if addr == "" {
@@ -49,19 +207,130 @@ clients unable to use the Protocol Buffer API.`,
 			return errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
 		}
 
-		encryptionPassword := viper.GetString("encryption_password")
-		if encryptionPassword == "" {
-			return errors.New("Missing required environment variable: $DEVICEREG_ENCRYPTION_PASSWORD")
+		keyEncrypter, err := newKeyEncrypter()
+		if err != nil {
+			return err
+		}
+
+		keyOptions, keyGenerator, err := newKeyGeneration()
+		if err != nil {
+			return err
+		}
+
+		keyPoolSize := viper.GetInt("key_pool_size")
+		if keyPoolSize < 0 {
+			return errors.New("Key pool size must not be negative")
+		}
+
+		keyPoolLowWatermark := viper.GetInt("key_pool_low_watermark")
+		if keyPoolLowWatermark < 0 {
+			return errors.New("Key pool low watermark must not be negative")
+		}
+
+		claimConcurrency := viper.GetInt("claim_concurrency")
+		if claimConcurrency < 1 {
+			return errors.New("Claim concurrency must be greater than 0")
+		}
+
+		claimChallengeTTL := viper.GetDuration("claim_challenge_ttl")
+		if claimChallengeTTL <= 0 {
+			return errors.New("Claim challenge TTL must be greater than 0")
+		}
+
+		encoderTimeout := viper.GetDuration("encoder_timeout")
+		if encoderTimeout <= 0 {
+			return errors.New("Encoder timeout must be greater than 0")
+		}
+
+		encoderMaxAttempts := viper.GetInt("encoder_max_attempts")
+		if encoderMaxAttempts < 1 {
+			return errors.New("Encoder max attempts must be greater than 0")
+		}
+
+		encoderBreakerThreshold := viper.GetInt("encoder_breaker_threshold")
+		if encoderBreakerThreshold < 1 {
+			return errors.New("Encoder breaker threshold must be greater than 0")
+		}
+
+		encoderBreakerCooldown := viper.GetDuration("encoder_breaker_cooldown")
+		if encoderBreakerCooldown <= 0 {
+			return errors.New("Encoder breaker cooldown must be greater than 0")
+		}
+
+		reconcileInterval := viper.GetDuration("reconcile_interval")
+		if reconcileInterval < 0 {
+			return errors.New("Reconcile interval must not be negative")
+		}
+
+		ipRateLimit := viper.GetFloat64("ip_rate_limit")
+		if ipRateLimit < 0 {
+			return errors.New("IP rate limit must not be negative")
+		}
+
+		ipRateBurst := viper.GetInt("ip_rate_burst")
+		if ipRateLimit > 0 && ipRateBurst < 1 {
+			return errors.New("IP rate burst must be greater than 0")
+		}
+
+		userRateLimit := viper.GetFloat64("user_rate_limit")
+		if userRateLimit < 0 {
+			return errors.New("User rate limit must not be negative")
+		}
+
+		userRateBurst := viper.GetInt("user_rate_burst")
+		if userRateLimit > 0 && userRateBurst < 1 {
+			return errors.New("User rate burst must be greater than 0")
+		}
+
+		authenticators, err := newAuthenticators()
+		if err != nil {
+			return err
+		}
+
+		if len(authenticators) == 0 && !viper.GetBool("allow_unauthenticated") {
+			return errors.New("Authentication requires --api-keys-file or --jwt-keys-file, or --allow-unauthenticated to serve the API without it")
+		}
+
+		serverTLS, clientAuth, err := newServerTLS()
+		if err != nil {
+			return err
+		}
+
+		encoderTLS, err := newEncoderTLS(encoderAddr)
+		if err != nil {
+			return err
 		}
 
 		logger := logger.NewLogger()
 
 		config := &server.Config{
-			ListenAddr:         addr,
-			ConnStr:            connStr,
-			EncryptionPassword: encryptionPassword,
-			EncoderAddr:        encoderAddr,
-			Verbose:            viper.GetBool("verbose"),
+			ListenAddr:              addr,
+			ConnStr:                 connStr,
+			KeyEncrypter:            keyEncrypter,
+			KeyOptions:              keyOptions,
+			KeyGenerator:            keyGenerator,
+			KeyPoolSize:             keyPoolSize,
+			KeyPoolLowWatermark:     keyPoolLowWatermark,
+			ClaimConcurrency:        claimConcurrency,
+			ClaimChallengeTTL:       claimChallengeTTL,
+			RequireClaimProof:       viper.GetBool("require_claim_proof"),
+			EncoderAddr:             encoderAddr,
+			EncoderTimeout:          encoderTimeout,
+			EncoderMaxAttempts:      encoderMaxAttempts,
+			EncoderBreakerThreshold: encoderBreakerThreshold,
+			EncoderBreakerCooldown:  encoderBreakerCooldown,
+			ReconcileInterval:       reconcileInterval,
+			Authenticators:          authenticators,
+			TLS:                     serverTLS,
+			ClientAuth:              clientAuth,
+			EncoderTLS:              encoderTLS,
+			IPRateLimit:             ipRateLimit,
+			IPRateBurst:             ipRateBurst,
+			UserRateLimit:           userRateLimit,
+			UserRateBurst:           userRateBurst,
+			TrustForwardedFor:       viper.GetBool("trust_forwarded_for"),
+			ReconcileRepair:         viper.GetBool("reconcile_repair"),
+			Verbose:                 viper.GetBool("verbose"),
 		}
 
 		s := server.NewServer(config, logger)
@@ -69,3 +338,29 @@ clients unable to use the Protocol Buffer API.`,
 		return s.Start()
 	},
 }
+
+// newAuthenticators returns the authenticators for our API configured via the
+// --api-keys-file and --jwt-keys-file flags, which is empty if neither is set.
+func newAuthenticators() ([]auth.Authenticator, error) {
+	authenticators := []auth.Authenticator{}
+
+	if path := viper.GetString("api_keys_file"); path != "" {
+		authenticator, err := auth.NewAPIKeyAuthenticator(path)
+		if err != nil {
+			return nil, err
+		}
+
+		authenticators = append(authenticators, authenticator)
+	}
+
+	if path := viper.GetString("jwt_keys_file"); path != "" {
+		authenticator, err := auth.NewJWTAuthenticator(path)
+		if err != nil {
+			return nil, err
+		}
+
+		authenticators = append(authenticators, authenticator)
+	}
+
+	return authenticators, nil
+}
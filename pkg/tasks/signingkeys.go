@@ -0,0 +1,168 @@
+package tasks
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/thingful/iotdevicereg/pkg/crypto"
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/postgres"
+	"github.com/thingful/iotdevicereg/pkg/system"
+	"github.com/thingful/iotdevicereg/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(signingKeysCmd)
+	signingKeysCmd.AddCommand(signingKeysRegisterCmd)
+}
+
+var signingKeysCmd = &cobra.Command{
+	Use:   "signing-keys",
+	Short: "Manage device signing keys",
+	Long: `This task provides subcommands for working with the public keys with which
+devices sign payloads.
+
+A device generates its own signing key pair, keeping the private key, and only
+its public key is registered here. VerifyDeviceSignature checks signatures
+against the registered key, so a device without one cannot have its
+signatures verified.`,
+}
+
+var signingKeysRegisterCmd = &cobra.Command{
+	Use:   "register [FILE]",
+	Short: "Register signing keys for devices",
+	Long: fmt.Sprintf(`This command registers a signing public key for each device listed in the
+given CSV file, or read from stdin if no file is given. The file must have a
+header row naming the device_token and public_key columns, and may also name
+key_curve and key_encoding columns, which default to ec25519 and base64.
+Registering a key for a device that already has one replaces it.
+
+Keys on the ec25519 curve are accepted either in the same form as the keys we
+generate, or as compressed 32 byte Ed25519 public keys. For example:
+
+    $ %s signing-keys register keys.csv`, version.BinaryName),
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr := viper.GetString("database_url")
+		if connStr == "" {
+			return errors.New("Missing required environment variable: $DEVICEREG_DATABASE_URL")
+		}
+
+		keyEncrypter, err := newKeyEncrypter()
+		if err != nil {
+			return err
+		}
+
+		var input io.Reader = os.Stdin
+
+		if len(args) > 0 {
+			file, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			input = file
+		}
+
+		logger := logger.NewLogger()
+
+		db := postgres.NewDB(&postgres.Config{
+			ConnStr:      connStr,
+			KeyEncrypter: keyEncrypter,
+		}, logger)
+
+		err = db.(system.Startable).Start()
+		if err != nil {
+			return err
+		}
+		defer db.(system.Stoppable).Stop()
+
+		err = db.MigrateUp()
+		if err != nil {
+			return err
+		}
+
+		reader := csv.NewReader(input)
+
+		header, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("Failed to read header: %v", err)
+		}
+
+		tokenIndex, keyIndex, curveIndex, encodingIndex := -1, -1, -1, -1
+
+		for i, column := range header {
+			switch column {
+			case "device_token":
+				tokenIndex = i
+			case "public_key":
+				keyIndex = i
+			case "key_curve":
+				curveIndex = i
+			case "key_encoding":
+				encodingIndex = i
+			}
+		}
+
+		if tokenIndex == -1 || keyIndex == -1 {
+			return errors.New("Header must name the device_token and public_key columns")
+		}
+
+		var row, registered int
+
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			row++
+
+			key := &postgres.SigningKey{
+				DeviceToken: record[tokenIndex],
+				PublicKey:   record[keyIndex],
+				KeyCurve:    crypto.Ec25519Curve,
+				KeyEncoding: crypto.Base64Encoding,
+			}
+
+			if curveIndex != -1 && record[curveIndex] != "" {
+				key.KeyCurve = record[curveIndex]
+			}
+
+			if encodingIndex != -1 && record[encodingIndex] != "" {
+				key.KeyEncoding = record[encodingIndex]
+			}
+
+			if key.DeviceToken == "" {
+				return fmt.Errorf("Missing device_token in row %d", row)
+			}
+
+			err = crypto.ValidateSigningKey(key.PublicKey, key.KeyOptions())
+			if err != nil {
+				return fmt.Errorf("Invalid signing key for device %s: %v", key.DeviceToken, err)
+			}
+
+			err = db.RegisterSigningKey(key)
+			if err != nil {
+				return err
+			}
+
+			registered++
+		}
+
+		logger.Log("msg", "registered signing keys", "registered", registered)
+
+		return nil
+	},
+}
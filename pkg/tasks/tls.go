@@ -0,0 +1,90 @@
+package tasks
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/thingful/iotdevicereg/pkg/logger"
+	"github.com/thingful/iotdevicereg/pkg/tlsconfig"
+)
+
+// newServerTLS returns the reloader of the certificate with which the server
+// serves HTTPS, read from the files given by --tls-cert and --tls-key (or
+// $DEVICEREG_TLS_CERT and $DEVICEREG_TLS_KEY), along with how client
+// certificates are verified against the CA bundle given by --tls-client-ca.
+// The reloader is nil if no certificate is configured, in which case we serve
+// plain HTTP.
+func newServerTLS() (*tlsconfig.Reloader, tls.ClientAuthType, error) {
+	certFile := viper.GetString("tls_cert")
+	keyFile := viper.GetString("tls_key")
+	clientCAFile := viper.GetString("tls_client_ca")
+	requireClientCert := viper.GetBool("tls_require_client_cert")
+
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" || requireClientCert {
+			return nil, tls.NoClientCert, errors.New("Client certificates may only be verified when --tls-cert and --tls-key are set")
+		}
+
+		return nil, tls.NoClientCert, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, tls.NoClientCert, errors.New("Must provide both --tls-cert and --tls-key to serve TLS")
+	}
+
+	clientAuth := tls.NoClientCert
+
+	if clientCAFile != "" {
+		clientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			clientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if requireClientCert {
+		return nil, tls.NoClientCert, errors.New("Must provide --tls-client-ca to require client certificates")
+	}
+
+	reloader, err := tlsconfig.NewReloader(&tlsconfig.Config{
+		Name:     "server",
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   clientCAFile,
+	}, logger.NewLogger())
+	if err != nil {
+		return nil, tls.NoClientCert, err
+	}
+
+	return reloader, clientAuth, nil
+}
+
+// newEncoderTLS returns the reloader of the CA bundle and client certificate
+// used to connect to the encoder at the given address, read from the files
+// given by $DEVICEREG_ENCODER_TLS_CA, $DEVICEREG_ENCODER_TLS_CERT and
+// $DEVICEREG_ENCODER_TLS_KEY (or the server's equivalent flags). The reloader
+// is nil unless the encoder address is an https URL.
+func newEncoderTLS(encoderAddr string) (*tlsconfig.Reloader, error) {
+	caFile := viper.GetString("encoder_tls_ca")
+	certFile := viper.GetString("encoder_tls_cert")
+	keyFile := viper.GetString("encoder_tls_key")
+
+	if !strings.HasPrefix(encoderAddr, "https://") {
+		if caFile != "" || certFile != "" || keyFile != "" {
+			return nil, errors.New("Encoder TLS files may only be given with an https encoder address")
+		}
+
+		return nil, nil
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("Must provide both an encoder TLS certificate and key, or neither")
+	}
+
+	return tlsconfig.NewReloader(&tlsconfig.Config{
+		Name:     "encoder",
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		CAFile:   caFile,
+	}, logger.NewLogger())
+}
@@ -0,0 +1,366 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultReloadDelay is the default interval we wait after a change to one
+	// of our files before reloading, so that a certificate and key written one
+	// after the other are reloaded together.
+	DefaultReloadDelay = 500 * time.Millisecond
+)
+
+var (
+	// tlsReloads is a prometheus counter recording reloads of certificates
+	// keyed by name and status.
+	tlsReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "decode_tls_reloads",
+			Help: "Counter of reloads of TLS certificates after their files changed",
+		},
+		[]string{
+			// which certificates were reloaded: server or encoder
+			"name",
+			// did the reload succeed: ok or error
+			"status",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(tlsReloads)
+}
+
+// Config is used to configure the reloader. CertFile and KeyFile are the paths
+// of a PEM encoded certificate and private key, and CAFile the path of a PEM
+// encoded bundle of CA certificates. For a server the CAs are those used to
+// verify client certificates, and for a client those used to verify the
+// server's certificate. Any of the paths may be empty, although CertFile and
+// KeyFile must be given together.
+type Config struct {
+	// Name identifies the reloader in logs and metrics.
+	Name string
+
+	CertFile string
+	KeyFile  string
+	CAFile   string
+
+	// ReloadDelay is the interval we wait after a file changes before
+	// reloading. If zero we use DefaultReloadDelay.
+	ReloadDelay time.Duration
+}
+
+// Reloader is a component holding a certificate and CA bundle read from files,
+// which are reloaded whenever the files change so that certificates can be
+// rotated without restarting. If a reload fails, for example because only one
+// of a new certificate and key has been written, we keep using the previously
+// loaded files.
+type Reloader struct {
+	name        string
+	certFile    string
+	keyFile     string
+	caFile      string
+	reloadDelay time.Duration
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+
+	watcher *fsnotify.Watcher
+	quit    chan struct{}
+	wg      sync.WaitGroup
+	logger  kitlog.Logger
+}
+
+// NewReloader returns a new Reloader for the files in the given config. The
+// files are read immediately, so an error is returned if they are missing or
+// invalid.
+func NewReloader(config *Config, logger kitlog.Logger) (*Reloader, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("certificate and key files must be given together")
+	}
+
+	logger = kitlog.With(logger, "module", "tlsconfig", "name", config.Name)
+
+	r := &Reloader{
+		name:        config.Name,
+		certFile:    config.CertFile,
+		keyFile:     config.KeyFile,
+		caFile:      config.CAFile,
+		reloadDelay: config.ReloadDelay,
+		quit:        make(chan struct{}),
+		logger:      logger,
+	}
+
+	if r.reloadDelay == 0 {
+		r.reloadDelay = DefaultReloadDelay
+	}
+
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Start starts watching our files for changes.
+func (r *Reloader) Start() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to create file watcher")
+	}
+
+	// we watch the directories rather than the files themselves, as files are
+	// often replaced by renaming a new file over them, which ends a watch on
+	// the file
+	for _, dir := range r.dirs() {
+		err = watcher.Add(dir)
+		if err != nil {
+			watcher.Close()
+			return errors.Wrap(err, "failed to watch directory")
+		}
+	}
+
+	r.logger.Log("msg", "starting reloader", "dirs", strings.Join(r.dirs(), ","))
+
+	r.watcher = watcher
+
+	r.wg.Add(1)
+	go r.run()
+
+	return nil
+}
+
+// Stop stops watching our files.
+func (r *Reloader) Stop() error {
+	r.logger.Log("msg", "stopping reloader")
+
+	close(r.quit)
+	r.wg.Wait()
+
+	if r.watcher != nil {
+		return r.watcher.Close()
+	}
+
+	return nil
+}
+
+// Reload reads our files, replacing the certificate and CA bundle we hold if
+// they are all read successfully.
+func (r *Reloader) Reload() error {
+	var (
+		cert *tls.Certificate
+		pool *x509.CertPool
+	)
+
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to load certificate")
+		}
+
+		cert = &c
+	}
+
+	if r.caFile != "" {
+		b, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read CA bundle")
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return errors.New("failed to parse any certificates from CA bundle")
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.mu.Unlock()
+
+	return nil
+}
+
+// ServerConfig returns a TLS config for a server presenting our certificate,
+// and verifying client certificates against our CA bundle according to the
+// given client auth type. The certificate and CA bundle in use are read for
+// each new connection, so reloads apply without restarting the server.
+func (r *Reloader) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, errors.New("no server certificate configured")
+			}
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   clientAuth,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns a TLS config for a client connecting to the given
+// server name, verifying the server's certificate against our CA bundle, or
+// the system roots if we have none, and presenting our certificate if the
+// server requests one.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	cert, pool := r.current()
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		RootCAs:    pool,
+	}
+
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	return config
+}
+
+// Transport returns an HTTP transport making TLS connections using our
+// ClientConfig. The config is created for each new connection, so reloads
+// apply to all connections opened after them, although connections already
+// open continue to be reused.
+func (r *Reloader) Transport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		DialContext: dialer.DialContext,
+		// the transport's handshake timeout does not apply to connections we
+		// dial, so the dialer's timeout covers the handshake instead
+		DialTLS: func(network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+
+			return tls.DialWithDialer(dialer, network, addr, r.ClientConfig(host))
+		},
+		MaxIdleConns:    100,
+		IdleConnTimeout: 90 * time.Second,
+	}
+}
+
+// current returns the certificate and CA bundle we currently hold.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, r.pool
+}
+
+// files returns the cleaned paths of all our configured files.
+func (r *Reloader) files() []string {
+	files := []string{}
+
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f != "" {
+			files = append(files, filepath.Clean(f))
+		}
+	}
+
+	return files
+}
+
+// dirs returns the distinct directories containing our files.
+func (r *Reloader) dirs() []string {
+	dirs := []string{}
+	seen := map[string]bool{}
+
+	for _, f := range r.files() {
+		dir := filepath.Dir(f)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+// affects returns true if the given file event may have changed one of our
+// files. As well as our files themselves we consider files whose names start
+// with "..", as Kubernetes updates mounted secrets by swapping a symlink with
+// such a name, leaving the paths we watch unchanged.
+func (r *Reloader) affects(event fsnotify.Event) bool {
+	name := filepath.Clean(event.Name)
+
+	if strings.HasPrefix(filepath.Base(name), "..") {
+		return true
+	}
+
+	for _, f := range r.files() {
+		if f == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// run is the main loop of the reloader, reloading our files shortly after
+// any change to them until we are stopped.
+func (r *Reloader) run() {
+	defer r.wg.Done()
+
+	var reload <-chan time.Time
+
+	for {
+		select {
+		case <-r.quit:
+			return
+		case event, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+
+			if r.affects(event) {
+				reload = time.After(r.reloadDelay)
+			}
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+
+			r.logger.Log("msg", "error watching files", "err", err)
+		case <-reload:
+			reload = nil
+
+			err := r.Reload()
+			if err != nil {
+				tlsReloads.With(prometheus.Labels{"name": r.name, "status": "error"}).Inc()
+				r.logger.Log("msg", "failed to reload certificates, continuing with previous", "err", err)
+				continue
+			}
+
+			tlsReloads.With(prometheus.Labels{"name": r.name, "status": "ok"}).Inc()
+			r.logger.Log("msg", "reloaded certificates")
+		}
+	}
+}
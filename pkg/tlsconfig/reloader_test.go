@@ -0,0 +1,284 @@
+package tlsconfig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/thingful/iotdevicereg/pkg/auth"
+	"github.com/thingful/iotdevicereg/pkg/server"
+	"github.com/thingful/iotdevicereg/pkg/tlsconfig"
+)
+
+// testCA is a certificate authority issuing certificates for our tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM encoded certificate and key with the given common name
+// signed by the CA, valid for localhost.
+func (ca *testCA) issue(t *testing.T, name string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	assert.Nil(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func writeFile(t *testing.T, dir, name string, contents []byte) string {
+	path := filepath.Join(dir, name)
+
+	err := ioutil.WriteFile(path, contents, 0600)
+	assert.Nil(t, err)
+
+	return path
+}
+
+// leafName returns the common name of the certificate the given server config
+// presents to new connections.
+func leafName(t *testing.T, config *tls.Config) string {
+	connConfig, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.Nil(t, err)
+
+	leaf, err := x509.ParseCertificate(connConfig.Certificates[0].Certificate[0])
+	assert.Nil(t, err)
+
+	return leaf.Subject.CommonName
+}
+
+func TestNewReloaderErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "ca")
+	certPEM, keyPEM := ca.issue(t, "server")
+
+	certFile := writeFile(t, dir, "cert.pem", certPEM)
+	keyFile := writeFile(t, dir, "key.pem", keyPEM)
+	invalidFile := writeFile(t, dir, "invalid.pem", []byte("not a certificate"))
+
+	testcases := []struct {
+		label       string
+		config      *tlsconfig.Config
+		expectedErr string
+	}{
+		{
+			label:       "cert without key",
+			config:      &tlsconfig.Config{CertFile: certFile},
+			expectedErr: "certificate and key files must be given together",
+		},
+		{
+			label:       "missing cert",
+			config:      &tlsconfig.Config{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: keyFile},
+			expectedErr: "failed to load certificate",
+		},
+		{
+			label:       "mismatched key",
+			config:      &tlsconfig.Config{CertFile: certFile, KeyFile: invalidFile},
+			expectedErr: "failed to load certificate",
+		},
+		{
+			label:       "missing CA bundle",
+			config:      &tlsconfig.Config{CAFile: filepath.Join(dir, "missing.pem")},
+			expectedErr: "failed to read CA bundle",
+		},
+		{
+			label:       "invalid CA bundle",
+			config:      &tlsconfig.Config{CAFile: invalidFile},
+			expectedErr: "failed to parse any certificates from CA bundle",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := tlsconfig.NewReloader(tc.config, kitlog.NewNopLogger())
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "ca")
+	serverCert, serverKey := ca.issue(t, "server")
+	clientCert, clientKey := ca.issue(t, "importer")
+
+	caFile := writeFile(t, dir, "ca.pem", ca.pem)
+
+	serverReloader, err := tlsconfig.NewReloader(&tlsconfig.Config{
+		Name:     "server",
+		CertFile: writeFile(t, dir, "server.pem", serverCert),
+		KeyFile:  writeFile(t, dir, "server-key.pem", serverKey),
+		CAFile:   caFile,
+	}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert, ok := auth.CertificateFromContext(r.Context())
+		assert.True(t, ok)
+
+		fmt.Fprint(w, cert.Subject.CommonName)
+	})
+
+	srv := httptest.NewUnstartedServer(server.ClientCertMiddleware(handler))
+	srv.TLS = serverReloader.ServerConfig(tls.RequireAndVerifyClientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientReloader, err := tlsconfig.NewReloader(&tlsconfig.Config{
+		Name:     "client",
+		CertFile: writeFile(t, dir, "client.pem", clientCert),
+		KeyFile:  writeFile(t, dir, "client-key.pem", clientKey),
+		CAFile:   caFile,
+	}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	client := &http.Client{Transport: clientReloader.Transport()}
+
+	resp, err := client.Get(srv.URL)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, "importer", string(body))
+
+	// a client without a certificate is rejected
+	anonymousReloader, err := tlsconfig.NewReloader(&tlsconfig.Config{
+		Name:   "anonymous",
+		CAFile: caFile,
+	}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	client = &http.Client{Transport: anonymousReloader.Transport()}
+
+	_, err = client.Get(srv.URL)
+	assert.NotNil(t, err)
+
+	// a client not trusting our CA rejects the server
+	otherCAFile := writeFile(t, dir, "other-ca.pem", newTestCA(t, "other").pem)
+
+	untrustingReloader, err := tlsconfig.NewReloader(&tlsconfig.Config{
+		Name:     "untrusting",
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+		CAFile:   otherCAFile,
+	}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	client = &http.Client{Transport: untrustingReloader.Transport()}
+
+	_, err = client.Get(srv.URL)
+	assert.NotNil(t, err)
+}
+
+func TestReloadOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t, "ca")
+	certPEM, keyPEM := ca.issue(t, "first")
+
+	certFile := writeFile(t, dir, "cert.pem", certPEM)
+	keyFile := writeFile(t, dir, "key.pem", keyPEM)
+
+	reloader, err := tlsconfig.NewReloader(&tlsconfig.Config{
+		Name:        "server",
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+		ReloadDelay: 10 * time.Millisecond,
+	}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	err = reloader.Start()
+	assert.Nil(t, err)
+	defer reloader.Stop()
+
+	config := reloader.ServerConfig(tls.NoClientCert)
+	assert.Equal(t, "first", leafName(t, config))
+
+	// an invalid certificate is ignored, keeping the previous one
+	writeFile(t, dir, "cert.pem", []byte("not a certificate"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "first", leafName(t, config))
+
+	certPEM, keyPEM = ca.issue(t, "second")
+	writeFile(t, dir, "cert.pem", certPEM)
+	writeFile(t, dir, "key.pem", keyPEM)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for leafName(t, config) != "second" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(t, "second", leafName(t, config))
+}